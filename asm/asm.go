@@ -27,7 +27,7 @@ const (
 	binSignature       = "go65"
 	sourceMapSignature = "sm65"
 	versionMajor       = 0
-	versionMinor       = 1
+	versionMinor       = 3
 )
 
 var modeName = []string{
@@ -44,22 +44,40 @@ var modeName = []string{
 	"IDX",
 	"IDY",
 	"ACC",
+	"ZPR",
+	"ABL",
+	"ALX",
+	"ILN",
+	"ILY",
+	"SRL",
+	"SRY",
+	"RLL",
+	"BLK",
 }
 
 var modeFormat = []string{
-	"#$%s",    // IMM
-	"%s",      // IMP
-	"$%s",     // REL
-	"$%s",     // ZPG
-	"$%s,X",   // ZPX
-	"$%s,Y",   // ZPY
-	"$%s",     // ABS
-	"$%s,X",   // ABX
-	"$%s,Y",   // ABY
-	"($%s)",   // IND
-	"($%s,X)", // IDX
-	"($%s),Y", // IDY
-	"%s",      // ACC
+	"#$%s",      // IMM
+	"%s",        // IMP
+	"$%s",       // REL
+	"$%s",       // ZPG
+	"$%s,X",     // ZPX
+	"$%s,Y",     // ZPY
+	"$%s",       // ABS
+	"$%s,X",     // ABX
+	"$%s,Y",     // ABY
+	"($%s)",     // IND
+	"($%s,X)",   // IDX
+	"($%s),Y",   // IDY
+	"%s",        // ACC
+	"$%s,$%s",   // ZPR
+	"$%s",       // ABL
+	"$%s,X",     // ALX
+	"[$%s]",     // ILN
+	"[$%s],Y",   // ILY
+	"$%s,S",     // SRL
+	"($%s,S),Y", // SRY
+	"$%s",       // RLL
+	"$%s,$%s",   // BLK
 }
 
 type pseudoOpData struct {
@@ -73,6 +91,7 @@ var pseudoOps = map[string]pseudoOpData{
 	".ar":      {fn: (*assembler).parseArch},
 	".arch":    {fn: (*assembler).parseArch},
 	"arch":     {fn: (*assembler).parseArch},
+	".bank":    {fn: (*assembler).parseBank},
 	".bin":     {fn: (*assembler).parseBinaryInclude},
 	".binary":  {fn: (*assembler).parseBinaryInclude},
 	".eq":      {fn: (*assembler).parseEquate},
@@ -99,8 +118,20 @@ var pseudoOps = map[string]pseudoOpData{
 	".ex":      {fn: (*assembler).parseExport},
 	".export":  {fn: (*assembler).parseExport},
 	"exp":      {fn: (*assembler).parseExport},
+	".a8":      {fn: (*assembler).parseWidth, param: widthA8},
+	".a16":     {fn: (*assembler).parseWidth, param: widthA16},
+	".i8":      {fn: (*assembler).parseWidth, param: widthI8},
+	".i16":     {fn: (*assembler).parseWidth, param: widthI16},
 }
 
+// Register widths selected by the 65c816 width pseudo-ops.
+const (
+	widthA8 = iota
+	widthA16
+	widthI8
+	widthI16
+)
+
 func init() {
 	// The .include pseudo-op must be initialized here to bypass go's overly
 	// aggressive initialization loop detection.
@@ -124,25 +155,48 @@ type instruction struct {
 	opcode    fstring          // opcode string
 	inst      *cpu.Instruction // selected instruction data for the opcode
 	operand   operand          // parameter data for the instruction
+	m16       bool             // 65c816 accumulator is 16 bits wide
+	x16       bool             // 65c816 index registers are 16 bits wide
 }
 
 func (i *instruction) address() int {
 	return i.addr
 }
 
+// Return the length of the selected instruction in bytes, taking the
+// 65c816 register widths into account.
+func (i *instruction) length() int {
+	return int(i.inst.LengthFor(i.m16, i.x16))
+}
+
+// Return the operand value. A 65c816 immediate operand is widened to 16
+// bits when its register is 16 bits wide.
+func (i *instruction) value() int {
+	if i.inst.Mode == cpu.IMM && i.length() == 3 {
+		return i.operand.expr.value & 0xffff
+	}
+	return i.operand.getValue()
+}
+
 // Format a byte code string for an instruction.
 func (i *instruction) codeString() string {
-	sz := i.inst.Length - 1
+	sz := i.length() - 1
 	switch {
 	case i.inst.Mode == cpu.REL:
-		offset, _ := relOffset(i.operand.getValue(), i.addr+int(i.inst.Length))
+		offset, _ := relOffset(i.operand.getValue(), i.addr+i.length())
 		return byteString([]byte{i.inst.Opcode, offset})
+	case i.inst.Mode == cpu.ZPR:
+		offset, _ := relOffset(i.operand.getTarget(), i.addr+i.length())
+		return byteString([]byte{i.inst.Opcode, byte(i.operand.getValue()), offset})
+	case i.inst.Mode == cpu.RLL:
+		offset := relLongOffset(i.operand.getValue(), i.addr+i.length())
+		return byteString(append([]byte{i.inst.Opcode}, toBytes(2, offset)...))
+	case i.inst.Mode == cpu.BLK:
+		return byteString([]byte{i.inst.Opcode, byte(i.operand.getTarget()), byte(i.operand.getValue())})
 	case sz == 0:
 		return byteString([]byte{i.inst.Opcode})
-	case sz == 1:
-		return byteString([]byte{i.inst.Opcode, byte(i.operand.getValue())})
 	default:
-		return byteString([]byte{i.inst.Opcode, byte(i.operand.getValue()), byte(i.operand.getValue() >> 8)})
+		return byteString(append([]byte{i.inst.Opcode}, toBytes(sz, i.value())...))
 	}
 }
 
@@ -150,12 +204,25 @@ func (i *instruction) codeString() string {
 func (i *instruction) operandString() string {
 	number := i.operand.getValue()
 
+	switch i.inst.Mode {
+	case cpu.ZPR:
+		return fmt.Sprintf(modeFormat[i.inst.Mode],
+			fmt.Sprintf("%02X", number), fmt.Sprintf("%04X", i.operand.getTarget()))
+	case cpu.BLK:
+		return fmt.Sprintf(modeFormat[i.inst.Mode],
+			fmt.Sprintf("%02X", number), fmt.Sprintf("%02X", i.operand.getTarget()))
+	case cpu.RLL:
+		return fmt.Sprintf(modeFormat[i.inst.Mode], fmt.Sprintf("%04X", number))
+	}
+
 	var n string
-	switch i.inst.Length {
+	switch i.length() {
 	case 2:
 		n = fmt.Sprintf("%02X", number)
+	case 4:
+		n = fmt.Sprintf("%06X", number)
 	default:
-		n = fmt.Sprintf("%04X", number)
+		n = fmt.Sprintf("%04X", i.value())
 	}
 
 	return fmt.Sprintf(modeFormat[i.inst.Mode], n)
@@ -165,6 +232,7 @@ func (i *instruction) operandString() string {
 type operand struct {
 	modeGuess      cpu.Mode // addressing mode guesed based on operand string
 	expr           *expr    // expression tree, used to resolve value
+	target         *expr    // second expression (ZPR and BLK modes only)
 	forceImmediate bool     // operand forces an immediate addressing mode
 	forceAbsolute  bool     // operand must use 2-byte absolute address
 }
@@ -183,6 +251,15 @@ func (o *operand) getValue() int {
 	}
 }
 
+// Return the branch target address of a zero page,relative operand.
+func (o *operand) getTarget() int {
+	v := o.target.value
+	if v < 0 {
+		v = 0x10000 + v
+	}
+	return v
+}
+
 // Return the size of the operand in bytes.
 func (o *operand) size() int {
 	switch {
@@ -190,6 +267,8 @@ func (o *operand) size() int {
 		return 0
 	case o.forceImmediate:
 		return 1
+	case o.expr.value > 0xffff:
+		return 3
 	case o.expr.address || o.forceAbsolute || o.expr.value > 0xff || o.expr.value < -128:
 		return 2
 	default:
@@ -284,7 +363,10 @@ type uneval struct {
 type assembler struct {
 	arch        cpu.Architecture    // requested architecture
 	instSet     *cpu.InstructionSet // instructions on current arch
+	m16         bool                // 65c816 accumulator is 16 bits wide
+	x16         bool                // 65c816 index registers are 16 bits wide
 	origin      int                 // requested origin
+	bank        int                 // requested bank
 	pc          int                 // the program counter
 	code        []byte              // generated machine code
 	r           io.Reader           // the reader passed to Assemble
@@ -293,6 +375,7 @@ type assembler struct {
 	labels      map[string]int      // label -> segment index
 	exports     []Export            // exported addresses
 	sourceLines []SourceLine        // source code line mappings
+	ranges      []CodeRange         // instruction and data address ranges
 	files       []string            // processed files
 	segments    []segment           // segment of machine code
 	unevaluated []uneval            // expressions requiring evaluation
@@ -306,6 +389,7 @@ type assembler struct {
 type Export struct {
 	Label   string
 	Address uint16
+	Bank    int
 }
 
 // Assembly contains the assembled machine code and other data associated with
@@ -401,11 +485,13 @@ func Assemble(r io.Reader, filename string, out io.Writer, options Option) (*Ass
 
 	sourceMap := &SourceMap{
 		Origin:  uint16(a.origin),
+		Bank:    a.bank,
 		Size:    uint32(len(a.code)),
 		CRC:     crc32.ChecksumIEEE(a.code),
 		Files:   a.files,
 		Lines:   a.sourceLines,
 		Exports: a.exports,
+		Ranges:  a.ranges,
 	}
 
 	return assembly, sourceMap, err
@@ -500,13 +586,14 @@ func (a *assembler) assignAddresses() error {
 				Address:   ss.addr,
 				FileIndex: ss.fileIndex,
 				Line:      ss.line,
+				Bank:      a.bank,
 			}
 			a.sourceLines = append(a.sourceLines, l)
 
 			a.log("%04X  %s Len:%d Mode:%s Opcode:%02X",
-				ss.addr, ss.opcode.str, ss.inst.Length,
+				ss.addr, ss.opcode.str, ss.length(),
 				modeName[ss.inst.Mode], ss.inst.Opcode)
-			a.pc += int(ss.inst.Length)
+			a.pc += ss.length()
 
 		case *data:
 			ss.addr = a.pc
@@ -584,22 +671,39 @@ func (a *assembler) generateCode() error {
 	for _, s := range a.segments {
 		switch ss := s.(type) {
 		case *instruction:
+			a.addRange(ss.addr, ss.length(), false)
 			a.code = append(a.code, ss.inst.Opcode)
 			switch {
-			case ss.inst.Length == 1:
+			case ss.length() == 1:
 				a.log("%04X-   %-8s    %s", ss.addr, ss.codeString(), ss.opcode.str)
 			case ss.inst.Mode == cpu.REL:
-				offset, err := relOffset(ss.operand.getValue(), ss.addr+int(ss.inst.Length))
+				offset, err := relOffset(ss.operand.getValue(), ss.addr+ss.length())
 				if err != nil {
 					a.addError(ss.opcode, "branch offset out of bounds")
 				}
 				a.code = append(a.code, offset)
 				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
-			case ss.inst.Length == 2:
+			case ss.inst.Mode == cpu.ZPR:
+				offset, err := relOffset(ss.operand.getTarget(), ss.addr+ss.length())
+				if err != nil {
+					a.addError(ss.opcode, "branch offset out of bounds")
+				}
+				a.code = append(a.code, byte(ss.operand.getValue()), offset)
+				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
+			case ss.inst.Mode == cpu.RLL:
+				offset := relLongOffset(ss.operand.getValue(), ss.addr+ss.length())
+				a.code = append(a.code, toBytes(2, offset)...)
+				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
+			case ss.inst.Mode == cpu.BLK:
+				// The source bank is written first in assembly code, but the
+				// destination bank is encoded first in machine code.
+				a.code = append(a.code, byte(ss.operand.getTarget()), byte(ss.operand.getValue()))
+				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
+			case ss.length() == 2:
 				a.code = append(a.code, byte(ss.operand.getValue()))
 				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
-			case ss.inst.Length == 3:
-				a.code = append(a.code, toBytes(2, ss.operand.getValue())...)
+			case ss.length() == 3 || ss.length() == 4:
+				a.code = append(a.code, toBytes(ss.length()-1, ss.value())...)
 				a.log("%04X-   %-8s    %s   %s", ss.addr, ss.codeString(), ss.opcode.str, ss.operandString())
 			default:
 				panic("invalid operand")
@@ -619,14 +723,17 @@ func (a *assembler) generateCode() error {
 					a.code = append(a.code, toBytes(ss.unit, e.value)...)
 				}
 			}
+			a.addRange(ss.addr, len(a.code)-start, true)
 			a.logBytes(ss.addr, a.code[start:])
 
 		case *bytedata:
+			a.addRange(ss.addr, len(ss.b), true)
 			a.code = append(a.code, ss.b...)
 			a.logBytes(ss.addr, ss.b)
 
 		case *alignment:
 			pad := make([]byte, ss.pad)
+			a.addRange(ss.addr, ss.pad, true)
 			a.code = append(a.code, pad...)
 			a.logBytes(ss.addr, pad)
 
@@ -635,6 +742,7 @@ func (a *assembler) generateCode() error {
 			for i := 0; i < ss.pad; i++ {
 				pad[i] = ss.value
 			}
+			a.addRange(ss.addr, ss.pad, true)
 			a.code = append(a.code, pad...)
 			a.logBytes(ss.addr, pad)
 
@@ -645,6 +753,7 @@ func (a *assembler) generateCode() error {
 			export := Export{
 				Label:   ss.expr.identifier.str,
 				Address: uint16(ss.expr.value),
+				Bank:    a.bank,
 			}
 			a.exports = append(a.exports, export)
 		}
@@ -652,6 +761,22 @@ func (a *assembler) generateCode() error {
 	return nil
 }
 
+// Record the generation of 'size' bytes of instructions or data at an
+// address, extending the previous range when possible.
+func (a *assembler) addRange(addr, size int, data bool) {
+	if size == 0 {
+		return
+	}
+	if n := len(a.ranges); n > 0 {
+		r := &a.ranges[n-1]
+		if r.Data == data && r.Address+r.Size == addr {
+			r.Size += size
+			return
+		}
+	}
+	a.ranges = append(a.ranges, CodeRange{Address: addr, Size: size, Data: data, Bank: a.bank})
+}
+
 // Parse a single line of assembly code.
 func (a *assembler) parseLine(line fstring) error {
 	// Skip empty (or comment-only) lines
@@ -767,6 +892,14 @@ func (a *assembler) parseArch(line, label fstring, param interface{}) error {
 		a.arch = cpu.NMOS
 	case arch == "65c02" || arch == "cmos":
 		a.arch = cpu.CMOS
+	case arch == "6502x" || arch == "nmosx":
+		a.arch = cpu.NMOSX
+	case arch == "w65c02" || arch == "wdc":
+		a.arch = cpu.WDC
+	case arch == "65816" || arch == "65c816" || arch == "w65c816":
+		a.arch = cpu.W65C816
+	case arch == "2a03" || arch == "r2a03" || arch == "nes":
+		a.arch = cpu.R2A03
 	default:
 		a.addError(line, "invalid architecture '%s'", archl.str)
 		return errParse
@@ -776,6 +909,23 @@ func (a *assembler) parseArch(line, label fstring, param interface{}) error {
 	return nil
 }
 
+// Parse a 65c816 register width pseudo-op. The selected width applies to
+// the immediate operands of all instructions that follow it.
+func (a *assembler) parseWidth(line, label fstring, param interface{}) error {
+	switch param.(int) {
+	case widthA8:
+		a.m16 = false
+	case widthA16:
+		a.m16 = true
+	case widthI8:
+		a.x16 = false
+	case widthI16:
+		a.x16 = true
+	}
+	a.logLine(line, "m16=%v x16=%v", a.m16, a.x16)
+	return nil
+}
+
 // Parse an ".EQU" constant definition.
 func (a *assembler) parseEquate(line, label fstring, param interface{}) error {
 	if label.str == "" {
@@ -838,6 +988,39 @@ func (a *assembler) parseOrigin(line, label fstring, param interface{}) error {
 	return nil
 }
 
+// Parse a ".BANK" bank definition. The bank is the number of the memory bank
+// the code will be loaded into on a system with banked memory.
+func (a *assembler) parseBank(line, label fstring, param interface{}) error {
+	if len(a.segments) > 0 {
+		a.addError(line, "bank directive must appear before first instruction")
+		return errParse
+	}
+
+	a.logLine(line, "bank=")
+
+	e, _, err := a.exprParser.parse(line, a.scopeLabel, allowParentheses)
+	if err != nil {
+		a.addExprErrors()
+		return errParse
+	}
+
+	if !e.eval(-1, a.constants, a.labels) {
+		a.addError(e.identifier, "unable to evaluate expression")
+		return errParse
+	}
+
+	if e.value < 0 || e.value > 0xffff {
+		a.addError(line, "invalid bank number")
+		return errParse
+	}
+
+	a.logLine(line, "expr=%s", e.String())
+	a.logLine(line, "val=%d", e.value)
+
+	a.bank = e.value
+	return nil
+}
+
 // Parse a data pseudo-op.
 func (a *assembler) parseData(line, label fstring, param interface{}) error {
 	a.logLine(line, "bytes=")
@@ -1111,6 +1294,8 @@ func (a *assembler) parseInstruction(opcode, remain fstring) error {
 		line:      remain.row,
 		opcode:    opcode,
 		operand:   operand,
+		m16:       a.m16,
+		x16:       a.x16,
 	}
 	a.segments = append(a.segments, seg)
 	return nil
@@ -1136,6 +1321,19 @@ func (a *assembler) parseOperand(line fstring) (o operand, remain fstring, err e
 			return
 		}
 
+	case line.startsWithChar('['):
+		var expr fstring
+		o.modeGuess, expr, remain, err = line.consume(1).consumeIndirectLong()
+		if err != nil {
+			a.addError(remain, "unknown addressing mode format")
+			return
+		}
+		o.expr, _, err = a.exprParser.parse(expr, a.scopeLabel, 0)
+		if err != nil {
+			a.addExprErrors()
+			return
+		}
+
 	case line.startsWithChar('#'):
 		o.modeGuess = cpu.IMM
 		o.forceImmediate = true
@@ -1163,6 +1361,18 @@ func (a *assembler) parseOperand(line fstring) (o operand, remain fstring, err e
 			a.addExprErrors()
 			return
 		}
+		if o.modeGuess == cpu.ZPR {
+			// ZPR also stands in for the two bank operands of a 65c816
+			// block move.
+			o.target, remain, err = a.exprParser.parse(remain, a.scopeLabel, 0)
+			if err != nil {
+				a.addExprErrors()
+				return
+			}
+			if !o.target.eval(-1, a.constants, a.labels) {
+				a.pushUnevaluated(o.target)
+			}
+		}
 	}
 
 	if !o.expr.eval(-1, a.constants, a.labels) {
@@ -1265,11 +1475,17 @@ func relOffset(addr1, addr2 int) (byte, error) {
 	}
 }
 
+// Compute the 16-bit relative offset of two addresses, as used by the
+// 65c816 long branch instructions. The offset wraps within the bank.
+func relLongOffset(addr1, addr2 int) int {
+	return (addr1 - addr2) & 0xffff
+}
+
 // Given an opcode and operand data, select the best 6502
 // instruction match. Prefer the instruction with the shortest
 // total length.
 func (a *assembler) findMatchingInstruction(opcode fstring, operand operand) *cpu.Instruction {
-	bestqual := 3
+	bestqual := 4
 	var found *cpu.Instruction
 	for _, inst := range a.instSet.GetInstructions(opcode.str) {
 		match, qual := false, 0
@@ -1289,11 +1505,29 @@ func (a *assembler) findMatchingInstruction(opcode fstring, operand operand) *cp
 		case inst.Mode == cpu.ZPY:
 			match, qual = (operand.modeGuess == cpu.ABY) && (operand.size() == 1), 1
 		case inst.Mode == cpu.ABS:
-			match, qual = (operand.modeGuess == cpu.ABS), 2
+			match, qual = (operand.modeGuess == cpu.ABS) && (operand.size() <= 2), 2
 		case inst.Mode == cpu.ABX:
-			match, qual = (operand.modeGuess == cpu.ABX), 2
+			match, qual = (operand.modeGuess == cpu.ABX) && (operand.size() <= 2), 2
 		case inst.Mode == cpu.ABY:
-			match, qual = (operand.modeGuess == cpu.ABY), 2
+			match, qual = (operand.modeGuess == cpu.ABY) && (operand.size() <= 2), 2
+		case inst.Mode == cpu.ABL:
+			match, qual = (operand.modeGuess == cpu.ABS), 3
+		case inst.Mode == cpu.ALX:
+			match, qual = (operand.modeGuess == cpu.ABX), 3
+		case inst.Mode == cpu.RLL:
+			match, qual = (operand.modeGuess == cpu.ABS), 1
+		case inst.Mode == cpu.ILN && inst.Length == 3:
+			match, qual = (operand.modeGuess == cpu.ILN) && (operand.size() <= 2), 2
+		case inst.Mode == cpu.ILN && inst.Length == 2:
+			match, qual = (operand.modeGuess == cpu.ILN) && (operand.size() == 1), 1
+		case inst.Mode == cpu.ILY:
+			match, qual = (operand.modeGuess == cpu.ILY) && (operand.size() == 1), 1
+		case inst.Mode == cpu.SRL:
+			match, qual = (operand.modeGuess == cpu.SRL) && (operand.size() == 1), 1
+		case inst.Mode == cpu.SRY:
+			match, qual = (operand.modeGuess == cpu.SRY) && (operand.size() == 1), 1
+		case inst.Mode == cpu.BLK:
+			match, qual = (operand.modeGuess == cpu.ZPR) && (operand.size() == 1), 1
 		case inst.Mode == cpu.IND && inst.Length == 3:
 			match, qual = (operand.modeGuess == cpu.IND), 2
 		case inst.Mode == cpu.IND && inst.Length == 2:
@@ -1302,6 +1536,8 @@ func (a *assembler) findMatchingInstruction(opcode fstring, operand operand) *cp
 			match, qual = (operand.modeGuess == cpu.IDX) && (operand.size() == 1), 1
 		case inst.Mode == cpu.IDY:
 			match, qual = (operand.modeGuess == cpu.IDY) && (operand.size() == 1), 1
+		case inst.Mode == cpu.ZPR:
+			match, qual = (operand.modeGuess == cpu.ZPR) && (operand.size() == 1), 1
 		}
 		if match && qual < bestqual {
 			bestqual, found = qual, inst
@@ -1321,6 +1557,8 @@ func (l fstring) consumeIndirect() (mode cpu.Mode, expr fstring, remain fstring,
 		mode, remain = cpu.IDX, remain.consume(3)
 	case remain.startsWithString("),Y") || remain.startsWithString("),y"):
 		mode, remain = cpu.IDY, remain.consume(3)
+	case remain.startsWithString(",S),Y") || remain.startsWithString(",s),y"):
+		mode, remain = cpu.SRY, remain.consume(5)
 	case remain.startsWithChar(')'):
 		mode, remain = cpu.IND, remain.consume(1)
 	default:
@@ -1335,6 +1573,40 @@ func (l fstring) consumeIndirect() (mode cpu.Mode, expr fstring, remain fstring,
 	return mode, expr, remain, err
 }
 
+// Consume a 65c816 operand expression starting with '[' until an indirect
+// long addressing mode substring is reached. Return the candidate
+// addressing mode and expression substring.
+func (l fstring) consumeIndirectLong() (mode cpu.Mode, expr fstring, remain fstring, err error) {
+	expr, remain = l.consumeUntilChar(']')
+
+	switch {
+	case remain.startsWithString("],Y") || remain.startsWithString("],y"):
+		mode, remain = cpu.ILY, remain.consume(3)
+	case remain.startsWithChar(']'):
+		mode, remain = cpu.ILN, remain.consume(1)
+	default:
+		err = errParse
+	}
+
+	remain = remain.consumeWhitespace()
+	if !remain.isEmpty() {
+		err = errParse
+	}
+
+	return mode, expr, remain, err
+}
+
+// Return true if the remainder of an operand selects the 65c816 stack
+// relative addressing mode. A ",S" followed by more label characters is
+// instead the start of a zero page,relative branch target.
+func isStackRelative(remain fstring) bool {
+	if !remain.startsWithString(",S") && !remain.startsWithString(",s") {
+		return false
+	}
+	remain = remain.consume(2)
+	return !remain.startsWith(labelChar)
+}
+
 // Consume an absolute operand expression until an absolute
 // addressing mode substring is reached. Guess the addressing mode,
 // and return the expression substring.
@@ -1346,6 +1618,13 @@ func (l fstring) consumeAbsolute() (mode cpu.Mode, expr fstring, remain fstring,
 		mode, remain = cpu.ABX, remain.consume(2)
 	case remain.startsWithString(",Y") || remain.startsWithString(",y"):
 		mode, remain = cpu.ABY, remain.consume(2)
+	case isStackRelative(remain):
+		mode, remain = cpu.SRL, remain.consume(2)
+	case remain.startsWithChar(','):
+		// A second expression follows. It is the branch target of a zero
+		// page,relative operand and is parsed by the caller.
+		mode, remain = cpu.ZPR, remain.consume(1).consumeWhitespace()
+		return mode, expr, remain, nil
 	default:
 		mode = cpu.ABS
 	}
@@ -351,3 +351,271 @@ func Test65c02FailOn6502(t *testing.T) {
 		checkASMError(t, prefix+line, "parse error")
 	}
 }
+
+var asm6502x = `	LAX $01
+	LAX $01,Y
+	LAX $1234,Y
+	SAX ($01,X)
+	DCP $1234,X
+	ISC ($01),Y
+	SLO $01,X
+	RLA $1234
+	SRE $1234,Y
+	RRA $01
+	ANC #$12
+	ALR #$12
+	ARR #$12
+	SBX #$12
+	LAS $1234,Y
+	JAM`
+
+func Test6502x(t *testing.T) {
+	prefix := `
+	.ARCH 6502x
+	.ORG $1000
+`
+	checkASM(t, prefix+asm6502x, "A701B701BF341283"+
+		"01DF3412F30117012F34125B341267010B124B126B12CB12BB341202")
+}
+
+func Test6502xFailOn6502(t *testing.T) {
+	lines := strings.Split(asm6502x, "\n")
+	prefix := `
+	.ARCH 6502
+	.ORG $1000
+`
+	for _, line := range lines {
+		checkASMError(t, prefix+line, "parse error")
+	}
+}
+
+var asmW65c02 = `	RMB0 $12
+	SMB7 $12
+	BBR3 $12,$1000
+	BBS5 $34,$100C
+	WAI
+	STP`
+
+func TestW65c02(t *testing.T) {
+	prefix := `
+	.ARCH w65c02
+	.ORG $1000
+`
+	checkASM(t, prefix+asmW65c02, "0712F7123F12F9DF3402CBDB")
+}
+
+func TestW65c02FailOn65c02(t *testing.T) {
+	lines := strings.Split(asmW65c02, "\n")
+	prefix := `
+	.ARCH 65c02
+	.ORG $1000
+`
+	for _, line := range lines {
+		checkASMError(t, prefix+line, "parse error")
+	}
+}
+
+func TestW65c02Labels(t *testing.T) {
+	asm := `
+	.ARCH w65c02
+	.ORG $1000
+ZP	.EQ $12
+L1	BBR3 ZP,L1
+	BBS5 ZP, L2
+L2	NOP`
+
+	checkASM(t, asm, "3F12FDDF1200EA")
+}
+
+var asm65816 = `	LDA $123456
+	LDA $123456,X
+	LDA [$12]
+	LDA [$12],Y
+	LDA $03,S
+	LDA ($03,S),Y
+	JML $123456
+	JSL $123456
+	JML [$1234]
+	MVN $01,$02
+	PEA $1234
+	REP #$30
+	XBA
+	LDA $1234
+	LDA $12
+	BRL $1000`
+
+func Test65816(t *testing.T) {
+	prefix := `
+	.ARCH 65816
+	.ORG $1000
+`
+	checkASM(t, prefix+asm65816,
+		"AF563412BF563412A712B712A303B3035C56341222563412DC3412540201F43412C230EBAD3412A51282D4FF")
+}
+
+func Test65816FailOn65c02(t *testing.T) {
+	lines := strings.Split(asm65816, "\n")
+	prefix := `
+	.ARCH 65c02
+	.ORG $1000
+`
+	for _, line := range lines[:10] {
+		checkASMError(t, prefix+line, "parse error")
+	}
+}
+
+func Test65816Widths(t *testing.T) {
+	asm := `
+	.ARCH 65816
+	.ORG $1000
+	.A16
+	LDA #$1234
+	LDX #$12
+	.I16
+	LDX #$12
+	.A8
+	LDA #$12
+	.I8
+	LDY #$12`
+
+	checkASM(t, asm, "A93412A212A21200A912A012")
+}
+
+func TestSourceMapBank(t *testing.T) {
+	asm := `
+	.bank 2
+	.org $8000
+	.ex START
+START:	LDA #$00
+	RTS`
+
+	r := bytes.NewReader([]byte(asm))
+	_, sm, err := Assemble(r, "test", os.Stdout, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	_, err = sm.WriteTo(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Merge the banked map with a map for the same addresses in bank 0.
+	sm0 := NewSourceMap()
+	sm0.Origin, sm0.Size = 0x8000, 3
+	sm0.Files = []string{"other"}
+	sm0.Lines = []SourceLine{{Address: 0x8000, Line: 10}}
+
+	sm2 := NewSourceMap()
+	_, err = sm2.ReadFrom(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	sm0.Merge(sm2)
+
+	if sm2.Bank != 2 || len(sm0.Exports) != 1 || sm0.Exports[0].Bank != 2 {
+		t.Error("source map bank not preserved")
+	}
+
+	if fn, line, err := sm0.FindInBank(0x8002, 2); err != nil || fn != "test" || line != 6 {
+		t.Errorf("FindInBank incorrect. got: %s:%d %v", fn, line, err)
+	}
+	if fn, line, err := sm0.Find(0x8000); err != nil || fn != "other" || line != 10 {
+		t.Errorf("Find incorrect. got: %s:%d %v", fn, line, err)
+	}
+	if _, _, err := sm0.FindInBank(0x8000, 1); err == nil {
+		t.Error("FindInBank found an address in an unmapped bank")
+	}
+}
+
+func TestSourceMapMergeFiles(t *testing.T) {
+	sm := NewSourceMap()
+	sm.Origin, sm.Size = 0x1000, 2
+	sm.Files = []string{"a", "b"}
+	sm.Lines = []SourceLine{
+		{Address: 0x1000, FileIndex: 0, Line: 1},
+		{Address: 0x1001, FileIndex: 1, Line: 2},
+	}
+
+	// The merged map shares one file with the original map and adds
+	// another, so the new file's index must follow the existing ones.
+	sm2 := NewSourceMap()
+	sm2.Origin, sm2.Size = 0x2000, 2
+	sm2.Files = []string{"c", "b"}
+	sm2.Lines = []SourceLine{
+		{Address: 0x2000, FileIndex: 0, Line: 3},
+		{Address: 0x2001, FileIndex: 1, Line: 4},
+	}
+	sm.Merge(sm2)
+
+	if len(sm.Files) != 3 {
+		t.Errorf("merged files incorrect. got: %v", sm.Files)
+	}
+	exp := []struct {
+		addr     int
+		filename string
+		line     int
+	}{
+		{0x1000, "a", 1},
+		{0x1001, "b", 2},
+		{0x2000, "c", 3},
+		{0x2001, "b", 4},
+	}
+	for _, e := range exp {
+		fn, line, err := sm.Find(e.addr)
+		if err != nil || fn != e.filename || line != e.line {
+			t.Errorf("Find($%04X) incorrect. exp: %s:%d, got: %s:%d %v",
+				e.addr, e.filename, e.line, fn, line, err)
+		}
+	}
+}
+
+func TestSourceMapRanges(t *testing.T) {
+	asm := `
+	.org $1000
+	LDA #$00
+	RTS
+	.db 1,2,3
+	.align 8
+	NOP`
+
+	r := bytes.NewReader([]byte(asm))
+	_, sm, err := Assemble(r, "test", os.Stdout, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	_, err = sm.WriteTo(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm2 := NewSourceMap()
+	_, err = sm2.ReadFrom(&buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := []CodeRange{
+		{Address: 0x1000, Size: 3},
+		{Address: 0x1003, Size: 5, Data: true},
+		{Address: 0x1008, Size: 1},
+	}
+	if len(sm2.Ranges) != len(exp) {
+		t.Errorf("code ranges incorrect. got: %+v", sm2.Ranges)
+		return
+	}
+	for i := range exp {
+		if sm2.Ranges[i] != exp[i] {
+			t.Errorf("code range %d incorrect. exp: %+v, got: %+v", i, exp[i], sm2.Ranges[i])
+		}
+	}
+}
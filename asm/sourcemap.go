@@ -18,11 +18,13 @@ import (
 // assembly code addresses.
 type SourceMap struct {
 	Origin  uint16
+	Bank    int
 	Size    uint32
 	CRC     uint32
 	Files   []string
 	Lines   []SourceLine
 	Exports []Export
+	Ranges  []CodeRange
 }
 
 // A SourceLine represents a mapping between a machine code address and
@@ -31,6 +33,16 @@ type SourceLine struct {
 	Address   int // Machine code address
 	FileIndex int // Source code file index
 	Line      int // Source code line number
+	Bank      int // Memory bank containing the address
+}
+
+// A CodeRange is a range of addresses the assembler filled with either
+// instructions or data.
+type CodeRange struct {
+	Address int  // First address of the range
+	Size    int  // Number of bytes in the range
+	Data    bool // The range contains data rather than instructions
+	Bank    int  // Memory bank containing the range
 }
 
 // Encoding flags
@@ -46,31 +58,53 @@ func NewSourceMap() *SourceMap {
 		Files:   []string{},
 		Lines:   []SourceLine{},
 		Exports: []Export{},
+		Ranges:  []CodeRange{},
 	}
 }
 
 // Find searches the source map for a source code line corresponding to the
 // requested address.
 func (s *SourceMap) Find(addr int) (filename string, line int, err error) {
+	return s.FindInBank(addr, 0)
+}
+
+// FindInBank searches the source map for a source code line corresponding
+// to the requested address within a memory bank.
+func (s *SourceMap) FindInBank(addr, bank int) (filename string, line int, err error) {
 	i := sort.Search(len(s.Lines), func(i int) bool {
-		return s.Lines[i].Address >= addr
+		return lineKey(s.Lines[i]) >= bank<<16|addr
 	})
-	if i < len(s.Lines) && s.Lines[i].Address == addr {
+	if i < len(s.Lines) && s.Lines[i].Address == addr && s.Lines[i].Bank == bank {
 		return s.Files[s.Lines[i].FileIndex], s.Lines[i].Line, nil
 	}
+	if bank != 0 {
+		return "", 0, fmt.Errorf("address $%04X not found in source file for bank %d", addr, bank)
+	}
 	return "", 0, fmt.Errorf("address $%04X not found in source file", addr)
 }
 
+// Return the sort key of a source line, which orders lines by bank and
+// then by address.
+func lineKey(l SourceLine) int {
+	return l.Bank<<16 | l.Address
+}
+
 // ClearRange clears portions of the source map that reference the
-// address range between `origin` and `origin+size``.
+// address range between 'origin' and 'origin+size'.
 func (s *SourceMap) ClearRange(origin, size int) {
+	s.ClearBankRange(origin, size, 0)
+}
+
+// ClearBankRange clears portions of the source map that reference the
+// address range between 'origin' and 'origin+size' within a memory bank.
+func (s *SourceMap) ClearBankRange(origin, size, bank int) {
 	min := uint16(origin)
 	max := uint16(origin + size)
 
 	// Filter out original exports covered by the new map's address range.
 	exports := make([]Export, 0, len(s.Exports))
 	for _, e := range s.Exports {
-		if e.Address < min || e.Address > max {
+		if e.Bank != bank || e.Address < min || e.Address > max {
 			exports = append(exports, e)
 		}
 	}
@@ -81,7 +115,7 @@ func (s *SourceMap) ClearRange(origin, size int) {
 	fileMap := make(map[string]int) // filename -> file index
 	lines := make([]SourceLine, 0, len(s.Lines))
 	for _, l := range s.Lines {
-		if uint16(l.Address) < min || uint16(l.Address) >= max {
+		if l.Bank != bank || uint16(l.Address) < min || uint16(l.Address) >= max {
 			filename := s.Files[l.FileIndex]
 			if fileIndex, ok := fileMap[filename]; ok {
 				l.FileIndex = fileIndex
@@ -95,6 +129,15 @@ func (s *SourceMap) ClearRange(origin, size int) {
 		}
 	}
 
+	// Filter out original code ranges starting within the new map's
+	// address range.
+	ranges := make([]CodeRange, 0, len(s.Ranges))
+	for _, r := range s.Ranges {
+		if r.Bank != bank || uint16(r.Address) < min || uint16(r.Address) >= max {
+			ranges = append(ranges, r)
+		}
+	}
+
 	// Build the files array from the file map.
 	files := make([]string, len(fileMap))
 	for f, i := range fileMap {
@@ -104,25 +147,36 @@ func (s *SourceMap) ClearRange(origin, size int) {
 	s.Files = files
 	s.Lines = lines
 	s.Exports = exports
+	s.Ranges = ranges
 }
 
 type bySLAddr []SourceLine
 
 func (a bySLAddr) Len() int           { return len(a) }
 func (a bySLAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a bySLAddr) Less(i, j int) bool { return a[i].Address < a[j].Address }
+func (a bySLAddr) Less(i, j int) bool { return lineKey(a[i]) < lineKey(a[j]) }
+
+type byRAddr []CodeRange
+
+func (a byRAddr) Len() int      { return len(a) }
+func (a byRAddr) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byRAddr) Less(i, j int) bool {
+	return a[i].Bank<<16|a[i].Address < a[j].Bank<<16|a[j].Address
+}
 
 type byEAddr []Export
 
-func (a byEAddr) Len() int           { return len(a) }
-func (a byEAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byEAddr) Less(i, j int) bool { return a[i].Address < a[j].Address }
+func (a byEAddr) Len() int      { return len(a) }
+func (a byEAddr) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byEAddr) Less(i, j int) bool {
+	return a[i].Bank<<16|int(a[i].Address) < a[j].Bank<<16|int(a[j].Address)
+}
 
 // Merge merges another source map (s2) into this source map.
 func (s *SourceMap) Merge(s2 *SourceMap) {
 	// Clear the portion of the original source map that references addresses
 	// in the new map's range.
-	s.ClearRange(int(s2.Origin), int(s2.Size))
+	s.ClearBankRange(int(s2.Origin), int(s2.Size), s2.Bank)
 
 	// Add exports from the new map.
 	for _, e := range s2.Exports {
@@ -132,8 +186,12 @@ func (s *SourceMap) Merge(s2 *SourceMap) {
 	// Sort exports by address.
 	sort.Sort(byEAddr(s.Exports))
 
+	// Add code ranges from the new map, sorted by address.
+	s.Ranges = append(s.Ranges, s2.Ranges...)
+	sort.Sort(byRAddr(s.Ranges))
+
 	// Build a mapping from filename to file index.
-	fileCount := 0
+	fileCount := len(s.Files)
 	fileMap := make(map[string]int)
 	for i, f := range s.Files {
 		fileMap[f] = i
@@ -167,8 +225,8 @@ func (s *SourceMap) Merge(s2 *SourceMap) {
 func (s *SourceMap) ReadFrom(r io.Reader) (n int64, err error) {
 	rr := bufio.NewReader(r)
 
-	b := make([]byte, 26)
-	nn, err := io.ReadFull(rr, b)
+	b := make([]byte, 28)
+	nn, err := io.ReadFull(rr, b[:26])
 	n += int64(nn)
 	if err != nil {
 		return n, err
@@ -177,9 +235,21 @@ func (s *SourceMap) ReadFrom(r io.Reader) (n int64, err error) {
 	if len(b) < 16 || bytes.Compare(b[0:4], []byte(sourceMapSignature)) != 0 {
 		return n, errors.New("invalid source map format")
 	}
-	if b[4] != versionMajor || b[5] != versionMinor {
+
+	// Version 0.1 source maps have no bank numbers, and versions before 0.3
+	// have no code ranges.
+	banked, ranged := b[5] >= 2, b[5] >= 3
+	if b[4] != versionMajor || b[5] < 1 || b[5] > versionMinor {
 		return n, errors.New("invalid source map version")
 	}
+	if banked {
+		nn, err = io.ReadFull(rr, b[26:28])
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+		s.Bank = int(binary.LittleEndian.Uint16(b[26:28]))
+	}
 
 	s.Origin = binary.LittleEndian.Uint16(b[6:8])
 	s.Size = binary.LittleEndian.Uint32(b[8:12])
@@ -200,7 +270,7 @@ func (s *SourceMap) ReadFrom(r io.Reader) (n int64, err error) {
 
 	s.Lines = make([]SourceLine, 0, lineCount)
 	if lineCount > 0 {
-		var line SourceLine
+		line := SourceLine{Bank: s.Bank}
 		for i := 0; i < lineCount; i++ {
 			var nn int
 			line, nn, err = decodeSourceLine(rr, line)
@@ -227,6 +297,39 @@ func (s *SourceMap) ReadFrom(r io.Reader) (n int64, err error) {
 			return n, err
 		}
 		s.Exports[i].Address = binary.LittleEndian.Uint16(b[0:2])
+		s.Exports[i].Bank = s.Bank
+
+		if banked {
+			nn, err = io.ReadFull(rr, b[:2])
+			n += int64(nn)
+			if err != nil {
+				return n, err
+			}
+			s.Exports[i].Bank = int(binary.LittleEndian.Uint16(b[0:2]))
+		}
+	}
+
+	s.Ranges = []CodeRange{}
+	if ranged {
+		nn, err = io.ReadFull(rr, b[:4])
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+		rangeCount := int(binary.LittleEndian.Uint32(b[0:4]))
+
+		s.Ranges = make([]CodeRange, rangeCount)
+		for i := 0; i < rangeCount; i++ {
+			nn, err = io.ReadFull(rr, b[:9])
+			n += int64(nn)
+			if err != nil {
+				return n, err
+			}
+			s.Ranges[i].Address = int(binary.LittleEndian.Uint16(b[0:2]))
+			s.Ranges[i].Size = int(binary.LittleEndian.Uint32(b[2:6]))
+			s.Ranges[i].Bank = int(binary.LittleEndian.Uint16(b[6:8]))
+			s.Ranges[i].Data = b[8] != 0
+		}
 	}
 
 	return n, nil
@@ -241,7 +344,7 @@ func (s *SourceMap) WriteTo(w io.Writer) (n int64, err error) {
 
 	ww := bufio.NewWriter(w)
 
-	var hdr [26]byte
+	var hdr [28]byte
 	copy(hdr[:], []byte(sourceMapSignature))
 	hdr[4] = versionMajor
 	hdr[5] = versionMinor
@@ -251,6 +354,7 @@ func (s *SourceMap) WriteTo(w io.Writer) (n int64, err error) {
 	binary.LittleEndian.PutUint16(hdr[16:18], fileCount)
 	binary.LittleEndian.PutUint32(hdr[18:22], lineCount)
 	binary.LittleEndian.PutUint32(hdr[22:26], exportCount)
+	binary.LittleEndian.PutUint16(hdr[26:28], uint16(s.Bank))
 	nn, err := ww.Write(hdr[:])
 	n += int64(nn)
 	if err != nil {
@@ -271,7 +375,7 @@ func (s *SourceMap) WriteTo(w io.Writer) (n int64, err error) {
 	}
 
 	if len(s.Lines) > 0 {
-		var prev SourceLine
+		prev := SourceLine{Bank: s.Bank}
 		for _, line := range s.Lines {
 			nn, err = encodeSourceLine(ww, prev, line)
 			n += int64(nn)
@@ -294,8 +398,32 @@ func (s *SourceMap) WriteTo(w io.Writer) (n int64, err error) {
 		}
 		n++
 
-		var b [2]byte
-		binary.LittleEndian.PutUint16(b[:], e.Address)
+		var b [4]byte
+		binary.LittleEndian.PutUint16(b[0:2], e.Address)
+		binary.LittleEndian.PutUint16(b[2:4], uint16(e.Bank))
+		nn, err = ww.Write(b[:])
+		n += int64(nn)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	var rc [4]byte
+	binary.LittleEndian.PutUint32(rc[:], uint32(len(s.Ranges)))
+	nn, err = ww.Write(rc[:])
+	n += int64(nn)
+	if err != nil {
+		return n, err
+	}
+
+	for _, r := range s.Ranges {
+		var b [9]byte
+		binary.LittleEndian.PutUint16(b[0:2], uint16(r.Address))
+		binary.LittleEndian.PutUint32(b[2:6], uint32(r.Size))
+		binary.LittleEndian.PutUint16(b[6:8], uint16(r.Bank))
+		if r.Data {
+			b[8] = 1
+		}
 		nn, err = ww.Write(b[:])
 		n += int64(nn)
 		if err != nil {
@@ -332,7 +460,11 @@ func decodeSourceLine(r *bufio.Reader, prev SourceLine) (line SourceLine, n int,
 		}
 	}
 
-	line.Address = prev.Address + da
+	// The address delta spans banks, since lines are ordered by bank and
+	// then by address.
+	key := lineKey(prev) + da
+	line.Address = key & 0xffff
+	line.Bank = key >> 16
 	line.FileIndex = prev.FileIndex + df
 	line.Line = prev.Line + dl
 	return line, n, nil
@@ -421,7 +553,7 @@ func decode67(r *bufio.Reader) (value int, n int, err error) {
 }
 
 func encodeSourceLine(w *bufio.Writer, l0, l1 SourceLine) (n int, err error) {
-	da := l1.Address - l0.Address
+	da := lineKey(l1) - lineKey(l0)
 	df := l1.FileIndex - l0.FileIndex
 	dl := l1.Line - l0.Line
 
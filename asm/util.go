@@ -38,6 +38,8 @@ func toBytes(bytes, value int) []byte {
 		return []byte{byte(value)}
 	case 2:
 		return []byte{byte(value), byte(value >> 8)}
+	case 3:
+		return []byte{byte(value), byte(value >> 8), byte(value >> 16)}
 	default:
 		return []byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)}
 	}
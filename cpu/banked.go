@@ -0,0 +1,231 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import "errors"
+
+// Errors
+var (
+	ErrInvalidWindow = errors.New("Invalid bank window")
+)
+
+// The Banked interface is implemented by memory that switches banks of
+// storage into the 16-bit address space.
+type Banked interface {
+	// Bank returns the number of the bank currently mapped at the address.
+	// Addresses outside of any banked window are in bank 0.
+	Bank(addr uint16) int
+
+	// SetBank maps a bank into the window containing the address. It is
+	// ignored if the address is outside of any banked window.
+	SetBank(addr uint16, bank int)
+}
+
+// BankedMemory is a 64K memory space in which windows of addresses are
+// backed by switchable banks of storage. Addresses outside of the windows
+// behave like FlatMemory.
+type BankedMemory struct {
+	FlatMemory
+	windows []*BankWindow
+}
+
+// A BankWindow is a range of addresses within a BankedMemory through which
+// one of several banks of storage is visible at a time.
+type BankWindow struct {
+	ReadOnly bool // writes to the window are ignored (e.g., ROM banks)
+
+	start uint16   // first address of the window
+	size  int      // size of the window and of each bank
+	banks [][]byte // storage of each bank
+	bank  int      // currently mapped bank
+	sel   uint16   // address whose stores select the bank
+}
+
+// NewBankedMemory creates a new banked memory space with no windows.
+func NewBankedMemory() *BankedMemory {
+	return &BankedMemory{}
+}
+
+// AddWindow adds a window of 'size' bytes starting at address 'start',
+// backed by 'banks' banks of storage. Storing a value to the 'sel' address
+// maps the bank with that number (modulo the number of banks) into the
+// window; the value is not stored to memory. Bank 0 is mapped initially.
+func (m *BankedMemory) AddWindow(start uint16, size, banks int, sel uint16) (*BankWindow, error) {
+	if size <= 0 || int(start)+size > 0x10000 || banks <= 0 {
+		return nil, ErrInvalidWindow
+	}
+	for _, w := range m.windows {
+		if int(start) < int(w.start)+w.size && int(w.start) < int(start)+size {
+			return nil, ErrInvalidWindow
+		}
+	}
+
+	w := &BankWindow{
+		start: start,
+		size:  size,
+		banks: make([][]byte, banks),
+		sel:   sel,
+	}
+	for i := range w.banks {
+		w.banks[i] = make([]byte, size)
+	}
+	m.windows = append(m.windows, w)
+	return w, nil
+}
+
+// Banks returns the number of banks backing the window.
+func (w *BankWindow) Banks() int {
+	return len(w.banks)
+}
+
+// Bank returns the number of the bank currently mapped into the window.
+func (w *BankWindow) Bank() int {
+	return w.bank
+}
+
+// SetBank maps a bank into the window. The bank number is taken modulo the
+// number of banks, so negative numbers count back from the last bank.
+func (w *BankWindow) SetBank(bank int) {
+	n := len(w.banks)
+	w.bank = (bank%n + n) % n
+}
+
+// Storage returns the storage of a bank, whether or not it is currently
+// mapped. It may be used to load ROM images.
+func (w *BankWindow) Storage(bank int) []byte {
+	return w.banks[bank]
+}
+
+func (w *BankWindow) contains(addr uint16) bool {
+	return addr >= w.start && int(addr) < int(w.start)+w.size
+}
+
+// Return the window containing the address, or nil if the address isn't
+// banked.
+func (m *BankedMemory) window(addr uint16) *BankWindow {
+	for _, w := range m.windows {
+		if w.contains(addr) {
+			return w
+		}
+	}
+	return nil
+}
+
+// Bank returns the number of the bank currently mapped at the address.
+func (m *BankedMemory) Bank(addr uint16) int {
+	if w := m.window(addr); w != nil {
+		return w.bank
+	}
+	return 0
+}
+
+// SetBank maps a bank into the window containing the address.
+func (m *BankedMemory) SetBank(addr uint16, bank int) {
+	if w := m.window(addr); w != nil {
+		w.SetBank(bank)
+	}
+}
+
+// LoadByte loads a single byte from the address and returns it.
+func (m *BankedMemory) LoadByte(addr uint16) byte {
+	if w := m.window(addr); w != nil {
+		return w.banks[w.bank][addr-w.start]
+	}
+	return m.b[addr]
+}
+
+// Peek returns the byte at the address. The result is false for a window's
+// select address, since a store there switches banks instead of replacing
+// the byte.
+func (m *BankedMemory) Peek(addr uint16) (byte, bool) {
+	for _, w := range m.windows {
+		if w.sel == addr {
+			return 0, false
+		}
+	}
+	return m.LoadByte(addr), true
+}
+
+// Append the bank mapped into each window to 'banks' and return the result.
+func (m *BankedMemory) saveBanks(banks []int) []int {
+	for _, w := range m.windows {
+		banks = append(banks, w.bank)
+	}
+	return banks
+}
+
+// Map the banks saved by saveBanks into the windows.
+func (m *BankedMemory) restoreBanks(banks []int) {
+	for i, w := range m.windows {
+		if i < len(banks) {
+			w.bank = banks[i]
+		}
+	}
+}
+
+// LoadBytes loads multiple bytes from the address and stores them into the
+// buffer 'b'.
+func (m *BankedMemory) LoadBytes(addr uint16, b []byte) {
+	for i := range b {
+		if int(addr)+i < len(m.b) {
+			b[i] = m.LoadByte(addr + uint16(i))
+		} else {
+			b[i] = 0
+		}
+	}
+}
+
+// LoadAddress loads a 16-bit address value from the requested address and
+// returns it. Like FlatMemory, the high byte is read from a page-wrapped
+// address when the address ends in 0xff.
+func (m *BankedMemory) LoadAddress(addr uint16) uint16 {
+	next := addr + 1
+	if (addr & 0xff) == 0xff {
+		next = addr - 0xff
+	}
+	return uint16(m.LoadByte(addr)) | uint16(m.LoadByte(next))<<8
+}
+
+// StoreByte stores a byte to the requested address. Storing to a window's
+// select address switches the window's bank instead.
+func (m *BankedMemory) StoreByte(addr uint16, v byte) {
+	selected := false
+	for _, w := range m.windows {
+		if w.sel == addr {
+			w.SetBank(int(v))
+			selected = true
+		}
+	}
+	if selected {
+		return
+	}
+
+	if w := m.window(addr); w != nil {
+		if !w.ReadOnly {
+			w.banks[w.bank][addr-w.start] = v
+		}
+		return
+	}
+	m.b[addr] = v
+}
+
+// StoreBytes stores multiple bytes to the requested address.
+func (m *BankedMemory) StoreBytes(addr uint16, b []byte) {
+	for i, v := range b {
+		if int(addr)+i < len(m.b) {
+			m.StoreByte(addr+uint16(i), v)
+		}
+	}
+}
+
+// StoreAddress stores a 16-bit address value to the requested address.
+func (m *BankedMemory) StoreAddress(addr uint16, v uint16) {
+	next := addr + 1
+	if (addr & 0xff) == 0xff {
+		next = addr - 0xff
+	}
+	m.StoreByte(addr, byte(v&0xff))
+	m.StoreByte(next, byte(v>>8))
+}
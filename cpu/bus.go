@@ -0,0 +1,353 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import "errors"
+
+// Errors
+var (
+	ErrInvalidRegion  = errors.New("Invalid bus region")
+	ErrTooManyRegions = errors.New("Too many bus regions")
+)
+
+// A Device is a region of memory or a peripheral attached to a Bus. Each
+// access to the device passes the offset of the address from the start of
+// the region the device was mapped into.
+type Device interface {
+	// Read returns the byte at the offset. Reads may have side-effects,
+	// such as acknowledging an interrupt.
+	Read(offset uint16) byte
+
+	// Write stores a byte at the offset.
+	Write(offset uint16, v byte)
+}
+
+// A Peeker is a Device whose bytes may be read without side effects. RAM
+// and ROM devices are Peekers.
+type Peeker interface {
+	// Peek returns the byte at the offset without side effects.
+	Peek(offset uint16) byte
+}
+
+// Return the byte at the offset of a device without side effects. The
+// result is false if the device isn't a Peeker or a mirror of one.
+func peekDevice(d Device, offset uint16) (byte, bool) {
+	switch d := d.(type) {
+	case *mirror:
+		return peekDevice(d.device, offset%d.size)
+	case Peeker:
+		return d.Peek(offset), true
+	default:
+		return 0, false
+	}
+}
+
+// A Bus is a Memory composed of devices mapped over ranges of the 16-bit
+// address space.
+//
+// Accesses to addresses not covered by any device are unmapped. By default,
+// an unmapped read returns the last value transferred on the bus (the "open
+// bus" value), and an unmapped write is ignored. Set the UnmappedRead and
+// UnmappedWrite callbacks to change this behavior.
+type Bus struct {
+	// UnmappedRead, if set, is called when an unmapped address is read.
+	// Its return value is the value read.
+	UnmappedRead func(addr uint16) byte
+
+	// UnmappedWrite, if set, is called when an unmapped address is
+	// written.
+	UnmappedWrite func(addr uint16, v byte)
+
+	regions []region         // mapped regions, indexed by map[addr]-1
+	m       [64 * 1024]uint8 // region index of each address (0 = unmapped)
+	last    byte             // last value transferred on the bus
+}
+
+type region struct {
+	start  uint16
+	device Device
+}
+
+// NewBus creates a new bus with no devices mapped.
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Map attaches a device to the bus over the address range start through
+// end, inclusive. The new device replaces any device previously mapped over
+// the same addresses. At most 255 regions may be mapped at once. An empty
+// RAM, ROM or mirror device can't be mapped.
+func (b *Bus) Map(start, end uint16, d Device) error {
+	if end < start || d == nil || emptyDevice(d) {
+		return ErrInvalidRegion
+	}
+	if len(b.regions) == 255 && !b.replaces(start, end) {
+		return ErrTooManyRegions
+	}
+
+	b.Unmap(start, end)
+	b.regions = append(b.regions, region{start: start, device: d})
+	index := uint8(len(b.regions))
+	for a := int(start); a <= int(end); a++ {
+		b.m[a] = index
+	}
+	return nil
+}
+
+// Unmap detaches all devices from the address range start through end,
+// inclusive.
+func (b *Bus) Unmap(start, end uint16) {
+	for a := int(start); a <= int(end); a++ {
+		b.m[a] = 0
+	}
+	b.compact()
+}
+
+// Return true if mapping over the address range would leave a region with
+// no addresses mapped to it.
+func (b *Bus) replaces(start, end uint16) bool {
+	var used [256]bool
+	for a, i := range b.m {
+		if a < int(start) || a > int(end) {
+			used[i] = true
+		}
+	}
+	for i := range b.regions {
+		if !used[i+1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the regions that no longer have any addresses mapped to them, so
+// that their slots may be reused. The remaining regions keep their order.
+func (b *Bus) compact() {
+	var used [256]bool
+	for _, i := range b.m {
+		used[i] = true
+	}
+
+	var index [256]uint8
+	regions := b.regions[:0]
+	for i, r := range b.regions {
+		if used[i+1] {
+			regions = append(regions, r)
+			index[i+1] = uint8(len(regions))
+		}
+	}
+	if len(regions) == len(b.regions) {
+		return
+	}
+
+	for i := len(regions); i < len(b.regions); i++ {
+		b.regions[i] = region{}
+	}
+	b.regions = regions
+	for a, i := range b.m {
+		b.m[a] = index[i]
+	}
+}
+
+// Return true if the device is a RAM, ROM or mirror with no bytes, which
+// can't be accessed.
+func emptyDevice(d Device) bool {
+	switch d := d.(type) {
+	case *RAM:
+		return len(d.b) == 0
+	case *ROM:
+		return len(d.b) == 0
+	case *mirror:
+		return d.size == 0 || emptyDevice(d.device)
+	default:
+		return false
+	}
+}
+
+// Device returns the device mapped at the address, or nil if the address is
+// unmapped.
+func (b *Bus) Device(addr uint16) Device {
+	if i := b.m[addr]; i != 0 {
+		return b.regions[i-1].device
+	}
+	return nil
+}
+
+// LoadByte loads a single byte from the address and returns it.
+func (b *Bus) LoadByte(addr uint16) byte {
+	i := b.m[addr]
+	switch {
+	case i != 0:
+		r := &b.regions[i-1]
+		b.last = r.device.Read(addr - r.start)
+	case b.UnmappedRead != nil:
+		b.last = b.UnmappedRead(addr)
+	}
+	return b.last
+}
+
+// Peek returns the byte at the address without the side effects of a read,
+// and without changing the open bus value. The result is false if the
+// address is unmapped or its device isn't a Peeker.
+func (b *Bus) Peek(addr uint16) (byte, bool) {
+	if i := b.m[addr]; i != 0 {
+		r := &b.regions[i-1]
+		return peekDevice(r.device, addr-r.start)
+	}
+	return 0, false
+}
+
+// LoadBytes loads multiple bytes from the address and stores them into the
+// buffer 'b'.
+func (b *Bus) LoadBytes(addr uint16, buf []byte) {
+	for i := range buf {
+		buf[i] = b.LoadByte(addr + uint16(i))
+	}
+}
+
+// LoadAddress loads a 16-bit address value from the requested address and
+// returns it. Like FlatMemory, the high byte is read from a page-wrapped
+// address when the address ends in 0xff.
+func (b *Bus) LoadAddress(addr uint16) uint16 {
+	next := addr + 1
+	if (addr & 0xff) == 0xff {
+		next = addr - 0xff
+	}
+	return uint16(b.LoadByte(addr)) | uint16(b.LoadByte(next))<<8
+}
+
+// StoreByte stores a byte to the requested address.
+func (b *Bus) StoreByte(addr uint16, v byte) {
+	b.last = v
+	i := b.m[addr]
+	switch {
+	case i != 0:
+		r := &b.regions[i-1]
+		r.device.Write(addr-r.start, v)
+	case b.UnmappedWrite != nil:
+		b.UnmappedWrite(addr, v)
+	}
+}
+
+// StoreBytes stores multiple bytes to the requested address.
+func (b *Bus) StoreBytes(addr uint16, buf []byte) {
+	for i, v := range buf {
+		b.StoreByte(addr+uint16(i), v)
+	}
+}
+
+// StoreAddress stores a 16-bit address value to the requested address.
+func (b *Bus) StoreAddress(addr uint16, v uint16) {
+	next := addr + 1
+	if (addr & 0xff) == 0xff {
+		next = addr - 0xff
+	}
+	b.StoreByte(addr, byte(v&0xff))
+	b.StoreByte(next, byte(v>>8))
+}
+
+// RAM is a read-write memory device. A RAM device mapped over a region
+// larger than itself is mirrored throughout the region.
+type RAM struct {
+	b []byte
+}
+
+// NewRAM creates a RAM device of the requested size in bytes. A RAM device
+// must have at least one byte to be mapped into a Bus.
+func NewRAM(size int) *RAM {
+	return &RAM{b: make([]byte, size)}
+}
+
+// Bytes returns the contents of the RAM.
+func (r *RAM) Bytes() []byte {
+	return r.b
+}
+
+// Read returns the byte at the offset.
+func (r *RAM) Read(offset uint16) byte {
+	return r.b[int(offset)%len(r.b)]
+}
+
+// Peek returns the byte at the offset.
+func (r *RAM) Peek(offset uint16) byte {
+	return r.b[int(offset)%len(r.b)]
+}
+
+// Write stores a byte at the offset.
+func (r *RAM) Write(offset uint16, v byte) {
+	r.b[int(offset)%len(r.b)] = v
+}
+
+// ROM is a read-only memory device. Writes to it are ignored. A ROM device
+// mapped over a region larger than itself is mirrored throughout the
+// region.
+type ROM struct {
+	b []byte
+}
+
+// NewROM creates a ROM device containing a copy of the image. A ROM device
+// must have at least one byte to be mapped into a Bus.
+func NewROM(image []byte) *ROM {
+	return &ROM{b: append([]byte(nil), image...)}
+}
+
+// Read returns the byte at the offset.
+func (r *ROM) Read(offset uint16) byte {
+	return r.b[int(offset)%len(r.b)]
+}
+
+// Peek returns the byte at the offset.
+func (r *ROM) Peek(offset uint16) byte {
+	return r.b[int(offset)%len(r.b)]
+}
+
+// Write ignores the write.
+func (r *ROM) Write(offset uint16, v byte) {
+}
+
+// IO is a device whose reads and writes are handled by callbacks. It is
+// typically used for the registers of a peripheral. A nil OnRead callback
+// reads as zero, and a nil OnWrite callback ignores writes.
+type IO struct {
+	OnRead  func(offset uint16) byte
+	OnWrite func(offset uint16, v byte)
+}
+
+// Read calls the OnRead callback.
+func (d *IO) Read(offset uint16) byte {
+	if d.OnRead == nil {
+		return 0
+	}
+	return d.OnRead(offset)
+}
+
+// Write calls the OnWrite callback.
+func (d *IO) Write(offset uint16, v byte) {
+	if d.OnWrite != nil {
+		d.OnWrite(offset, v)
+	}
+}
+
+// A mirror repeats a device every 'size' bytes.
+type mirror struct {
+	device Device
+	size   uint16
+}
+
+// NewMirror returns a device that repeats the first 'size' bytes of another
+// device throughout the region it is mapped into. For example, a peripheral
+// with 8 registers mirrored through a 1K region. The size must be at least
+// 1 for the mirror to be mapped into a Bus.
+func NewMirror(d Device, size uint16) Device {
+	return &mirror{device: d, size: size}
+}
+
+func (m *mirror) Read(offset uint16) byte {
+	return m.device.Read(offset % m.size)
+}
+
+func (m *mirror) Write(offset uint16, v byte) {
+	m.device.Write(offset%m.size, v)
+}
@@ -0,0 +1,175 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// CallKind identifies how a call frame was entered.
+type CallKind byte
+
+const (
+	// CallSubroutine is a frame entered by a JSR or JSL instruction.
+	CallSubroutine CallKind = iota
+
+	// CallBreak is a frame entered by a BRK or COP instruction.
+	CallBreak
+
+	// CallInterrupt is a frame entered by an IRQ or NMI.
+	CallInterrupt
+)
+
+// A CallFrame describes a subroutine call or interrupt on a CallStack.
+type CallFrame struct {
+	Kind   CallKind
+	Site   uint16 // address of the call instruction or interrupted instruction
+	Target uint16 // address of the subroutine or interrupt handler
+	Return uint16 // address the frame is expected to return to
+	SP     uint16 // stack pointer after the return address was pushed
+}
+
+// A CallMismatch describes a frame that didn't return the way it was
+// called, usually because the program manipulated the stack.
+type CallMismatch struct {
+	PC        uint16    // address of the return instruction
+	Frame     CallFrame // the frame that didn't return normally
+	Return    uint16    // address the instruction returned to
+	Discarded bool      // the frame was removed from the stack without returning
+}
+
+// A CallStack is a Tracer that maintains a shadow copy of the subroutine
+// calls and interrupts in progress. Attach it to a CPU with AttachTracer.
+//
+// Frames are matched to return instructions by their stack pointers. A
+// return that finds its frame deeper in the stack discards the frames
+// above it, and a return to an address other than the one pushed by the
+// call is reported as a mismatch. A return through an address pushed by
+// the program, as in an RTS jump table, is treated as a jump. Call Clear
+// after resetting the CPU.
+type CallStack struct {
+	Frames     []CallFrame                     // frames in progress, innermost last
+	Mismatches int                             // number of mismatches detected
+	OnMismatch func(cpu *CPU, m *CallMismatch) // optional mismatch callback
+}
+
+// NewCallStack creates an empty call stack.
+func NewCallStack() *CallStack {
+	return &CallStack{}
+}
+
+// Depth returns the number of frames in progress.
+func (cs *CallStack) Depth() int {
+	return len(cs.Frames)
+}
+
+// Clear removes all frames from the call stack.
+func (cs *CallStack) Clear() {
+	cs.Frames = cs.Frames[:0]
+}
+
+// Unwind removes the frames that are no longer on the CPU's stack, as
+// after the CPU's stack pointer has been changed by hand. A History
+// restores the frames of the call stacks attached to the CPU when it
+// rewinds the CPU.
+func (cs *CallStack) Unwind(cpu *CPU) {
+	sp := stackPointer(cpu, &cpu.Reg)
+	for len(cs.Frames) > 0 && cs.top().SP < sp {
+		cs.Frames = cs.Frames[:len(cs.Frames)-1]
+	}
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+func (cs *CallStack) BeforeInstruction(cpu *CPU, t *Trace) {
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (cs *CallStack) AfterInstruction(cpu *CPU, t *Trace) {
+	switch t.Inst.Name {
+	case "JSR", "JSL":
+		cs.push(cpu, CallSubroutine, t.PC, t.PC+uint16(t.Inst.Length))
+	case "BRK", "COP":
+		cs.push(cpu, CallBreak, t.PC, t.PC+2)
+	case "RTS", "RTL", "RTI":
+		cs.pop(cpu, t)
+	}
+}
+
+// OnInterrupt is called by the CPU after it services an IRQ or NMI.
+func (cs *CallStack) OnInterrupt(cpu *CPU, vector uint16) {
+	cs.push(cpu, CallInterrupt, cpu.LastPC, cpu.LastPC)
+}
+
+func (cs *CallStack) push(cpu *CPU, kind CallKind, site, ret uint16) {
+	cs.Frames = append(cs.Frames, CallFrame{
+		Kind:   kind,
+		Site:   site,
+		Target: cpu.Reg.PC,
+		Return: ret,
+		SP:     stackPointer(cpu, &cpu.Reg),
+	})
+}
+
+func (cs *CallStack) pop(cpu *CPU, t *Trace) {
+	sp := stackPointer(cpu, &t.Reg)
+
+	// Frames deeper than the stack pointer were discarded by code that
+	// manipulated the stack.
+	for len(cs.Frames) > 0 && cs.top().SP < sp {
+		f := cs.remove()
+		cs.mismatch(cpu, &CallMismatch{PC: t.PC, Frame: f, Return: cpu.Reg.PC, Discarded: true})
+	}
+
+	if len(cs.Frames) == 0 || cs.top().SP != sp {
+		return
+	}
+
+	f := cs.remove()
+	if cpu.Reg.PC != f.Return {
+		cs.mismatch(cpu, &CallMismatch{PC: t.PC, Frame: f, Return: cpu.Reg.PC})
+	}
+}
+
+// Remove the innermost frame and return it.
+func (cs *CallStack) remove() CallFrame {
+	f := cs.Frames[len(cs.Frames)-1]
+	cs.Frames = cs.Frames[:len(cs.Frames)-1]
+	return f
+}
+
+// The state of a CallStack saved by a History.
+type callStackState struct {
+	frames []CallFrame
+}
+
+// Save a copy of the frames for a History.
+func (cs *CallStack) saveState(buf interface{}) interface{} {
+	s, _ := buf.(*callStackState)
+	if s == nil {
+		s = &callStackState{}
+	}
+	s.frames = append(s.frames[:0], cs.Frames...)
+	return s
+}
+
+// Restore the frames saved by saveState.
+func (cs *CallStack) restoreState(s interface{}) {
+	cs.Frames = append(cs.Frames[:0], s.(*callStackState).frames...)
+}
+
+func (cs *CallStack) top() *CallFrame {
+	return &cs.Frames[len(cs.Frames)-1]
+}
+
+func (cs *CallStack) mismatch(cpu *CPU, m *CallMismatch) {
+	cs.Mismatches++
+	if cs.OnMismatch != nil {
+		cs.OnMismatch(cpu, m)
+	}
+}
+
+// Return the full address of the stack pointer in the registers.
+func stackPointer(cpu *CPU, r *Registers) uint16 {
+	if cpu.Arch == W65C816 {
+		return r.S16()
+	}
+	return stackAddress(r.SP)
+}
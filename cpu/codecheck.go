@@ -0,0 +1,184 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// CodeFaultKind identifies the kind of problem found by a CodeChecker.
+type CodeFaultKind byte
+
+const (
+	// CodeModified indicates an instruction byte was written.
+	CodeModified CodeFaultKind = iota
+
+	// DataExecuted indicates the program counter entered a data range.
+	DataExecuted
+)
+
+// A CodeFault describes a problem found by a CodeChecker.
+type CodeFault struct {
+	Kind    CodeFaultKind
+	PC      uint16 // address of the responsible instruction
+	Address uint16 // address of the byte written, or of the data entered
+	Action  CodeAction
+}
+
+// A CodeAction selects what a CodeChecker does when it finds a fault at an
+// address.
+type CodeAction byte
+
+const (
+	// CodeStop reports the fault and stops the CPU's Run function.
+	CodeStop CodeAction = iota
+
+	// CodeReport reports the fault without stopping.
+	CodeReport
+
+	// CodeIgnore ignores the fault, as for intentionally self-modifying
+	// code.
+	CodeIgnore
+)
+
+// The kind of bytes at an address.
+const (
+	codeUnknown byte = iota
+	codeInstruction
+	codeData
+)
+
+// A CodeChecker knows which addresses hold instructions and which hold
+// data, typically from the code ranges of an assembler source map, and
+// finds code that writes to instruction bytes or jumps, branches or falls
+// into data. It is both a Tracer and a MemoryHook, and must be attached to
+// a CPU with both AttachTracer and AttachMemoryHook.
+//
+// The action taken for a fault depends on the address written or entered.
+// By default each fault stops Run with StopCodeFault, after the responsible
+// instruction has executed. Addresses that were never marked are never
+// faulted. The checker tracks a single 64K address space, so it doesn't
+// distinguish the banks of a Banked memory.
+type CodeChecker struct {
+	OnFault func(cpu *CPU, f *CodeFault) // optional fault callback
+
+	kinds     [0x10000]byte       // kind of the byte at each address
+	actions   [0x10000]CodeAction // action taken for each address
+	pc        uint16              // address of the executing instruction
+	executing bool                // an instruction is executing
+}
+
+// NewCodeChecker creates a code checker with no addresses marked.
+func NewCodeChecker() *CodeChecker {
+	return &CodeChecker{}
+}
+
+// Clear forgets all marked addresses and actions.
+func (cc *CodeChecker) Clear() {
+	cc.kinds = [0x10000]byte{}
+	cc.actions = [0x10000]CodeAction{}
+}
+
+// MarkInstructions marks 'n' bytes starting at 'addr' as instruction bytes.
+func (cc *CodeChecker) MarkInstructions(addr uint16, n int) {
+	cc.mark(addr, n, codeInstruction)
+}
+
+// MarkData marks 'n' bytes starting at 'addr' as data.
+func (cc *CodeChecker) MarkData(addr uint16, n int) {
+	cc.mark(addr, n, codeData)
+}
+
+// Unmark forgets whether 'n' bytes starting at 'addr' hold instructions or
+// data.
+func (cc *CodeChecker) Unmark(addr uint16, n int) {
+	cc.mark(addr, n, codeUnknown)
+}
+
+// IsInstruction returns true if the address is marked as an instruction
+// byte.
+func (cc *CodeChecker) IsInstruction(addr uint16) bool {
+	return cc.kinds[addr] == codeInstruction
+}
+
+// IsData returns true if the address is marked as data.
+func (cc *CodeChecker) IsData(addr uint16) bool {
+	return cc.kinds[addr] == codeData
+}
+
+// SetAction sets the action taken for faults at 'n' addresses starting at
+// 'addr'.
+func (cc *CodeChecker) SetAction(addr uint16, n int, action CodeAction) {
+	for i := 0; i < n && i < 0x10000; i++ {
+		cc.actions[addr+uint16(i)] = action
+	}
+}
+
+// Action returns the action taken for faults at the address.
+func (cc *CodeChecker) Action(addr uint16) CodeAction {
+	return cc.actions[addr]
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+func (cc *CodeChecker) BeforeInstruction(cpu *CPU, t *Trace) {
+	cc.pc, cc.executing = t.PC, true
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (cc *CodeChecker) AfterInstruction(cpu *CPU, t *Trace) {
+	cc.executing = false
+
+	// Execution within a data range was reported when it was entered.
+	if cc.IsData(cpu.Reg.PC) && !cc.IsData(t.PC) {
+		cc.fault(cpu, CodeFault{Kind: DataExecuted, PC: t.PC, Address: cpu.Reg.PC})
+	}
+}
+
+// OnInterrupt is called by the CPU after it services an IRQ or NMI.
+func (cc *CodeChecker) OnInterrupt(cpu *CPU, vector uint16) {
+	if cc.IsData(cpu.Reg.PC) {
+		cc.fault(cpu, CodeFault{Kind: DataExecuted, PC: cpu.LastPC, Address: cpu.Reg.PC})
+	}
+}
+
+// OnRead is called after a byte is read from memory.
+func (cc *CodeChecker) OnRead(cpu *CPU, addr uint16, v byte) {
+}
+
+// OnWrite is called after a byte is written to memory.
+func (cc *CodeChecker) OnWrite(cpu *CPU, addr uint16, v byte) {
+	if !cc.IsInstruction(addr) {
+		return
+	}
+	pc := cpu.LastPC
+	if cc.executing {
+		pc = cc.pc
+	}
+	cc.fault(cpu, CodeFault{Kind: CodeModified, PC: pc, Address: addr})
+}
+
+// Set the kind of the 'n' bytes starting at 'addr'. A range that runs past
+// $FFFF wraps to address 0, and no byte is marked more than once.
+func (cc *CodeChecker) mark(addr uint16, n int, kind byte) {
+	for i := 0; i < n && i < 0x10000; i++ {
+		cc.kinds[addr+uint16(i)] = kind
+	}
+}
+
+// Report a fault according to the action for its address. A fault that
+// stops the CPU's Run function is kept unless another one already stopped
+// it.
+func (cc *CodeChecker) fault(cpu *CPU, f CodeFault) {
+	f.Action = cc.actions[f.Address]
+	if f.Action == CodeIgnore {
+		return
+	}
+	if cc.OnFault != nil {
+		cc.OnFault(cpu, &f)
+	}
+	if f.Action != CodeStop || cpu.hit.Code != nil {
+		return
+	}
+	cpu.hit.Code = &f
+	if cpu.hit.DataBreakpoint == nil && cpu.hit.Watch == nil && cpu.hit.Stack == nil {
+		cpu.hit.Reason = StopCodeFault
+	}
+}
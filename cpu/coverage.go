@@ -0,0 +1,82 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// A Coverage is a Tracer that counts how many times the instruction at each
+// address executed, and how many times each conditional branch was taken
+// and not taken. Attach it to a CPU with AttachTracer.
+//
+// Addresses are counted separately for each bank of a Banked memory.
+type Coverage struct {
+	counts   map[int]*[0x10000]uint64 // execution counts of each bank
+	branches map[int]*BranchCount     // branch counts by bank and address
+}
+
+// A BranchCount holds the number of times a conditional branch was taken
+// and not taken.
+type BranchCount struct {
+	Taken    uint64
+	NotTaken uint64
+}
+
+// NewCoverage creates an empty coverage counter.
+func NewCoverage() *Coverage {
+	c := &Coverage{}
+	c.Clear()
+	return c
+}
+
+// Clear resets all counts to zero.
+func (c *Coverage) Clear() {
+	c.counts = make(map[int]*[0x10000]uint64)
+	c.branches = make(map[int]*BranchCount)
+}
+
+// Count returns the number of times the instruction at the address
+// executed.
+func (c *Coverage) Count(addr uint16, bank int) uint64 {
+	if counts, ok := c.counts[bank]; ok {
+		return counts[addr]
+	}
+	return 0
+}
+
+// Branch returns the counts of the conditional branch at the address. It
+// returns false if no conditional branch at the address executed.
+func (c *Coverage) Branch(addr uint16, bank int) (BranchCount, bool) {
+	if b, ok := c.branches[bank<<16|int(addr)]; ok {
+		return *b, true
+	}
+	return BranchCount{}, false
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+func (c *Coverage) BeforeInstruction(cpu *CPU, t *Trace) {
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (c *Coverage) AfterInstruction(cpu *CPU, t *Trace) {
+	counts, ok := c.counts[t.Bank]
+	if !ok {
+		counts = new([0x10000]uint64)
+		c.counts[t.Bank] = counts
+	}
+	counts[t.PC]++
+
+	if t.Inst.ConditionalBranch() {
+		key := t.Bank<<16 | int(t.PC)
+		b, ok := c.branches[key]
+		if !ok {
+			b = &BranchCount{}
+			c.branches[key] = b
+		}
+		switch cpu.Reg.PC != t.PC+uint16(t.Inst.Length) {
+		case true:
+			b.Taken++
+		case false:
+			b.NotTaken++
+		}
+	}
+}
@@ -6,6 +6,8 @@
 // set and emulator.
 package cpu
 
+import "fmt"
+
 // Architecture selects the CPU chip: 6502 or 65c02
 type Architecture byte
 
@@ -15,8 +17,34 @@ const (
 
 	// CMOS 65c02 CPU
 	CMOS
+
+	// NMOS 6502 CPU with support for the stable undocumented instructions
+	NMOSX
+
+	// WDC W65C02S CPU, a CMOS 65c02 with the bit manipulation instructions
+	// and the WAI and STP instructions
+	WDC
+
+	// WDC W65C816S CPU
+	W65C816
+
+	// Ricoh 2A03 CPU, an NMOS 6502 that ignores the Decimal flag
+	R2A03
+
+	numArchitectures
 )
 
+// Return the base architecture whose instruction implementations are
+// shared by the architecture.
+func (arch Architecture) base() Architecture {
+	switch arch {
+	case CMOS, WDC, W65C816:
+		return CMOS
+	default:
+		return NMOS
+	}
+}
+
 // CPU represents a single 6502 CPU. It contains a pointer to the
 // memory associated with the CPU.
 type CPU struct {
@@ -30,8 +58,74 @@ type CPU struct {
 	deltaCycles int8
 	debugger    *Debugger
 	storeByte   func(cpu *CPU, addr uint16, v byte)
+	irq         IRQSource  // IRQ sources currently asserting the IRQ line
+	nmiLine     bool       // current level of the NMI input
+	nmi         bool       // NMI edge latch
+	irqDelayed  bool       // the next IRQ poll sees irqMasked, not the InterruptDisable flag
+	irqMasked   bool       // InterruptDisable flag before CLI, SEI or PLP changed it
+	stopped     bool       // CPU stopped until reset
+	waiting     bool       // CPU waiting for an interrupt
+	halted      bool       // CPU halted by an undefined opcode
+	fault       error      // undefined opcode that halted or jammed the CPU
+	tick        tickState  // state of the instruction being ticked
+	longMem     LongMemory // 24-bit view of memory (65c816 only)
+	hit         Stop       // breakpoint hit by the last step
+	tracers     []Tracer   // attached instruction tracers
+	memHooks    []MemoryHook
+	trace       Trace    // instruction being traced
+	history     *History // attached execution history
+
+	// Undefined selects how undefined opcodes are handled, and OnUndefined
+	// is the callback used by the UndefinedTrap policy.
+	Undefined   UndefinedPolicy
+	OnUndefined func(cpu *CPU, inst *Instruction)
+}
+
+// UndefinedPolicy selects how the CPU handles an undefined opcode, which is
+// any opcode with no instruction on the CPU's architecture.
+type UndefinedPolicy byte
+
+const (
+	// UndefinedIgnore executes an undefined opcode as a NOP that consumes
+	// the opcode's operand bytes and cycles. This is the default.
+	UndefinedIgnore UndefinedPolicy = iota
+
+	// UndefinedHalt halts the CPU before the undefined opcode executes.
+	// A halted CPU consumes no cycles until it is reset. The opcode is
+	// reported by Fault.
+	UndefinedHalt
+
+	// UndefinedJam locks up the CPU as the NMOS JAM instructions do. The
+	// CPU continues to run clock cycles, but does nothing else until it is
+	// reset. The opcode is reported by Fault.
+	UndefinedJam
+
+	// UndefinedTrap calls the CPU's OnUndefined callback before the
+	// undefined opcode executes, with the program counter still addressing
+	// the opcode. If the callback leaves the program counter unchanged, the
+	// opcode is then ignored.
+	UndefinedTrap
+
+	// UndefinedReset performs the CPU's reset sequence.
+	UndefinedReset
+)
+
+// An UndefinedOpcodeError describes an undefined opcode encountered by the
+// CPU.
+type UndefinedOpcodeError struct {
+	Opcode  byte   // the undefined opcode
+	Address uint16 // address of the opcode
+}
+
+func (e *UndefinedOpcodeError) Error() string {
+	return fmt.Sprintf("undefined opcode $%02X at $%04X", e.Opcode, e.Address)
 }
 
+// An IRQSource is a bit mask identifying one or more devices that drive the
+// CPU's shared IRQ line. Each device should use its own bit, so that the
+// line remains asserted until every device has released it.
+type IRQSource uint32
+
 // Interrupt vectors
 const (
 	vectorNMI   = 0xfffa
@@ -40,6 +134,13 @@ const (
 	vectorBRK   = 0xfffe
 )
 
+// Number of CPU cycles consumed by an interrupt or reset sequence.
+const interruptCycles = 7
+
+// The value ORed with the accumulator by the unstable NMOS ANE and LXA
+// instructions. It varies between chips; this is the most common value.
+const unstableMagic = 0xee
+
 // NewCPU creates an emulated 6502 CPU bound to the specified memory.
 func NewCPU(arch Architecture, m Memory) *CPU {
 	cpu := &CPU{
@@ -49,6 +150,16 @@ func NewCPU(arch Architecture, m Memory) *CPU {
 		storeByte: (*CPU).storeByteNormal,
 	}
 
+	if arch == W65C816 {
+		// Memory that doesn't span the 24-bit address space is mirrored
+		// into every bank.
+		if lm, ok := m.(LongMemory); ok {
+			cpu.longMem = lm
+		} else {
+			cpu.longMem = mirroredMemory{m}
+		}
+	}
+
 	cpu.Reg.Init()
 	return cpu
 }
@@ -64,17 +175,68 @@ func (cpu *CPU) GetInstruction(addr uint16) *Instruction {
 	return cpu.instSet.Lookup(opcode)
 }
 
-// Step the cpu by one instruction.
+// Return the bank mapped at the address if the CPU's memory is banked.
+func (cpu *CPU) bank(addr uint16) int {
+	if m, ok := cpu.Mem.(Banked); ok {
+		return m.Bank(addr)
+	}
+	return 0
+}
+
+// Step the cpu by one instruction. If an interrupt is pending when Step is
+// called, the interrupt sequence is executed instead of the next
+// instruction.
 func (cpu *CPU) Step() {
+	// Complete an instruction partially executed by Tick.
+	if cpu.tick.busy {
+		for cpu.tick.busy {
+			cpu.Tick()
+		}
+		return
+	}
+
+	// A halted CPU does nothing until it is reset.
+	if cpu.halted {
+		return
+	}
+
+	// A stopped CPU continues to run clock cycles but does nothing else
+	// until it is reset.
+	if cpu.stopped {
+		cpu.Cycles++
+		return
+	}
+
+	// A waiting CPU continues to run clock cycles until an interrupt is
+	// signaled. If the IRQ is masked, execution resumes with the next
+	// instruction without servicing the interrupt.
+	if cpu.waiting {
+		if !cpu.nmi && cpu.irq == 0 {
+			cpu.Cycles++
+			return
+		}
+		cpu.waiting = false
+	}
+
+	// Sample the interrupt inputs between instructions.
+	if cpu.nmi || cpu.irqPending() {
+		cpu.serviceInterrupt()
+		return
+	}
+
+	if cpu.Arch == W65C816 {
+		cpu.step65816()
+		return
+	}
+
 	// Grab the next opcode at the current PC
-	opcode := cpu.Mem.LoadByte(cpu.Reg.PC)
+	opcode := cpu.fetch(cpu.Reg.PC)
 
 	// Look up the instruction data for the opcode
 	inst := cpu.instSet.Lookup(opcode)
 
-	// If the instruction is undefined, reset the CPU (for now).
-	if inst.fn == nil {
-		cpu.reset()
+	// Handle an undefined instruction according to the CPU's policy.
+	if inst.Undefined() && !cpu.handleUndefined(inst) {
 		return
 	}
 
@@ -82,12 +244,19 @@ func (cpu *CPU) Step() {
 	var buf [2]byte
 	operand := buf[:inst.Length-1]
 	cpu.Mem.LoadBytes(cpu.Reg.PC+1, operand)
+	if cpu.memHooks != nil {
+		cpu.onFetch(cpu.Reg.PC+1, operand)
+	}
+	if cpu.tracers != nil {
+		cpu.traceBefore(inst, operand)
+	}
 	cpu.LastPC = cpu.Reg.PC
 	cpu.Reg.PC += uint16(inst.Length)
 
 	// Execute the instruction
 	cpu.pageCrossed = false
 	cpu.deltaCycles = 0
+	cpu.irqDelayed = false
 	inst.fn(cpu, inst, operand)
 
 	// Update the CPU cycle counter, with special-case logic
@@ -97,64 +266,212 @@ func (cpu *CPU) Step() {
 		cpu.Cycles += uint64(inst.BPCycles)
 	}
 
+	if cpu.tracers != nil {
+		cpu.traceAfter()
+	}
+
 	// Update the debugger so it handle breakpoints.
 	if cpu.debugger != nil {
 		cpu.debugger.onUpdatePC(cpu, cpu.Reg.PC)
 	}
 }
 
+// AssertIRQ asserts the maskable interrupt request line on behalf of the
+// interrupt source(s) 'src'. The IRQ line is level-triggered and shared, so
+// it stays asserted, and the interrupt stays pending, until all sources
+// have released it with ReleaseIRQ. A pending IRQ is serviced before the
+// next instruction whenever the InterruptDisable flag is clear. As on the
+// real CPU, a change to the flag by CLI, SEI or PLP takes effect one
+// instruction late: the instruction following CLI runs before the IRQ is
+// serviced, and an IRQ pending when SEI runs is still serviced after it.
+func (cpu *CPU) AssertIRQ(src IRQSource) {
+	cpu.irq |= src
+}
+
+// ReleaseIRQ releases the IRQ line on behalf of the interrupt source(s)
+// 'src'. Devices typically call this when the interrupt handler
+// acknowledges the interrupt.
+func (cpu *CPU) ReleaseIRQ(src IRQSource) {
+	cpu.irq &^= src
+}
+
+// IRQ returns the set of interrupt sources currently asserting the IRQ
+// line.
+func (cpu *CPU) IRQ() IRQSource {
+	return cpu.irq
+}
+
+// SetNMI sets the level of the non-maskable interrupt input. The NMI input
+// is edge-triggered: a transition from released to asserted latches a
+// pending NMI, which is serviced before the next instruction. Holding the
+// line asserted does not generate further interrupts.
+func (cpu *CPU) SetNMI(asserted bool) {
+	if asserted && !cpu.nmiLine {
+		cpu.nmi = true
+	}
+	cpu.nmiLine = asserted
+}
+
+// TriggerNMI pulses the non-maskable interrupt input, latching a pending
+// NMI.
+func (cpu *CPU) TriggerNMI() {
+	cpu.SetNMI(true)
+	cpu.SetNMI(false)
+}
+
+// Stopped returns true if the CPU has stopped executing instructions, for
+// example because of a JAM instruction. A stopped CPU resumes only when it
+// is reset.
+func (cpu *CPU) Stopped() bool {
+	return cpu.stopped
+}
+
+// Halted returns true if the CPU has been halted by an undefined opcode
+// under the UndefinedHalt policy.
+func (cpu *CPU) Halted() bool {
+	return cpu.halted
+}
+
+// Fault returns an *UndefinedOpcodeError describing the undefined opcode
+// that halted or jammed the CPU, or nil if the CPU hasn't been halted or
+// jammed by one. The fault is cleared by Reset.
+func (cpu *CPU) Fault() error {
+	return cpu.fault
+}
+
+// Handle the undefined instruction at the program counter according to the
+// CPU's undefined opcode policy. Return true if the instruction should be
+// executed.
+func (cpu *CPU) handleUndefined(inst *Instruction) bool {
+	switch cpu.Undefined {
+	case UndefinedHalt:
+		cpu.halted = true
+		cpu.fault = &UndefinedOpcodeError{Opcode: inst.Opcode, Address: cpu.Reg.PC}
+		return false
+
+	case UndefinedJam:
+		cpu.stopped = true
+		cpu.fault = &UndefinedOpcodeError{Opcode: inst.Opcode, Address: cpu.Reg.PC}
+		cpu.Cycles++
+		return false
+
+	case UndefinedTrap:
+		pc := cpu.Reg.PC
+		if cpu.OnUndefined != nil {
+			cpu.OnUndefined(cpu, inst)
+		}
+		return cpu.Reg.PC == pc
+
+	case UndefinedReset:
+		cpu.Reset()
+		return false
+
+	default:
+		return true
+	}
+}
+
+// Waiting returns true if the CPU is waiting for an interrupt after
+// executing a WAI instruction.
+func (cpu *CPU) Waiting() bool {
+	return cpu.waiting
+}
+
+// Reset performs the CPU's reset sequence. Any latched NMI is discarded,
+// the stack pointer is decremented by 3 without writing to memory,
+// interrupts are disabled, and the program counter is loaded from the
+// reset vector.
+func (cpu *CPU) Reset() {
+	cpu.tick.busy = false
+	cpu.tick.queue = nil
+	cpu.stopped = false
+	cpu.waiting = false
+	cpu.halted = false
+	cpu.fault = nil
+	cpu.nmi = false
+	cpu.irqDelayed = false
+	if cpu.Arch == W65C816 {
+		cpu.reset65816()
+	}
+	cpu.Reg.SP -= 3
+	cpu.Reg.InterruptDisable = true
+	if cpu.Arch.base() == CMOS {
+		cpu.Reg.Decimal = false
+	}
+	cpu.Reg.PC = cpu.readAddress(vectorReset)
+	cpu.Cycles += interruptCycles
+}
+
 // AttachDebugger attaches a debugger to the CPU. The debugger receives
 // notifications whenever the CPU executes an instruction or stores a byte
 // to memory.
 func (cpu *CPU) AttachDebugger(debugger *Debugger) {
 	cpu.debugger = debugger
-	cpu.storeByte = (*CPU).storeByteDebugger
+	cpu.selectStoreByte()
 }
 
 // DetachDebugger detaches the currently debugger from the CPU.
 func (cpu *CPU) DetachDebugger() {
 	cpu.debugger = nil
-	cpu.storeByte = (*CPU).storeByteNormal
+	cpu.selectStoreByte()
+}
+
+// Select the function used to store bytes, so that stores are only
+// observed when a debugger, memory hook or history is attached.
+func (cpu *CPU) selectStoreByte() {
+	if cpu.debugger != nil || cpu.memHooks != nil || cpu.history != nil {
+		cpu.storeByte = (*CPU).storeByteHooked
+	} else {
+		cpu.storeByte = (*CPU).storeByteNormal
+	}
 }
 
 // Load a byte value from using the requested addressing mode
 // and the operand to determine where to load it from.
 func (cpu *CPU) load(mode Mode, operand []byte) byte {
+	if cpu.tick.latched && mode != IMM && mode != ACC {
+		return cpu.tick.data
+	}
+
 	switch mode {
 	case IMM:
 		return operand[0]
 	case ZPG:
 		zpaddr := operandToAddress(operand)
-		return cpu.Mem.LoadByte(zpaddr)
+		return cpu.read(zpaddr)
 	case ZPX:
 		zpaddr := operandToAddress(operand)
 		zpaddr = offsetZeroPage(zpaddr, cpu.Reg.X)
-		return cpu.Mem.LoadByte(zpaddr)
+		return cpu.read(zpaddr)
 	case ZPY:
 		zpaddr := operandToAddress(operand)
 		zpaddr = offsetZeroPage(zpaddr, cpu.Reg.Y)
-		return cpu.Mem.LoadByte(zpaddr)
+		return cpu.read(zpaddr)
 	case ABS:
 		addr := operandToAddress(operand)
-		return cpu.Mem.LoadByte(addr)
+		return cpu.read(addr)
 	case ABX:
 		addr := operandToAddress(operand)
 		addr, cpu.pageCrossed = offsetAddress(addr, cpu.Reg.X)
-		return cpu.Mem.LoadByte(addr)
+		return cpu.read(addr)
 	case ABY:
 		addr := operandToAddress(operand)
 		addr, cpu.pageCrossed = offsetAddress(addr, cpu.Reg.Y)
-		return cpu.Mem.LoadByte(addr)
+		return cpu.read(addr)
 	case IDX:
 		zpaddr := operandToAddress(operand)
 		zpaddr = offsetZeroPage(zpaddr, cpu.Reg.X)
-		addr := cpu.Mem.LoadAddress(zpaddr)
-		return cpu.Mem.LoadByte(addr)
+		addr := cpu.readAddress(zpaddr)
+		return cpu.read(addr)
 	case IDY:
 		zpaddr := operandToAddress(operand)
-		addr := cpu.Mem.LoadAddress(zpaddr)
+		addr := cpu.readAddress(zpaddr)
 		addr, cpu.pageCrossed = offsetAddress(addr, cpu.Reg.Y)
-		return cpu.Mem.LoadByte(addr)
+		return cpu.read(addr)
+	case IND:
+		zpaddr := operandToAddress(operand)
+		addr := cpu.readAddress(zpaddr)
+		return cpu.read(addr)
 	case ACC:
 		return cpu.Reg.A
 	default:
@@ -170,7 +487,10 @@ func (cpu *CPU) loadAddress(mode Mode, operand []byte) uint16 {
 		return operandToAddress(operand)
 	case IND:
 		addr := operandToAddress(operand)
-		return cpu.Mem.LoadAddress(addr)
+		return cpu.readAddress(addr)
+	case ABX:
+		addr := operandToAddress(operand) + uint16(cpu.Reg.X)
+		return uint16(cpu.read(addr)) | uint16(cpu.read(addr+1))<<8
 	default:
 		panic("Invalid addressing mode")
 	}
@@ -179,6 +499,11 @@ func (cpu *CPU) loadAddress(mode Mode, operand []byte) uint16 {
 // Store a byte value using the specified addressing mode and the
 // variable-sized instruction operand to determine where to store it.
 func (cpu *CPU) store(mode Mode, operand []byte, v byte) {
+	if cpu.tick.latched && mode != ACC {
+		cpu.tick.result = v
+		return
+	}
+
 	switch mode {
 	case ZPG:
 		zpaddr := operandToAddress(operand)
@@ -205,13 +530,17 @@ func (cpu *CPU) store(mode Mode, operand []byte, v byte) {
 	case IDX:
 		zpaddr := operandToAddress(operand)
 		zpaddr = offsetZeroPage(zpaddr, cpu.Reg.X)
-		addr := cpu.Mem.LoadAddress(zpaddr)
+		addr := cpu.readAddress(zpaddr)
 		cpu.storeByte(cpu, addr, v)
 	case IDY:
 		zpaddr := operandToAddress(operand)
-		addr := cpu.Mem.LoadAddress(zpaddr)
+		addr := cpu.readAddress(zpaddr)
 		addr, cpu.pageCrossed = offsetAddress(addr, cpu.Reg.Y)
 		cpu.storeByte(cpu, addr, v)
+	case IND:
+		zpaddr := operandToAddress(operand)
+		addr := cpu.readAddress(zpaddr)
+		cpu.storeByte(cpu, addr, v)
 	case ACC:
 		cpu.Reg.A = v
 	default:
@@ -219,6 +548,41 @@ func (cpu *CPU) store(mode Mode, operand []byte, v byte) {
 	}
 }
 
+// Store a byte value ANDed with the high byte of the unindexed target
+// address plus one. This mimics the unstable NMOS SHA, SHX, SHY and TAS
+// instructions. If indexing crosses a page boundary, the stored value
+// also replaces the high byte of the target address.
+func (cpu *CPU) storeHighAnd(mode Mode, operand []byte, v byte) {
+	if cpu.tick.latched {
+		v &= byte(cpu.tick.base>>8) + 1
+		if cpu.tick.crossed {
+			cpu.tick.addr = uint16(v)<<8 | (cpu.tick.addr & 0xff)
+		}
+		cpu.tick.result = v
+		return
+	}
+
+	var addr uint16
+	var index byte
+	switch mode {
+	case ABX:
+		addr, index = operandToAddress(operand), cpu.Reg.X
+	case ABY:
+		addr, index = operandToAddress(operand), cpu.Reg.Y
+	case IDY:
+		addr, index = cpu.readAddress(operandToAddress(operand)), cpu.Reg.Y
+	default:
+		panic("Invalid addressing mode")
+	}
+
+	v &= byte(addr>>8) + 1
+	addr, cpu.pageCrossed = offsetAddress(addr, index)
+	if cpu.pageCrossed {
+		addr = uint16(v)<<8 | (addr & 0xff)
+	}
+	cpu.storeByte(cpu, addr, v)
+}
+
 // Execute a branch using the instruction operand.
 func (cpu *CPU) branch(operand []byte) {
 	offset := operandToAddress(operand)
@@ -229,7 +593,7 @@ func (cpu *CPU) branch(operand []byte) {
 		cpu.Reg.PC -= uint16(0x100 - offset)
 	}
 	cpu.deltaCycles++
-	if ((cpu.Reg.PC ^ oldPC) & 0xff00) != 0 {
+	if ((cpu.Reg.PC^oldPC)&0xff00) != 0 && (cpu.Arch != W65C816 || cpu.Reg.Emulation) {
 		cpu.deltaCycles++
 	}
 }
@@ -239,14 +603,31 @@ func (cpu *CPU) storeByteNormal(addr uint16, v byte) {
 	cpu.Mem.StoreByte(addr, v)
 }
 
-// Store the byte value 'v' add the address 'addr'.
-func (cpu *CPU) storeByteDebugger(addr uint16, v byte) {
-	cpu.debugger.onDataStore(cpu, addr, v)
+// Store the byte value 'v' add the address 'addr', notifying the debugger
+// and memory hooks.
+func (cpu *CPU) storeByteHooked(addr uint16, v byte) {
+	if cpu.debugger != nil {
+		cpu.debugger.onDataStore(cpu, addr, v)
+		if cpu.debugger.watchpoints != nil {
+			cpu.debugger.onWatchStore(cpu, addr, v)
+		}
+	}
+	if cpu.history != nil {
+		cpu.history.onStore(cpu.Mem, addr)
+	}
 	cpu.Mem.StoreByte(addr, v)
+	for _, h := range cpu.memHooks {
+		h.OnWrite(cpu, addr, v)
+	}
 }
 
 // Push a value 'v' onto the stack.
 func (cpu *CPU) push(v byte) {
+	if cpu.tick.latched {
+		cpu.tick.addr, cpu.tick.result = stackAddress(cpu.Reg.SP), v
+		cpu.Reg.SP--
+		return
+	}
 	cpu.storeByte(cpu, stackAddress(cpu.Reg.SP), v)
 	cpu.Reg.SP--
 }
@@ -260,7 +641,10 @@ func (cpu *CPU) pushAddress(addr uint16) {
 // Pop a value from the stack and return it.
 func (cpu *CPU) pop() byte {
 	cpu.Reg.SP++
-	return cpu.Mem.LoadByte(stackAddress(cpu.Reg.SP))
+	if cpu.tick.latched {
+		return cpu.tick.data
+	}
+	return cpu.read(stackAddress(cpu.Reg.SP))
 }
 
 // Pop a 16-bit address off the stack.
@@ -280,32 +664,61 @@ func (cpu *CPU) updateNZ(v byte) {
 // flags on the stack. Then switch the program counter to the requested
 // address.
 func (cpu *CPU) handleInterrupt(brk bool, addr uint16) {
+	cpu.irqDelayed = false
+	if cpu.Arch == W65C816 {
+		cpu.handleInterrupt65816(brk, addr)
+		return
+	}
+
 	cpu.pushAddress(cpu.Reg.PC)
 	cpu.push(cpu.Reg.SavePS(brk))
 
 	cpu.Reg.InterruptDisable = true
-	if cpu.Arch == CMOS {
+	if cpu.Arch.base() == CMOS {
 		cpu.Reg.Decimal = false
 	}
 
-	cpu.Reg.PC = cpu.Mem.LoadAddress(addr)
+	cpu.Reg.PC = cpu.readAddress(addr)
 }
 
-// Generate a maskable IRQ (hardware) interrupt request.
-func (cpu *CPU) irq() {
-	if !cpu.Reg.InterruptDisable {
-		cpu.handleInterrupt(false, vectorIRQ)
+// Return true if a pending IRQ is serviced before the next instruction.
+// The CPU polls the IRQ line before the last cycle of each instruction, so
+// the poll following CLI, SEI or PLP sees the InterruptDisable flag as it
+// was before the instruction changed it.
+func (cpu *CPU) irqPending() bool {
+	masked := cpu.Reg.InterruptDisable
+	if cpu.irqDelayed {
+		masked = cpu.irqMasked
 	}
+	return cpu.irq != 0 && !masked
 }
 
-// Generate a non-maskable interrupt.
-func (cpu *CPU) nmi() {
-	cpu.handleInterrupt(false, vectorNMI)
+// Delay the effect of a change to the InterruptDisable flag on the next
+// IRQ poll. CLI, SEI and PLP call this before changing the flag.
+func (cpu *CPU) delayIRQMask() {
+	cpu.irqDelayed = true
+	cpu.irqMasked = cpu.Reg.InterruptDisable
 }
 
-// Generate a reset signal.
-func (cpu *CPU) reset() {
-	cpu.Reg.PC = cpu.Mem.LoadAddress(vectorReset)
+// Service a pending NMI or IRQ. NMI takes priority over IRQ.
+func (cpu *CPU) serviceInterrupt() {
+	cpu.LastPC = cpu.Reg.PC
+
+	vector := uint16(vectorIRQ)
+	if cpu.nmi {
+		cpu.nmi = false
+		vector = vectorNMI
+	}
+	cpu.handleInterrupt(false, vector)
+	cpu.Cycles += interruptCycles
+
+	if cpu.tracers != nil {
+		cpu.traceInterrupt(vector)
+	}
+
+	if cpu.debugger != nil {
+		cpu.debugger.onUpdatePC(cpu, cpu.Reg.PC)
+	}
 }
 
 // Add with carry (CMOS)
@@ -321,30 +734,20 @@ func (cpu *CPU) adcc(inst *Instruction, operand []byte) {
 	case true:
 		cpu.deltaCycles++
 
+		// Adjust the low digit, then adjust the sum if the high digit
+		// is out of range. The overflow flag reflects the sum before
+		// the high digit is adjusted.
 		lo := (acc & 0x0f) + (add & 0x0f) + carry
-
-		var carrylo uint32
 		if lo >= 0x0a {
-			carrylo = 0x10
-			lo -= 0xa
+			lo = ((lo + 0x06) & 0x0f) + 0x10
 		}
 
-		hi := (acc & 0xf0) + (add & 0xf0) + carrylo
-
-		if hi >= 0xa0 {
-			cpu.Reg.Carry = true
-			if hi >= 0x180 {
-				cpu.Reg.Overflow = false
-			}
-			hi -= 0xa0
-		} else {
-			cpu.Reg.Carry = false
-			if hi < 0x80 {
-				cpu.Reg.Overflow = false
-			}
+		v = (acc & 0xf0) + (add & 0xf0) + lo
+		cpu.Reg.Overflow = ((acc^v)&0x80) != 0 && ((acc^add)&0x80) == 0
+		if v >= 0xa0 {
+			v += 0x60
 		}
-
-		v = hi | lo
+		cpu.Reg.Carry = (v >= 0x100)
 
 	case false:
 		v = acc + add + carry
@@ -367,42 +770,50 @@ func (cpu *CPU) adcc(inst *Instruction, operand []byte) {
 
 // Add with carry (NMOS)
 func (cpu *CPU) adcn(inst *Instruction, operand []byte) {
+	cpu.addn(cpu.load(inst.Mode, operand))
+}
+
+// Return true if the Decimal flag selects BCD arithmetic. The 2A03 has
+// no decimal mode and performs binary arithmetic regardless of the flag.
+func (cpu *CPU) decimalMode() bool {
+	return cpu.Reg.Decimal && cpu.Arch != R2A03
+}
+
+// Add a value and the carry to the accumulator (NMOS)
+func (cpu *CPU) addn(value byte) {
 	acc := uint32(cpu.Reg.A)
-	add := uint32(cpu.load(inst.Mode, operand))
+	add := uint32(value)
 	carry := boolToUint32(cpu.Reg.Carry)
 	var v uint32
 
-	switch cpu.Reg.Decimal {
+	switch cpu.decimalMode() {
 	case true:
+		// Adjust the low digit, then adjust the sum if the high digit
+		// is out of range. The sign and overflow flags reflect the sum
+		// before the high digit is adjusted, and the zero flag reflects
+		// the binary sum.
 		lo := (acc & 0x0f) + (add & 0x0f) + carry
-
-		var carrylo uint32
 		if lo >= 0x0a {
-			carrylo = 0x10
-			lo -= 0x0a
+			lo = ((lo + 0x06) & 0x0f) + 0x10
 		}
 
-		hi := (acc & 0xf0) + (add & 0xf0) + carrylo
-
-		if hi >= 0xa0 {
-			cpu.Reg.Carry = true
-			hi -= 0xa0
-		} else {
-			cpu.Reg.Carry = false
-		}
-
-		v = hi | lo
-
+		v = (acc & 0xf0) + (add & 0xf0) + lo
 		cpu.Reg.Overflow = ((acc^v)&0x80) != 0 && ((acc^add)&0x80) == 0
+		cpu.Reg.Sign = ((v & 0x80) != 0)
+		cpu.Reg.Zero = (byte(acc+add+carry) == 0)
+		if v >= 0xa0 {
+			v += 0x60
+		}
+		cpu.Reg.Carry = (v >= 0x100)
+		cpu.Reg.A = byte(v)
 
 	case false:
 		v = acc + add + carry
 		cpu.Reg.Carry = (v >= 0x100)
 		cpu.Reg.Overflow = (((acc & 0x80) == (add & 0x80)) && ((acc & 0x80) != (v & 0x80)))
+		cpu.Reg.A = byte(v)
+		cpu.updateNZ(cpu.Reg.A)
 	}
-
-	cpu.Reg.A = byte(v)
-	cpu.updateNZ(cpu.Reg.A)
 }
 
 // Boolean AND
@@ -411,6 +822,57 @@ func (cpu *CPU) and(inst *Instruction, operand []byte) {
 	cpu.updateNZ(cpu.Reg.A)
 }
 
+// AND then Logical Shift Right accumulator (undocumented NMOS)
+func (cpu *CPU) alr(inst *Instruction, operand []byte) {
+	v := cpu.Reg.A & cpu.load(inst.Mode, operand)
+	cpu.Reg.Carry = ((v & 1) == 1)
+	cpu.Reg.A = v >> 1
+	cpu.updateNZ(cpu.Reg.A)
+}
+
+// AND with carry set from the sign bit (undocumented NMOS)
+func (cpu *CPU) anc(inst *Instruction, operand []byte) {
+	cpu.Reg.A &= cpu.load(inst.Mode, operand)
+	cpu.updateNZ(cpu.Reg.A)
+	cpu.Reg.Carry = cpu.Reg.Sign
+}
+
+// Transfer X register to Accumulator and AND (undocumented NMOS, unstable)
+func (cpu *CPU) ane(inst *Instruction, operand []byte) {
+	cpu.Reg.A = (cpu.Reg.A | unstableMagic) & cpu.Reg.X & cpu.load(inst.Mode, operand)
+	cpu.updateNZ(cpu.Reg.A)
+}
+
+// AND then Rotate Right accumulator (undocumented NMOS)
+func (cpu *CPU) arr(inst *Instruction, operand []byte) {
+	t := cpu.Reg.A & cpu.load(inst.Mode, operand)
+	v := (t >> 1) | (boolToByte(cpu.Reg.Carry) << 7)
+
+	switch cpu.Reg.Decimal {
+	case true:
+		// The flags come from the binary result, and then each nybble
+		// of the result is BCD-fixed based on the value before rotation.
+		cpu.Reg.Sign = cpu.Reg.Carry
+		cpu.Reg.Zero = (v == 0)
+		cpu.Reg.Overflow = ((t ^ v) & 0x40) != 0
+		lo, hi := t&0x0f, t>>4
+		if lo+(lo&1) > 5 {
+			v = (v & 0xf0) | ((v + 6) & 0x0f)
+		}
+		cpu.Reg.Carry = hi+(hi&1) > 5
+		if cpu.Reg.Carry {
+			v += 0x60
+		}
+
+	case false:
+		cpu.updateNZ(v)
+		cpu.Reg.Carry = ((v & 0x40) != 0)
+		cpu.Reg.Overflow = (((v >> 6) ^ (v >> 5)) & 1) != 0
+	}
+
+	cpu.Reg.A = v
+}
+
 // Arithmetic Shift Left
 func (cpu *CPU) asl(inst *Instruction, operand []byte) {
 	v := cpu.load(inst.Mode, operand)
@@ -418,11 +880,27 @@ func (cpu *CPU) asl(inst *Instruction, operand []byte) {
 	v = v << 1
 	cpu.updateNZ(v)
 	cpu.store(inst.Mode, operand, v)
-	if cpu.Arch == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
+	if cpu.Arch.base() == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
 		cpu.deltaCycles--
 	}
 }
 
+// Branch on Bit Reset (WDC 65c02)
+func (cpu *CPU) bbr(inst *Instruction, operand []byte) {
+	bit := (inst.Opcode >> 4) & 7
+	if cpu.load(ZPG, operand[:1])&(1<<bit) == 0 {
+		cpu.branch(operand[1:])
+	}
+}
+
+// Branch on Bit Set (WDC 65c02)
+func (cpu *CPU) bbs(inst *Instruction, operand []byte) {
+	bit := (inst.Opcode >> 4) & 7
+	if cpu.load(ZPG, operand[:1])&(1<<bit) != 0 {
+		cpu.branch(operand[1:])
+	}
+}
+
 // Branch if Carry Clear
 func (cpu *CPU) bcc(inst *Instruction, operand []byte) {
 	if !cpu.Reg.Carry {
@@ -510,6 +988,7 @@ func (cpu *CPU) cld(inst *Instruction, operand []byte) {
 
 // Clear InterruptDisable flag
 func (cpu *CPU) cli(inst *Instruction, operand []byte) {
+	cpu.delayIRQMask()
 	cpu.Reg.InterruptDisable = false
 }
 
@@ -539,6 +1018,14 @@ func (cpu *CPU) cpy(inst *Instruction, operand []byte) {
 	cpu.updateNZ(cpu.Reg.Y - v)
 }
 
+// Decrement memory value and Compare to accumulator (undocumented NMOS)
+func (cpu *CPU) dcp(inst *Instruction, operand []byte) {
+	v := cpu.load(inst.Mode, operand) - 1
+	cpu.store(inst.Mode, operand, v)
+	cpu.Reg.Carry = (cpu.Reg.A >= v)
+	cpu.updateNZ(cpu.Reg.A - v)
+}
+
 // Decrement memory value
 func (cpu *CPU) dec(inst *Instruction, operand []byte) {
 	v := cpu.load(inst.Mode, operand) - 1
@@ -583,6 +1070,19 @@ func (cpu *CPU) iny(inst *Instruction, operand []byte) {
 	cpu.updateNZ(cpu.Reg.Y)
 }
 
+// Increment memory value and Subtract with Carry (undocumented NMOS)
+func (cpu *CPU) isc(inst *Instruction, operand []byte) {
+	v := cpu.load(inst.Mode, operand) + 1
+	cpu.store(inst.Mode, operand, v)
+	cpu.subn(v)
+}
+
+// Jam the CPU, stopping it until it is reset (undocumented NMOS)
+func (cpu *CPU) jam(inst *Instruction, operand []byte) {
+	cpu.Reg.PC = cpu.LastPC
+	cpu.stopped = true
+}
+
 // Jump to memory address (NMOS 6502)
 func (cpu *CPU) jmpn(inst *Instruction, operand []byte) {
 	cpu.Reg.PC = cpu.loadAddress(inst.Mode, operand)
@@ -596,8 +1096,8 @@ func (cpu *CPU) jmpc(inst *Instruction, operand []byte) {
 		// In CMOS, it loads the MSB from $1300.
 		addr0 := uint16(operand[1])<<8 | 0xff
 		addr1 := addr0 + 1
-		lo := cpu.Mem.LoadByte(addr0)
-		hi := cpu.Mem.LoadByte(addr1)
+		lo := cpu.read(addr0)
+		hi := cpu.read(addr1)
 		cpu.Reg.PC = uint16(lo) | uint16(hi)<<8
 		cpu.deltaCycles++
 		return
@@ -613,6 +1113,21 @@ func (cpu *CPU) jsr(inst *Instruction, operand []byte) {
 	cpu.Reg.PC = addr
 }
 
+// AND memory with Stack pointer and load Accumulator, X register and
+// Stack pointer (undocumented NMOS)
+func (cpu *CPU) las(inst *Instruction, operand []byte) {
+	v := cpu.load(inst.Mode, operand) & cpu.Reg.SP
+	cpu.Reg.A, cpu.Reg.X, cpu.Reg.SP = v, v, v
+	cpu.updateNZ(v)
+}
+
+// Load Accumulator and X register (undocumented NMOS)
+func (cpu *CPU) lax(inst *Instruction, operand []byte) {
+	cpu.Reg.A = cpu.load(inst.Mode, operand)
+	cpu.Reg.X = cpu.Reg.A
+	cpu.updateNZ(cpu.Reg.A)
+}
+
 // load Accumulator
 func (cpu *CPU) lda(inst *Instruction, operand []byte) {
 	cpu.Reg.A = cpu.load(inst.Mode, operand)
@@ -638,14 +1153,24 @@ func (cpu *CPU) lsr(inst *Instruction, operand []byte) {
 	v = v >> 1
 	cpu.updateNZ(v)
 	cpu.store(inst.Mode, operand, v)
-	if cpu.Arch == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
+	if cpu.Arch.base() == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
 		cpu.deltaCycles--
 	}
 }
 
-// No-operation
+// Load Accumulator and X register immediate (undocumented NMOS, unstable)
+func (cpu *CPU) lxa(inst *Instruction, operand []byte) {
+	cpu.Reg.A = (cpu.Reg.A | unstableMagic) & cpu.load(inst.Mode, operand)
+	cpu.Reg.X = cpu.Reg.A
+	cpu.updateNZ(cpu.Reg.A)
+}
+
+// No-operation. The undocumented NMOS variants of NOP that take a memory
+// operand perform a read and discard the result.
 func (cpu *CPU) nop(inst *Instruction, operand []byte) {
-	// Do nothing
+	if inst.Mode != IMP {
+		cpu.load(inst.Mode, operand)
+	}
 }
 
 // Boolean OR
@@ -682,6 +1207,7 @@ func (cpu *CPU) pla(inst *Instruction, operand []byte) {
 
 // Pull (pop) Processor flags
 func (cpu *CPU) plp(inst *Instruction, operand []byte) {
+	cpu.delayIRQMask()
 	v := cpu.pop()
 	cpu.Reg.RestorePS(v)
 }
@@ -698,6 +1224,23 @@ func (cpu *CPU) ply(inst *Instruction, operand []byte) {
 	cpu.updateNZ(cpu.Reg.Y)
 }
 
+// Rotate Left memory value and AND with accumulator (undocumented NMOS)
+func (cpu *CPU) rla(inst *Instruction, operand []byte) {
+	tmp := cpu.load(inst.Mode, operand)
+	v := (tmp << 1) | boolToByte(cpu.Reg.Carry)
+	cpu.Reg.Carry = ((tmp & 0x80) != 0)
+	cpu.store(inst.Mode, operand, v)
+	cpu.Reg.A &= v
+	cpu.updateNZ(cpu.Reg.A)
+}
+
+// Reset Memory Bit (WDC 65c02)
+func (cpu *CPU) rmb(inst *Instruction, operand []byte) {
+	bit := (inst.Opcode >> 4) & 7
+	v := cpu.load(inst.Mode, operand) &^ (1 << bit)
+	cpu.store(inst.Mode, operand, v)
+}
+
 // Rotate Left
 func (cpu *CPU) rol(inst *Instruction, operand []byte) {
 	tmp := cpu.load(inst.Mode, operand)
@@ -705,7 +1248,7 @@ func (cpu *CPU) rol(inst *Instruction, operand []byte) {
 	cpu.Reg.Carry = ((tmp & 0x80) != 0)
 	cpu.updateNZ(v)
 	cpu.store(inst.Mode, operand, v)
-	if cpu.Arch == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
+	if cpu.Arch.base() == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
 		cpu.deltaCycles--
 	}
 }
@@ -717,11 +1260,20 @@ func (cpu *CPU) ror(inst *Instruction, operand []byte) {
 	cpu.Reg.Carry = ((tmp & 1) != 0)
 	cpu.updateNZ(v)
 	cpu.store(inst.Mode, operand, v)
-	if cpu.Arch == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
+	if cpu.Arch.base() == CMOS && inst.Mode == ABX && !cpu.pageCrossed {
 		cpu.deltaCycles--
 	}
 }
 
+// Rotate Right memory value and Add with Carry (undocumented NMOS)
+func (cpu *CPU) rra(inst *Instruction, operand []byte) {
+	tmp := cpu.load(inst.Mode, operand)
+	v := (tmp >> 1) | (boolToByte(cpu.Reg.Carry) << 7)
+	cpu.Reg.Carry = ((tmp & 1) != 0)
+	cpu.store(inst.Mode, operand, v)
+	cpu.addn(v)
+}
+
 // Return from Interrupt
 func (cpu *CPU) rti(inst *Instruction, operand []byte) {
 	v := cpu.pop()
@@ -735,59 +1287,43 @@ func (cpu *CPU) rts(inst *Instruction, operand []byte) {
 	cpu.Reg.PC = addr + 1
 }
 
+// Store Accumulator AND X register (undocumented NMOS)
+func (cpu *CPU) sax(inst *Instruction, operand []byte) {
+	cpu.store(inst.Mode, operand, cpu.Reg.A&cpu.Reg.X)
+}
+
 // Subtract with Carry (CMOS)
 func (cpu *CPU) sbcc(inst *Instruction, operand []byte) {
 	acc := uint32(cpu.Reg.A)
 	sub := uint32(cpu.load(inst.Mode, operand))
 	carry := boolToUint32(cpu.Reg.Carry)
 	cpu.Reg.Overflow = ((acc ^ sub) & 0x80) != 0
-	var v uint32
 
-	switch cpu.Reg.Decimal {
-	case true:
-		cpu.deltaCycles++
-
-		lo := 0x0f + (acc & 0x0f) - (sub & 0x0f) + carry
-
-		var carrylo uint32
-		if lo < 0x10 {
-			lo -= 0x06
-			carrylo = 0
-		} else {
-			lo -= 0x10
-			carrylo = 0x10
+	v := 0xff + acc - sub + carry
+	if v < 0x100 {
+		cpu.Reg.Carry = false
+		if v < 0x80 {
+			cpu.Reg.Overflow = false
 		}
-
-		hi := 0xf0 + (acc & 0xf0) - (sub & 0xf0) + carrylo
-
-		if hi < 0x100 {
-			cpu.Reg.Carry = false
-			if hi < 0x80 {
-				cpu.Reg.Overflow = false
-			}
-			hi -= 0x60
-		} else {
-			cpu.Reg.Carry = true
-			if hi >= 0x180 {
-				cpu.Reg.Overflow = false
-			}
-			hi -= 0x100
+	} else {
+		cpu.Reg.Carry = true
+		if v >= 0x180 {
+			cpu.Reg.Overflow = false
 		}
+	}
 
-		v = hi | lo
+	// In decimal mode, the carry and overflow flags reflect the binary
+	// difference, which is adjusted if it or the difference of the low
+	// digits is negative.
+	if cpu.Reg.Decimal {
+		cpu.deltaCycles++
 
-	case false:
-		v = 0xff + acc - sub + carry
+		lo := int32(acc&0x0f) - int32(sub&0x0f) + int32(carry) - 1
 		if v < 0x100 {
-			cpu.Reg.Carry = false
-			if v < 0x80 {
-				cpu.Reg.Overflow = false
-			}
-		} else {
-			cpu.Reg.Carry = true
-			if v >= 0x180 {
-				cpu.Reg.Overflow = false
-			}
+			v -= 0x60
+		}
+		if lo < 0 {
+			v -= 0x06
 		}
 	}
 
@@ -797,46 +1333,46 @@ func (cpu *CPU) sbcc(inst *Instruction, operand []byte) {
 
 // Subtract with Carry (NMOS)
 func (cpu *CPU) sbcn(inst *Instruction, operand []byte) {
+	cpu.subn(cpu.load(inst.Mode, operand))
+}
+
+// Subtract a value and the borrow from the accumulator (NMOS)
+func (cpu *CPU) subn(value byte) {
 	acc := uint32(cpu.Reg.A)
-	sub := uint32(cpu.load(inst.Mode, operand))
+	sub := uint32(value)
 	carry := boolToUint32(cpu.Reg.Carry)
-	var v uint32
 
-	switch cpu.Reg.Decimal {
-	case true:
-		lo := 0x0f + (acc & 0x0f) - (sub & 0x0f) + carry
+	// The flags reflect the binary difference, even in decimal mode.
+	v := 0xff + acc - sub + carry
+	cpu.Reg.Carry = (v >= 0x100)
+	cpu.Reg.Overflow = (((acc & 0x80) != (sub & 0x80)) && ((acc & 0x80) != (v & 0x80)))
+	cpu.updateNZ(byte(v))
 
-		var carrylo uint32
-		if lo < 0x10 {
-			lo -= 0x06
-			carrylo = 0
-		} else {
-			lo -= 0x10
-			carrylo = 0x10
+	// In decimal mode, adjust the low digit if it's negative, then adjust
+	// the difference if it's negative.
+	if cpu.decimalMode() {
+		lo := int32(acc&0x0f) - int32(sub&0x0f) + int32(carry) - 1
+		if lo < 0 {
+			lo = ((lo - 0x06) & 0x0f) - 0x10
 		}
-
-		hi := 0xf0 + (acc & 0xf0) - (sub & 0xf0) + carrylo
-
-		if hi < 0x100 {
-			cpu.Reg.Carry = false
-			hi -= 0x60
-		} else {
-			cpu.Reg.Carry = true
-			hi -= 0x100
+		d := int32(acc&0xf0) - int32(sub&0xf0) + lo
+		if d < 0 {
+			d -= 0x60
 		}
-
-		v = hi | lo
-
-		cpu.Reg.Overflow = ((acc^v)&0x80) != 0 && ((acc^sub)&0x80) != 0
-
-	case false:
-		v = 0xff + acc - sub + carry
-		cpu.Reg.Carry = (v >= 0x100)
-		cpu.Reg.Overflow = (((acc & 0x80) != (sub & 0x80)) && ((acc & 0x80) != (v & 0x80)))
+		v = uint32(d)
 	}
 
 	cpu.Reg.A = byte(v)
-	cpu.updateNZ(byte(v))
+}
+
+// Subtract from Accumulator AND X register into X register, without
+// borrow (undocumented NMOS)
+func (cpu *CPU) sbx(inst *Instruction, operand []byte) {
+	ax := cpu.Reg.A & cpu.Reg.X
+	v := cpu.load(inst.Mode, operand)
+	cpu.Reg.Carry = (ax >= v)
+	cpu.Reg.X = ax - v
+	cpu.updateNZ(cpu.Reg.X)
 }
 
 // Set Carry flag
@@ -851,14 +1387,63 @@ func (cpu *CPU) sed(inst *Instruction, operand []byte) {
 
 // Set InterruptDisable flag
 func (cpu *CPU) sei(inst *Instruction, operand []byte) {
+	cpu.delayIRQMask()
 	cpu.Reg.InterruptDisable = true
 }
 
+// Store Accumulator AND X register AND high address byte + 1
+// (undocumented NMOS, unstable)
+func (cpu *CPU) sha(inst *Instruction, operand []byte) {
+	cpu.storeHighAnd(inst.Mode, operand, cpu.Reg.A&cpu.Reg.X)
+}
+
+// Store X register AND high address byte + 1 (undocumented NMOS, unstable)
+func (cpu *CPU) shx(inst *Instruction, operand []byte) {
+	cpu.storeHighAnd(inst.Mode, operand, cpu.Reg.X)
+}
+
+// Store Y register AND high address byte + 1 (undocumented NMOS, unstable)
+func (cpu *CPU) shy(inst *Instruction, operand []byte) {
+	cpu.storeHighAnd(inst.Mode, operand, cpu.Reg.Y)
+}
+
+// Shift Left memory value and OR with accumulator (undocumented NMOS)
+func (cpu *CPU) slo(inst *Instruction, operand []byte) {
+	v := cpu.load(inst.Mode, operand)
+	cpu.Reg.Carry = ((v & 0x80) == 0x80)
+	v = v << 1
+	cpu.store(inst.Mode, operand, v)
+	cpu.Reg.A |= v
+	cpu.updateNZ(cpu.Reg.A)
+}
+
+// Set Memory Bit (WDC 65c02)
+func (cpu *CPU) smb(inst *Instruction, operand []byte) {
+	bit := (inst.Opcode >> 4) & 7
+	v := cpu.load(inst.Mode, operand) | (1 << bit)
+	cpu.store(inst.Mode, operand, v)
+}
+
+// Shift Right memory value and XOR with accumulator (undocumented NMOS)
+func (cpu *CPU) sre(inst *Instruction, operand []byte) {
+	v := cpu.load(inst.Mode, operand)
+	cpu.Reg.Carry = ((v & 1) == 1)
+	v = v >> 1
+	cpu.store(inst.Mode, operand, v)
+	cpu.Reg.A ^= v
+	cpu.updateNZ(cpu.Reg.A)
+}
+
 // Store Accumulator
 func (cpu *CPU) sta(inst *Instruction, operand []byte) {
 	cpu.store(inst.Mode, operand, cpu.Reg.A)
 }
 
+// Stop the processor until it is reset (WDC 65c02)
+func (cpu *CPU) stp(inst *Instruction, operand []byte) {
+	cpu.stopped = true
+}
+
 // Store X register
 func (cpu *CPU) stx(inst *Instruction, operand []byte) {
 	cpu.store(inst.Mode, operand, cpu.Reg.X)
@@ -874,6 +1459,13 @@ func (cpu *CPU) stz(inst *Instruction, operand []byte) {
 	cpu.store(inst.Mode, operand, 0)
 }
 
+// Transfer Accumulator AND X register to Stack pointer, then store it AND
+// high address byte + 1 (undocumented NMOS, unstable)
+func (cpu *CPU) tas(inst *Instruction, operand []byte) {
+	cpu.Reg.SP = cpu.Reg.A & cpu.Reg.X
+	cpu.storeHighAnd(inst.Mode, operand, cpu.Reg.SP)
+}
+
 // Transfer Accumulator to X register
 func (cpu *CPU) tax(inst *Instruction, operand []byte) {
 	cpu.Reg.X = cpu.Reg.A
@@ -934,3 +1526,8 @@ func (cpu *CPU) unusedn(inst *Instruction, operand []byte) {
 func (cpu *CPU) unusedc(inst *Instruction, operand []byte) {
 	// Do nothing
 }
+
+// Wait for an interrupt (WDC 65c02)
+func (cpu *CPU) wai(inst *Instruction, operand []byte) {
+	cpu.waiting = true
+}
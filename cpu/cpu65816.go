@@ -0,0 +1,978 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// 65c816 interrupt vectors. In native mode, the vectors are located 16
+// bytes below their emulation mode counterparts, except for BRK, which has
+// its own vector.
+const (
+	vectorCOP       = 0xfff4
+	vectorNativeBRK = 0xffe6
+)
+
+// Execute a single 65c816 instruction.
+func (cpu *CPU) step65816() {
+	// Grab the next opcode from the program bank.
+	opcode := cpu.fetchLong(cpu.programAddress(cpu.Reg.PC))
+	inst := cpu.instSet.Lookup(opcode)
+
+	// Fetch the operand, whose size may depend on the register widths, and
+	// advance the PC.
+	var buf [3]byte
+	operand := buf[:inst.LengthFor(cpu.m16(), cpu.x16())-1]
+	for i := range operand {
+		operand[i] = cpu.fetchLong(cpu.programAddress(cpu.Reg.PC + 1 + uint16(i)))
+	}
+	if cpu.tracers != nil {
+		cpu.traceBefore(inst, operand)
+	}
+	cpu.LastPC = cpu.Reg.PC
+	cpu.Reg.PC += uint16(len(operand) + 1)
+
+	// Execute the instruction
+	cpu.pageCrossed = false
+	cpu.deltaCycles = 0
+	cpu.irqDelayed = false
+	inst.fn(cpu, inst, operand)
+
+	cpu.Cycles += uint64(int8(inst.Cycles) + cpu.deltaCycles)
+	if cpu.pageCrossed {
+		cpu.Cycles += uint64(inst.BPCycles)
+	}
+
+	if cpu.tracers != nil {
+		cpu.traceAfter()
+	}
+
+	if cpu.debugger != nil {
+		cpu.debugger.onUpdatePC(cpu, cpu.Reg.PC)
+	}
+}
+
+// Put the 65c816 registers into their reset state.
+func (cpu *CPU) reset65816() {
+	cpu.Reg.Emulation = true
+	cpu.Reg.MemorySelect, cpu.Reg.IndexSelect = true, true
+	cpu.Reg.XH, cpu.Reg.YH, cpu.Reg.SPH = 0, 0, 0x01
+	cpu.Reg.D, cpu.Reg.DBR, cpu.Reg.PBR = 0, 0, 0
+}
+
+// Handle a 65c816 interrupt. In native mode, the program bank is pushed
+// onto the stack along with the program counter and status flags, and the
+// native mode vector is used.
+func (cpu *CPU) handleInterrupt65816(brk bool, addr uint16) {
+	if cpu.Reg.Emulation {
+		cpu.pushWord65816(cpu.Reg.PC)
+		cpu.push65816(cpu.Reg.SavePS(brk))
+	} else {
+		cpu.push65816(cpu.Reg.PBR)
+		cpu.pushWord65816(cpu.Reg.PC)
+		cpu.push65816(cpu.Reg.SavePSNative())
+		cpu.Cycles++
+
+		if brk {
+			addr = vectorNativeBRK
+		} else {
+			addr -= 0x10
+		}
+	}
+
+	cpu.Reg.InterruptDisable = true
+	cpu.Reg.Decimal = false
+	cpu.Reg.PBR = 0
+	cpu.Reg.PC = cpu.loadPointer(addr)
+}
+
+// Return true if the accumulator and memory accesses are 16 bits wide.
+func (cpu *CPU) m16() bool {
+	return !cpu.Reg.MemorySelect
+}
+
+// Return true if the index registers are 16 bits wide.
+func (cpu *CPU) x16() bool {
+	return !cpu.Reg.IndexSelect
+}
+
+// Return the accumulator, using the width selected by the M bit.
+func (cpu *CPU) acc() uint16 {
+	if cpu.m16() {
+		return cpu.Reg.C()
+	}
+	return uint16(cpu.Reg.A)
+}
+
+// Update the accumulator, using the width selected by the M bit. An 8-bit
+// update leaves the high byte (B) intact.
+func (cpu *CPU) setAcc(v uint16) {
+	cpu.Reg.A = byte(v)
+	if cpu.m16() {
+		cpu.Reg.B = byte(v >> 8)
+	}
+}
+
+// Update the X register, using the width selected by the X bit.
+func (cpu *CPU) setX(v uint16) {
+	cpu.Reg.X = byte(v)
+	if cpu.x16() {
+		cpu.Reg.XH = byte(v >> 8)
+	}
+}
+
+// Update the Y register, using the width selected by the X bit.
+func (cpu *CPU) setY(v uint16) {
+	cpu.Reg.Y = byte(v)
+	if cpu.x16() {
+		cpu.Reg.YH = byte(v >> 8)
+	}
+}
+
+// Update the stack pointer. In emulation mode, the stack is confined to
+// page 1.
+func (cpu *CPU) setS(v uint16) {
+	cpu.Reg.SP = byte(v)
+	if !cpu.Reg.Emulation {
+		cpu.Reg.SPH = byte(v >> 8)
+	}
+}
+
+// Update the processor status flags. In emulation mode, the M and X bits
+// are unaffected.
+func (cpu *CPU) setPS65816(ps byte) {
+	if cpu.Reg.Emulation {
+		cpu.Reg.RestorePS(ps)
+	} else {
+		cpu.Reg.RestorePSNative(ps)
+	}
+}
+
+// Update the Zero and Negative flags based on the 8- or 16-bit value 'v'.
+func (cpu *CPU) updateNZ16(v uint16, wide bool) {
+	if !wide {
+		cpu.updateNZ(byte(v))
+		return
+	}
+	cpu.Reg.Zero = (v == 0)
+	cpu.Reg.Sign = ((v & 0x8000) != 0)
+}
+
+// Return the sign bit of an 8- or 16-bit value.
+func signBit(wide bool) uint16 {
+	if wide {
+		return 0x8000
+	}
+	return 0x80
+}
+
+// Return the 24-bit address of 'addr' in the program bank.
+func (cpu *CPU) programAddress(addr uint16) uint32 {
+	return uint32(cpu.Reg.PBR)<<16 | uint32(addr)
+}
+
+// Return the 24-bit address of 'addr' in the data bank.
+func (cpu *CPU) dataAddress(addr uint16) uint32 {
+	return uint32(cpu.Reg.DBR)<<16 | uint32(addr)
+}
+
+// Return the bank 0 address of a direct page operand, offset by 'index'.
+// In emulation mode with a page-aligned direct page register, indexing
+// wraps within the direct page as it does on the 6502.
+func (cpu *CPU) direct(offset byte, index uint16) uint16 {
+	if (cpu.Reg.D & 0xff) != 0 {
+		cpu.deltaCycles++
+		return cpu.Reg.D + uint16(offset) + index
+	}
+	if cpu.Reg.Emulation {
+		return cpu.Reg.D | uint16(offset+byte(index))
+	}
+	return cpu.Reg.D + uint16(offset) + index
+}
+
+// Offset the 24-bit address 'addr' by 'index'. Indexing costs an extra
+// cycle when it crosses a page boundary or the index registers are 16 bits
+// wide.
+func (cpu *CPU) indexed(addr uint32, index uint16) uint32 {
+	newAddr := (addr + uint32(index)) & 0xffffff
+	cpu.pageCrossed = cpu.x16() || ((newAddr^addr)&0xff00) != 0
+	return newAddr
+}
+
+// Return the 24-bit effective address of a memory operand.
+func (cpu *CPU) address65816(mode Mode, operand []byte) uint32 {
+	switch mode {
+	case ZPG:
+		return uint32(cpu.direct(operand[0], 0))
+	case ZPX:
+		return uint32(cpu.direct(operand[0], cpu.Reg.X16()))
+	case ZPY:
+		return uint32(cpu.direct(operand[0], cpu.Reg.Y16()))
+	case ABS:
+		return cpu.dataAddress(operandToAddress(operand))
+	case ABX:
+		return cpu.indexed(cpu.dataAddress(operandToAddress(operand)), cpu.Reg.X16())
+	case ABY:
+		return cpu.indexed(cpu.dataAddress(operandToAddress(operand)), cpu.Reg.Y16())
+	case IND:
+		return cpu.dataAddress(cpu.loadPointer(cpu.direct(operand[0], 0)))
+	case IDX:
+		return cpu.dataAddress(cpu.loadPointer(cpu.direct(operand[0], cpu.Reg.X16())))
+	case IDY:
+		addr := cpu.dataAddress(cpu.loadPointer(cpu.direct(operand[0], 0)))
+		return cpu.indexed(addr, cpu.Reg.Y16())
+	case ILN:
+		return cpu.loadLongPointer(cpu.direct(operand[0], 0))
+	case ILY:
+		addr := cpu.loadLongPointer(cpu.direct(operand[0], 0))
+		return (addr + uint32(cpu.Reg.Y16())) & 0xffffff
+	case ABL:
+		return operandToLongAddress(operand)
+	case ALX:
+		return (operandToLongAddress(operand) + uint32(cpu.Reg.X16())) & 0xffffff
+	case SRL:
+		return uint32(cpu.Reg.S16() + uint16(operand[0]))
+	case SRY:
+		addr := cpu.dataAddress(cpu.loadPointer(cpu.Reg.S16() + uint16(operand[0])))
+		return (addr + uint32(cpu.Reg.Y16())) & 0xffffff
+	default:
+		panic("Invalid addressing mode")
+	}
+}
+
+// Load a byte from a 24-bit address. Loads from bank 0 are visible to
+// memory hooks and watchpoints.
+func (cpu *CPU) loadLong(addr uint32) byte {
+	v := cpu.fetchLong(addr)
+	if cpu.debugger != nil && cpu.debugger.watchpoints != nil && addr&0xffffff < 0x10000 {
+		cpu.debugger.onWatchLoad(cpu, uint16(addr), v)
+	}
+	return v
+}
+
+// Fetch an instruction byte from a 24-bit address. Fetches from bank 0 are
+// visible to memory hooks.
+func (cpu *CPU) fetchLong(addr uint32) byte {
+	addr &= 0xffffff
+	v := cpu.longMem.LoadByteLong(addr)
+	if cpu.memHooks != nil && addr < 0x10000 {
+		for _, h := range cpu.memHooks {
+			h.OnRead(cpu, uint16(addr), v)
+		}
+	}
+	return v
+}
+
+// Store a byte to a 24-bit address. Stores to bank 0 are visible to the
+// debugger and memory hooks.
+func (cpu *CPU) storeLong(addr uint32, v byte) {
+	addr &= 0xffffff
+	if addr < 0x10000 {
+		cpu.storeByte(cpu, uint16(addr), v)
+		return
+	}
+	cpu.longMem.StoreByteLong(addr, v)
+}
+
+// Load an 8- or 16-bit value from a 24-bit address.
+func (cpu *CPU) loadWord(addr uint32, wide bool) uint16 {
+	v := uint16(cpu.loadLong(addr))
+	if wide {
+		v |= uint16(cpu.loadLong(addr+1)) << 8
+	}
+	return v
+}
+
+// Store an 8- or 16-bit value to a 24-bit address.
+func (cpu *CPU) storeWord(addr uint32, v uint16, wide bool) {
+	cpu.storeLong(addr, byte(v))
+	if wide {
+		cpu.storeLong(addr+1, byte(v>>8))
+	}
+}
+
+// Load a 16-bit pointer from bank 0.
+func (cpu *CPU) loadPointer(addr uint16) uint16 {
+	return uint16(cpu.loadLong(uint32(addr))) | uint16(cpu.loadLong(uint32(addr+1)))<<8
+}
+
+// Load a 24-bit pointer from bank 0.
+func (cpu *CPU) loadLongPointer(addr uint16) uint32 {
+	return uint32(cpu.loadPointer(addr)) | uint32(cpu.loadLong(uint32(addr+2)))<<16
+}
+
+// Convert a 3-byte operand into a 24-bit address.
+func operandToLongAddress(operand []byte) uint32 {
+	return uint32(operand[0]) | uint32(operand[1])<<8 | uint32(operand[2])<<16
+}
+
+// Load an 8- or 16-bit value using the requested addressing mode and the
+// operand to determine where to load it from.
+func (cpu *CPU) load65816(mode Mode, operand []byte, wide bool) uint16 {
+	if wide {
+		cpu.deltaCycles++
+	}
+	if mode == IMM {
+		return operandToAddress(operand)
+	}
+	return cpu.loadWord(cpu.address65816(mode, operand), wide)
+}
+
+// Store an 8- or 16-bit value using the requested addressing mode and the
+// operand to determine where to store it.
+func (cpu *CPU) store65816(mode Mode, operand []byte, v uint16, wide bool) {
+	if wide {
+		cpu.deltaCycles++
+	}
+	cpu.storeWord(cpu.address65816(mode, operand), v, wide)
+}
+
+// Read a value from memory or the accumulator, modify it with 'op' and
+// write the result back. The width is selected by the M bit.
+func (cpu *CPU) modify65816(mode Mode, operand []byte, op func(v uint16, wide bool) uint16) {
+	wide := cpu.m16()
+	if mode == ACC {
+		cpu.setAcc(op(cpu.acc(), wide))
+		return
+	}
+
+	if wide {
+		cpu.deltaCycles += 2
+	}
+	addr := cpu.address65816(mode, operand)
+	v := op(cpu.loadWord(addr, wide), wide)
+	cpu.storeWord(addr, v, wide)
+}
+
+// Push a byte onto the stack.
+func (cpu *CPU) push65816(v byte) {
+	cpu.storeByte(cpu, cpu.Reg.S16(), v)
+	cpu.setS(cpu.Reg.S16() - 1)
+}
+
+// Push a 16-bit value onto the stack.
+func (cpu *CPU) pushWord65816(v uint16) {
+	cpu.push65816(byte(v >> 8))
+	cpu.push65816(byte(v))
+}
+
+// Push an 8- or 16-bit register value onto the stack. Pushing a 16-bit
+// value costs an extra cycle.
+func (cpu *CPU) pushValue65816(v uint16, wide bool) {
+	if wide {
+		cpu.deltaCycles++
+		cpu.pushWord65816(v)
+	} else {
+		cpu.push65816(byte(v))
+	}
+}
+
+// Pop a byte from the stack and return it.
+func (cpu *CPU) pop65816() byte {
+	cpu.setS(cpu.Reg.S16() + 1)
+	return cpu.loadLong(uint32(cpu.Reg.S16()))
+}
+
+// Pop a 16-bit value from the stack.
+func (cpu *CPU) popWord65816() uint16 {
+	lo := cpu.pop65816()
+	hi := cpu.pop65816()
+	return uint16(lo) | uint16(hi)<<8
+}
+
+// Pop an 8- or 16-bit register value from the stack. Pulling a 16-bit
+// value costs an extra cycle.
+func (cpu *CPU) popValue65816(wide bool) uint16 {
+	if wide {
+		cpu.deltaCycles++
+		return cpu.popWord65816()
+	}
+	return uint16(cpu.pop65816())
+}
+
+// Add with Carry (65c816)
+func (cpu *CPU) adc816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	acc := uint32(cpu.acc())
+	add := uint32(cpu.load65816(inst.Mode, operand, wide))
+	carry := boolToUint32(cpu.Reg.Carry)
+	sign, bits := uint32(signBit(wide)), uint(8)
+	if wide {
+		bits = 16
+	}
+
+	bin := acc + add + carry
+	cpu.Reg.Overflow = ((acc ^ bin) & (add ^ bin) & sign) != 0
+
+	var v uint32
+	switch cpu.Reg.Decimal {
+	case true:
+		// Add one decimal digit at a time.
+		for shift := uint(0); shift < bits; shift += 4 {
+			d := (acc>>shift)&0xf + (add>>shift)&0xf + carry
+			carry = 0
+			if d > 9 {
+				d, carry = d-10, 1
+			}
+			v |= (d & 0xf) << shift
+		}
+
+	case false:
+		v = bin
+		carry = (v >> bits) & 1
+	}
+
+	cpu.Reg.Carry = (carry != 0)
+	cpu.setAcc(uint16(v))
+	cpu.updateNZ16(uint16(v), wide)
+}
+
+// Boolean AND (65c816)
+func (cpu *CPU) and816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	v := cpu.acc() & cpu.load65816(inst.Mode, operand, wide)
+	cpu.setAcc(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Arithmetic Shift Left (65c816)
+func (cpu *CPU) asl816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		cpu.Reg.Carry = ((v & signBit(wide)) != 0)
+		v = v << 1
+		cpu.updateNZ16(v, wide)
+		return v
+	})
+}
+
+// Bit Test (65c816)
+func (cpu *CPU) bit816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	v := cpu.load65816(inst.Mode, operand, wide)
+	cpu.Reg.Zero = ((v & cpu.acc()) == 0)
+	if inst.Mode != IMM {
+		cpu.Reg.Sign = ((v & signBit(wide)) != 0)
+		cpu.Reg.Overflow = ((v & (signBit(wide) >> 1)) != 0)
+	}
+}
+
+// Break (65c816)
+func (cpu *CPU) brk816(inst *Instruction, operand []byte) {
+	cpu.handleInterrupt(true, vectorBRK)
+}
+
+// Branch Long (65c816)
+func (cpu *CPU) brl816(inst *Instruction, operand []byte) {
+	cpu.Reg.PC += operandToAddress(operand)
+}
+
+// Compare to accumulator (65c816)
+func (cpu *CPU) cmp816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	cpu.compare65816(cpu.acc(), cpu.load65816(inst.Mode, operand, wide), wide)
+}
+
+// Compare a register value 'r' to 'v' and update the flags.
+func (cpu *CPU) compare65816(r, v uint16, wide bool) {
+	cpu.Reg.Carry = (r >= v)
+	cpu.updateNZ16(r-v, wide)
+}
+
+// Co-processor interrupt (65c816)
+func (cpu *CPU) cop816(inst *Instruction, operand []byte) {
+	cpu.handleInterrupt(false, vectorCOP)
+}
+
+// Compare to X register (65c816)
+func (cpu *CPU) cpx816(inst *Instruction, operand []byte) {
+	wide := cpu.x16()
+	cpu.compare65816(cpu.Reg.X16(), cpu.load65816(inst.Mode, operand, wide), wide)
+}
+
+// Compare to Y register (65c816)
+func (cpu *CPU) cpy816(inst *Instruction, operand []byte) {
+	wide := cpu.x16()
+	cpu.compare65816(cpu.Reg.Y16(), cpu.load65816(inst.Mode, operand, wide), wide)
+}
+
+// Decrement memory value (65c816)
+func (cpu *CPU) dec816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		v--
+		cpu.updateNZ16(v, wide)
+		return v
+	})
+}
+
+// Decrement X register (65c816)
+func (cpu *CPU) dex816(inst *Instruction, operand []byte) {
+	cpu.setX(cpu.Reg.X16() - 1)
+	cpu.updateNZ16(cpu.Reg.X16(), cpu.x16())
+}
+
+// Decrement Y register (65c816)
+func (cpu *CPU) dey816(inst *Instruction, operand []byte) {
+	cpu.setY(cpu.Reg.Y16() - 1)
+	cpu.updateNZ16(cpu.Reg.Y16(), cpu.x16())
+}
+
+// Boolean XOR (65c816)
+func (cpu *CPU) eor816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	v := cpu.acc() ^ cpu.load65816(inst.Mode, operand, wide)
+	cpu.setAcc(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Increment memory value (65c816)
+func (cpu *CPU) inc816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		v++
+		cpu.updateNZ16(v, wide)
+		return v
+	})
+}
+
+// Increment X register (65c816)
+func (cpu *CPU) inx816(inst *Instruction, operand []byte) {
+	cpu.setX(cpu.Reg.X16() + 1)
+	cpu.updateNZ16(cpu.Reg.X16(), cpu.x16())
+}
+
+// Increment Y register (65c816)
+func (cpu *CPU) iny816(inst *Instruction, operand []byte) {
+	cpu.setY(cpu.Reg.Y16() + 1)
+	cpu.updateNZ16(cpu.Reg.Y16(), cpu.x16())
+}
+
+// Jump Long (65c816)
+func (cpu *CPU) jml816(inst *Instruction, operand []byte) {
+	var addr uint32
+	switch inst.Mode {
+	case ABL:
+		addr = operandToLongAddress(operand)
+	case ILN:
+		addr = cpu.loadLongPointer(operandToAddress(operand))
+	}
+	cpu.Reg.PBR = byte(addr >> 16)
+	cpu.Reg.PC = uint16(addr)
+}
+
+// Jump to memory address (65c816)
+func (cpu *CPU) jmp816(inst *Instruction, operand []byte) {
+	cpu.Reg.PC = cpu.jumpAddress65816(inst.Mode, operand)
+}
+
+// Return the target address of a jump within the program bank.
+func (cpu *CPU) jumpAddress65816(mode Mode, operand []byte) uint16 {
+	addr := operandToAddress(operand)
+	switch mode {
+	case IND:
+		return cpu.loadPointer(addr)
+	case ABX:
+		ptr := cpu.programAddress(addr + cpu.Reg.X16())
+		return uint16(cpu.loadLong(ptr)) | uint16(cpu.loadLong(cpu.programAddress(uint16(ptr)+1)))<<8
+	default:
+		return addr
+	}
+}
+
+// Jump to Subroutine Long (65c816)
+func (cpu *CPU) jsl816(inst *Instruction, operand []byte) {
+	cpu.push65816(cpu.Reg.PBR)
+	cpu.pushWord65816(cpu.Reg.PC - 1)
+	cpu.Reg.PBR = operand[2]
+	cpu.Reg.PC = operandToAddress(operand[:2])
+}
+
+// Jump to Subroutine (65c816)
+func (cpu *CPU) jsr816(inst *Instruction, operand []byte) {
+	addr := cpu.jumpAddress65816(inst.Mode, operand)
+	cpu.pushWord65816(cpu.Reg.PC - 1)
+	cpu.Reg.PC = addr
+}
+
+// load Accumulator (65c816)
+func (cpu *CPU) lda816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	v := cpu.load65816(inst.Mode, operand, wide)
+	cpu.setAcc(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// load the X register (65c816)
+func (cpu *CPU) ldx816(inst *Instruction, operand []byte) {
+	wide := cpu.x16()
+	v := cpu.load65816(inst.Mode, operand, wide)
+	cpu.setX(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// load the Y register (65c816)
+func (cpu *CPU) ldy816(inst *Instruction, operand []byte) {
+	wide := cpu.x16()
+	v := cpu.load65816(inst.Mode, operand, wide)
+	cpu.setY(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Logical Shift Right (65c816)
+func (cpu *CPU) lsr816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		cpu.Reg.Carry = ((v & 1) == 1)
+		v = v >> 1
+		cpu.updateNZ16(v, wide)
+		return v
+	})
+}
+
+// Move one byte of a block and repeat the instruction until the byte count
+// in the accumulator is exhausted. The operand holds the destination and
+// source banks.
+func (cpu *CPU) blockMove65816(operand []byte, step uint16) {
+	dst, src := operand[0], operand[1]
+	v := cpu.loadLong(uint32(src)<<16 | uint32(cpu.Reg.X16()))
+	cpu.storeLong(uint32(dst)<<16|uint32(cpu.Reg.Y16()), v)
+
+	cpu.Reg.DBR = dst
+	cpu.setX(cpu.Reg.X16() + step)
+	cpu.setY(cpu.Reg.Y16() + step)
+
+	c := cpu.Reg.C() - 1
+	cpu.Reg.A, cpu.Reg.B = byte(c), byte(c>>8)
+	if c != 0xffff {
+		cpu.Reg.PC = cpu.LastPC
+	}
+}
+
+// Block Move Negative (65c816)
+func (cpu *CPU) mvn816(inst *Instruction, operand []byte) {
+	cpu.blockMove65816(operand, 1)
+}
+
+// Block Move Positive (65c816)
+func (cpu *CPU) mvp816(inst *Instruction, operand []byte) {
+	cpu.blockMove65816(operand, 0xffff)
+}
+
+// Boolean OR (65c816)
+func (cpu *CPU) ora816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	v := cpu.acc() | cpu.load65816(inst.Mode, operand, wide)
+	cpu.setAcc(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Push Effective Absolute address (65c816)
+func (cpu *CPU) pea816(inst *Instruction, operand []byte) {
+	cpu.pushWord65816(operandToAddress(operand))
+}
+
+// Push Effective Indirect address (65c816)
+func (cpu *CPU) pei816(inst *Instruction, operand []byte) {
+	cpu.pushWord65816(cpu.loadPointer(cpu.direct(operand[0], 0)))
+}
+
+// Push Effective PC-relative address (65c816)
+func (cpu *CPU) per816(inst *Instruction, operand []byte) {
+	cpu.pushWord65816(cpu.Reg.PC + operandToAddress(operand))
+}
+
+// Push Accumulator (65c816)
+func (cpu *CPU) pha816(inst *Instruction, operand []byte) {
+	cpu.pushValue65816(cpu.acc(), cpu.m16())
+}
+
+// Push Data Bank register (65c816)
+func (cpu *CPU) phb816(inst *Instruction, operand []byte) {
+	cpu.push65816(cpu.Reg.DBR)
+}
+
+// Push Direct page register (65c816)
+func (cpu *CPU) phd816(inst *Instruction, operand []byte) {
+	cpu.pushWord65816(cpu.Reg.D)
+}
+
+// Push program bank register (65c816)
+func (cpu *CPU) phk816(inst *Instruction, operand []byte) {
+	cpu.push65816(cpu.Reg.PBR)
+}
+
+// Push Processor flags (65c816)
+func (cpu *CPU) php816(inst *Instruction, operand []byte) {
+	if cpu.Reg.Emulation {
+		cpu.push65816(cpu.Reg.SavePS(true))
+	} else {
+		cpu.push65816(cpu.Reg.SavePSNative())
+	}
+}
+
+// Push X register (65c816)
+func (cpu *CPU) phx816(inst *Instruction, operand []byte) {
+	cpu.pushValue65816(cpu.Reg.X16(), cpu.x16())
+}
+
+// Push Y register (65c816)
+func (cpu *CPU) phy816(inst *Instruction, operand []byte) {
+	cpu.pushValue65816(cpu.Reg.Y16(), cpu.x16())
+}
+
+// Pull (pop) Accumulator (65c816)
+func (cpu *CPU) pla816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	v := cpu.popValue65816(wide)
+	cpu.setAcc(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Pull (pop) Data Bank register (65c816)
+func (cpu *CPU) plb816(inst *Instruction, operand []byte) {
+	cpu.Reg.DBR = cpu.pop65816()
+	cpu.updateNZ(cpu.Reg.DBR)
+}
+
+// Pull (pop) Direct page register (65c816)
+func (cpu *CPU) pld816(inst *Instruction, operand []byte) {
+	cpu.Reg.D = cpu.popWord65816()
+	cpu.updateNZ16(cpu.Reg.D, true)
+}
+
+// Pull (pop) Processor flags (65c816)
+func (cpu *CPU) plp816(inst *Instruction, operand []byte) {
+	cpu.delayIRQMask()
+	cpu.setPS65816(cpu.pop65816())
+}
+
+// Pull (pop) X register (65c816)
+func (cpu *CPU) plx816(inst *Instruction, operand []byte) {
+	wide := cpu.x16()
+	v := cpu.popValue65816(wide)
+	cpu.setX(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Pull (pop) Y register (65c816)
+func (cpu *CPU) ply816(inst *Instruction, operand []byte) {
+	wide := cpu.x16()
+	v := cpu.popValue65816(wide)
+	cpu.setY(v)
+	cpu.updateNZ16(v, wide)
+}
+
+// Reset Processor status bits (65c816)
+func (cpu *CPU) rep816(inst *Instruction, operand []byte) {
+	cpu.setPS65816(cpu.Reg.SavePSNative() &^ operand[0])
+}
+
+// Rotate Left (65c816)
+func (cpu *CPU) rol816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		carry := cpu.Reg.Carry
+		cpu.Reg.Carry = ((v & signBit(wide)) != 0)
+		v = (v << 1) | uint16(boolToByte(carry))
+		cpu.updateNZ16(v, wide)
+		return v
+	})
+}
+
+// Rotate Right (65c816)
+func (cpu *CPU) ror816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		carry := cpu.Reg.Carry
+		cpu.Reg.Carry = ((v & 1) != 0)
+		v = v >> 1
+		if carry {
+			v |= signBit(wide)
+		}
+		cpu.updateNZ16(v, wide)
+		return v
+	})
+}
+
+// Return from Interrupt (65c816)
+func (cpu *CPU) rti816(inst *Instruction, operand []byte) {
+	cpu.setPS65816(cpu.pop65816())
+	cpu.Reg.PC = cpu.popWord65816()
+	if !cpu.Reg.Emulation {
+		cpu.Reg.PBR = cpu.pop65816()
+		cpu.deltaCycles++
+	}
+}
+
+// Return from Subroutine Long (65c816)
+func (cpu *CPU) rtl816(inst *Instruction, operand []byte) {
+	cpu.Reg.PC = cpu.popWord65816() + 1
+	cpu.Reg.PBR = cpu.pop65816()
+}
+
+// Return from Subroutine (65c816)
+func (cpu *CPU) rts816(inst *Instruction, operand []byte) {
+	cpu.Reg.PC = cpu.popWord65816() + 1
+}
+
+// Subtract with Carry (65c816)
+func (cpu *CPU) sbc816(inst *Instruction, operand []byte) {
+	wide := cpu.m16()
+	acc := uint32(cpu.acc())
+	sub := uint32(cpu.load65816(inst.Mode, operand, wide))
+	borrow := 1 - boolToUint32(cpu.Reg.Carry)
+	sign, bits := uint32(signBit(wide)), uint(8)
+	if wide {
+		bits = 16
+	}
+
+	bin := acc - sub - borrow
+	cpu.Reg.Overflow = ((acc ^ sub) & (acc ^ bin) & sign) != 0
+
+	var v uint32
+	switch cpu.Reg.Decimal {
+	case true:
+		// Subtract one decimal digit at a time.
+		for shift := uint(0); shift < bits; shift += 4 {
+			d := int32((acc>>shift)&0xf) - int32((sub>>shift)&0xf) - int32(borrow)
+			borrow = 0
+			if d < 0 {
+				d, borrow = d+10, 1
+			}
+			v |= uint32(d&0xf) << shift
+		}
+
+	case false:
+		v = bin
+		borrow = (v >> bits) & 1
+	}
+
+	cpu.Reg.Carry = (borrow == 0)
+	cpu.setAcc(uint16(v))
+	cpu.updateNZ16(uint16(v), wide)
+}
+
+// Set Processor status bits (65c816)
+func (cpu *CPU) sep816(inst *Instruction, operand []byte) {
+	cpu.setPS65816(cpu.Reg.SavePSNative() | operand[0])
+}
+
+// Store Accumulator (65c816)
+func (cpu *CPU) sta816(inst *Instruction, operand []byte) {
+	cpu.store65816(inst.Mode, operand, cpu.acc(), cpu.m16())
+}
+
+// Store X register (65c816)
+func (cpu *CPU) stx816(inst *Instruction, operand []byte) {
+	cpu.store65816(inst.Mode, operand, cpu.Reg.X16(), cpu.x16())
+}
+
+// Store Y register (65c816)
+func (cpu *CPU) sty816(inst *Instruction, operand []byte) {
+	cpu.store65816(inst.Mode, operand, cpu.Reg.Y16(), cpu.x16())
+}
+
+// Store Zero (65c816)
+func (cpu *CPU) stz816(inst *Instruction, operand []byte) {
+	cpu.store65816(inst.Mode, operand, 0, cpu.m16())
+}
+
+// Transfer Accumulator to X register (65c816)
+func (cpu *CPU) tax816(inst *Instruction, operand []byte) {
+	cpu.setX(cpu.Reg.C())
+	cpu.updateNZ16(cpu.Reg.X16(), cpu.x16())
+}
+
+// Transfer Accumulator to Y register (65c816)
+func (cpu *CPU) tay816(inst *Instruction, operand []byte) {
+	cpu.setY(cpu.Reg.C())
+	cpu.updateNZ16(cpu.Reg.Y16(), cpu.x16())
+}
+
+// Transfer 16-bit accumulator to Direct page register (65c816)
+func (cpu *CPU) tcd816(inst *Instruction, operand []byte) {
+	cpu.Reg.D = cpu.Reg.C()
+	cpu.updateNZ16(cpu.Reg.D, true)
+}
+
+// Transfer 16-bit accumulator to Stack pointer (65c816)
+func (cpu *CPU) tcs816(inst *Instruction, operand []byte) {
+	cpu.setS(cpu.Reg.C())
+}
+
+// Transfer Direct page register to 16-bit accumulator (65c816)
+func (cpu *CPU) tdc816(inst *Instruction, operand []byte) {
+	cpu.Reg.A, cpu.Reg.B = byte(cpu.Reg.D), byte(cpu.Reg.D>>8)
+	cpu.updateNZ16(cpu.Reg.D, true)
+}
+
+// Test and Reset Bits (65c816)
+func (cpu *CPU) trb816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		cpu.Reg.Zero = ((v & cpu.acc()) == 0)
+		return v &^ cpu.acc()
+	})
+}
+
+// Test and Set Bits (65c816)
+func (cpu *CPU) tsb816(inst *Instruction, operand []byte) {
+	cpu.modify65816(inst.Mode, operand, func(v uint16, wide bool) uint16 {
+		cpu.Reg.Zero = ((v & cpu.acc()) == 0)
+		return v | cpu.acc()
+	})
+}
+
+// Transfer Stack pointer to 16-bit accumulator (65c816)
+func (cpu *CPU) tsc816(inst *Instruction, operand []byte) {
+	s := cpu.Reg.S16()
+	cpu.Reg.A, cpu.Reg.B = byte(s), byte(s>>8)
+	cpu.updateNZ16(s, true)
+}
+
+// Transfer Stack pointer to X register (65c816)
+func (cpu *CPU) tsx816(inst *Instruction, operand []byte) {
+	cpu.setX(cpu.Reg.S16())
+	cpu.updateNZ16(cpu.Reg.X16(), cpu.x16())
+}
+
+// Transfer X register to Accumulator (65c816)
+func (cpu *CPU) txa816(inst *Instruction, operand []byte) {
+	cpu.setAcc(cpu.Reg.X16())
+	cpu.updateNZ16(cpu.acc(), cpu.m16())
+}
+
+// Transfer X register to Stack pointer (65c816)
+func (cpu *CPU) txs816(inst *Instruction, operand []byte) {
+	cpu.setS(cpu.Reg.X16())
+}
+
+// Transfer X register to Y register (65c816)
+func (cpu *CPU) txy816(inst *Instruction, operand []byte) {
+	cpu.setY(cpu.Reg.X16())
+	cpu.updateNZ16(cpu.Reg.Y16(), cpu.x16())
+}
+
+// Transfer Y register to Accumulator (65c816)
+func (cpu *CPU) tya816(inst *Instruction, operand []byte) {
+	cpu.setAcc(cpu.Reg.Y16())
+	cpu.updateNZ16(cpu.acc(), cpu.m16())
+}
+
+// Transfer Y register to X register (65c816)
+func (cpu *CPU) tyx816(inst *Instruction, operand []byte) {
+	cpu.setX(cpu.Reg.Y16())
+	cpu.updateNZ16(cpu.Reg.X16(), cpu.x16())
+}
+
+// Reserved for future expansion; does nothing (65c816)
+func (cpu *CPU) wdm816(inst *Instruction, operand []byte) {
+}
+
+// Exchange the B and A accumulators (65c816)
+func (cpu *CPU) xba816(inst *Instruction, operand []byte) {
+	cpu.Reg.A, cpu.Reg.B = cpu.Reg.B, cpu.Reg.A
+	cpu.updateNZ(cpu.Reg.A)
+}
+
+// Exchange Carry and Emulation flags (65c816)
+func (cpu *CPU) xce816(inst *Instruction, operand []byte) {
+	cpu.Reg.Carry, cpu.Reg.Emulation = cpu.Reg.Emulation, cpu.Reg.Carry
+	if cpu.Reg.Emulation {
+		cpu.Reg.MemorySelect, cpu.Reg.IndexSelect = true, true
+		cpu.Reg.XH, cpu.Reg.YH, cpu.Reg.SPH = 0, 0, 0x01
+	}
+}
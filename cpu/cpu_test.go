@@ -1,6 +1,9 @@
 package cpu_test
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
@@ -10,6 +13,10 @@ import (
 )
 
 func loadCPU(t *testing.T, asmString string) *cpu.CPU {
+	return loadCPUArch(t, asmString, cpu.NMOS)
+}
+
+func loadCPUArch(t *testing.T, asmString string, arch cpu.Architecture) *cpu.CPU {
 	b := strings.NewReader(asmString)
 	r, sm, err := asm.Assemble(b, "test.asm", os.Stdout, 0)
 	if err != nil {
@@ -17,13 +24,22 @@ func loadCPU(t *testing.T, asmString string) *cpu.CPU {
 		return nil
 	}
 
-	mem := cpu.NewFlatMemory()
-	cpu := cpu.NewCPU(cpu.NMOS, mem)
+	var mem cpu.Memory = cpu.NewFlatMemory()
+	if arch == cpu.W65C816 {
+		mem = cpu.NewFlatLongMemory()
+	}
+	cpu := cpu.NewCPU(arch, mem)
 	mem.StoreBytes(sm.Origin, r.Code)
 	cpu.SetPC(sm.Origin)
 	return cpu
 }
 
+func tickCPU(cpu *cpu.CPU, ticks int) {
+	for i := 0; i < ticks; i++ {
+		cpu.Tick()
+	}
+}
+
 func stepCPU(cpu *cpu.CPU, steps int) {
 	for i := 0; i < steps; i++ {
 		cpu.Step()
@@ -69,6 +85,13 @@ func expectMem(t *testing.T, cpu *cpu.CPU, addr uint16, v byte) {
 	}
 }
 
+func expectLongMem(t *testing.T, c *cpu.CPU, addr uint32, v byte) {
+	got := c.Mem.(cpu.LongMemory).LoadByteLong(addr)
+	if got != v {
+		t.Errorf("Memory at $%06X incorrect. exp: $%02X, got: $%02X", addr, v, got)
+	}
+}
+
 func TestAccumulator(t *testing.T) {
 	asm := `
 	.ORG $1000
@@ -188,3 +211,1552 @@ func TestUnused65c02(t *testing.T) {
 	expectPC(t, cpu, 0x1009)
 	expectCycles(t, cpu, 10)
 }
+
+func TestIRQ(t *testing.T) {
+	asm := `
+	.ORG $1000
+	CLI
+	NOP
+	NOP
+	SEI
+	CLI
+	SEI
+	NOP`
+
+	cpu := loadCPU(t, asm)
+	if cpu == nil {
+		return
+	}
+	cpu.Mem.StoreBytes(0x2000, []byte{0x40})
+	cpu.Mem.StoreAddress(0xfffe, 0x2000)
+
+	// IRQ is masked until CLI executes, and the instruction following CLI
+	// runs before the IRQ is serviced.
+	cpu.Reg.InterruptDisable = true
+	cpu.AssertIRQ(1)
+	stepCPU(cpu, 2)
+	expectPC(t, cpu, 0x1002)
+
+	// The interrupt sequence runs in place of the next instruction.
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x2000)
+	expectSP(t, cpu, 0xfc)
+	expectCycles(t, cpu, 11)
+	expectMem(t, cpu, 0x1ff, 0x10)
+	expectMem(t, cpu, 0x1fe, 0x02)
+
+	// The IRQ stays pending until every source releases it, so it is
+	// serviced again as soon as RTI clears the InterruptDisable flag.
+	cpu.AssertIRQ(2)
+	cpu.ReleaseIRQ(1)
+	stepCPU(cpu, 2)
+	expectPC(t, cpu, 0x2000)
+	expectSP(t, cpu, 0xfc)
+
+	cpu.ReleaseIRQ(2)
+	stepCPU(cpu, 2)
+	expectPC(t, cpu, 0x1003)
+	expectSP(t, cpu, 0xff)
+
+	// An IRQ that is pending when SEI executes is serviced after it, with
+	// the InterruptDisable flag set in the pushed status.
+	stepCPU(cpu, 1)
+	cpu.AssertIRQ(1)
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x2000)
+	expectMem(t, cpu, 0x1fe, 0x04)
+	if cpu.Mem.LoadByte(0x1fd)&0x04 == 0 {
+		t.Error("InterruptDisable flag not pushed after SEI")
+	}
+
+	// RTI restores the flag, so the IRQ is masked again. CLI followed by
+	// SEI lets it through once more.
+	stepCPU(cpu, 3)
+	expectPC(t, cpu, 0x1006)
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x2000)
+	expectMem(t, cpu, 0x1fe, 0x06)
+	stepCPU(cpu, 2)
+	expectPC(t, cpu, 0x1007)
+
+	// Tick polls the IRQ line the same way.
+	cpu = loadCPU(t, asm)
+	cpu.Mem.StoreAddress(0xfffe, 0x2000)
+	cpu.Reg.InterruptDisable = true
+	cpu.AssertIRQ(1)
+	tickCPU(cpu, 4)
+	expectPC(t, cpu, 0x1002)
+	tickCPU(cpu, 7)
+	expectPC(t, cpu, 0x2000)
+}
+
+func TestNMI(t *testing.T) {
+	asm := `
+	.ORG $1000
+	SEI
+	NOP
+	NOP
+	NOP`
+
+	cpu := loadCPU(t, asm)
+	if cpu == nil {
+		return
+	}
+	cpu.Mem.StoreBytes(0x2000, []byte{0x40})
+	cpu.Mem.StoreAddress(0xfffa, 0x2000)
+
+	// NMI ignores the InterruptDisable flag.
+	stepCPU(cpu, 1)
+	cpu.SetNMI(true)
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x2000)
+	expectCycles(t, cpu, 9)
+
+	// Holding the line asserted does not retrigger the interrupt.
+	stepCPU(cpu, 2)
+	expectPC(t, cpu, 0x1002)
+
+	cpu.SetNMI(false)
+	cpu.TriggerNMI()
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x2000)
+}
+
+func TestReset(t *testing.T) {
+	asm := `
+	.ORG $1000
+	CLI
+	NOP`
+
+	cpu := loadCPU(t, asm)
+	if cpu == nil {
+		return
+	}
+	cpu.Mem.StoreAddress(0xfffc, 0x1001)
+
+	stepCPU(cpu, 1)
+	cpu.TriggerNMI()
+	cpu.Reset()
+	expectPC(t, cpu, 0x1001)
+	expectSP(t, cpu, 0xfc)
+	expectCycles(t, cpu, 9)
+	if !cpu.Reg.InterruptDisable {
+		t.Error("InterruptDisable flag not set by reset")
+	}
+
+	// The latched NMI is discarded by the reset.
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x1002)
+}
+
+func TestUndocumented(t *testing.T) {
+	asm := `
+	.ORG $1000
+	.ARCH 6502x
+	LDA #$F0		; 2 cycles
+	LDX #$3C		; 2 cycles
+	SAX $10			; 3 cycles
+	LAX $10			; 3 cycles
+	DCP $11			; 5 cycles
+	SLO $12			; 5 cycles
+	JAM			; 2 cycles`
+
+	cpu := loadCPUArch(t, asm, cpu.NMOSX)
+	if cpu == nil {
+		return
+	}
+	cpu.Mem.StoreBytes(0x11, []byte{0x31, 0x81})
+
+	stepCPU(cpu, 6)
+	expectPC(t, cpu, 0x100c)
+	expectCycles(t, cpu, 20)
+	expectACC(t, cpu, 0x32)
+	expectMem(t, cpu, 0x10, 0x30)
+	expectMem(t, cpu, 0x11, 0x30)
+	expectMem(t, cpu, 0x12, 0x02)
+	if cpu.Reg.X != 0x30 {
+		t.Errorf("X register incorrect. exp: $30, got: $%02X", cpu.Reg.X)
+	}
+	if !cpu.Reg.Carry {
+		t.Error("Carry flag not set by SLO")
+	}
+
+	// JAM stops the CPU until it is reset.
+	stepCPU(cpu, 3)
+	expectPC(t, cpu, 0x100c)
+	if !cpu.Stopped() {
+		t.Error("CPU not stopped by JAM")
+	}
+}
+
+func TestUndocumentedResults(t *testing.T) {
+	const flags = cpu.SignBit | cpu.OverflowBit | cpu.ZeroBit | cpu.CarryBit
+	tests := []struct {
+		setup  string // instructions that set up the registers and flags
+		op     string // instruction under test
+		m      byte   // value at $10 before the instruction
+		a, x   byte   // registers after the instruction
+		mem    byte   // value at $10 after the instruction
+		ps     byte   // N, V, Z and C flags after the instruction
+		cycles uint64 // cycles taken by the instruction
+	}{
+		{"LDA #$F0\n SEC", "ARR #$C8", 0, 0xe0, 0, 0, 0x81, 2},
+		{"LDA #$FF\n CLC", "ARR #$40", 0, 0x20, 0, 0, 0x40, 2},
+		{"LDA #$FF\n SED\n SEC", "ARR #$5C", 0, 0x04, 0, 0, 0xc1, 2},
+		{"LDA #$FF\n SED\n CLC", "ARR #$22", 0, 0x11, 0, 0, 0x00, 2},
+		{"LDA #$F3\n LDX #$3E", "SBX #$02", 0, 0xf3, 0x30, 0, 0x01, 2},
+		{"LDA #$0F\n LDX #$FF", "SBX #$10", 0, 0x0f, 0xff, 0, 0x80, 2},
+		{"LDA #$FF\n LDX #$10", "SBX #$10", 0, 0xff, 0x00, 0, 0x03, 2},
+		{"LDA #$F0", "ANC #$8F", 0, 0x80, 0, 0, 0x81, 2},
+		{"LDA #$0F", "ANC #$F0", 0, 0x00, 0, 0, 0x02, 2},
+		{"LDA #$FF", "ALR #$03", 0, 0x01, 0, 0, 0x01, 2},
+		{"LDA #$20\n SEC", "ISC $10", 0x0f, 0x10, 0, 0x10, 0x01, 5},
+		{"LDA #$20\n SED\n SEC", "ISC $10", 0x08, 0x11, 0, 0x09, 0x01, 5},
+		{"LDA #$FF\n SEC", "RLA $10", 0x81, 0x03, 0, 0x03, 0x01, 5},
+		{"LDA #$80", "SRE $10", 0x03, 0x81, 0, 0x01, 0x81, 5},
+		{"LDA #$10\n SEC", "RRA $10", 0x02, 0x91, 0, 0x81, 0x80, 5},
+		{"LDA #$19\n SED\n SEC", "RRA $10", 0x02, 0x00, 0, 0x81, 0x81, 5},
+	}
+
+	for i, test := range tests {
+		src := "\t.ARCH 6502x\n\t.OR $1000\n\t" + test.setup + "\n\t" + test.op
+		c := loadCPUArch(t, src, cpu.NMOSX)
+		c.Mem.StoreByte(0x10, test.m)
+		stepCPU(c, strings.Count(test.setup, "\n")+1)
+
+		cycles := c.Cycles
+		c.Step()
+		ps := c.Reg.SavePS(false) & flags
+		if c.Reg.A != test.a || c.Reg.X != test.x || ps != test.ps {
+			t.Errorf("test %d: %s: A=$%02X X=$%02X flags=$%02X, exp A=$%02X X=$%02X flags=$%02X",
+				i, test.op, c.Reg.A, c.Reg.X, ps, test.a, test.x, test.ps)
+		}
+		if m := c.Mem.LoadByte(0x10); m != test.mem {
+			t.Errorf("test %d: %s: memory $%02X, exp $%02X", i, test.op, m, test.mem)
+		}
+		if c.Cycles-cycles != test.cycles {
+			t.Errorf("test %d: %s: %d cycles, exp %d", i, test.op, c.Cycles-cycles, test.cycles)
+		}
+	}
+}
+
+func TestWDC(t *testing.T) {
+	asm := `
+	.ORG $1000
+	.ARCH w65c02
+	SMB2 $10		; 5 cycles
+	RMB7 $10		; 5 cycles
+	BBR2 $10,$1000		; 5 cycles, not taken
+	BBS2 $10,$1010		; 6 cycles, taken
+	.DH 000000000000
+	CLI			; 2 cycles
+	WAI			; 3 cycles
+	STP			; 3 cycles`
+
+	cpu := loadCPUArch(t, asm, cpu.WDC)
+	if cpu == nil {
+		return
+	}
+	cpu.Mem.StoreBytes(0x10, []byte{0x80})
+	cpu.Mem.StoreBytes(0x2000, []byte{0x40})
+	cpu.Mem.StoreAddress(0xfffe, 0x2000)
+
+	stepCPU(cpu, 4)
+	expectPC(t, cpu, 0x1010)
+	expectCycles(t, cpu, 21)
+	expectMem(t, cpu, 0x10, 0x04)
+
+	// WAI idles until an interrupt is signaled.
+	stepCPU(cpu, 4)
+	expectPC(t, cpu, 0x1012)
+	expectCycles(t, cpu, 28)
+	if !cpu.Waiting() {
+		t.Error("CPU not waiting after WAI")
+	}
+
+	cpu.AssertIRQ(1)
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x2000)
+	cpu.ReleaseIRQ(1)
+	stepCPU(cpu, 1)
+	expectPC(t, cpu, 0x1012)
+
+	// STP stops the CPU until it is reset.
+	stepCPU(cpu, 3)
+	expectPC(t, cpu, 0x1013)
+	if !cpu.Stopped() {
+		t.Error("CPU not stopped by STP")
+	}
+}
+
+func TestW65C816(t *testing.T) {
+	asm := `
+	.ORG $1000
+	.ARCH 65816
+	CLC
+	XCE
+	REP #$30
+	.A16
+	.I16
+	LDA #$1234
+	STA $022000
+	LDX #$2000
+	LDY #$3000
+	LDA #$0001
+	MVN $02,$03
+	SEP #$20
+	.A8
+	LDA $033001`
+
+	cpu := loadCPUArch(t, asm, cpu.W65C816)
+	if cpu == nil {
+		return
+	}
+
+	// Enter native mode and widen all registers.
+	stepCPU(cpu, 3)
+	if cpu.Reg.Emulation || cpu.Reg.MemorySelect || cpu.Reg.IndexSelect {
+		t.Error("CPU not in native mode with 16-bit registers")
+	}
+
+	stepCPU(cpu, 2)
+	expectLongMem(t, cpu, 0x022000, 0x34)
+	expectLongMem(t, cpu, 0x022001, 0x12)
+
+	// The block move repeats until the count in C underflows.
+	stepCPU(cpu, 5)
+	if cpu.Reg.C() != 0xffff {
+		t.Errorf("C register incorrect. exp: $FFFF, got: $%04X", cpu.Reg.C())
+	}
+	if cpu.Reg.X16() != 0x2002 || cpu.Reg.Y16() != 0x3002 {
+		t.Errorf("Index registers incorrect. exp: $2002,$3002, got: $%04X,$%04X",
+			cpu.Reg.X16(), cpu.Reg.Y16())
+	}
+	expectLongMem(t, cpu, 0x033000, 0x34)
+	expectLongMem(t, cpu, 0x033001, 0x12)
+	if cpu.Reg.DBR != 0x03 {
+		t.Errorf("Data bank register incorrect. exp: $03, got: $%02X", cpu.Reg.DBR)
+	}
+
+	stepCPU(cpu, 2)
+	expectACC(t, cpu, 0x12)
+	if cpu.Reg.B != 0xff {
+		t.Errorf("B register incorrect. exp: $FF, got: $%02X", cpu.Reg.B)
+	}
+}
+
+func TestR2A03(t *testing.T) {
+	asm := `
+	.ORG $1000
+	.ARCH 2a03
+	SED
+	CLC
+	LDA #$09
+	ADC #$01
+	STA $10
+	SEC
+	SBC #$01`
+
+	cpu := loadCPUArch(t, asm, cpu.R2A03)
+	if cpu == nil {
+		return
+	}
+
+	// The Decimal flag is set, but the arithmetic is binary.
+	stepCPU(cpu, 5)
+	expectMem(t, cpu, 0x10, 0x0a)
+	stepCPU(cpu, 2)
+	expectACC(t, cpu, 0x09)
+	if !cpu.Reg.Decimal {
+		t.Error("Decimal flag not set by SED")
+	}
+}
+
+// A memory that records the sequence of bus accesses made by the CPU.
+type busRecorder struct {
+	*cpu.FlatMemory
+	log []string
+}
+
+func (m *busRecorder) LoadByte(addr uint16) byte {
+	v := m.FlatMemory.LoadByte(addr)
+	m.log = append(m.log, fmt.Sprintf("R%04X=%02X", addr, v))
+	return v
+}
+
+func (m *busRecorder) LoadBytes(addr uint16, b []byte) {
+	for i := range b {
+		b[i] = m.LoadByte(addr + uint16(i))
+	}
+}
+
+func (m *busRecorder) LoadAddress(addr uint16) uint16 {
+	next := addr + 1
+	if (addr & 0xff) == 0xff {
+		next = addr - 0xff
+	}
+	return uint16(m.LoadByte(addr)) | uint16(m.LoadByte(next))<<8
+}
+
+// Like a Bus, the recorder may be peeked without recording an access.
+func (m *busRecorder) Peek(addr uint16) (byte, bool) {
+	return m.FlatMemory.LoadByte(addr), true
+}
+
+func (m *busRecorder) StoreByte(addr uint16, v byte) {
+	m.log = append(m.log, fmt.Sprintf("W%04X=%02X", addr, v))
+	m.FlatMemory.StoreByte(addr, v)
+}
+
+func expectBus(t *testing.T, m *busRecorder, exp string) {
+	got := strings.Join(m.log, " ")
+	if got != exp {
+		t.Errorf("Bus accesses incorrect.\nexp: %s\ngot: %s", exp, got)
+	}
+	m.log = nil
+}
+
+func TestTick(t *testing.T) {
+	mem := &busRecorder{FlatMemory: cpu.NewFlatMemory()}
+	mem.FlatMemory.StoreBytes(0x1000, []byte{
+		0xe6, 0x10, // INC $10
+		0xbd, 0xff, 0x20, // LDA $20FF,X
+		0x9d, 0x00, 0x20, // STA $2000,X
+		0x48, // PHA
+	})
+	mem.FlatMemory.StoreBytes(0x10, []byte{0x7f})
+
+	cpu := cpu.NewCPU(cpu.NMOS, mem)
+	cpu.SetPC(0x1000)
+	cpu.Reg.X = 0x01
+
+	// The NMOS read-modify-write instructions write the unmodified value
+	// back before writing the result.
+	tickCPU(cpu, 4)
+	expectBus(t, mem, "R1000=E6 R1001=10 R0010=7F W0010=7F")
+	tickCPU(cpu, 1)
+	expectBus(t, mem, "W0010=80")
+
+	// Indexing across a page boundary reads the unfixed address first.
+	// Stores always perform the dummy read.
+	tickCPU(cpu, 10)
+	expectBus(t, mem, "R1002=BD R1003=FF R1004=20 R2000=00 R2100=00 "+
+		"R1005=9D R1006=00 R1007=20 R2001=00 W2001=00")
+
+	// Step completes an instruction started by Tick.
+	tickCPU(cpu, 1)
+	cpu.Step()
+	expectBus(t, mem, "R1008=48 R1009=00 W01FF=00")
+	expectPC(t, cpu, 0x1009)
+	expectCycles(t, cpu, 18)
+}
+
+func TestTickMatchesStep(t *testing.T) {
+	archs := []cpu.Architecture{cpu.NMOS, cpu.CMOS, cpu.NMOSX, cpu.WDC, cpu.R2A03}
+	policies := []cpu.UndefinedPolicy{cpu.UndefinedIgnore, cpu.UndefinedHalt,
+		cpu.UndefinedJam, cpu.UndefinedReset}
+
+	var image [64 * 1024]byte
+	seed := uint32(1)
+	random := func() byte {
+		seed ^= seed << 13
+		seed ^= seed >> 17
+		seed ^= seed << 5
+		return byte(seed)
+	}
+	for i := range image {
+		image[i] = random()
+	}
+
+	for _, arch := range archs {
+		for opcode := 0; opcode < 256; opcode++ {
+			for run := 0; run < 8; run++ {
+				policy := policies[run%len(policies)]
+				var cpus [2]*cpu.CPU
+				reg := cpu.Registers{
+					A:  random(),
+					X:  random(),
+					Y:  random(),
+					SP: random(),
+					PC: 0x1000,
+				}
+				reg.RestorePS(random())
+				for i := range cpus {
+					mem := cpu.NewFlatMemory()
+					mem.StoreBytes(0, image[:])
+					mem.StoreBytes(0x1000, []byte{byte(opcode), byte(run * 0x21), 0x10})
+					cpus[i] = cpu.NewCPU(arch, mem)
+					cpus[i].Reg = reg
+					cpus[i].Undefined = policy
+				}
+
+				cpus[0].Step()
+				for cpus[1].Cycles < cpus[0].Cycles {
+					cpus[1].Tick()
+				}
+
+				var m0, m1 [64 * 1024]byte
+				cpus[0].Mem.LoadBytes(0, m0[:])
+				cpus[1].Mem.LoadBytes(0, m1[:])
+				if cpus[0].Reg != cpus[1].Reg || cpus[0].Cycles != cpus[1].Cycles || m0 != m1 {
+					t.Errorf("arch %d opcode $%02X run %d policy %d: Tick and Step results differ",
+						arch, opcode, run, policy)
+				}
+			}
+		}
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := cpu.NewBus()
+	ram := cpu.NewRAM(0x800)
+	bus.Map(0x0000, 0x1fff, ram)
+	bus.Map(0xf000, 0xffff, cpu.NewROM([]byte{0xea, 0x4c, 0x00, 0xf0}))
+
+	var reads, writes int
+	var latch byte
+	io := &cpu.IO{
+		OnRead: func(offset uint16) byte {
+			reads++
+			return latch + byte(offset)
+		},
+		OnWrite: func(offset uint16, v byte) {
+			writes++
+			latch = v
+		},
+	}
+	bus.Map(0x4000, 0x43ff, cpu.NewMirror(io, 4))
+
+	// RAM mirrors every 2K.
+	bus.StoreByte(0x0801, 0x12)
+	if v := bus.LoadByte(0x1001); v != 0x12 || ram.Bytes()[1] != 0x12 {
+		t.Errorf("RAM mirror incorrect. exp: $12, got: $%02X", v)
+	}
+
+	// ROM ignores writes and repeats through its region.
+	bus.StoreByte(0xf000, 0x00)
+	if v := bus.LoadByte(0xf004); v != 0xea {
+		t.Errorf("ROM incorrect. exp: $EA, got: $%02X", v)
+	}
+
+	// I/O handlers see the mirrored offset.
+	bus.StoreByte(0x4105, 0x20)
+	if v := bus.LoadByte(0x4007); v != 0x23 || reads != 1 || writes != 1 {
+		t.Errorf("I/O incorrect. exp: $23, got: $%02X", v)
+	}
+
+	// Unmapped reads return the open bus value.
+	if v := bus.LoadByte(0x8000); v != 0x23 {
+		t.Errorf("Open bus incorrect. exp: $23, got: $%02X", v)
+	}
+
+	// Peeking has no side effects, and only RAM and ROM can be peeked.
+	if v, ok := bus.Peek(0x1001); v != 0x12 || !ok {
+		t.Errorf("RAM peek incorrect. exp: $12, got: $%02X", v)
+	}
+	if v, ok := bus.Peek(0xf004); v != 0xea || !ok {
+		t.Errorf("ROM peek incorrect. exp: $EA, got: $%02X", v)
+	}
+	if _, ok := bus.Peek(0x4007); ok || reads != 1 {
+		t.Error("I/O peek incorrect.")
+	}
+	if _, ok := bus.Peek(0x8000); ok || bus.LoadByte(0x8000) != 0x23 {
+		t.Error("Unmapped peek incorrect.")
+	}
+
+	var unmapped []uint16
+	bus.UnmappedRead = func(addr uint16) byte {
+		unmapped = append(unmapped, addr)
+		return 0xff
+	}
+	bus.UnmappedWrite = func(addr uint16, v byte) {
+		unmapped = append(unmapped, addr)
+	}
+	bus.StoreByte(0x8000, 0x00)
+	if v := bus.LoadByte(0x8001); v != 0xff || len(unmapped) != 2 {
+		t.Errorf("Unmapped callbacks incorrect. got: %v", unmapped)
+	}
+
+	// Run code from ROM.
+	c := cpu.NewCPU(cpu.NMOS, bus)
+	c.SetPC(0xf000)
+	stepCPU(c, 2)
+	expectPC(t, c, 0xf000)
+
+	// Later mappings replace earlier ones.
+	bus.Map(0x1000, 0x1fff, cpu.NewRAM(0x1000))
+	if v := bus.LoadByte(0x1001); v != 0x00 {
+		t.Errorf("Remap incorrect. exp: $00, got: $%02X", v)
+	}
+	bus.Unmap(0x1000, 0x1fff)
+	if bus.Device(0x1001) != nil || bus.Device(0x0001) != ram {
+		t.Error("Unmap incorrect.")
+	}
+
+	// Regions that are replaced or unmapped free their slots.
+	for i := 0; i < 1000; i++ {
+		if err := bus.Map(0x2000, 0x2fff, cpu.NewRAM(0x100)); err != nil {
+			t.Fatalf("Remap %d failed: %v", i, err)
+		}
+	}
+	bus.Unmap(0x2000, 0x2fff)
+	var state bytes.Buffer
+	if err := bus.SaveState(&state); err != nil || state.Bytes()[1] != 3 {
+		t.Errorf("Bus regions incorrect. exp: 3, got: %d", state.Bytes()[1])
+	}
+	if bus.Device(0x0001) != ram || bus.LoadByte(0xf004) != 0xea {
+		t.Error("Regions renumbered incorrectly.")
+	}
+	for i := 0; i < 252; i++ {
+		if err := bus.Map(uint16(0x2000+i), uint16(0x2000+i), cpu.NewRAM(1)); err != nil {
+			t.Fatalf("Map %d failed: %v", i, err)
+		}
+	}
+	if err := bus.Map(0x3000, 0x3000, cpu.NewRAM(1)); err != cpu.ErrTooManyRegions {
+		t.Error("Too many regions not rejected.")
+	}
+	if err := bus.Map(0x2000, 0x2000, cpu.NewRAM(1)); err != nil {
+		t.Errorf("Replacing region with all slots used failed: %v", err)
+	}
+
+	// Empty devices can't be mapped.
+	empty := []cpu.Device{cpu.NewRAM(0), cpu.NewROM(nil), cpu.NewMirror(ram, 0),
+		cpu.NewMirror(cpu.NewROM([]byte{}), 4)}
+	for i, d := range empty {
+		if err := bus.Map(0x3000, 0x30ff, d); err != cpu.ErrInvalidRegion {
+			t.Errorf("Empty device %d not rejected.", i)
+		}
+	}
+}
+
+type bpHandler struct {
+	hits []int
+}
+
+func (h *bpHandler) OnBreakpoint(c *cpu.CPU, b *cpu.Breakpoint) {
+	h.hits = append(h.hits, b.Bank)
+}
+
+func (h *bpHandler) OnDataBreakpoint(c *cpu.CPU, b *cpu.DataBreakpoint) {
+}
+
+func TestBankedMemory(t *testing.T) {
+	mem := cpu.NewBankedMemory()
+	w, err := mem.AddWindow(0x8000, 0x4000, 4, 0xffff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.AddWindow(0xb000, 0x2000, 2, 0xfffe); err != cpu.ErrInvalidWindow {
+		t.Error("Overlapping window not rejected.")
+	}
+
+	// Each bank jumps back to the main loop, which cycles through the
+	// banks.
+	for bank := 0; bank < w.Banks(); bank++ {
+		copy(w.Storage(bank), []byte{0xe8, 0x4c, 0x00, 0x10}) // INX; JMP $1000
+	}
+	mem.StoreBytes(0x1000, []byte{
+		0x8a,             // TXA
+		0x8d, 0xff, 0xff, // STA $FFFF
+		0x4c, 0x00, 0x80, // JMP $8000
+	})
+
+	c := cpu.NewCPU(cpu.NMOS, mem)
+	h := &bpHandler{}
+	d := cpu.NewDebugger(h)
+	d.AddBankBreakpoint(0x8000, 2)
+	c.AttachDebugger(d)
+	c.SetPC(0x1000)
+
+	stepCPU(c, 30)
+	if len(h.hits) != 1 || h.hits[0] != 2 {
+		t.Errorf("Banked breakpoint incorrect. got: %v", h.hits)
+	}
+	if mem.Bank(0x9000) != 1 || mem.Bank(0x1000) != 0 || w.Bank() != 1 {
+		t.Errorf("Bank incorrect. exp: 1, got: %d", w.Bank())
+	}
+
+	// Selecting a bank doesn't store to memory.
+	if v := mem.LoadByte(0xffff); v != 0x00 {
+		t.Errorf("Select address stored. got: $%02X", v)
+	}
+
+	// Stores go to the mapped bank.
+	mem.StoreByte(0x8004, 0x55)
+	if w.Storage(1)[4] != 0x55 || w.Storage(0)[4] != 0x00 {
+		t.Error("Bank store incorrect.")
+	}
+	w.ReadOnly = true
+	mem.StoreByte(0x8004, 0xaa)
+	if mem.LoadByte(0x8004) != 0x55 {
+		t.Error("Read-only bank stored.")
+	}
+
+	// Bank numbers wrap in both directions.
+	for _, c := range []struct{ bank, exp int }{{5, 1}, {-1, 3}, {-6, 2}} {
+		w.SetBank(c.bank)
+		if w.Bank() != c.exp {
+			t.Errorf("SetBank(%d) incorrect. exp: %d, got: %d", c.bank, c.exp, w.Bank())
+		}
+		mem.LoadByte(0x8000)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	bus := cpu.NewBus()
+	ram := cpu.NewRAM(0x8000)
+	bus.Map(0x0000, 0x7fff, ram)
+	bus.Map(0xf000, 0xffff, cpu.NewROM([]byte{0xe8, 0x4c, 0x00, 0xf0})) // INX; JMP $F000
+
+	c := cpu.NewCPU(cpu.CMOS, bus)
+	c.SetPC(0x1000)
+	bus.StoreBytes(0x1000, []byte{
+		0xa9, 0x33, // LDA #$33
+		0x85, 0x10, // STA $10
+		0xe6, 0x10, // INC $10
+		0x58,             // CLI
+		0x4c, 0x06, 0x10, // JMP $1006
+	})
+	stepCPU(c, 3)
+	c.AssertIRQ(1)
+
+	var b bytes.Buffer
+	if err := c.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	stepCPU(c, 4)
+	reg, cycles := c.Reg, c.Cycles
+
+	// Restore into a new CPU of a different architecture. It takes on the
+	// saved architecture.
+	bus2 := cpu.NewBus()
+	bus2.Map(0x0000, 0x7fff, cpu.NewRAM(0x8000))
+	bus2.Map(0xf000, 0xffff, cpu.NewROM([]byte{0xe8, 0x4c, 0x00, 0xf0}))
+	c2 := cpu.NewCPU(cpu.NMOS, bus2)
+	if err := c2.Load(&b); err != nil {
+		t.Fatal(err)
+	}
+	if c2.Arch != cpu.CMOS || c2.IRQ() != 1 {
+		t.Error("Snapshot CPU state incorrect.")
+	}
+	expectPC(t, c2, 0x1006)
+
+	// The restored CPU services the pending IRQ just like the original.
+	stepCPU(c2, 4)
+	if c2.Reg != reg || c2.Cycles != cycles {
+		t.Error("Restored CPU diverged from the original.")
+	}
+	if v := bus2.LoadByte(0x10); v != 0x34 {
+		t.Errorf("Restored memory incorrect. exp: $34, got: $%02X", v)
+	}
+
+	// Loading into a bus with different devices fails.
+	b.Reset()
+	c.Save(&b)
+	c3 := cpu.NewCPU(cpu.CMOS, cpu.NewBus())
+	if err := c3.Load(&b); err != cpu.ErrSnapshotMismatch {
+		t.Errorf("Mismatched snapshot load incorrect. got: %v", err)
+	}
+
+	// A failed load changes neither the CPU nor its memory, even if some of
+	// the devices were restored before the mismatch was found.
+	b.Reset()
+	c.Save(&b)
+	bus4 := cpu.NewBus()
+	bus4.Map(0x0000, 0x7fff, cpu.NewRAM(0x8000))
+	bus4.Map(0xf000, 0xffff, cpu.NewRAM(0x1000))
+	bus4.StoreByte(0x10, 0xaa)
+	c4 := cpu.NewCPU(cpu.NMOS, bus4)
+	c4.SetPC(0x2000)
+	if err := c4.Load(&b); err == nil {
+		t.Error("Mismatched snapshot loaded.")
+	}
+	if v := bus4.LoadByte(0x10); v != 0xaa || c4.Arch != cpu.NMOS || c4.Reg.PC != 0x2000 {
+		t.Errorf("Failed load changed the CPU or memory. got: $%02X %v $%04X", v, c4.Arch, c4.Reg.PC)
+	}
+
+	// A CPU halted by an undefined opcode stays halted.
+	for _, policy := range []cpu.UndefinedPolicy{cpu.UndefinedHalt, cpu.UndefinedJam} {
+		mem := cpu.NewFlatMemory()
+		mem.StoreByte(0x1000, 0x02)
+		c5 := cpu.NewCPU(cpu.CMOS, mem)
+		c5.Undefined = policy
+		c5.SetPC(0x1000)
+		c5.Step()
+
+		b.Reset()
+		if err := c5.Save(&b); err != nil {
+			t.Fatal(err)
+		}
+		c6 := cpu.NewCPU(cpu.CMOS, cpu.NewFlatMemory())
+		if err := c6.Load(&b); err != nil {
+			t.Fatal(err)
+		}
+		e, ok := c6.Fault().(*cpu.UndefinedOpcodeError)
+		if c6.Halted() != c5.Halted() || c6.Stopped() != c5.Stopped() || !ok ||
+			e.Opcode != 0x02 || e.Address != 0x1000 {
+			t.Errorf("Snapshot fault incorrect for policy %d. got: %v", policy, c6.Fault())
+		}
+		c6.Step()
+		expectPC(t, c6, 0x1000)
+	}
+}
+
+func TestUndefinedOpcode(t *testing.T) {
+	newCPU := func(policy cpu.UndefinedPolicy) *cpu.CPU {
+		mem := cpu.NewFlatMemory()
+		mem.StoreBytes(0x1000, []byte{0xea, 0x02, 0xea}) // NOP; ???; NOP
+		mem.StoreAddress(0xfffc, 0x2000)
+		c := cpu.NewCPU(cpu.NMOS, mem)
+		c.SetPC(0x1000)
+		c.Undefined = policy
+		return c
+	}
+
+	// The NMOS $02 opcode is ignored as a 2-byte NOP.
+	c := newCPU(cpu.UndefinedIgnore)
+	stepCPU(c, 2)
+	expectPC(t, c, 0x1003)
+
+	c = newCPU(cpu.UndefinedHalt)
+	stepCPU(c, 3)
+	expectPC(t, c, 0x1001)
+	expectCycles(t, c, 2)
+	err, ok := c.Fault().(*cpu.UndefinedOpcodeError)
+	if !ok || !c.Halted() || err.Opcode != 0x02 || err.Address != 0x1001 {
+		t.Errorf("Halt incorrect. got: %v", c.Fault())
+	}
+	c.Reset()
+	if c.Halted() || c.Fault() != nil {
+		t.Error("Reset didn't clear the halt.")
+	}
+	expectPC(t, c, 0x2000)
+
+	c = newCPU(cpu.UndefinedHalt)
+	tickCPU(c, 4)
+	expectPC(t, c, 0x1001)
+	expectCycles(t, c, 2)
+
+	c = newCPU(cpu.UndefinedJam)
+	stepCPU(c, 3)
+	expectPC(t, c, 0x1001)
+	expectCycles(t, c, 4)
+	if !c.Stopped() || c.Fault() == nil {
+		t.Error("Jam incorrect.")
+	}
+
+	var trapped []byte
+	c = newCPU(cpu.UndefinedTrap)
+	c.OnUndefined = func(c *cpu.CPU, inst *cpu.Instruction) {
+		trapped = append(trapped, inst.Opcode)
+		c.Reg.PC++
+	}
+	stepCPU(c, 2)
+	expectPC(t, c, 0x1002)
+	if len(trapped) != 1 || trapped[0] != 0x02 {
+		t.Errorf("Trap incorrect. got: %v", trapped)
+	}
+
+	c = newCPU(cpu.UndefinedReset)
+	stepCPU(c, 2)
+	expectPC(t, c, 0x2000)
+}
+
+func TestRun(t *testing.T) {
+	src := `
+	.ARCH	65c02
+	.OR	$1000
+	INX
+	STX	$2000
+	JMP	$1000`
+
+	c := loadCPUArch(t, src, cpu.CMOS)
+	s := c.Run(context.Background(), cpu.Budget{Instructions: 5})
+	if s.Reason != cpu.StopBudget {
+		t.Errorf("Instruction budget incorrect. got: %v", s.Reason)
+	}
+	expectPC(t, c, 0x1004)
+	expectCycles(t, c, 2+4+3+2+4)
+
+	c = loadCPUArch(t, src, cpu.CMOS)
+	c.Run(context.Background(), cpu.Budget{Cycles: 10})
+	expectCycles(t, c, 2+4+3+2)
+
+	c = loadCPUArch(t, src, cpu.CMOS)
+	d := cpu.NewDebugger(nil)
+	b := d.AddBreakpoint(0x1004)
+	d.AddConditionalDataBreakpoint(0x2000, 3)
+	c.AttachDebugger(d)
+	s = c.Run(context.Background(), cpu.Budget{})
+	if s.Reason != cpu.StopBreakpoint || s.Breakpoint != b {
+		t.Errorf("Breakpoint incorrect. got: %v", s.Reason)
+	}
+	expectPC(t, c, 0x1004)
+	c.Run(context.Background(), cpu.Budget{})
+	s = c.Run(context.Background(), cpu.Budget{})
+	if s.Reason != cpu.StopDataBreakpoint || s.DataBreakpoint == nil || s.Breakpoint != b {
+		t.Errorf("Data breakpoint incorrect. got: %v", s.Reason)
+	}
+	expectMem(t, c, 0x2000, 0x03)
+
+	c = loadCPUArch(t, src, cpu.CMOS)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s = c.Run(ctx, cpu.Budget{})
+	if s.Reason != cpu.StopCanceled || s.Err != context.Canceled {
+		t.Errorf("Cancel incorrect. got: %v", s.Reason)
+	}
+
+	c = loadCPUArch(t, "\t.ARCH 65c02\n\t.OR $1000\n\tNOP\n\t.DB $02", cpu.NMOS)
+	c.Undefined = cpu.UndefinedHalt
+	s = c.Run(context.Background(), cpu.Budget{})
+	if s.Reason != cpu.StopHalted || s.Err == nil {
+		t.Errorf("Halt incorrect. got: %v", s.Reason)
+	}
+	expectPC(t, c, 0x1001)
+}
+
+type traceRecorder struct {
+	log []string
+}
+
+func (r *traceRecorder) BeforeInstruction(c *cpu.CPU, t *cpu.Trace) {
+	r.log = append(r.log, fmt.Sprintf("%04X %s %X", t.PC, t.Inst.Name, t.Operand))
+}
+
+func (r *traceRecorder) AfterInstruction(c *cpu.CPU, t *cpu.Trace) {
+	s := fmt.Sprintf(" A=%02X->%02X C=%d", t.Reg.A, c.Reg.A, t.Cycles)
+	if t.HasAddress {
+		s += fmt.Sprintf(" EA=%04X", t.Address)
+	}
+	r.log[len(r.log)-1] += s
+}
+
+func (r *traceRecorder) OnRead(c *cpu.CPU, addr uint16, v byte) {
+	r.log = append(r.log, fmt.Sprintf("R%04X", addr))
+}
+
+func (r *traceRecorder) OnWrite(c *cpu.CPU, addr uint16, v byte) {
+	r.log = append(r.log, fmt.Sprintf("W%04X=%02X", addr, v))
+}
+
+func TestTracer(t *testing.T) {
+	src := `
+	.ARCH	6502
+	.OR	$1000
+	LDX	#$01
+	LDA	$10,X
+	STA	($20),Y
+	BNE	$1000`
+
+	exp := []string{
+		"1000 LDX 01 A=00->00 C=2",
+		"1002 LDA 10 A=00->00 C=4 EA=0011",
+		"1004 STA 20 A=00->00 C=6 EA=3000",
+		"1006 BNE F8 A=00->00 C=2 EA=1000",
+	}
+
+	for _, tick := range []bool{false, true} {
+		c := loadCPU(t, src)
+		c.Mem.StoreAddress(0x20, 0x3000)
+		r := &traceRecorder{}
+		c.AttachTracer(r)
+		if tick {
+			tickCPU(c, 14)
+		} else {
+			stepCPU(c, 4)
+		}
+		if strings.Join(r.log, "\n") != strings.Join(exp, "\n") {
+			t.Errorf("Trace incorrect (tick=%v). got:\n%s", tick, strings.Join(r.log, "\n"))
+		}
+
+		c.DetachTracer(r)
+		c.AttachMemoryHook(r)
+		r.log = nil
+		c.SetPC(0x1004)
+		c.Step()
+		c.DetachMemoryHook(r)
+		c.Step()
+		if got := strings.Join(r.log, " "); got != "R1004 R1005 R0020 R0021 W3000=00" {
+			t.Errorf("Memory hook incorrect (tick=%v). got: %s", tick, got)
+		}
+	}
+}
+
+func TestTickTracer(t *testing.T) {
+	code := []byte{
+		0xa2, 0x01, // LDX #$01
+		0xb5, 0x10, // LDA $10,X
+		0x91, 0x20, // STA ($20),Y
+		0x6c, 0x30, 0x00, // JMP ($0030)
+	}
+
+	// Ticking with a tracer attached makes the same bus accesses as ticking
+	// without one, including the pointers of the indirect instructions.
+	var logs [2][]string
+	for i, trace := range []bool{false, true} {
+		mem := &busRecorder{FlatMemory: cpu.NewFlatMemory()}
+		mem.FlatMemory.StoreBytes(0x1000, code)
+		mem.FlatMemory.StoreBytes(0x2000, []byte{0x20, 0x00, 0x30}) // JSR $3000
+		mem.FlatMemory.StoreAddress(0x20, 0x3000)
+		mem.FlatMemory.StoreAddress(0x30, 0x2000)
+		c := cpu.NewCPU(cpu.NMOS, mem)
+		c.SetPC(0x1000)
+		r := &traceRecorder{}
+		if trace {
+			c.AttachTracer(r)
+		}
+		tickCPU(c, 23)
+		logs[i] = mem.log
+
+		if trace {
+			exp := "1000 LDX 01 A=00->00 C=2\n" +
+				"1002 LDA 10 A=00->00 C=4 EA=0011\n" +
+				"1004 STA 20 A=00->00 C=6 EA=3000\n" +
+				"1006 JMP 3000 A=00->00 C=5 EA=2000\n" +
+				"2000 JSR 0030 A=00->00 C=6 EA=3000"
+			if got := strings.Join(r.log, "\n"); got != exp {
+				t.Errorf("Trace incorrect. got:\n%s", got)
+			}
+		}
+	}
+	if got, exp := strings.Join(logs[1], " "), strings.Join(logs[0], " "); got != exp {
+		t.Errorf("Bus accesses incorrect.\nexp: %s\ngot: %s", exp, got)
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	src := `
+	.ARCH	6502
+	.OR	$1000
+	LDA	$2000
+	STA	$2001
+	INC	$2002
+	JMP	$1000`
+
+	c := loadCPU(t, src)
+	c.Mem.StoreByte(0x2000, 0x81)
+	d := cpu.NewDebugger(nil)
+	c.AttachDebugger(d)
+	r := d.AddWatchpoint(0x2000, 0x2000, cpu.WatchRead)
+	w := d.AddWatchpoint(0x2001, 0x2002, cpu.WatchWrite)
+	w.Mask, w.MatchNew, w.New = 0x0f, true, 0x03
+
+	s := c.Run(context.Background(), cpu.Budget{})
+	if s.Reason != cpu.StopWatchpoint || s.Watch.Watchpoint != r || s.Watch.New != 0x81 {
+		t.Errorf("Read watchpoint incorrect. got: %v", s.Reason)
+	}
+	expectPC(t, c, 0x1003)
+
+	// INC $2002 writes $01, $02 and $03 on successive passes. Only the
+	// third matches the masked value.
+	r.Disabled = true
+	s = c.Run(context.Background(), cpu.Budget{})
+	if s.Reason != cpu.StopWatchpoint || s.Watch.Address != 0x2002 || s.Watch.New != 0x03 {
+		t.Errorf("Write watchpoint incorrect. got: %v", s.Watch)
+	}
+	expectPC(t, c, 0x1009)
+
+	d.RemoveWatchpoint(w.ID)
+	w = d.AddWatchpoint(0x2001, 0x2001, cpu.WatchWrite)
+	w.Changed = true
+	c.Mem.StoreByte(0x2000, 0x81)
+	s = c.Run(context.Background(), cpu.Budget{Instructions: 20})
+	if s.Reason != cpu.StopBudget {
+		t.Errorf("Changed watchpoint hit. got: %v", s.Watch)
+	}
+	c.Mem.StoreByte(0x2000, 0x82)
+	s = c.Run(context.Background(), cpu.Budget{})
+	if s.Reason != cpu.StopWatchpoint || s.Watch.Old != 0x81 || s.Watch.New != 0x82 {
+		t.Errorf("Changed watchpoint incorrect. got: %v", s.Watch)
+	}
+	if len(d.GetWatchpoints()) != 2 || d.GetWatchpoint(w.ID) != w {
+		t.Error("Watchpoint list incorrect.")
+	}
+}
+
+type xAtLeast byte
+
+func (x xAtLeast) Check(c *cpu.CPU) bool {
+	return c.Reg.X >= byte(x)
+}
+
+func TestConditionalBreakpoint(t *testing.T) {
+	src := `
+	.ARCH	65c02
+	.OR	$1000
+	INX
+	STX	$2000
+	JMP	$1000`
+
+	c := loadCPUArch(t, src, cpu.CMOS)
+	d := cpu.NewDebugger(nil)
+	b := d.AddBreakpoint(0x1001)
+	b.Condition = xAtLeast(3)
+	b.Ignore = 1
+	c.AttachDebugger(d)
+
+	s := c.Run(context.Background(), cpu.Budget{Instructions: 100})
+	if s.Reason != cpu.StopBreakpoint || s.Breakpoint != b {
+		t.Errorf("Conditional breakpoint incorrect. got: %v", s.Reason)
+	}
+	expectPC(t, c, 0x1001)
+	if c.Reg.X != 0x04 {
+		t.Errorf("X register incorrect. exp: $04, got: $%02X", c.Reg.X)
+	}
+	if b.Hits != 2 || b.Ignore != 0 {
+		t.Errorf("Breakpoint counts incorrect. got: hits=%d ignore=%d", b.Hits, b.Ignore)
+	}
+
+	s = c.Run(context.Background(), cpu.Budget{Instructions: 100})
+	if s.Reason != cpu.StopBreakpoint {
+		t.Errorf("Conditional breakpoint incorrect. got: %v", s.Reason)
+	}
+	if c.Reg.X != 0x05 {
+		t.Errorf("X register incorrect. exp: $05, got: $%02X", c.Reg.X)
+	}
+	if b.Hits != 3 {
+		t.Errorf("Breakpoint hits incorrect. got: %d", b.Hits)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	src := `
+	.ARCH	65c02
+	.OR	$1000
+	LDX	#0
+LOOP	INX
+	STX	$2000
+	JSR	SUB
+	JMP	LOOP
+SUB	INC	$2001
+	RTS`
+
+	c := loadCPUArch(t, src, cpu.CMOS)
+	h := cpu.NewHistory(100)
+	c.AttachHistory(h)
+
+	reg, cycles := c.Reg, c.Cycles
+	for i := 0; i < 30; i++ {
+		c.Step()
+	}
+	reg2, cycles2 := c.Reg, c.Cycles
+	if h.Len() != 30 {
+		t.Errorf("History length incorrect. exp: 30, got: %d", h.Len())
+	}
+	if e := h.Entry(h.Len() - 2); fmt.Sprintf("%04X %X", e.PC, e.Code) != "100C EE0120" {
+		t.Errorf("History entry incorrect. got: %04X %X", e.PC, e.Code)
+	}
+
+	for i := 0; i < 30; i++ {
+		if err := h.StepBack(c); err != nil {
+			t.Fatalf("StepBack failed: %v", err)
+		}
+	}
+	if err := h.StepBack(c); err != cpu.ErrHistoryEmpty {
+		t.Errorf("StepBack on empty history incorrect. got: %v", err)
+	}
+	if c.Reg != reg {
+		t.Errorf("Registers not rewound. exp: %+v, got: %+v", reg, c.Reg)
+	}
+	expectCycles(t, c, cycles)
+	expectMem(t, c, 0x2000, 0x00)
+	expectMem(t, c, 0x2001, 0x00)
+	expectMem(t, c, 0x01ff, 0x00)
+
+	// Running forward again reaches the same state.
+	for i := 0; i < 30; i++ {
+		c.Step()
+	}
+	if c.Reg != reg2 {
+		t.Errorf("Registers not replayed. exp: %+v, got: %+v", reg2, c.Reg)
+	}
+	expectCycles(t, c, cycles2)
+
+	// Only the most recent instructions are kept.
+	h = cpu.NewHistory(4)
+	c.AttachHistory(h)
+	for i := 0; i < 10; i++ {
+		c.Step()
+	}
+	if h.Len() != 4 {
+		t.Errorf("History length incorrect. exp: 4, got: %d", h.Len())
+	}
+	last := h.Entry(3).PC
+	h.StepBack(c)
+	expectPC(t, c, last)
+
+	// Bank switches are rewound, and bytes are restored to the bank that
+	// was mapped when they were written.
+	banked := cpu.NewBankedMemory()
+	w, _ := banked.AddWindow(0x8000, 0x1000, 4, 0x7fff)
+	banked.StoreBytes(0x1000, []byte{
+		0xa9, 0x01, // LDA #1
+		0x8d, 0x00, 0x80, // STA $8000
+		0x8d, 0xff, 0x7f, // STA $7FFF
+		0xa9, 0x02, // LDA #2
+		0x8d, 0x00, 0x80, // STA $8000
+	})
+	c = cpu.NewCPU(cpu.CMOS, banked)
+	c.SetPC(0x1000)
+	h = cpu.NewHistory(10)
+	c.AttachHistory(h)
+	stepCPU(c, 5)
+	if w.Bank() != 1 || w.Storage(0)[0] != 1 || w.Storage(1)[0] != 2 {
+		t.Errorf("Banked stores incorrect. bank: %d", w.Bank())
+	}
+	for _, exp := range []struct {
+		bank   int
+		b0, b1 byte
+	}{{1, 1, 0}, {1, 1, 0}, {0, 1, 0}, {0, 0, 0}} {
+		h.StepBack(c)
+		if w.Bank() != exp.bank || w.Storage(0)[0] != exp.b0 || w.Storage(1)[0] != exp.b1 {
+			t.Errorf("Banked rewind incorrect. exp: %d $%02X $%02X, got: %d $%02X $%02X",
+				exp.bank, exp.b0, exp.b1, w.Bank(), w.Storage(0)[0], w.Storage(1)[0])
+		}
+	}
+	if v := banked.LoadByte(0x7fff); v != 0x00 {
+		t.Errorf("Bank select address incorrect. exp: $00, got: $%02X", v)
+	}
+
+	// Stores to devices that can't be peeked aren't recorded, and don't
+	// read the device.
+	bus := cpu.NewBus()
+	bus.Map(0x0000, 0x7fff, cpu.NewRAM(0x8000))
+	reads := 0
+	bus.Map(0x8000, 0x8000, &cpu.IO{OnRead: func(uint16) byte {
+		reads++
+		return 0
+	}})
+	bus.StoreBytes(0x1000, []byte{
+		0x8d, 0x00, 0x80, // STA $8000
+		0x8d, 0x00, 0x20, // STA $2000
+	})
+	bus.StoreByte(0x2000, 0x55)
+	c = cpu.NewCPU(cpu.CMOS, bus)
+	c.SetPC(0x1000)
+	c.Reg.A = 0xaa
+	h = cpu.NewHistory(10)
+	c.AttachHistory(h)
+	stepCPU(c, 2)
+	h.StepBack(c)
+	h.StepBack(c)
+	if reads != 0 {
+		t.Errorf("I/O reads incorrect. exp: 0, got: %d", reads)
+	}
+	expectPC(t, c, 0x1000)
+	expectMem(t, c, 0x2000, 0x55)
+
+	// The frames of attached call stacks are restored, including frames
+	// removed by returns. Running the RTS again after rewinding it finds
+	// its frame on the stack checker's call stack.
+	c = loadCPUArch(t, src, cpu.CMOS)
+	cs := cpu.NewCallStack()
+	c.AttachTracer(cs)
+	sc := cpu.NewStackChecker()
+	sc.CheckReturns = true
+	c.AttachTracer(sc)
+	h = cpu.NewHistory(10)
+	c.AttachHistory(h)
+	stepCPU(c, 6) // through the RTS
+	if cs.Depth() != 0 {
+		t.Errorf("Call stack depth incorrect. exp: 0, got: %d", cs.Depth())
+	}
+	h.StepBack(c)
+	if cs.Depth() != 1 || cs.Frames[0].Site != 0x1006 {
+		t.Errorf("Call stack not restored. got: %+v", cs.Frames)
+	}
+	if stop := c.Run(context.Background(), cpu.Budget{Instructions: 1}); stop.Reason != cpu.StopBudget {
+		t.Errorf("Stop reason incorrect. exp: %v, got: %v", cpu.StopBudget, stop.Reason)
+	}
+	h.StepBack(c)
+	h.StepBack(c)
+	h.StepBack(c)
+	if cs.Depth() != 0 {
+		t.Errorf("Call stack depth incorrect. exp: 0, got: %d", cs.Depth())
+	}
+}
+
+func TestCallStack(t *testing.T) {
+	src := `
+	.ARCH	65c02
+	.OR	$1000
+	JSR	SUBA
+	CLI
+	NOP
+	NOP
+SUBA	JSR	SUBB
+	RTS
+SUBB	PLA
+	PLA
+	RTS`
+
+	c := loadCPUArch(t, src, cpu.CMOS)
+	c.Mem.StoreAddress(0xfffe, 0x2000)
+	c.Mem.StoreByte(0x2000, 0x40) // RTI
+
+	cs := cpu.NewCallStack()
+	var mismatches []cpu.CallMismatch
+	cs.OnMismatch = func(c *cpu.CPU, m *cpu.CallMismatch) {
+		mismatches = append(mismatches, *m)
+	}
+	c.AttachTracer(cs)
+
+	c.Step()
+	c.Step()
+	if cs.Depth() != 2 {
+		t.Fatalf("Call depth incorrect. exp: 2, got: %d", cs.Depth())
+	}
+	if f := cs.Frames[1]; f.Site != 0x1006 || f.Target != 0x100a || f.Return != 0x1009 {
+		t.Errorf("Call frame incorrect. got: %+v", f)
+	}
+
+	// SUBB discards its return address and returns to SUBA's caller.
+	c.Step()
+	c.Step()
+	c.Step()
+	expectPC(t, c, 0x1003)
+	if cs.Depth() != 0 {
+		t.Errorf("Call depth incorrect. exp: 0, got: %d", cs.Depth())
+	}
+	if len(mismatches) != 1 || !mismatches[0].Discarded || mismatches[0].Frame.Target != 0x100a {
+		t.Errorf("Call mismatches incorrect. got: %+v", mismatches)
+	}
+
+	c.Step()
+	c.AssertIRQ(1)
+	c.Step()
+	c.ReleaseIRQ(1)
+	if cs.Depth() != 1 || cs.Frames[0].Kind != cpu.CallInterrupt || cs.Frames[0].Return != 0x1004 {
+		t.Errorf("Interrupt frame incorrect. got: %+v", cs.Frames)
+	}
+	c.Step()
+	expectPC(t, c, 0x1004)
+	if cs.Depth() != 0 || len(mismatches) != 1 {
+		t.Errorf("Interrupt return incorrect. got: %+v", cs.Frames)
+	}
+}
+
+func TestStackChecker(t *testing.T) {
+	tests := []struct {
+		code   string
+		floor  uint16
+		kind   cpu.StackFaultKind
+		pc     uint16
+		sp     uint16
+		rets   bool
+		retAdr uint16
+	}{
+		{"LDX #$01\n TXS\n PHA\n PHA\n NOP", 0, cpu.StackOverflow, 0x1004, 0x01ff, false, 0},
+		{"LDX #$FF\n TXS\n PLA\n NOP", 0, cpu.StackUnderflow, 0x1003, 0x0100, false, 0},
+		{"LDX #$F1\n TXS\n PHA\n PHA\n NOP", 0x01f0, cpu.StackFloorCrossed, 0x1004, 0x01ef, false, 0},
+		{"LDA #$20\n PHA\n LDA #$FF\n PHA\n RTS", 0, cpu.StackBadReturn, 0x1006, 0x01ff, true, 0x2100},
+	}
+
+	for i, test := range tests {
+		src := "\t.ARCH 65c02\n\t.OR $1000\n\t" + test.code
+		c := loadCPUArch(t, src, cpu.CMOS)
+		sc := cpu.NewStackChecker()
+		sc.Floor, sc.CheckReturns = test.floor, test.rets
+		c.AttachTracer(sc)
+
+		s := c.Run(context.Background(), cpu.Budget{Instructions: 10})
+		if s.Reason != cpu.StopStackFault || s.Stack == nil {
+			t.Errorf("test %d: stop reason incorrect. got: %v", i, s.Reason)
+			continue
+		}
+		f := s.Stack
+		if f.Kind != test.kind || f.PC != test.pc || f.SP != test.sp || f.Return != test.retAdr {
+			t.Errorf("test %d: stack fault incorrect. got: %+v", i, *f)
+		}
+	}
+
+	// Matched calls and returns don't fault.
+	c := loadCPUArch(t, "\t.ARCH 65c02\n\t.OR $1000\n\tJSR SUB\n\tNOP\nSUB\tRTS", cpu.CMOS)
+	sc := cpu.NewStackChecker()
+	sc.CheckReturns = true
+	c.AttachTracer(sc)
+	s := c.Run(context.Background(), cpu.Budget{Instructions: 3})
+	if s.Reason != cpu.StopBudget {
+		t.Errorf("Matched return incorrect. got: %v", s.Reason)
+	}
+}
+
+func TestInitChecker(t *testing.T) {
+	src := "\t.ARCH 65c02\n\t.OR $1000\n" +
+		"\tLDA $80\n" + // uninitialized
+		"\tSTA $81\n" +
+		"\tLDA $81\n" + // initialized by the store
+		"\tLDA $80\n" + // already reported
+		"\tLDA ($82)\n" + // pointer uninitialized
+		"\tBRK\n" // vector uninitialized
+	c := loadCPUArch(t, src, cpu.CMOS)
+
+	var reads []cpu.UninitializedRead
+	ic := cpu.NewInitChecker()
+	ic.MarkInitialized(0x1000, 12)
+	ic.OnUninitialized = func(c *cpu.CPU, r *cpu.UninitializedRead) {
+		reads = append(reads, *r)
+	}
+	c.AttachTracer(ic)
+	c.AttachMemoryHook(ic)
+	stepCPU(c, 6)
+
+	exp := []cpu.UninitializedRead{
+		{Address: 0x80, PC: 0x1000},
+		{Address: 0x82, PC: 0x1008},
+		{Address: 0x83, PC: 0x1008},
+		{Address: 0x0000, PC: 0x1008},
+		{Address: 0xfffe, PC: 0x100a},
+		{Address: 0xffff, PC: 0x100a},
+	}
+	if len(reads) != len(exp) {
+		t.Fatalf("Uninitialized reads incorrect. got: %+v", reads)
+	}
+	for i := range exp {
+		if reads[i] != exp[i] {
+			t.Errorf("Uninitialized read %d incorrect. exp: %+v, got: %+v", i, exp[i], reads[i])
+		}
+	}
+
+	ic.Reset()
+	if ic.Initialized(0x81) || ic.Reads != 0 {
+		t.Error("Reset incorrect.")
+	}
+}
+
+func TestFillRAM(t *testing.T) {
+	m := cpu.NewFlatMemory()
+	cpu.FillRAM(m, cpu.PatternFill([]byte{0xaa, 0x55}))
+	if m.LoadByte(0x1000) != 0xaa || m.LoadByte(0x1001) != 0x55 {
+		t.Error("Pattern fill incorrect.")
+	}
+
+	cpu.FillRAM(m, cpu.RandomFill(1))
+	a := m.LoadAddress(0x2000)
+	cpu.FillRAM(m, cpu.RandomFill(1))
+	if m.LoadAddress(0x2000) != a {
+		t.Error("Random fill isn't repeatable.")
+	}
+
+	b := cpu.NewBus()
+	b.Map(0x0000, 0x7fff, cpu.NewRAM(0x8000))
+	b.Map(0xc000, 0xffff, cpu.NewROM([]byte{0x12}))
+	cpu.FillRAM(b, cpu.PatternFill([]byte{0xee}))
+	if b.LoadByte(0x7fff) != 0xee || b.LoadByte(0xc000) != 0x12 {
+		t.Error("Bus fill incorrect.")
+	}
+}
+
+func TestCodeChecker(t *testing.T) {
+	src := "\t.ARCH 65c02\n\t.OR $1000\n" +
+		"\tLDA #$EA\n" +
+		"\tSTA $1008\n" + // modifies the next instruction
+		"\tJMP $1009\n" + // enters data
+		"\tNOP\n" +
+		"\t.DB $EA\n"
+	c := loadCPUArch(t, src, cpu.CMOS)
+
+	cc := cpu.NewCodeChecker()
+	cc.MarkInstructions(0x1000, 9)
+	cc.MarkData(0x1009, 1)
+	c.AttachTracer(cc)
+	c.AttachMemoryHook(cc)
+
+	s := c.Run(context.Background(), cpu.Budget{Instructions: 10})
+	if s.Reason != cpu.StopCodeFault || s.Code == nil ||
+		*s.Code != (cpu.CodeFault{Kind: cpu.CodeModified, PC: 0x1002, Address: 0x1008}) {
+		t.Errorf("Code modification incorrect. got: %v %+v", s.Reason, s.Code)
+	}
+
+	s = c.Run(context.Background(), cpu.Budget{Instructions: 10})
+	if s.Reason != cpu.StopCodeFault || s.Code == nil ||
+		*s.Code != (cpu.CodeFault{Kind: cpu.DataExecuted, PC: 0x1005, Address: 0x1009}) {
+		t.Errorf("Data execution incorrect. got: %v %+v", s.Reason, s.Code)
+	}
+
+	// Faults in regions set to report don't stop the CPU, and those in
+	// regions set to ignore aren't reported.
+	var faults []cpu.CodeFault
+	cc.OnFault = func(c *cpu.CPU, f *cpu.CodeFault) {
+		faults = append(faults, *f)
+	}
+	cc.SetAction(0x1008, 1, cpu.CodeIgnore)
+	cc.SetAction(0x1009, 1, cpu.CodeReport)
+	c.SetPC(0x1000)
+	s = c.Run(context.Background(), cpu.Budget{Instructions: 3})
+	if s.Reason != cpu.StopBudget || len(faults) != 1 || faults[0].Kind != cpu.DataExecuted ||
+		faults[0].Action != cpu.CodeReport {
+		t.Errorf("Code actions incorrect. got: %v %+v", s.Reason, faults)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	src := "\t.ARCH 65c02\n\t.OR $1000\n" +
+		"\tLDX #3\n" +
+		"L\tDEX\n" +
+		"\tBNE L\n" +
+		"\tBRA E\n" +
+		"E\tNOP\n"
+	c := loadCPUArch(t, src, cpu.CMOS)
+
+	cov := cpu.NewCoverage()
+	c.AttachTracer(cov)
+	stepCPU(c, 9)
+
+	for addr, exp := range map[uint16]uint64{0x1000: 1, 0x1002: 3, 0x1003: 3, 0x1005: 1, 0x1007: 1} {
+		if n := cov.Count(addr, 0); n != exp {
+			t.Errorf("Count at $%04X incorrect. exp: %d, got: %d", addr, exp, n)
+		}
+	}
+
+	b, ok := cov.Branch(0x1003, 0)
+	if !ok || b.Taken != 2 || b.NotTaken != 1 {
+		t.Errorf("Branch counts incorrect. got: %+v", b)
+	}
+	if _, ok := cov.Branch(0x1005, 0); ok {
+		t.Error("Unconditional branch counted as conditional.")
+	}
+
+	cov.Clear()
+	if cov.Count(0x1002, 0) != 0 {
+		t.Error("Clear incorrect.")
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	src := "\t.ARCH 65c02\n\t.OR $1000\n" +
+		"\tJSR S\n" + // $1000: 6 cycles
+		"\tNOP\n" + //   $1003: 2 cycles
+		"S\tNOP\n" + //  $1004: 2 cycles
+		"\tRTS\n" //     $1005: 6 cycles
+	c := loadCPUArch(t, src, cpu.CMOS)
+
+	p := cpu.NewProfiler()
+	c.AttachTracer(p)
+	stepCPU(c, 4)
+
+	if p.Total() != 16 || p.Cycles(0x1000) != 6 || p.Cycles(0x1005) != 6 {
+		t.Errorf("Profile cycles incorrect. got: total=%d", p.Total())
+	}
+
+	var inSub uint64
+	for _, s := range p.Samples() {
+		switch s.Stack[0].PC {
+		case 0x1004, 0x1005:
+			if len(s.Stack) != 2 || !s.Stack[0].Called || s.Stack[0].Routine != 0x1004 ||
+				s.Stack[1].PC != 0x1000 || s.Stack[1].Called {
+				t.Errorf("Sample stack incorrect. got: %+v", s.Stack)
+			}
+			inSub += s.Cycles
+		default:
+			if len(s.Stack) != 1 || s.Stack[0].Called {
+				t.Errorf("Sample stack incorrect. got: %+v", s.Stack)
+			}
+		}
+	}
+	if inSub != 8 {
+		t.Errorf("Subroutine cycles incorrect. exp: 8, got: %d", inSub)
+	}
+}
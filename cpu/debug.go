@@ -10,22 +10,48 @@ import "sort"
 // and after they are executed on the emulated CPU.
 type Debugger struct {
 	Handler         DebuggerHandler
-	breakpoints     map[uint16]*Breakpoint
+	breakpoints     map[uint32]*Breakpoint // keyed by bank and address
 	dataBreakpoints map[uint16]*DataBreakpoint
+	watchpoints     []*Watchpoint // nil if there are none
+	nextWatchID     int
 }
 
 // The DebuggerHandler interface should be implemented by any object that
-// wishes to receive debugger notifications.
+// wishes to receive debugger notifications. The handler is optional; the
+// CPU's Run function also reports breakpoints as stop reasons.
 type DebuggerHandler interface {
 	OnBreakpoint(cpu *CPU, b *Breakpoint)
 	OnDataBreakpoint(cpu *CPU, b *DataBreakpoint)
 }
 
+// The WatchpointHandler interface may be implemented by a DebuggerHandler
+// that wishes to be notified when a watchpoint is hit.
+type WatchpointHandler interface {
+	OnWatchpoint(cpu *CPU, w *WatchHit)
+}
+
 // A Breakpoint represents an address that will cause the debugger to stop
-// code execution when the program counter reaches it.
+// code execution when the program counter reaches it. When the CPU's memory
+// is Banked, the breakpoint is hit only while its bank is mapped at the
+// address.
+//
+// If the breakpoint has a Condition, it is hit only when the condition is
+// true. Each hit increments Hits. While Ignore is non-zero, a hit decrements
+// it instead of stopping the CPU.
 type Breakpoint struct {
-	Address  uint16 // address of execution breakpoint
-	Disabled bool   // this breakpoint is currently disabled
+	Address   uint16         // address of execution breakpoint
+	Bank      int            // bank containing the address
+	Disabled  bool           // this breakpoint is currently disabled
+	Condition BreakCondition // hit only when this condition is true, if non-nil
+	Ignore    int            // number of upcoming hits to ignore
+	Hits      int            // number of times the breakpoint has been hit
+}
+
+// The BreakCondition interface may be implemented to make a breakpoint
+// conditional. Check is called each time the program counter reaches the
+// breakpoint's address, before the instruction there executes.
+type BreakCondition interface {
+	Check(cpu *CPU) bool
 }
 
 // A DataBreakpoint represents an address that will cause the debugger to
@@ -37,25 +63,80 @@ type DataBreakpoint struct {
 	Value       byte   // the value that must be stored if the breakpoint is conditional
 }
 
+// WatchAccess selects the kinds of memory access that hit a watchpoint.
+type WatchAccess byte
+
+// Watchpoint access kinds
+const (
+	WatchRead      WatchAccess = 1 << iota // loads from memory
+	WatchWrite                             // stores to memory
+	WatchReadWrite = WatchRead | WatchWrite
+)
+
+// A Watchpoint represents a range of addresses that will cause the debugger
+// to stop executing code when the CPU reads or writes data within it.
+// Instruction fetches don't hit watchpoints, but stack accesses, interrupt
+// vector loads and, in Tick mode, the dummy reads made by the hardware do.
+//
+// The Old and New conditions compare only the bits selected by Mask. For a
+// read, the value read is both the old and the new value. For a write, the
+// old value is read from memory before the write, but only if the
+// watchpoint has an Old or Changed condition. The old value is peeked if
+// the memory allows it, so that the read has no side effects.
+type Watchpoint struct {
+	ID       int         // identifies the watchpoint within the debugger
+	Start    uint16      // first address of the watched range
+	End      uint16      // last address of the watched range
+	Access   WatchAccess // accesses that hit the watchpoint
+	Disabled bool        // this watchpoint is currently disabled
+	Mask     byte        // bits of the value compared by the conditions
+	MatchOld bool        // hit only if the old value matches Old
+	Old      byte        // old value to match
+	MatchNew bool        // hit only if the new value matches New
+	New      byte        // new value to match
+	Changed  bool        // hit only if a write changes the value
+}
+
+// A WatchHit describes the memory access that hit a watchpoint.
+type WatchHit struct {
+	Watchpoint *Watchpoint
+	Address    uint16      // address accessed
+	Access     WatchAccess // WatchRead or WatchWrite
+	Old        byte        // value before the access, if it was read
+	New        byte        // value read or written
+}
+
 // NewDebugger creates a new CPU debugger.
 func NewDebugger(handler DebuggerHandler) *Debugger {
 	return &Debugger{
 		Handler:         handler,
-		breakpoints:     make(map[uint16]*Breakpoint),
+		breakpoints:     make(map[uint32]*Breakpoint),
 		dataBreakpoints: make(map[uint16]*DataBreakpoint),
 	}
 }
 
 type byBPAddr []*Breakpoint
 
-func (a byBPAddr) Len() int           { return len(a) }
-func (a byBPAddr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a byBPAddr) Less(i, j int) bool { return a[i].Address < a[j].Address }
+func (a byBPAddr) Len() int      { return len(a) }
+func (a byBPAddr) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byBPAddr) Less(i, j int) bool {
+	return bpKey(a[i].Address, a[i].Bank) < bpKey(a[j].Address, a[j].Bank)
+}
+
+func bpKey(addr uint16, bank int) uint32 {
+	return uint32(bank)<<16 | uint32(addr)
+}
 
 // GetBreakpoint looks up a breakpoint by address and returns it if found.
 // Otherwise it returns nil.
 func (d *Debugger) GetBreakpoint(addr uint16) *Breakpoint {
-	if b, ok := d.breakpoints[addr]; ok {
+	return d.GetBankBreakpoint(addr, 0)
+}
+
+// GetBankBreakpoint looks up a breakpoint by address and bank and returns
+// it if found. Otherwise it returns nil.
+func (d *Debugger) GetBankBreakpoint(addr uint16, bank int) *Breakpoint {
+	if b, ok := d.breakpoints[bpKey(addr, bank)]; ok {
 		return b
 	}
 	return nil
@@ -74,16 +155,26 @@ func (d *Debugger) GetBreakpoints() []*Breakpoint {
 // AddBreakpoint adds a new breakpoint address to the debugger. If the
 // breakpoint was already set, the request is ignored.
 func (d *Debugger) AddBreakpoint(addr uint16) *Breakpoint {
-	b := &Breakpoint{Address: addr}
-	d.breakpoints[addr] = b
+	return d.AddBankBreakpoint(addr, 0)
+}
+
+// AddBankBreakpoint adds a new breakpoint on an address within a bank of
+// banked memory.
+func (d *Debugger) AddBankBreakpoint(addr uint16, bank int) *Breakpoint {
+	b := &Breakpoint{Address: addr, Bank: bank}
+	d.breakpoints[bpKey(addr, bank)] = b
 	return b
 }
 
 // RemoveBreakpoint removes a breakpoint from the debugger.
 func (d *Debugger) RemoveBreakpoint(addr uint16) {
-	if _, ok := d.breakpoints[addr]; ok {
-		delete(d.breakpoints, addr)
-	}
+	d.RemoveBankBreakpoint(addr, 0)
+}
+
+// RemoveBankBreakpoint removes a breakpoint on an address within a bank of
+// banked memory.
+func (d *Debugger) RemoveBankBreakpoint(addr uint16, bank int) {
+	delete(d.breakpoints, bpKey(addr, bank))
 }
 
 type byDBPAddr []*DataBreakpoint
@@ -138,20 +229,146 @@ func (d *Debugger) RemoveDataBreakpoint(addr uint16) {
 	}
 }
 
+// GetWatchpoint looks up a watchpoint by ID and returns it if found.
+// Otherwise it returns nil.
+func (d *Debugger) GetWatchpoint(id int) *Watchpoint {
+	for _, w := range d.watchpoints {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+// GetWatchpoints returns all watchpoints currently set in the debugger, in
+// the order they were added.
+func (d *Debugger) GetWatchpoints() []*Watchpoint {
+	return append([]*Watchpoint(nil), d.watchpoints...)
+}
+
+// AddWatchpoint adds a watchpoint on the range of addresses from 'start'
+// to 'end', inclusive. The watchpoint has no conditions until its fields
+// are updated.
+func (d *Debugger) AddWatchpoint(start, end uint16, access WatchAccess) *Watchpoint {
+	d.nextWatchID++
+	w := &Watchpoint{
+		ID:     d.nextWatchID,
+		Start:  start,
+		End:    end,
+		Access: access,
+		Mask:   0xff,
+	}
+	d.watchpoints = append(d.watchpoints, w)
+	return w
+}
+
+// RemoveWatchpoint removes the watchpoint with the requested ID.
+func (d *Debugger) RemoveWatchpoint(id int) {
+	for i, w := range d.watchpoints {
+		if w.ID == id {
+			d.watchpoints = append(d.watchpoints[:i:i], d.watchpoints[i+1:]...)
+			break
+		}
+	}
+	if len(d.watchpoints) == 0 {
+		d.watchpoints = nil
+	}
+}
+
 func (d *Debugger) onUpdatePC(cpu *CPU, addr uint16) {
-	if d.Handler != nil {
-		if b, ok := d.breakpoints[addr]; ok && !b.Disabled {
+	if b, ok := d.breakpoints[bpKey(addr, cpu.bank(addr))]; ok && !b.Disabled {
+		if b.Condition != nil && !b.Condition.Check(cpu) {
+			return
+		}
+		b.Hits++
+		if b.Ignore > 0 {
+			b.Ignore--
+			return
+		}
+		cpu.hit.Breakpoint = b
+		if cpu.hit.DataBreakpoint == nil && cpu.hit.Watch == nil && cpu.hit.Stack == nil &&
+			cpu.hit.Code == nil {
+			cpu.hit.Reason = StopBreakpoint
+		}
+		if d.Handler != nil {
 			d.Handler.OnBreakpoint(cpu, b)
 		}
 	}
 }
 
 func (d *Debugger) onDataStore(cpu *CPU, addr uint16, v byte) {
-	if d.Handler != nil {
-		if b, ok := d.dataBreakpoints[addr]; ok && !b.Disabled {
-			if !b.Conditional || b.Value == v {
+	if b, ok := d.dataBreakpoints[addr]; ok && !b.Disabled {
+		if !b.Conditional || b.Value == v {
+			cpu.hit.DataBreakpoint = b
+			if cpu.hit.Watch == nil {
+				cpu.hit.Reason = StopDataBreakpoint
+			}
+			if d.Handler != nil {
 				d.Handler.OnDataBreakpoint(cpu, b)
 			}
 		}
 	}
 }
+
+// Check the watchpoints for a store of the value 'v' to an address.
+func (d *Debugger) onWatchStore(cpu *CPU, addr uint16, v byte) {
+	for _, w := range d.watchpoints {
+		if w.Access&WatchWrite == 0 || !w.contains(addr) || w.Disabled {
+			continue
+		}
+		old := v
+		if w.MatchOld || w.Changed {
+			var ok bool
+			if old, ok = peekByte(cpu.Mem, addr); !ok {
+				old = cpu.Mem.LoadByte(addr)
+			}
+		}
+		if w.matches(old, v) {
+			d.onWatch(cpu, &WatchHit{w, addr, WatchWrite, old, v})
+			return
+		}
+	}
+}
+
+// Check the watchpoints for a load of the value 'v' from an address.
+func (d *Debugger) onWatchLoad(cpu *CPU, addr uint16, v byte) {
+	for _, w := range d.watchpoints {
+		if w.Access&WatchRead == 0 || !w.contains(addr) || w.Disabled {
+			continue
+		}
+		if w.matches(v, v) {
+			d.onWatch(cpu, &WatchHit{w, addr, WatchRead, v, v})
+			return
+		}
+	}
+}
+
+func (d *Debugger) onWatch(cpu *CPU, h *WatchHit) {
+	if cpu.hit.Watch == nil {
+		cpu.hit.Watch = h
+		if cpu.hit.DataBreakpoint == nil {
+			cpu.hit.Reason = StopWatchpoint
+		}
+	}
+	if wh, ok := d.Handler.(WatchpointHandler); ok {
+		wh.OnWatchpoint(cpu, h)
+	}
+}
+
+func (w *Watchpoint) contains(addr uint16) bool {
+	return addr >= w.Start && addr <= w.End
+}
+
+// Return true if the old and new values satisfy the watchpoint's
+// conditions.
+func (w *Watchpoint) matches(old, new byte) bool {
+	switch {
+	case w.MatchOld && old&w.Mask != w.Old&w.Mask:
+		return false
+	case w.MatchNew && new&w.Mask != w.New&w.Mask:
+		return false
+	case w.Changed && old&w.Mask == new&w.Mask:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,194 @@
+package cpu_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/beevik/go6502/asm"
+	"github.com/beevik/go6502/cpu"
+)
+
+// The Klaus Dormann 6502/65C02 functional test suite is not distributed
+// with this package. See testdata/dormann/README.md for instructions on
+// building the test binaries. Missing binaries are skipped, unless the
+// GO6502_REQUIRE_TESTDATA environment variable is set. The decimal and
+// interrupt tests are assembled from sources written in the same style.
+type dormannTest struct {
+	name      string
+	file      string              // binary in testdata/dormann
+	source    string              // source in testdata/dormann, loaded at its origin
+	arch      cpu.Architecture    // architecture under test
+	undefined cpu.UndefinedPolicy // how undefined opcodes are handled
+	load      uint16              // address at which the binary is loaded
+	start     uint16              // address at which execution starts
+	success   uint16              // trap address when all tests pass (0 = any)
+	testNum   uint16              // address of the current test number (0 = none)
+	errFlag   uint16              // address of an error flag (0 = none)
+	port      uint16              // address of the interrupt feedback port (0 = none)
+}
+
+var dormannTests = []dormannTest{
+	{
+		name: "functional/NMOS", file: "6502_functional_test.bin",
+		arch: cpu.NMOS, undefined: cpu.UndefinedHalt,
+		load: 0x0000, start: 0x0400, success: 0x3469, testNum: 0x0200,
+	},
+	{
+		name: "functional/CMOS", file: "6502_functional_test.bin",
+		arch: cpu.CMOS, undefined: cpu.UndefinedHalt,
+		load: 0x0000, start: 0x0400, success: 0x3469, testNum: 0x0200,
+	},
+	{
+		name: "decimal/NMOS", source: "decimal.asm",
+		arch: cpu.NMOS, undefined: cpu.UndefinedHalt,
+		errFlag: 0x000b,
+	},
+	{
+		name: "decimal/CMOS", source: "decimal.asm",
+		arch: cpu.CMOS, undefined: cpu.UndefinedHalt,
+		errFlag: 0x000b,
+	},
+	{
+		name: "interrupt/NMOS", source: "interrupt.asm",
+		arch: cpu.NMOS, undefined: cpu.UndefinedHalt,
+		testNum: 0x0200, port: 0xbffc,
+	},
+	{
+		name: "interrupt/CMOS", source: "interrupt.asm",
+		arch: cpu.CMOS, undefined: cpu.UndefinedHalt,
+		testNum: 0x0200, port: 0xbffc,
+	},
+	{
+		// The extended test checks that undefined opcodes execute as NOPs.
+		name: "extended/WDC", file: "65C02_extended_opcodes_test.bin",
+		arch: cpu.WDC, undefined: cpu.UndefinedIgnore,
+		load: 0x0000, start: 0x0400, success: 0x24f1, testNum: 0x0202,
+	},
+}
+
+// The maximum number of instructions to run before giving up on a test.
+const dormannMaxSteps = 200000000
+
+func TestDormann(t *testing.T) {
+	for _, d := range dormannTests {
+		d := d
+		t.Run(d.name, func(t *testing.T) {
+			runDormann(t, &d)
+		})
+	}
+}
+
+func runDormann(t *testing.T, d *dormannTest) {
+	var b []byte
+	if d.source != "" {
+		b = assembleDormann(t, d)
+	} else {
+		var err error
+		b, err = ioutil.ReadFile(filepath.Join("testdata", "dormann", d.file))
+		if err != nil {
+			skipMissingTestdata(t, "%s not found", d.file)
+		}
+	}
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+
+	mem := &feedbackMemory{FlatMemory: cpu.NewFlatMemory(), port: d.port}
+	c := cpu.NewCPU(d.arch, mem)
+	mem.cpu = c
+	c.Undefined = d.undefined
+	mem.StoreBytes(d.load, b)
+	c.SetPC(d.start)
+
+	for i := 0; i < dormannMaxSteps; i++ {
+		pc := c.Reg.PC
+		c.Step()
+
+		// Each test ends in a trap: a branch or jump to itself, or an
+		// instruction that stops the CPU. The decimal test traps with a
+		// jump to itself whether it passes or fails, so its error flag
+		// tells the two apart.
+		switch {
+		case c.Halted() && d.errFlag == 0:
+			t.Fatalf("%s", c.Fault())
+		case c.Reg.PC != pc && !c.Stopped() && !c.Halted():
+			continue
+		}
+
+		if d.success != 0 && pc != d.success {
+			if d.testNum != 0 {
+				t.Fatalf("test $%02X failed at $%04X", mem.LoadByte(d.testNum), pc)
+			}
+			t.Fatalf("failed at $%04X", pc)
+		}
+		if d.errFlag != 0 && mem.LoadByte(d.errFlag) != 0 {
+			t.Fatalf("failed at $%04X with operands $%02X and $%02X",
+				pc, mem.LoadByte(0x0000), mem.LoadByte(0x0001))
+		}
+		t.Logf("passed in %d cycles", c.Cycles)
+		return
+	}
+	t.Fatalf("no trap after %d instructions, PC=$%04X", dormannMaxSteps, c.Reg.PC)
+}
+
+// Assemble the source of a test. The test is loaded and started at the
+// origin of the source, and passes by trapping at its exported SUCCESS
+// label, if it has one.
+func assembleDormann(t *testing.T, d *dormannTest) []byte {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "dormann", d.source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	a, sm, err := asm.Assemble(f, d.source, &out, 0)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, strings.Join(a.Errors, "\n"))
+	}
+	d.load, d.start = sm.Origin, sm.Origin
+	for _, e := range sm.Exports {
+		if e.Label == "SUCCESS" {
+			d.success = e.Address
+		}
+	}
+	return a.Code
+}
+
+// A feedbackMemory is a flat memory with an optional interrupt feedback
+// port. Bit 0 of a byte stored to the port sets the level of the CPU's IRQ
+// line, and bit 1 sets the level of its NMI line.
+type feedbackMemory struct {
+	*cpu.FlatMemory
+	cpu  *cpu.CPU
+	port uint16
+}
+
+func (m *feedbackMemory) StoreByte(addr uint16, v byte) {
+	m.FlatMemory.StoreByte(addr, v)
+	if m.port == 0 || addr != m.port {
+		return
+	}
+	if v&0x01 != 0 {
+		m.cpu.AssertIRQ(1)
+	} else {
+		m.cpu.ReleaseIRQ(1)
+	}
+	m.cpu.SetNMI(v&0x02 != 0)
+}
+
+// Skip a test whose data files are missing. When the
+// GO6502_REQUIRE_TESTDATA environment variable is set, as in continuous
+// integration builds that fetch the test suites, the test fails instead.
+func skipMissingTestdata(t *testing.T, format string, args ...interface{}) {
+	t.Helper()
+	if os.Getenv("GO6502_REQUIRE_TESTDATA") != "" {
+		t.Fatalf(format, args...)
+	}
+	t.Skipf(format, args...)
+}
@@ -0,0 +1,83 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import "math/rand"
+
+// A FillFunc returns the value a byte of RAM holds at power-on.
+type FillFunc func(addr uint16) byte
+
+// PatternFill returns a fill function that repeats the pattern of bytes
+// throughout memory, starting at address 0.
+func PatternFill(pattern []byte) FillFunc {
+	p := append([]byte(nil), pattern...)
+	if len(p) == 0 {
+		p = []byte{0}
+	}
+	return func(addr uint16) byte {
+		return p[int(addr)%len(p)]
+	}
+}
+
+// RandomFill returns a fill function producing pseudo-random bytes
+// generated from the seed.
+func RandomFill(seed int64) FillFunc {
+	r := rand.New(rand.NewSource(seed))
+	return func(addr uint16) byte {
+		return byte(r.Intn(256))
+	}
+}
+
+// FillRAM fills the RAM of the memory using the fill function, simulating
+// the indeterminate contents of RAM at power-on. A FlatMemory is filled in
+// its entirety. A BankedMemory has every bank of its writable windows
+// filled, and a Bus has each of its RAM devices filled; ROM and other
+// devices are left alone. Only bank 0 of a FlatLongMemory is filled. Any
+// other memory is filled by storing to every address.
+func FillRAM(m Memory, fill FillFunc) {
+	switch mm := m.(type) {
+	case *FlatMemory:
+		mm.fill(fill)
+
+	case *FlatLongMemory:
+		mm.FlatMemory.fill(fill)
+
+	case *BankedMemory:
+		mm.FlatMemory.fill(fill)
+		for _, w := range mm.windows {
+			if w.ReadOnly {
+				continue
+			}
+			for _, b := range w.banks {
+				for i := range b {
+					b[i] = fill(w.start + uint16(i))
+				}
+			}
+		}
+
+	case *Bus:
+		for addr, i := range mm.m {
+			if i == 0 {
+				continue
+			}
+			r := &mm.regions[i-1]
+			if ram, ok := r.device.(*RAM); ok {
+				ram.Write(uint16(addr)-r.start, fill(uint16(addr)))
+			}
+		}
+
+	default:
+		for addr := 0; addr < 0x10000; addr++ {
+			m.StoreByte(uint16(addr), fill(uint16(addr)))
+		}
+	}
+}
+
+// Fill every byte of the memory using the fill function.
+func (m *FlatMemory) fill(fill FillFunc) {
+	for i := range m.b {
+		m.b[i] = fill(uint16(i))
+	}
+}
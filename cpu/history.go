@@ -0,0 +1,234 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import "errors"
+
+// Errors
+var (
+	ErrHistoryEmpty = errors.New("No execution history")
+	ErrHistoryBusy  = errors.New("Rewind requested while an instruction is in progress")
+)
+
+// A History records the instructions executed by a CPU, along with the
+// registers before each instruction and the memory bytes it overwrote, so
+// that the CPU and its memory can be rewound. Only the most recent
+// instructions are kept.
+//
+// An interrupt sequence is recorded as part of the instruction before it.
+// The banks mapped into a BankedMemory's windows are recorded. The state of
+// memory-mapped devices and of the CPU's interrupt inputs is not recorded,
+// and neither are 65c816 stores outside of bank 0. Overwritten bytes are
+// peeked rather than read, so stores to Bus devices that aren't Peekers
+// aren't recorded. The frames of the CallStacks and StackCheckers attached
+// to the CPU are recorded, so that they are restored when it is rewound.
+type History struct {
+	entries []HistoryEntry // ring buffer of recorded instructions
+	first   int            // index of the oldest entry
+	n       int            // number of entries recorded
+}
+
+// A HistoryEntry describes the state of the CPU before it executed an
+// instruction.
+type HistoryEntry struct {
+	PC     uint16    // address of the instruction
+	Bank   int       // bank containing the instruction, if memory is Banked
+	Code   []byte    // the instruction's opcode and operand bytes
+	Reg    Registers // registers before the instruction executed
+	Cycles uint64    // cycle count before the instruction executed
+
+	code    [4]byte // storage for Code
+	lastPC  uint16
+	delayed bool // the CPU's delayed IRQ mask
+	masked  bool
+	stopped bool
+	waiting bool
+	halted  bool
+	fault   error
+	banks   []int          // banks mapped into a BankedMemory's windows
+	writes  []historyWrite // bytes overwritten, in the order written
+	states  []historyState // states of the rewinders attached to the CPU
+}
+
+// A rewinder is a Tracer whose state is recorded by a History before each
+// instruction, and restored when the History rewinds the CPU.
+type rewinder interface {
+	// Save the tracer's state and return it. 'buf' is nil or a state
+	// returned by an earlier call, whose storage may be reused.
+	saveState(buf interface{}) interface{}
+
+	// Restore a state returned by saveState.
+	restoreState(s interface{})
+}
+
+// The state of a rewinder before an instruction executed.
+type historyState struct {
+	r     rewinder
+	state interface{}
+}
+
+// A memory byte overwritten by an instruction.
+type historyWrite struct {
+	addr uint16
+	old  byte
+	bank int // bank mapped at the address, if memory is Banked
+}
+
+// NewHistory creates a history that records up to 'size' instructions.
+func NewHistory(size int) *History {
+	if size < 1 {
+		size = 1
+	}
+	return &History{entries: make([]HistoryEntry, size)}
+}
+
+// Size returns the maximum number of instructions the history records.
+func (h *History) Size() int {
+	return len(h.entries)
+}
+
+// Len returns the number of instructions currently recorded.
+func (h *History) Len() int {
+	return h.n
+}
+
+// Entry returns the i'th recorded instruction, where 0 is the oldest and
+// Len()-1 is the most recent.
+func (h *History) Entry(i int) *HistoryEntry {
+	return &h.entries[(h.first+i)%len(h.entries)]
+}
+
+// Clear discards all recorded instructions.
+func (h *History) Clear() {
+	h.first, h.n = 0, 0
+}
+
+// StepBack rewinds the CPU and its memory to their state before the most
+// recently recorded instruction, and removes the instruction from the
+// history.
+func (h *History) StepBack(cpu *CPU) error {
+	if cpu.tick.busy {
+		return ErrHistoryBusy
+	}
+	if h.n == 0 {
+		return ErrHistoryEmpty
+	}
+
+	e := h.Entry(h.n - 1)
+	banked, _ := cpu.Mem.(Banked)
+	for i := len(e.writes) - 1; i >= 0; i-- {
+		w := &e.writes[i]
+		if banked != nil {
+			banked.SetBank(w.addr, w.bank)
+		}
+		cpu.Mem.StoreByte(w.addr, w.old)
+	}
+	if m, ok := cpu.Mem.(*BankedMemory); ok {
+		m.restoreBanks(e.banks)
+	}
+
+	for i := range e.states {
+		e.states[i].r.restoreState(e.states[i].state)
+	}
+
+	cpu.Reg = e.Reg
+	cpu.Cycles = e.Cycles
+	cpu.LastPC = e.lastPC
+	cpu.irqDelayed, cpu.irqMasked = e.delayed, e.masked
+	cpu.stopped, cpu.waiting, cpu.halted = e.stopped, e.waiting, e.halted
+	cpu.fault = e.fault
+	h.n--
+	return nil
+}
+
+// Record the state of the CPU before an instruction executes.
+func (h *History) begin(cpu *CPU, t *Trace) {
+	var e *HistoryEntry
+	switch {
+	case h.n < len(h.entries):
+		e = h.Entry(h.n)
+		h.n++
+	default:
+		e = &h.entries[h.first]
+		h.first = (h.first + 1) % len(h.entries)
+	}
+
+	e.PC, e.Bank, e.Reg, e.Cycles = t.PC, t.Bank, t.Reg, cpu.Cycles
+	e.code[0] = t.Inst.Opcode
+	e.Code = e.code[:1+copy(e.code[1:], t.Operand)]
+	e.lastPC = cpu.LastPC
+	e.delayed, e.masked = cpu.irqDelayed, cpu.irqMasked
+	e.stopped, e.waiting, e.halted = cpu.stopped, cpu.waiting, cpu.halted
+	e.fault = cpu.fault
+	e.banks = e.banks[:0]
+	if m, ok := cpu.Mem.(*BankedMemory); ok {
+		e.banks = m.saveBanks(e.banks)
+	}
+	e.writes = e.writes[:0]
+
+	e.states = e.states[:0]
+	for _, t := range cpu.tracers {
+		r, ok := t.(rewinder)
+		if !ok {
+			continue
+		}
+		if len(e.states) < cap(e.states) {
+			e.states = e.states[:len(e.states)+1]
+		} else {
+			e.states = append(e.states, historyState{})
+		}
+		s := &e.states[len(e.states)-1]
+		s.r, s.state = r, r.saveState(s.state)
+	}
+}
+
+// Record the value about to be overwritten by a store to 'addr'.
+func (h *History) onStore(mem Memory, addr uint16) {
+	if h.n == 0 {
+		return
+	}
+	old, ok := peekByte(mem, addr)
+	if !ok {
+		return
+	}
+	bank := 0
+	if b, ok := mem.(Banked); ok {
+		bank = b.Bank(addr)
+	}
+	e := h.Entry(h.n - 1)
+	e.writes = append(e.writes, historyWrite{addr, old, bank})
+}
+
+// The tracer used to record a History.
+type historyTracer struct {
+	h *History
+}
+
+func (t historyTracer) BeforeInstruction(cpu *CPU, tr *Trace) {
+	t.h.begin(cpu, tr)
+}
+
+func (t historyTracer) AfterInstruction(cpu *CPU, tr *Trace) {}
+
+// AttachHistory attaches a history to the CPU, which records each
+// instruction executed from then on. Attaching a history replaces any
+// history already attached.
+func (cpu *CPU) AttachHistory(h *History) {
+	if cpu.history != nil {
+		cpu.DetachHistory()
+	}
+	cpu.history = h
+	cpu.AttachTracer(historyTracer{h})
+	cpu.selectStoreByte()
+}
+
+// DetachHistory detaches the currently attached history from the CPU.
+func (cpu *CPU) DetachHistory() {
+	if cpu.history != nil {
+		cpu.DetachTracer(historyTracer{cpu.history})
+		cpu.history = nil
+		cpu.selectStoreByte()
+	}
+}
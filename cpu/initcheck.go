@@ -0,0 +1,92 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// An UninitializedRead describes a read of a memory byte that was never
+// initialized.
+type UninitializedRead struct {
+	Address uint16 // address of the byte read
+	PC      uint16 // address of the instruction that read it
+}
+
+// An InitChecker tracks which bytes of memory have been written, and
+// reports reads of bytes that never were. It is both a Tracer and a
+// MemoryHook, and must be attached to a CPU with both AttachTracer and
+// AttachMemoryHook.
+//
+// The checker only observes the CPU's own accesses, so bytes stored into
+// memory by other means, such as a program loader or a ROM image, must be
+// marked with MarkInitialized. Each address is reported only once until
+// the checker is reset.
+type InitChecker struct {
+	OnUninitialized func(cpu *CPU, r *UninitializedRead) // read callback
+	Reads           int                                  // number of reads reported
+
+	initialized [0x10000 / 8]byte // bit set for each initialized byte
+	reported    [0x10000 / 8]byte // bit set for each address reported
+	pc          uint16            // address of the executing instruction
+	executing   bool              // an instruction is executing
+}
+
+// NewInitChecker creates a checker with all memory uninitialized.
+func NewInitChecker() *InitChecker {
+	return &InitChecker{}
+}
+
+// Reset marks all memory uninitialized and forgets the reads already
+// reported.
+func (ic *InitChecker) Reset() {
+	ic.initialized = [0x10000 / 8]byte{}
+	ic.reported = [0x10000 / 8]byte{}
+	ic.Reads = 0
+}
+
+// MarkInitialized marks 'n' bytes of memory starting at 'addr' as
+// initialized. The range wraps at the end of the address space.
+func (ic *InitChecker) MarkInitialized(addr uint16, n int) {
+	for i := 0; i < n && i < 0x10000; i++ {
+		a := addr + uint16(i)
+		ic.initialized[a>>3] |= 1 << (a & 7)
+	}
+}
+
+// Initialized returns true if the byte at the address has been initialized.
+func (ic *InitChecker) Initialized(addr uint16) bool {
+	return ic.initialized[addr>>3]&(1<<(addr&7)) != 0
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+func (ic *InitChecker) BeforeInstruction(cpu *CPU, t *Trace) {
+	ic.pc, ic.executing = t.PC, true
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (ic *InitChecker) AfterInstruction(cpu *CPU, t *Trace) {
+	ic.executing = false
+}
+
+// OnRead is called after a byte is read from memory.
+func (ic *InitChecker) OnRead(cpu *CPU, addr uint16, v byte) {
+	if ic.Initialized(addr) || ic.reported[addr>>3]&(1<<(addr&7)) != 0 {
+		return
+	}
+	ic.reported[addr>>3] |= 1 << (addr & 7)
+	ic.Reads++
+
+	// Opcode and operand fetches and interrupt vector reads happen
+	// outside of the traced instruction.
+	pc := cpu.Reg.PC
+	if ic.executing {
+		pc = ic.pc
+	}
+	if ic.OnUninitialized != nil {
+		ic.OnUninitialized(cpu, &UninitializedRead{Address: addr, PC: pc})
+	}
+}
+
+// OnWrite is called after a byte is written to memory.
+func (ic *InitChecker) OnWrite(cpu *CPU, addr uint16, v byte) {
+	ic.initialized[addr>>3] |= 1 << (addr & 7)
+}
@@ -75,6 +75,92 @@ const (
 	symTXA
 	symTXS
 	symTYA
+
+	// Undocumented NMOS instructions
+	symALR
+	symANC
+	symANE
+	symARR
+	symDCP
+	symISC
+	symJAM
+	symLAS
+	symLAX
+	symLXA
+	symRLA
+	symRRA
+	symSAX
+	symSBX
+	symSHA
+	symSHX
+	symSHY
+	symSLO
+	symSRE
+	symTAS
+
+	// WDC 65c02 instructions
+	symBBR0
+	symBBR1
+	symBBR2
+	symBBR3
+	symBBR4
+	symBBR5
+	symBBR6
+	symBBR7
+	symBBS0
+	symBBS1
+	symBBS2
+	symBBS3
+	symBBS4
+	symBBS5
+	symBBS6
+	symBBS7
+	symRMB0
+	symRMB1
+	symRMB2
+	symRMB3
+	symRMB4
+	symRMB5
+	symRMB6
+	symRMB7
+	symSMB0
+	symSMB1
+	symSMB2
+	symSMB3
+	symSMB4
+	symSMB5
+	symSMB6
+	symSMB7
+	symSTP
+	symWAI
+
+	// 65c816 instructions
+	symBRL
+	symCOP
+	symJML
+	symJSL
+	symMVN
+	symMVP
+	symPEA
+	symPEI
+	symPER
+	symPHB
+	symPHD
+	symPHK
+	symPLB
+	symPLD
+	symREP
+	symRTL
+	symSEP
+	symTCD
+	symTCS
+	symTDC
+	symTSC
+	symTXY
+	symTYX
+	symWDM
+	symXBA
+	symXCE
 )
 
 type instfunc func(c *CPU, inst *Instruction, operand []byte)
@@ -83,7 +169,7 @@ type instfunc func(c *CPU, inst *Instruction, operand []byte)
 type opcodeImpl struct {
 	sym  opsym
 	name string
-	fn   [2]instfunc // NMOS=0, CMOS=1
+	fn   [2]instfunc // NMOS=0, CMOS=1 (by base architecture)
 }
 
 var impl = []opcodeImpl{
@@ -151,6 +237,62 @@ var impl = []opcodeImpl{
 	{symTXA, "TXA", [2]instfunc{(*CPU).txa, (*CPU).txa}},
 	{symTXS, "TXS", [2]instfunc{(*CPU).txs, (*CPU).txs}},
 	{symTYA, "TYA", [2]instfunc{(*CPU).tya, (*CPU).tya}},
+
+	{symALR, "ALR", [2]instfunc{(*CPU).alr, nil}},
+	{symANC, "ANC", [2]instfunc{(*CPU).anc, nil}},
+	{symANE, "ANE", [2]instfunc{(*CPU).ane, nil}},
+	{symARR, "ARR", [2]instfunc{(*CPU).arr, nil}},
+	{symDCP, "DCP", [2]instfunc{(*CPU).dcp, nil}},
+	{symISC, "ISC", [2]instfunc{(*CPU).isc, nil}},
+	{symJAM, "JAM", [2]instfunc{(*CPU).jam, nil}},
+	{symLAS, "LAS", [2]instfunc{(*CPU).las, nil}},
+	{symLAX, "LAX", [2]instfunc{(*CPU).lax, nil}},
+	{symLXA, "LXA", [2]instfunc{(*CPU).lxa, nil}},
+	{symRLA, "RLA", [2]instfunc{(*CPU).rla, nil}},
+	{symRRA, "RRA", [2]instfunc{(*CPU).rra, nil}},
+	{symSAX, "SAX", [2]instfunc{(*CPU).sax, nil}},
+	{symSBX, "SBX", [2]instfunc{(*CPU).sbx, nil}},
+	{symSHA, "SHA", [2]instfunc{(*CPU).sha, nil}},
+	{symSHX, "SHX", [2]instfunc{(*CPU).shx, nil}},
+	{symSHY, "SHY", [2]instfunc{(*CPU).shy, nil}},
+	{symSLO, "SLO", [2]instfunc{(*CPU).slo, nil}},
+	{symSRE, "SRE", [2]instfunc{(*CPU).sre, nil}},
+	{symTAS, "TAS", [2]instfunc{(*CPU).tas, nil}},
+
+	{symBBR0, "BBR0", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR1, "BBR1", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR2, "BBR2", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR3, "BBR3", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR4, "BBR4", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR5, "BBR5", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR6, "BBR6", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBR7, "BBR7", [2]instfunc{nil, (*CPU).bbr}},
+	{symBBS0, "BBS0", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS1, "BBS1", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS2, "BBS2", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS3, "BBS3", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS4, "BBS4", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS5, "BBS5", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS6, "BBS6", [2]instfunc{nil, (*CPU).bbs}},
+	{symBBS7, "BBS7", [2]instfunc{nil, (*CPU).bbs}},
+	{symRMB0, "RMB0", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB1, "RMB1", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB2, "RMB2", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB3, "RMB3", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB4, "RMB4", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB5, "RMB5", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB6, "RMB6", [2]instfunc{nil, (*CPU).rmb}},
+	{symRMB7, "RMB7", [2]instfunc{nil, (*CPU).rmb}},
+	{symSMB0, "SMB0", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB1, "SMB1", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB2, "SMB2", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB3, "SMB3", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB4, "SMB4", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB5, "SMB5", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB6, "SMB6", [2]instfunc{nil, (*CPU).smb}},
+	{symSMB7, "SMB7", [2]instfunc{nil, (*CPU).smb}},
+	{symSTP, "STP", [2]instfunc{nil, (*CPU).stp}},
+	{symWAI, "WAI", [2]instfunc{nil, (*CPU).wai}},
 }
 
 // Mode describes a memory addressing mode.
@@ -171,6 +313,15 @@ const (
 	IDX             // (Indirect,X)
 	IDY             // (Indirect),Y
 	ACC             // Accumulator (no operand)
+	ZPR             // Zero Page,Relative
+	ABL             // Absolute Long (65c816)
+	ALX             // Absolute Long,X (65c816)
+	ILN             // [Indirect Long] (65c816)
+	ILY             // [Indirect Long],Y (65c816)
+	SRL             // Stack Relative (65c816)
+	SRY             // (Stack Relative Indirect),Y (65c816)
+	RLL             // Relative Long (65c816)
+	BLK             // Block Move (65c816)
 )
 
 // Opcode data for an (opcode, mode) pair
@@ -488,17 +639,262 @@ var unusedData = []unused{
 	{0xff, ACC, 1, 1},
 }
 
+// Undocumented (opcode, mode) pairs, valid only on the NMOSX architecture.
+// These replace the unused opcodes of the NMOS instruction set.
+var undocumentedData = []opcodeData{
+	{symLAX, ZPG, 0xa7, 2, 3, 0, false},
+	{symLAX, ZPY, 0xb7, 2, 4, 0, false},
+	{symLAX, ABS, 0xaf, 3, 4, 0, false},
+	{symLAX, ABY, 0xbf, 3, 4, 1, false},
+	{symLAX, IDX, 0xa3, 2, 6, 0, false},
+	{symLAX, IDY, 0xb3, 2, 5, 1, false},
+	{symLXA, IMM, 0xab, 2, 2, 0, false},
+
+	{symSAX, ZPG, 0x87, 2, 3, 0, false},
+	{symSAX, ZPY, 0x97, 2, 4, 0, false},
+	{symSAX, ABS, 0x8f, 3, 4, 0, false},
+	{symSAX, IDX, 0x83, 2, 6, 0, false},
+
+	{symSLO, IDX, 0x03, 2, 8, 0, false},
+	{symSLO, ZPG, 0x07, 2, 5, 0, false},
+	{symSLO, ABS, 0x0f, 3, 6, 0, false},
+	{symSLO, IDY, 0x13, 2, 8, 0, false},
+	{symSLO, ZPX, 0x17, 2, 6, 0, false},
+	{symSLO, ABY, 0x1b, 3, 7, 0, false},
+	{symSLO, ABX, 0x1f, 3, 7, 0, false},
+
+	{symRLA, IDX, 0x23, 2, 8, 0, false},
+	{symRLA, ZPG, 0x27, 2, 5, 0, false},
+	{symRLA, ABS, 0x2f, 3, 6, 0, false},
+	{symRLA, IDY, 0x33, 2, 8, 0, false},
+	{symRLA, ZPX, 0x37, 2, 6, 0, false},
+	{symRLA, ABY, 0x3b, 3, 7, 0, false},
+	{symRLA, ABX, 0x3f, 3, 7, 0, false},
+
+	{symSRE, IDX, 0x43, 2, 8, 0, false},
+	{symSRE, ZPG, 0x47, 2, 5, 0, false},
+	{symSRE, ABS, 0x4f, 3, 6, 0, false},
+	{symSRE, IDY, 0x53, 2, 8, 0, false},
+	{symSRE, ZPX, 0x57, 2, 6, 0, false},
+	{symSRE, ABY, 0x5b, 3, 7, 0, false},
+	{symSRE, ABX, 0x5f, 3, 7, 0, false},
+
+	{symRRA, IDX, 0x63, 2, 8, 0, false},
+	{symRRA, ZPG, 0x67, 2, 5, 0, false},
+	{symRRA, ABS, 0x6f, 3, 6, 0, false},
+	{symRRA, IDY, 0x73, 2, 8, 0, false},
+	{symRRA, ZPX, 0x77, 2, 6, 0, false},
+	{symRRA, ABY, 0x7b, 3, 7, 0, false},
+	{symRRA, ABX, 0x7f, 3, 7, 0, false},
+
+	{symDCP, IDX, 0xc3, 2, 8, 0, false},
+	{symDCP, ZPG, 0xc7, 2, 5, 0, false},
+	{symDCP, ABS, 0xcf, 3, 6, 0, false},
+	{symDCP, IDY, 0xd3, 2, 8, 0, false},
+	{symDCP, ZPX, 0xd7, 2, 6, 0, false},
+	{symDCP, ABY, 0xdb, 3, 7, 0, false},
+	{symDCP, ABX, 0xdf, 3, 7, 0, false},
+
+	{symISC, IDX, 0xe3, 2, 8, 0, false},
+	{symISC, ZPG, 0xe7, 2, 5, 0, false},
+	{symISC, ABS, 0xef, 3, 6, 0, false},
+	{symISC, IDY, 0xf3, 2, 8, 0, false},
+	{symISC, ZPX, 0xf7, 2, 6, 0, false},
+	{symISC, ABY, 0xfb, 3, 7, 0, false},
+	{symISC, ABX, 0xff, 3, 7, 0, false},
+
+	{symANC, IMM, 0x0b, 2, 2, 0, false},
+	{symANC, IMM, 0x2b, 2, 2, 0, false},
+	{symALR, IMM, 0x4b, 2, 2, 0, false},
+	{symARR, IMM, 0x6b, 2, 2, 0, false},
+	{symANE, IMM, 0x8b, 2, 2, 0, false},
+	{symSBX, IMM, 0xcb, 2, 2, 0, false},
+	{symSBC, IMM, 0xeb, 2, 2, 0, false},
+
+	{symLAS, ABY, 0xbb, 3, 4, 1, false},
+	{symTAS, ABY, 0x9b, 3, 5, 0, false},
+	{symSHA, ABY, 0x9f, 3, 5, 0, false},
+	{symSHA, IDY, 0x93, 2, 6, 0, false},
+	{symSHX, ABY, 0x9e, 3, 5, 0, false},
+	{symSHY, ABX, 0x9c, 3, 5, 0, false},
+
+	{symNOP, IMP, 0x1a, 1, 2, 0, false},
+	{symNOP, IMP, 0x3a, 1, 2, 0, false},
+	{symNOP, IMP, 0x5a, 1, 2, 0, false},
+	{symNOP, IMP, 0x7a, 1, 2, 0, false},
+	{symNOP, IMP, 0xda, 1, 2, 0, false},
+	{symNOP, IMP, 0xfa, 1, 2, 0, false},
+	{symNOP, IMM, 0x80, 2, 2, 0, false},
+	{symNOP, IMM, 0x82, 2, 2, 0, false},
+	{symNOP, IMM, 0x89, 2, 2, 0, false},
+	{symNOP, IMM, 0xc2, 2, 2, 0, false},
+	{symNOP, IMM, 0xe2, 2, 2, 0, false},
+	{symNOP, ZPG, 0x04, 2, 3, 0, false},
+	{symNOP, ZPG, 0x44, 2, 3, 0, false},
+	{symNOP, ZPG, 0x64, 2, 3, 0, false},
+	{symNOP, ZPX, 0x14, 2, 4, 0, false},
+	{symNOP, ZPX, 0x34, 2, 4, 0, false},
+	{symNOP, ZPX, 0x54, 2, 4, 0, false},
+	{symNOP, ZPX, 0x74, 2, 4, 0, false},
+	{symNOP, ZPX, 0xd4, 2, 4, 0, false},
+	{symNOP, ZPX, 0xf4, 2, 4, 0, false},
+	{symNOP, ABS, 0x0c, 3, 4, 0, false},
+	{symNOP, ABX, 0x1c, 3, 4, 1, false},
+	{symNOP, ABX, 0x3c, 3, 4, 1, false},
+	{symNOP, ABX, 0x5c, 3, 4, 1, false},
+	{symNOP, ABX, 0x7c, 3, 4, 1, false},
+	{symNOP, ABX, 0xdc, 3, 4, 1, false},
+	{symNOP, ABX, 0xfc, 3, 4, 1, false},
+
+	{symJAM, IMP, 0x02, 1, 2, 0, false},
+	{symJAM, IMP, 0x12, 1, 2, 0, false},
+	{symJAM, IMP, 0x22, 1, 2, 0, false},
+	{symJAM, IMP, 0x32, 1, 2, 0, false},
+	{symJAM, IMP, 0x42, 1, 2, 0, false},
+	{symJAM, IMP, 0x52, 1, 2, 0, false},
+	{symJAM, IMP, 0x62, 1, 2, 0, false},
+	{symJAM, IMP, 0x72, 1, 2, 0, false},
+	{symJAM, IMP, 0x92, 1, 2, 0, false},
+	{symJAM, IMP, 0xb2, 1, 2, 0, false},
+	{symJAM, IMP, 0xd2, 1, 2, 0, false},
+	{symJAM, IMP, 0xf2, 1, 2, 0, false},
+}
+
+// WDC 65c02 (opcode, mode) pairs, valid only on the WDC architecture. These
+// replace the unused opcodes of the CMOS instruction set.
+var wdcData = []opcodeData{
+	{symRMB0, ZPG, 0x07, 2, 5, 0, false},
+	{symRMB1, ZPG, 0x17, 2, 5, 0, false},
+	{symRMB2, ZPG, 0x27, 2, 5, 0, false},
+	{symRMB3, ZPG, 0x37, 2, 5, 0, false},
+	{symRMB4, ZPG, 0x47, 2, 5, 0, false},
+	{symRMB5, ZPG, 0x57, 2, 5, 0, false},
+	{symRMB6, ZPG, 0x67, 2, 5, 0, false},
+	{symRMB7, ZPG, 0x77, 2, 5, 0, false},
+
+	{symSMB0, ZPG, 0x87, 2, 5, 0, false},
+	{symSMB1, ZPG, 0x97, 2, 5, 0, false},
+	{symSMB2, ZPG, 0xa7, 2, 5, 0, false},
+	{symSMB3, ZPG, 0xb7, 2, 5, 0, false},
+	{symSMB4, ZPG, 0xc7, 2, 5, 0, false},
+	{symSMB5, ZPG, 0xd7, 2, 5, 0, false},
+	{symSMB6, ZPG, 0xe7, 2, 5, 0, false},
+	{symSMB7, ZPG, 0xf7, 2, 5, 0, false},
+
+	{symBBR0, ZPR, 0x0f, 3, 5, 0, false},
+	{symBBR1, ZPR, 0x1f, 3, 5, 0, false},
+	{symBBR2, ZPR, 0x2f, 3, 5, 0, false},
+	{symBBR3, ZPR, 0x3f, 3, 5, 0, false},
+	{symBBR4, ZPR, 0x4f, 3, 5, 0, false},
+	{symBBR5, ZPR, 0x5f, 3, 5, 0, false},
+	{symBBR6, ZPR, 0x6f, 3, 5, 0, false},
+	{symBBR7, ZPR, 0x7f, 3, 5, 0, false},
+
+	{symBBS0, ZPR, 0x8f, 3, 5, 0, false},
+	{symBBS1, ZPR, 0x9f, 3, 5, 0, false},
+	{symBBS2, ZPR, 0xaf, 3, 5, 0, false},
+	{symBBS3, ZPR, 0xbf, 3, 5, 0, false},
+	{symBBS4, ZPR, 0xcf, 3, 5, 0, false},
+	{symBBS5, ZPR, 0xdf, 3, 5, 0, false},
+	{symBBS6, ZPR, 0xef, 3, 5, 0, false},
+	{symBBS7, ZPR, 0xff, 3, 5, 0, false},
+
+	{symWAI, IMP, 0xcb, 1, 3, 0, false},
+	{symSTP, IMP, 0xdb, 1, 3, 0, false},
+}
+
+// A busAccess describes how an instruction uses the bus after its operand
+// has been fetched. The cycle-accurate Tick mode uses it to order the
+// instruction's memory accesses.
+type busAccess byte
+
+const (
+	accessRead      busAccess = iota // reads its operand
+	accessWrite                      // writes its operand
+	accessModify                     // reads, modifies and writes back its operand
+	accessPush                       // pushes a register onto the stack
+	accessPull                       // pulls a register from the stack
+	accessJump                       // JMP
+	accessCall                       // JSR
+	accessReturn                     // RTS
+	accessReturnInt                  // RTI
+	accessBreak                      // BRK
+	accessUnused                     // unused opcode that only eats cycles
+)
+
+// Bus access patterns of the instructions that don't simply read their
+// operand.
+var accessOf = map[opsym]busAccess{
+	symSTA: accessWrite, symSTX: accessWrite, symSTY: accessWrite,
+	symSTZ: accessWrite, symSAX: accessWrite, symSHA: accessWrite,
+	symSHX: accessWrite, symSHY: accessWrite, symTAS: accessWrite,
+
+	symASL: accessModify, symLSR: accessModify, symROL: accessModify,
+	symROR: accessModify, symINC: accessModify, symDEC: accessModify,
+	symTRB: accessModify, symTSB: accessModify, symSLO: accessModify,
+	symRLA: accessModify, symSRE: accessModify, symRRA: accessModify,
+	symDCP: accessModify, symISC: accessModify,
+	symRMB0: accessModify, symRMB1: accessModify, symRMB2: accessModify,
+	symRMB3: accessModify, symRMB4: accessModify, symRMB5: accessModify,
+	symRMB6: accessModify, symRMB7: accessModify,
+	symSMB0: accessModify, symSMB1: accessModify, symSMB2: accessModify,
+	symSMB3: accessModify, symSMB4: accessModify, symSMB5: accessModify,
+	symSMB6: accessModify, symSMB7: accessModify,
+
+	symPHA: accessPush, symPHP: accessPush, symPHX: accessPush,
+	symPHY: accessPush,
+	symPLA: accessPull, symPLP: accessPull, symPLX: accessPull,
+	symPLY: accessPull,
+
+	symJMP: accessJump,
+	symJSR: accessCall,
+	symRTS: accessReturn,
+	symRTI: accessReturnInt,
+	symBRK: accessBreak,
+}
+
 // An Instruction describes a CPU instruction, including its name,
 // its addressing mode, its opcode value, its operand size, and its CPU cycle
 // cost.
 type Instruction struct {
-	Name     string   // all-caps name of the instruction
-	Mode     Mode     // addressing mode
-	Opcode   byte     // hexadecimal opcode value
-	Length   byte     // combined size of opcode and operand, in bytes
-	Cycles   byte     // number of CPU cycles to execute the instruction
-	BPCycles byte     // additional cycles required if boundary page crossed
-	fn       instfunc // emulator implementation of the function
+	Name     string    // all-caps name of the instruction
+	Mode     Mode      // addressing mode
+	Opcode   byte      // hexadecimal opcode value
+	Length   byte      // combined size of opcode and operand, in bytes
+	Cycles   byte      // number of CPU cycles to execute the instruction
+	BPCycles byte      // additional cycles required if boundary page crossed
+	MSized   bool      // immediate operand is 16-bit if the 65c816 M bit is clear
+	XSized   bool      // immediate operand is 16-bit if the 65c816 X bit is clear
+	fn       instfunc  // emulator implementation of the function
+	access   busAccess // bus access pattern used by Tick
+}
+
+// Undefined returns true if the instruction's opcode is undefined on its
+// architecture.
+func (inst *Instruction) Undefined() bool {
+	return inst.access == accessUnused
+}
+
+// ConditionalBranch returns true if the instruction is a branch that is
+// taken or not depending on a condition.
+func (inst *Instruction) ConditionalBranch() bool {
+	switch inst.Mode {
+	case REL:
+		return inst.Name != "BRA"
+	case ZPR:
+		return true
+	}
+	return false
+}
+
+// LengthFor returns the combined size of the instruction's opcode and
+// operand, in bytes, given whether the 65c816 accumulator ('m16') and index
+// registers ('x16') are 16 bits wide.
+func (inst *Instruction) LengthFor(m16, x16 bool) byte {
+	if (inst.MSized && m16) || (inst.XSized && x16) {
+		return inst.Length + 1
+	}
+	return inst.Length
 }
 
 // An InstructionSet defines the set of all possible instructions that
@@ -522,7 +918,12 @@ func (s *InstructionSet) GetInstructions(name string) []*Instruction {
 
 // Create an instruction set for a CPU architecture.
 func newInstructionSet(arch Architecture) *InstructionSet {
+	if arch == W65C816 {
+		return newInstructionSet65816()
+	}
+
 	set := &InstructionSet{Arch: arch}
+	base := arch.base()
 
 	// Create a map from symbol to implementation for fast lookups.
 	symToImpl := make(map[opsym]*opcodeImpl, len(impl))
@@ -543,7 +944,7 @@ func newInstructionSet(arch Architecture) *InstructionSet {
 
 		// If opcode has only a CMOS implementation and this is NMOS, create
 		// an unused instruction for it.
-		if d.cmos && arch != CMOS {
+		if d.cmos && base != CMOS {
 			inst.Name = unusedName
 			inst.Mode = d.mode
 			inst.Opcode = d.opcode
@@ -551,23 +952,11 @@ func newInstructionSet(arch Architecture) *InstructionSet {
 			inst.Cycles = d.cycles
 			inst.BPCycles = 0
 			inst.fn = (*CPU).unusedn
+			inst.access = accessUnused
 			continue
 		}
 
-		impl := symToImpl[d.sym]
-		if impl.fn[arch] == nil {
-			continue // some opcodes have no architecture implementation
-		}
-
-		inst.Name = impl.name
-		inst.Mode = d.mode
-		inst.Opcode = d.opcode
-		inst.Length = d.length
-		inst.Cycles = d.cycles
-		inst.BPCycles = d.bpcycles
-		inst.fn = impl.fn[arch]
-
-		set.variants[inst.Name] = append(set.variants[inst.Name], inst)
+		set.add(symToImpl[d.sym], d, base)
 	}
 
 	// Add unused opcodes to the instruction set. This information is useful
@@ -581,7 +970,8 @@ func newInstructionSet(arch Architecture) *InstructionSet {
 		inst.Length = u.length
 		inst.Cycles = u.cycles
 		inst.BPCycles = 0
-		switch arch {
+		inst.access = accessUnused
+		switch base {
 		case NMOS:
 			inst.fn = (*CPU).unusedn
 		case CMOS:
@@ -589,6 +979,19 @@ func newInstructionSet(arch Architecture) *InstructionSet {
 		}
 	}
 
+	// Replace unused opcodes with the additional instructions supported by
+	// some architectures.
+	switch arch {
+	case NMOSX:
+		for _, d := range undocumentedData {
+			set.add(symToImpl[d.sym], d, base)
+		}
+	case WDC:
+		for _, d := range wdcData {
+			set.add(symToImpl[d.sym], d, base)
+		}
+	}
+
 	for i := 0; i < 256; i++ {
 		if set.instructions[i].Name == "" {
 			panic("missing instruction")
@@ -597,7 +1000,27 @@ func newInstructionSet(arch Architecture) *InstructionSet {
 	return set
 }
 
-var instructionSets [2]*InstructionSet
+// Add an (opcode, mode) pair to the instruction set using the implementation
+// for the base architecture.
+func (s *InstructionSet) add(impl *opcodeImpl, d opcodeData, base Architecture) {
+	if impl.fn[base] == nil {
+		return // some opcodes have no architecture implementation
+	}
+
+	inst := &s.instructions[d.opcode]
+	inst.Name = impl.name
+	inst.Mode = d.mode
+	inst.Opcode = d.opcode
+	inst.Length = d.length
+	inst.Cycles = d.cycles
+	inst.BPCycles = d.bpcycles
+	inst.fn = impl.fn[base]
+	inst.access = accessOf[d.sym]
+
+	s.variants[inst.Name] = append(s.variants[inst.Name], inst)
+}
+
+var instructionSets [numArchitectures]*InstructionSet
 
 // GetInstructionSet returns an instruction set for the requested CPU
 // architecture.
@@ -0,0 +1,505 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// Emulator implementation for each 65c816 opcode. Nearly all instructions
+// depend on the widths of the accumulator and index registers, so the
+// 65c816 has its own implementations.
+type opcodeImpl65816 struct {
+	sym  opsym
+	name string
+	fn   instfunc
+}
+
+var impl65816 = []opcodeImpl65816{
+	{symADC, "ADC", (*CPU).adc816},
+	{symAND, "AND", (*CPU).and816},
+	{symASL, "ASL", (*CPU).asl816},
+	{symBCC, "BCC", (*CPU).bcc},
+	{symBCS, "BCS", (*CPU).bcs},
+	{symBEQ, "BEQ", (*CPU).beq},
+	{symBIT, "BIT", (*CPU).bit816},
+	{symBMI, "BMI", (*CPU).bmi},
+	{symBNE, "BNE", (*CPU).bne},
+	{symBPL, "BPL", (*CPU).bpl},
+	{symBRA, "BRA", (*CPU).bra},
+	{symBRK, "BRK", (*CPU).brk816},
+	{symBRL, "BRL", (*CPU).brl816},
+	{symBVC, "BVC", (*CPU).bvc},
+	{symBVS, "BVS", (*CPU).bvs},
+	{symCLC, "CLC", (*CPU).clc},
+	{symCLD, "CLD", (*CPU).cld},
+	{symCLI, "CLI", (*CPU).cli},
+	{symCLV, "CLV", (*CPU).clv},
+	{symCMP, "CMP", (*CPU).cmp816},
+	{symCOP, "COP", (*CPU).cop816},
+	{symCPX, "CPX", (*CPU).cpx816},
+	{symCPY, "CPY", (*CPU).cpy816},
+	{symDEC, "DEC", (*CPU).dec816},
+	{symDEX, "DEX", (*CPU).dex816},
+	{symDEY, "DEY", (*CPU).dey816},
+	{symEOR, "EOR", (*CPU).eor816},
+	{symINC, "INC", (*CPU).inc816},
+	{symINX, "INX", (*CPU).inx816},
+	{symINY, "INY", (*CPU).iny816},
+	{symJML, "JML", (*CPU).jml816},
+	{symJMP, "JMP", (*CPU).jmp816},
+	{symJSL, "JSL", (*CPU).jsl816},
+	{symJSR, "JSR", (*CPU).jsr816},
+	{symLDA, "LDA", (*CPU).lda816},
+	{symLDX, "LDX", (*CPU).ldx816},
+	{symLDY, "LDY", (*CPU).ldy816},
+	{symLSR, "LSR", (*CPU).lsr816},
+	{symMVN, "MVN", (*CPU).mvn816},
+	{symMVP, "MVP", (*CPU).mvp816},
+	{symNOP, "NOP", (*CPU).nop},
+	{symORA, "ORA", (*CPU).ora816},
+	{symPEA, "PEA", (*CPU).pea816},
+	{symPEI, "PEI", (*CPU).pei816},
+	{symPER, "PER", (*CPU).per816},
+	{symPHA, "PHA", (*CPU).pha816},
+	{symPHB, "PHB", (*CPU).phb816},
+	{symPHD, "PHD", (*CPU).phd816},
+	{symPHK, "PHK", (*CPU).phk816},
+	{symPHP, "PHP", (*CPU).php816},
+	{symPHX, "PHX", (*CPU).phx816},
+	{symPHY, "PHY", (*CPU).phy816},
+	{symPLA, "PLA", (*CPU).pla816},
+	{symPLB, "PLB", (*CPU).plb816},
+	{symPLD, "PLD", (*CPU).pld816},
+	{symPLP, "PLP", (*CPU).plp816},
+	{symPLX, "PLX", (*CPU).plx816},
+	{symPLY, "PLY", (*CPU).ply816},
+	{symREP, "REP", (*CPU).rep816},
+	{symROL, "ROL", (*CPU).rol816},
+	{symROR, "ROR", (*CPU).ror816},
+	{symRTI, "RTI", (*CPU).rti816},
+	{symRTL, "RTL", (*CPU).rtl816},
+	{symRTS, "RTS", (*CPU).rts816},
+	{symSBC, "SBC", (*CPU).sbc816},
+	{symSEC, "SEC", (*CPU).sec},
+	{symSED, "SED", (*CPU).sed},
+	{symSEI, "SEI", (*CPU).sei},
+	{symSEP, "SEP", (*CPU).sep816},
+	{symSTA, "STA", (*CPU).sta816},
+	{symSTP, "STP", (*CPU).stp},
+	{symSTX, "STX", (*CPU).stx816},
+	{symSTY, "STY", (*CPU).sty816},
+	{symSTZ, "STZ", (*CPU).stz816},
+	{symTAX, "TAX", (*CPU).tax816},
+	{symTAY, "TAY", (*CPU).tay816},
+	{symTCD, "TCD", (*CPU).tcd816},
+	{symTCS, "TCS", (*CPU).tcs816},
+	{symTDC, "TDC", (*CPU).tdc816},
+	{symTRB, "TRB", (*CPU).trb816},
+	{symTSB, "TSB", (*CPU).tsb816},
+	{symTSC, "TSC", (*CPU).tsc816},
+	{symTSX, "TSX", (*CPU).tsx816},
+	{symTXA, "TXA", (*CPU).txa816},
+	{symTXS, "TXS", (*CPU).txs816},
+	{symTXY, "TXY", (*CPU).txy816},
+	{symTYA, "TYA", (*CPU).tya816},
+	{symTYX, "TYX", (*CPU).tyx816},
+	{symWAI, "WAI", (*CPU).wai},
+	{symWDM, "WDM", (*CPU).wdm816},
+	{symXBA, "XBA", (*CPU).xba816},
+	{symXCE, "XCE", (*CPU).xce816},
+}
+
+// All 65c816 (opcode, mode) pairs. Cycle counts assume 8-bit registers,
+// emulation mode and a page-aligned direct page register. The emulator adds
+// cycles as necessary.
+var data65816 = []opcodeData{
+	{symADC, IDX, 0x61, 2, 6, 0, false},
+	{symADC, SRL, 0x63, 2, 4, 0, false},
+	{symADC, ZPG, 0x65, 2, 3, 0, false},
+	{symADC, ILN, 0x67, 2, 6, 0, false},
+	{symADC, IMM, 0x69, 2, 2, 0, false},
+	{symADC, ABS, 0x6d, 3, 4, 0, false},
+	{symADC, ABL, 0x6f, 4, 5, 0, false},
+	{symADC, IDY, 0x71, 2, 5, 1, false},
+	{symADC, IND, 0x72, 2, 5, 0, false},
+	{symADC, SRY, 0x73, 2, 7, 0, false},
+	{symADC, ZPX, 0x75, 2, 4, 0, false},
+	{symADC, ILY, 0x77, 2, 6, 0, false},
+	{symADC, ABY, 0x79, 3, 4, 1, false},
+	{symADC, ABX, 0x7d, 3, 4, 1, false},
+	{symADC, ALX, 0x7f, 4, 5, 0, false},
+
+	{symAND, IDX, 0x21, 2, 6, 0, false},
+	{symAND, SRL, 0x23, 2, 4, 0, false},
+	{symAND, ZPG, 0x25, 2, 3, 0, false},
+	{symAND, ILN, 0x27, 2, 6, 0, false},
+	{symAND, IMM, 0x29, 2, 2, 0, false},
+	{symAND, ABS, 0x2d, 3, 4, 0, false},
+	{symAND, ABL, 0x2f, 4, 5, 0, false},
+	{symAND, IDY, 0x31, 2, 5, 1, false},
+	{symAND, IND, 0x32, 2, 5, 0, false},
+	{symAND, SRY, 0x33, 2, 7, 0, false},
+	{symAND, ZPX, 0x35, 2, 4, 0, false},
+	{symAND, ILY, 0x37, 2, 6, 0, false},
+	{symAND, ABY, 0x39, 3, 4, 1, false},
+	{symAND, ABX, 0x3d, 3, 4, 1, false},
+	{symAND, ALX, 0x3f, 4, 5, 0, false},
+
+	{symASL, ZPG, 0x06, 2, 5, 0, false},
+	{symASL, ACC, 0x0a, 1, 2, 0, false},
+	{symASL, ABS, 0x0e, 3, 6, 0, false},
+	{symASL, ZPX, 0x16, 2, 6, 0, false},
+	{symASL, ABX, 0x1e, 3, 7, 0, false},
+
+	{symBCC, REL, 0x90, 2, 2, 0, false},
+
+	{symBCS, REL, 0xb0, 2, 2, 0, false},
+
+	{symBEQ, REL, 0xf0, 2, 2, 0, false},
+
+	{symBIT, ZPG, 0x24, 2, 3, 0, false},
+	{symBIT, ABS, 0x2c, 3, 4, 0, false},
+	{symBIT, ZPX, 0x34, 2, 4, 0, false},
+	{symBIT, ABX, 0x3c, 3, 4, 1, false},
+	{symBIT, IMM, 0x89, 2, 2, 0, false},
+
+	{symBMI, REL, 0x30, 2, 2, 0, false},
+
+	{symBNE, REL, 0xd0, 2, 2, 0, false},
+
+	{symBPL, REL, 0x10, 2, 2, 0, false},
+
+	{symBRA, REL, 0x80, 2, 2, 0, false},
+
+	{symBRK, IMP, 0x00, 2, 7, 0, false},
+
+	{symBRL, RLL, 0x82, 3, 4, 0, false},
+
+	{symBVC, REL, 0x50, 2, 2, 0, false},
+
+	{symBVS, REL, 0x70, 2, 2, 0, false},
+
+	{symCLC, IMP, 0x18, 1, 2, 0, false},
+
+	{symCLD, IMP, 0xd8, 1, 2, 0, false},
+
+	{symCLI, IMP, 0x58, 1, 2, 0, false},
+
+	{symCLV, IMP, 0xb8, 1, 2, 0, false},
+
+	{symCMP, IDX, 0xc1, 2, 6, 0, false},
+	{symCMP, SRL, 0xc3, 2, 4, 0, false},
+	{symCMP, ZPG, 0xc5, 2, 3, 0, false},
+	{symCMP, ILN, 0xc7, 2, 6, 0, false},
+	{symCMP, IMM, 0xc9, 2, 2, 0, false},
+	{symCMP, ABS, 0xcd, 3, 4, 0, false},
+	{symCMP, ABL, 0xcf, 4, 5, 0, false},
+	{symCMP, IDY, 0xd1, 2, 5, 1, false},
+	{symCMP, IND, 0xd2, 2, 5, 0, false},
+	{symCMP, SRY, 0xd3, 2, 7, 0, false},
+	{symCMP, ZPX, 0xd5, 2, 4, 0, false},
+	{symCMP, ILY, 0xd7, 2, 6, 0, false},
+	{symCMP, ABY, 0xd9, 3, 4, 1, false},
+	{symCMP, ABX, 0xdd, 3, 4, 1, false},
+	{symCMP, ALX, 0xdf, 4, 5, 0, false},
+
+	{symCOP, IMP, 0x02, 2, 7, 0, false},
+
+	{symCPX, IMM, 0xe0, 2, 2, 0, false},
+	{symCPX, ZPG, 0xe4, 2, 3, 0, false},
+	{symCPX, ABS, 0xec, 3, 4, 0, false},
+
+	{symCPY, IMM, 0xc0, 2, 2, 0, false},
+	{symCPY, ZPG, 0xc4, 2, 3, 0, false},
+	{symCPY, ABS, 0xcc, 3, 4, 0, false},
+
+	{symDEC, ACC, 0x3a, 1, 2, 0, false},
+	{symDEC, ZPG, 0xc6, 2, 5, 0, false},
+	{symDEC, ABS, 0xce, 3, 6, 0, false},
+	{symDEC, ZPX, 0xd6, 2, 6, 0, false},
+	{symDEC, ABX, 0xde, 3, 7, 0, false},
+
+	{symDEX, IMP, 0xca, 1, 2, 0, false},
+
+	{symDEY, IMP, 0x88, 1, 2, 0, false},
+
+	{symEOR, IDX, 0x41, 2, 6, 0, false},
+	{symEOR, SRL, 0x43, 2, 4, 0, false},
+	{symEOR, ZPG, 0x45, 2, 3, 0, false},
+	{symEOR, ILN, 0x47, 2, 6, 0, false},
+	{symEOR, IMM, 0x49, 2, 2, 0, false},
+	{symEOR, ABS, 0x4d, 3, 4, 0, false},
+	{symEOR, ABL, 0x4f, 4, 5, 0, false},
+	{symEOR, IDY, 0x51, 2, 5, 1, false},
+	{symEOR, IND, 0x52, 2, 5, 0, false},
+	{symEOR, SRY, 0x53, 2, 7, 0, false},
+	{symEOR, ZPX, 0x55, 2, 4, 0, false},
+	{symEOR, ILY, 0x57, 2, 6, 0, false},
+	{symEOR, ABY, 0x59, 3, 4, 1, false},
+	{symEOR, ABX, 0x5d, 3, 4, 1, false},
+	{symEOR, ALX, 0x5f, 4, 5, 0, false},
+
+	{symINC, ACC, 0x1a, 1, 2, 0, false},
+	{symINC, ZPG, 0xe6, 2, 5, 0, false},
+	{symINC, ABS, 0xee, 3, 6, 0, false},
+	{symINC, ZPX, 0xf6, 2, 6, 0, false},
+	{symINC, ABX, 0xfe, 3, 7, 0, false},
+
+	{symINX, IMP, 0xe8, 1, 2, 0, false},
+
+	{symINY, IMP, 0xc8, 1, 2, 0, false},
+
+	{symJML, ABL, 0x5c, 4, 4, 0, false},
+	{symJML, ILN, 0xdc, 3, 6, 0, false},
+
+	{symJMP, ABS, 0x4c, 3, 3, 0, false},
+	{symJMP, IND, 0x6c, 3, 5, 0, false},
+	{symJMP, ABX, 0x7c, 3, 6, 0, false},
+
+	{symJSL, ABL, 0x22, 4, 8, 0, false},
+
+	{symJSR, ABS, 0x20, 3, 6, 0, false},
+	{symJSR, ABX, 0xfc, 3, 8, 0, false},
+
+	{symLDA, IDX, 0xa1, 2, 6, 0, false},
+	{symLDA, SRL, 0xa3, 2, 4, 0, false},
+	{symLDA, ZPG, 0xa5, 2, 3, 0, false},
+	{symLDA, ILN, 0xa7, 2, 6, 0, false},
+	{symLDA, IMM, 0xa9, 2, 2, 0, false},
+	{symLDA, ABS, 0xad, 3, 4, 0, false},
+	{symLDA, ABL, 0xaf, 4, 5, 0, false},
+	{symLDA, IDY, 0xb1, 2, 5, 1, false},
+	{symLDA, IND, 0xb2, 2, 5, 0, false},
+	{symLDA, SRY, 0xb3, 2, 7, 0, false},
+	{symLDA, ZPX, 0xb5, 2, 4, 0, false},
+	{symLDA, ILY, 0xb7, 2, 6, 0, false},
+	{symLDA, ABY, 0xb9, 3, 4, 1, false},
+	{symLDA, ABX, 0xbd, 3, 4, 1, false},
+	{symLDA, ALX, 0xbf, 4, 5, 0, false},
+
+	{symLDX, IMM, 0xa2, 2, 2, 0, false},
+	{symLDX, ZPG, 0xa6, 2, 3, 0, false},
+	{symLDX, ABS, 0xae, 3, 4, 0, false},
+	{symLDX, ZPY, 0xb6, 2, 4, 0, false},
+	{symLDX, ABY, 0xbe, 3, 4, 1, false},
+
+	{symLDY, IMM, 0xa0, 2, 2, 0, false},
+	{symLDY, ZPG, 0xa4, 2, 3, 0, false},
+	{symLDY, ABS, 0xac, 3, 4, 0, false},
+	{symLDY, ZPX, 0xb4, 2, 4, 0, false},
+	{symLDY, ABX, 0xbc, 3, 4, 1, false},
+
+	{symLSR, ZPG, 0x46, 2, 5, 0, false},
+	{symLSR, ACC, 0x4a, 1, 2, 0, false},
+	{symLSR, ABS, 0x4e, 3, 6, 0, false},
+	{symLSR, ZPX, 0x56, 2, 6, 0, false},
+	{symLSR, ABX, 0x5e, 3, 7, 0, false},
+
+	{symMVN, BLK, 0x54, 3, 7, 0, false},
+
+	{symMVP, BLK, 0x44, 3, 7, 0, false},
+
+	{symNOP, IMP, 0xea, 1, 2, 0, false},
+
+	{symORA, IDX, 0x01, 2, 6, 0, false},
+	{symORA, SRL, 0x03, 2, 4, 0, false},
+	{symORA, ZPG, 0x05, 2, 3, 0, false},
+	{symORA, ILN, 0x07, 2, 6, 0, false},
+	{symORA, IMM, 0x09, 2, 2, 0, false},
+	{symORA, ABS, 0x0d, 3, 4, 0, false},
+	{symORA, ABL, 0x0f, 4, 5, 0, false},
+	{symORA, IDY, 0x11, 2, 5, 1, false},
+	{symORA, IND, 0x12, 2, 5, 0, false},
+	{symORA, SRY, 0x13, 2, 7, 0, false},
+	{symORA, ZPX, 0x15, 2, 4, 0, false},
+	{symORA, ILY, 0x17, 2, 6, 0, false},
+	{symORA, ABY, 0x19, 3, 4, 1, false},
+	{symORA, ABX, 0x1d, 3, 4, 1, false},
+	{symORA, ALX, 0x1f, 4, 5, 0, false},
+
+	{symPEA, ABS, 0xf4, 3, 5, 0, false},
+
+	{symPEI, IND, 0xd4, 2, 6, 0, false},
+
+	{symPER, RLL, 0x62, 3, 6, 0, false},
+
+	{symPHA, IMP, 0x48, 1, 3, 0, false},
+
+	{symPHB, IMP, 0x8b, 1, 3, 0, false},
+
+	{symPHD, IMP, 0x0b, 1, 4, 0, false},
+
+	{symPHK, IMP, 0x4b, 1, 3, 0, false},
+
+	{symPHP, IMP, 0x08, 1, 3, 0, false},
+
+	{symPHX, IMP, 0xda, 1, 3, 0, false},
+
+	{symPHY, IMP, 0x5a, 1, 3, 0, false},
+
+	{symPLA, IMP, 0x68, 1, 4, 0, false},
+
+	{symPLB, IMP, 0xab, 1, 4, 0, false},
+
+	{symPLD, IMP, 0x2b, 1, 5, 0, false},
+
+	{symPLP, IMP, 0x28, 1, 4, 0, false},
+
+	{symPLX, IMP, 0xfa, 1, 4, 0, false},
+
+	{symPLY, IMP, 0x7a, 1, 4, 0, false},
+
+	{symREP, IMM, 0xc2, 2, 3, 0, false},
+
+	{symROL, ZPG, 0x26, 2, 5, 0, false},
+	{symROL, ACC, 0x2a, 1, 2, 0, false},
+	{symROL, ABS, 0x2e, 3, 6, 0, false},
+	{symROL, ZPX, 0x36, 2, 6, 0, false},
+	{symROL, ABX, 0x3e, 3, 7, 0, false},
+
+	{symROR, ZPG, 0x66, 2, 5, 0, false},
+	{symROR, ACC, 0x6a, 1, 2, 0, false},
+	{symROR, ABS, 0x6e, 3, 6, 0, false},
+	{symROR, ZPX, 0x76, 2, 6, 0, false},
+	{symROR, ABX, 0x7e, 3, 7, 0, false},
+
+	{symRTI, IMP, 0x40, 1, 6, 0, false},
+
+	{symRTL, IMP, 0x6b, 1, 6, 0, false},
+
+	{symRTS, IMP, 0x60, 1, 6, 0, false},
+
+	{symSBC, IDX, 0xe1, 2, 6, 0, false},
+	{symSBC, SRL, 0xe3, 2, 4, 0, false},
+	{symSBC, ZPG, 0xe5, 2, 3, 0, false},
+	{symSBC, ILN, 0xe7, 2, 6, 0, false},
+	{symSBC, IMM, 0xe9, 2, 2, 0, false},
+	{symSBC, ABS, 0xed, 3, 4, 0, false},
+	{symSBC, ABL, 0xef, 4, 5, 0, false},
+	{symSBC, IDY, 0xf1, 2, 5, 1, false},
+	{symSBC, IND, 0xf2, 2, 5, 0, false},
+	{symSBC, SRY, 0xf3, 2, 7, 0, false},
+	{symSBC, ZPX, 0xf5, 2, 4, 0, false},
+	{symSBC, ILY, 0xf7, 2, 6, 0, false},
+	{symSBC, ABY, 0xf9, 3, 4, 1, false},
+	{symSBC, ABX, 0xfd, 3, 4, 1, false},
+	{symSBC, ALX, 0xff, 4, 5, 0, false},
+
+	{symSEC, IMP, 0x38, 1, 2, 0, false},
+
+	{symSED, IMP, 0xf8, 1, 2, 0, false},
+
+	{symSEI, IMP, 0x78, 1, 2, 0, false},
+
+	{symSEP, IMM, 0xe2, 2, 3, 0, false},
+
+	{symSTA, IDX, 0x81, 2, 6, 0, false},
+	{symSTA, SRL, 0x83, 2, 4, 0, false},
+	{symSTA, ZPG, 0x85, 2, 3, 0, false},
+	{symSTA, ILN, 0x87, 2, 6, 0, false},
+	{symSTA, ABS, 0x8d, 3, 4, 0, false},
+	{symSTA, ABL, 0x8f, 4, 5, 0, false},
+	{symSTA, IDY, 0x91, 2, 6, 0, false},
+	{symSTA, IND, 0x92, 2, 5, 0, false},
+	{symSTA, SRY, 0x93, 2, 7, 0, false},
+	{symSTA, ZPX, 0x95, 2, 4, 0, false},
+	{symSTA, ILY, 0x97, 2, 6, 0, false},
+	{symSTA, ABY, 0x99, 3, 5, 0, false},
+	{symSTA, ABX, 0x9d, 3, 5, 0, false},
+	{symSTA, ALX, 0x9f, 4, 5, 0, false},
+
+	{symSTP, IMP, 0xdb, 1, 3, 0, false},
+
+	{symSTX, ZPG, 0x86, 2, 3, 0, false},
+	{symSTX, ABS, 0x8e, 3, 4, 0, false},
+	{symSTX, ZPY, 0x96, 2, 4, 0, false},
+
+	{symSTY, ZPG, 0x84, 2, 3, 0, false},
+	{symSTY, ABS, 0x8c, 3, 4, 0, false},
+	{symSTY, ZPX, 0x94, 2, 4, 0, false},
+
+	{symSTZ, ZPG, 0x64, 2, 3, 0, false},
+	{symSTZ, ZPX, 0x74, 2, 4, 0, false},
+	{symSTZ, ABS, 0x9c, 3, 4, 0, false},
+	{symSTZ, ABX, 0x9e, 3, 5, 0, false},
+
+	{symTAX, IMP, 0xaa, 1, 2, 0, false},
+
+	{symTAY, IMP, 0xa8, 1, 2, 0, false},
+
+	{symTCD, IMP, 0x5b, 1, 2, 0, false},
+
+	{symTCS, IMP, 0x1b, 1, 2, 0, false},
+
+	{symTDC, IMP, 0x7b, 1, 2, 0, false},
+
+	{symTRB, ZPG, 0x14, 2, 5, 0, false},
+	{symTRB, ABS, 0x1c, 3, 6, 0, false},
+
+	{symTSB, ZPG, 0x04, 2, 5, 0, false},
+	{symTSB, ABS, 0x0c, 3, 6, 0, false},
+
+	{symTSC, IMP, 0x3b, 1, 2, 0, false},
+
+	{symTSX, IMP, 0xba, 1, 2, 0, false},
+
+	{symTXA, IMP, 0x8a, 1, 2, 0, false},
+
+	{symTXS, IMP, 0x9a, 1, 2, 0, false},
+
+	{symTXY, IMP, 0x9b, 1, 2, 0, false},
+
+	{symTYA, IMP, 0x98, 1, 2, 0, false},
+
+	{symTYX, IMP, 0xbb, 1, 2, 0, false},
+
+	{symWAI, IMP, 0xcb, 1, 3, 0, false},
+
+	{symWDM, IMM, 0x42, 2, 2, 0, false},
+
+	{symXBA, IMP, 0xeb, 1, 3, 0, false},
+
+	{symXCE, IMP, 0xfb, 1, 2, 0, false},
+}
+
+// Create the instruction set for the 65c816.
+func newInstructionSet65816() *InstructionSet {
+	set := &InstructionSet{Arch: W65C816}
+
+	symToImpl := make(map[opsym]*opcodeImpl65816, len(impl65816))
+	for i := range impl65816 {
+		symToImpl[impl65816[i].sym] = &impl65816[i]
+	}
+
+	set.variants = make(map[string][]*Instruction)
+
+	for _, d := range data65816 {
+		impl := symToImpl[d.sym]
+
+		inst := &set.instructions[d.opcode]
+		inst.Name = impl.name
+		inst.Mode = d.mode
+		inst.Opcode = d.opcode
+		inst.Length = d.length
+		inst.Cycles = d.cycles
+		inst.BPCycles = d.bpcycles
+		inst.fn = impl.fn
+
+		if d.mode == IMM {
+			switch d.sym {
+			case symADC, symAND, symBIT, symCMP, symEOR, symLDA, symORA, symSBC:
+				inst.MSized = true
+			case symCPX, symCPY, symLDX, symLDY:
+				inst.XSized = true
+			}
+		}
+
+		set.variants[inst.Name] = append(set.variants[inst.Name], inst)
+	}
+
+	for i := 0; i < 256; i++ {
+		if set.instructions[i].Name == "" {
+			panic("missing instruction")
+		}
+	}
+	return set
+}
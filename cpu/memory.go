@@ -35,6 +35,38 @@ type Memory interface {
 	StoreAddress(addr uint16, v uint16)
 }
 
+// A memory whose bytes may be read without side effects, such as a Bus.
+type memoryPeeker interface {
+	Peek(addr uint16) (byte, bool)
+}
+
+// Return the byte at the address without the side effects of a read. The
+// result is false if the memory can't provide it. Memory that isn't a
+// memoryPeeker is assumed to have no side effects.
+func peekByte(m Memory, addr uint16) (byte, bool) {
+	if p, ok := m.(memoryPeeker); ok {
+		return p.Peek(addr)
+	}
+	return m.LoadByte(addr), true
+}
+
+// Return the 16-bit address stored at 'addr' without the side effects of a
+// read. If 'wrap' is true, the high byte is read from a page-wrapped address
+// when the address ends in 0xff, as LoadAddress does. The result is false if
+// the memory can't provide either byte.
+func peekAddress(m Memory, addr uint16, wrap bool) (uint16, bool) {
+	next := addr + 1
+	if wrap && (addr&0xff) == 0xff {
+		next = addr - 0xff
+	}
+	lo, ok1 := peekByte(m, addr)
+	hi, ok2 := peekByte(m, next)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return uint16(lo) | uint16(hi)<<8, true
+}
+
 // FlatMemory represents an entire 16-bit address space as a singular
 // 64K buffer.
 type FlatMemory struct {
@@ -97,6 +129,72 @@ func (m *FlatMemory) StoreAddress(addr uint16, v uint16) {
 	}
 }
 
+// LongMemory is a companion to the Memory interface for memory that spans
+// the 24-bit address space of the 65c816. Addresses $000000-$00FFFF (bank
+// 0) are the same memory accessed through the Memory interface.
+type LongMemory interface {
+	Memory
+
+	// LoadByteLong loads a single byte from the 24-bit address and returns
+	// it.
+	LoadByteLong(addr uint32) byte
+
+	// StoreByteLong stores a byte to the requested 24-bit address.
+	StoreByteLong(addr uint32, v byte)
+}
+
+// FlatLongMemory represents the entire 24-bit address space of the 65c816.
+// Bank 0 is a FlatMemory. Each of the other banks is a 64K buffer that is
+// allocated the first time it is stored to.
+type FlatLongMemory struct {
+	FlatMemory
+	banks [255]*[64 * 1024]byte
+}
+
+// NewFlatLongMemory creates a new 24-bit memory space.
+func NewFlatLongMemory() *FlatLongMemory {
+	return &FlatLongMemory{}
+}
+
+// LoadByteLong loads a single byte from the 24-bit address and returns it.
+func (m *FlatLongMemory) LoadByteLong(addr uint32) byte {
+	bank := byte(addr >> 16)
+	if bank == 0 {
+		return m.b[uint16(addr)]
+	}
+	if b := m.banks[bank-1]; b != nil {
+		return b[uint16(addr)]
+	}
+	return 0
+}
+
+// StoreByteLong stores a byte at the requested 24-bit address.
+func (m *FlatLongMemory) StoreByteLong(addr uint32, v byte) {
+	bank := byte(addr >> 16)
+	if bank == 0 {
+		m.b[uint16(addr)] = v
+		return
+	}
+	if m.banks[bank-1] == nil {
+		m.banks[bank-1] = new([64 * 1024]byte)
+	}
+	m.banks[bank-1][uint16(addr)] = v
+}
+
+// A mirroredMemory adapts a 16-bit Memory to the LongMemory interface by
+// mirroring it into every bank.
+type mirroredMemory struct {
+	Memory
+}
+
+func (m mirroredMemory) LoadByteLong(addr uint32) byte {
+	return m.LoadByte(uint16(addr))
+}
+
+func (m mirroredMemory) StoreByteLong(addr uint32, v byte) {
+	m.StoreByte(uint16(addr), v)
+}
+
 // Return the offset address 'addr' + 'offset'. If the offset
 // crossed a page boundary, return 'pageCrossed' as true.
 func offsetAddress(addr uint16, offset byte) (newAddr uint16, pageCrossed bool) {
@@ -0,0 +1,137 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import "encoding/binary"
+
+// A Profiler is a Tracer that attributes the cycles taken by each
+// instruction to the instruction's address and to the subroutine calls and
+// interrupts in progress, as tracked by a shadow call stack. Attach it to a
+// CPU with AttachTracer.
+//
+// Cycles taken by interrupt sequences aren't attributed. Addresses are 16
+// bits, so the banks of Banked memory aren't distinguished.
+type Profiler struct {
+	calls   CallStack
+	cycles  [0x10000]uint64           // cycles spent at each address
+	samples map[string]*ProfileSample // samples keyed by call stack
+	total   uint64                    // total cycles attributed
+	key     []byte                    // key of the current call stack
+	depth   int                       // depth of the call stack in the key, or -1
+}
+
+// A ProfileSample holds the cycles spent and instructions executed at one
+// address with one call stack.
+type ProfileSample struct {
+	Stack        []ProfileLocation // locations, innermost first
+	Cycles       uint64            // cycles spent
+	Instructions uint64            // instructions executed
+}
+
+// A ProfileLocation is an address on a sampled call stack: the executing
+// instruction, or the call site of an enclosing subroutine or interrupt.
+type ProfileLocation struct {
+	PC      uint16 // address of the instruction or call site
+	Routine uint16 // entry address of the subroutine containing PC
+	Called  bool   // Routine is known; false for code outside of any call
+}
+
+// NewProfiler creates an empty profiler.
+func NewProfiler() *Profiler {
+	p := &Profiler{}
+	p.Clear()
+	return p
+}
+
+// Clear discards all profile data. The call stack is also cleared, so it
+// should be called after the CPU is reset.
+func (p *Profiler) Clear() {
+	p.calls.Clear()
+	p.cycles = [0x10000]uint64{}
+	p.samples = make(map[string]*ProfileSample)
+	p.total = 0
+	p.key = p.key[:0]
+	p.depth = 0
+}
+
+// Cycles returns the number of cycles spent executing the instruction at
+// the address.
+func (p *Profiler) Cycles(addr uint16) uint64 {
+	return p.cycles[addr]
+}
+
+// Total returns the total number of cycles attributed.
+func (p *Profiler) Total() uint64 {
+	return p.total
+}
+
+// Samples returns the profile's samples, one for each distinct combination
+// of address and call stack.
+func (p *Profiler) Samples() []ProfileSample {
+	samples := make([]ProfileSample, 0, len(p.samples))
+	for _, s := range p.samples {
+		samples = append(samples, *s)
+	}
+	return samples
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+func (p *Profiler) BeforeInstruction(cpu *CPU, t *Trace) {
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (p *Profiler) AfterInstruction(cpu *CPU, t *Trace) {
+	p.cycles[t.PC] += t.Cycles
+	p.total += t.Cycles
+
+	// The instruction is attributed to the call stack in effect before it
+	// executed, so the stack is updated afterward.
+	if p.depth != len(p.calls.Frames) {
+		p.updateKey()
+	}
+	p.key = append(p.key, byte(t.PC), byte(t.PC>>8))
+	s, ok := p.samples[string(p.key)]
+	if !ok {
+		s = &ProfileSample{Stack: p.stack(t.PC)}
+		p.samples[string(p.key)] = s
+	}
+	s.Cycles += t.Cycles
+	s.Instructions++
+	p.key = p.key[:len(p.key)-2]
+
+	p.calls.AfterInstruction(cpu, t)
+}
+
+// OnInterrupt is called by the CPU after it services an IRQ or NMI.
+func (p *Profiler) OnInterrupt(cpu *CPU, vector uint16) {
+	p.calls.OnInterrupt(cpu, vector)
+	p.depth = -1 // an instruction may have returned in the same step
+}
+
+// Rebuild the key identifying the current call stack.
+func (p *Profiler) updateKey() {
+	p.key = p.key[:0]
+	for _, f := range p.calls.Frames {
+		var b [4]byte
+		binary.LittleEndian.PutUint16(b[0:2], f.Site)
+		binary.LittleEndian.PutUint16(b[2:4], f.Target)
+		p.key = append(p.key, b[:]...)
+	}
+	p.depth = len(p.calls.Frames)
+}
+
+// Return the locations of the current call stack with the instruction at
+// 'pc' innermost.
+func (p *Profiler) stack(pc uint16) []ProfileLocation {
+	frames := p.calls.Frames
+	stack := make([]ProfileLocation, 0, len(frames)+1)
+	stack = append(stack, ProfileLocation{PC: pc})
+	for i := len(frames) - 1; i >= 0; i-- {
+		stack[len(stack)-1].Routine = frames[i].Target
+		stack[len(stack)-1].Called = true
+		stack = append(stack, ProfileLocation{PC: frames[i].Site})
+	}
+	return stack
+}
@@ -17,6 +17,20 @@ type Registers struct {
 	Decimal          bool   // PS: Decimal bit
 	Overflow         bool   // PS: Overflow bit
 	Sign             bool   // PS: Sign bit
+
+	// 65c816 registers, unused by the other architectures. The low bytes
+	// of the 16-bit accumulator, index registers and stack pointer are held
+	// in A, X, Y and SP.
+	B            byte   // high byte of the 16-bit accumulator
+	XH           byte   // high byte of the X indexing register
+	YH           byte   // high byte of the Y indexing register
+	SPH          byte   // high byte of the stack pointer
+	D            uint16 // direct page register
+	DBR          byte   // data bank register
+	PBR          byte   // program bank register
+	Emulation    bool   // E: 6502 emulation mode
+	MemorySelect bool   // PS: M bit (8-bit accumulator and memory if set)
+	IndexSelect  bool   // PS: X bit (8-bit index registers if set)
 }
 
 // Bits assigned to the processor status byte
@@ -29,6 +43,10 @@ const (
 	ReservedBit         = 1 << 5
 	OverflowBit         = 1 << 6
 	SignBit             = 1 << 7
+
+	// 65c816 native mode bits
+	IndexSelectBit  = 1 << 4
+	MemorySelectBit = 1 << 5
 )
 
 // SavePS saves the CPU processor status into a byte value. The break bit
@@ -69,6 +87,50 @@ func (r *Registers) RestorePS(ps byte) {
 	r.Sign = ((ps & SignBit) != 0)
 }
 
+// SavePSNative saves the 65c816 native mode processor status into a byte
+// value. The M and X bits replace the reserved and break bits.
+func (r *Registers) SavePSNative() byte {
+	ps := r.SavePS(false) &^ (ReservedBit | BreakBit)
+	if r.MemorySelect {
+		ps |= MemorySelectBit
+	}
+	if r.IndexSelect {
+		ps |= IndexSelectBit
+	}
+	return ps
+}
+
+// RestorePSNative restores the 65c816 native mode processor status from a
+// byte. Setting the X bit clears the high bytes of the index registers.
+func (r *Registers) RestorePSNative(ps byte) {
+	r.RestorePS(ps)
+	r.MemorySelect = ((ps & MemorySelectBit) != 0)
+	r.IndexSelect = ((ps & IndexSelectBit) != 0)
+	if r.IndexSelect {
+		r.XH, r.YH = 0, 0
+	}
+}
+
+// C returns the 65c816 16-bit accumulator.
+func (r *Registers) C() uint16 {
+	return uint16(r.B)<<8 | uint16(r.A)
+}
+
+// X16 returns the 65c816 16-bit X indexing register.
+func (r *Registers) X16() uint16 {
+	return uint16(r.XH)<<8 | uint16(r.X)
+}
+
+// Y16 returns the 65c816 16-bit Y indexing register.
+func (r *Registers) Y16() uint16 {
+	return uint16(r.YH)<<8 | uint16(r.Y)
+}
+
+// S16 returns the 65c816 16-bit stack pointer.
+func (r *Registers) S16() uint16 {
+	return uint16(r.SPH)<<8 | uint16(r.SP)
+}
+
 func boolToUint32(v bool) uint32 {
 	if v {
 		return 1
@@ -84,6 +146,7 @@ func boolToByte(v bool) byte {
 }
 
 // Init initializes all registers. A, X, Y = 0. SP = 0xff. PC = 0. PS = 0.
+// The 65c816 registers are initialized for emulation mode.
 func (r *Registers) Init() {
 	r.A = 0
 	r.X = 0
@@ -91,4 +154,8 @@ func (r *Registers) Init() {
 	r.SP = 0xff
 	r.PC = 0
 	r.RestorePS(0)
+
+	r.B, r.XH, r.YH, r.SPH = 0, 0, 0, 0x01
+	r.D, r.DBR, r.PBR = 0, 0, 0
+	r.Emulation, r.MemorySelect, r.IndexSelect = true, true, true
 }
@@ -0,0 +1,99 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import "context"
+
+// A Budget limits the amount of work done by a call to Run. A zero field
+// places no limit.
+type Budget struct {
+	Cycles       uint64 // maximum number of CPU cycles to run
+	Instructions uint64 // maximum number of instructions to step
+}
+
+// StopReason identifies why a call to Run returned.
+type StopReason byte
+
+const (
+	// StopBudget indicates the cycle or instruction budget was exhausted.
+	StopBudget StopReason = iota
+
+	// StopBreakpoint indicates an execution breakpoint was hit.
+	StopBreakpoint
+
+	// StopDataBreakpoint indicates a data breakpoint was hit.
+	StopDataBreakpoint
+
+	// StopWatchpoint indicates a watchpoint was hit.
+	StopWatchpoint
+
+	// StopStackFault indicates a StackChecker found a problem with the
+	// stack.
+	StopStackFault
+
+	// StopCodeFault indicates a CodeChecker found code that modified an
+	// instruction or entered data.
+	StopCodeFault
+
+	// StopHalted indicates the CPU stopped executing instructions, for
+	// example because of a STP or JAM instruction or an undefined opcode.
+	StopHalted
+
+	// StopCanceled indicates the context was canceled.
+	StopCanceled
+)
+
+// A Stop describes why a call to Run returned.
+type Stop struct {
+	Reason         StopReason
+	Breakpoint     *Breakpoint     // breakpoint hit, if any
+	DataBreakpoint *DataBreakpoint // data breakpoint hit, if any
+	Watch          *WatchHit       // watchpoint hit, if any
+	Stack          *StackFault     // stack fault found, if any
+	Code           *CodeFault      // code fault found, if any
+	Err            error           // context error or CPU fault, if any
+}
+
+// How many instructions to step between checks of the context.
+const runCheckInterval = 256
+
+// Run steps the CPU until the budget is exhausted, a breakpoint, data
+// breakpoint or watchpoint attached through a Debugger is hit, a
+// StackChecker or CodeChecker finds a fault, the CPU stops executing
+// instructions, or the context is canceled. It returns the reason it
+// stopped.
+//
+// Breakpoints stop the CPU with the program counter at the breakpoint
+// address. Data breakpoints, watchpoints and faults stop the CPU
+// after the instruction responsible. If an instruction hits more than
+// one, the reason is the first one hit and all of them are reported. An
+// interrupt sequence counts as one instruction against the budget.
+func (cpu *CPU) Run(ctx context.Context, budget Budget) Stop {
+	done := ctx.Done()
+	start := cpu.Cycles
+	for n := uint64(0); ; n++ {
+		if cpu.stopped || cpu.halted {
+			return Stop{Reason: StopHalted, Err: cpu.fault}
+		}
+		if (budget.Instructions != 0 && n >= budget.Instructions) ||
+			(budget.Cycles != 0 && cpu.Cycles-start >= budget.Cycles) {
+			return Stop{Reason: StopBudget}
+		}
+		if done != nil && n%runCheckInterval == 0 {
+			select {
+			case <-done:
+				return Stop{Reason: StopCanceled, Err: ctx.Err()}
+			default:
+			}
+		}
+
+		cpu.hit = Stop{}
+		cpu.Step()
+		if cpu.hit.Breakpoint != nil || cpu.hit.DataBreakpoint != nil || cpu.hit.Watch != nil ||
+			cpu.hit.Stack != nil || cpu.hit.Code != nil {
+			return cpu.hit
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package cpu_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// Per-opcode test vectors in the SingleStepTests JSON format are not
+// distributed with this package. See testdata/singlestep/README.md for
+// where to put them. Missing files are skipped, unless the
+// GO6502_REQUIRE_TESTDATA environment variable is set.
+type singleStepArch struct {
+	name          string
+	arch          cpu.Architecture
+	dir           string   // directory in testdata/singlestep
+	suffixes      []string // file name suffixes following the opcode
+	skipUndefined bool     // skip vectors for opcodes the CPU doesn't define
+}
+
+var singleStepArchs = []singleStepArch{
+	{"NMOS", cpu.NMOS, "6502", []string{""}, true},
+	{"NMOSX", cpu.NMOSX, "6502", []string{""}, true},
+	{"CMOS", cpu.CMOS, "synertek65c02", []string{""}, false},
+	{"WDC", cpu.WDC, "wdc65c02", []string{""}, false},
+	{"R2A03", cpu.R2A03, "nes6502", []string{""}, true},
+	{"W65C816", cpu.W65C816, "65816", []string{".e", ".n"}, false},
+}
+
+// The CPU state before or after a test vector. The 65c816 fields are absent
+// from the 6502 vectors.
+type singleStepState struct {
+	PC  uint16      `json:"pc"`
+	S   uint16      `json:"s"`
+	P   byte        `json:"p"`
+	A   uint16      `json:"a"`
+	X   uint16      `json:"x"`
+	Y   uint16      `json:"y"`
+	DBR byte        `json:"dbr"`
+	D   uint16      `json:"d"`
+	PBR byte        `json:"pbr"`
+	E   byte        `json:"e"`
+	RAM [][2]uint32 `json:"ram"`
+}
+
+type singleStepTest struct {
+	Name    string            `json:"name"`
+	Initial singleStepState   `json:"initial"`
+	Final   singleStepState   `json:"final"`
+	Cycles  []json.RawMessage `json:"cycles"` // one entry per bus cycle
+}
+
+// The results of the test vectors for one opcode.
+type singleStepResult struct {
+	run, failed int
+	first       string // description of the first failure
+}
+
+func TestSingleStep(t *testing.T) {
+	for _, a := range singleStepArchs {
+		a := a
+		t.Run(a.name, func(t *testing.T) {
+			dir := filepath.Join("testdata", "singlestep", a.dir)
+			if _, err := os.Stat(dir); err != nil {
+				skipMissingTestdata(t, "%s not found", dir)
+			}
+			if testing.Short() {
+				t.Skip("skipping in short mode")
+			}
+			runSingleStepDir(t, &a, dir)
+		})
+	}
+}
+
+// The sample vectors are hand-written vectors for seven NMOS opcodes ($20,
+// $48, $60, $68, $A9, $BD and $E6) that are distributed with this package.
+// Only these opcodes run when the full set is missing, on the NMOS and
+// NMOSX architectures.
+func TestSingleStepSample(t *testing.T) {
+	for _, a := range singleStepArchs {
+		a := a
+		dir := filepath.Join("testdata", "singlestep", "sample", a.dir)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		t.Run(a.name, func(t *testing.T) {
+			runSingleStepDir(t, &a, dir)
+		})
+	}
+}
+
+// Run the test vectors of each opcode in a directory.
+func runSingleStepDir(t *testing.T, a *singleStepArch, dir string) {
+	var results [256]*singleStepResult
+	set := cpu.GetInstructionSet(a.arch)
+	for op := 0; op < 256; op++ {
+		if a.skipUndefined && set.Lookup(byte(op)).Undefined() {
+			continue
+		}
+		for _, suffix := range a.suffixes {
+			file := filepath.Join(dir, fmt.Sprintf("%02x%s.json", op, suffix))
+			r, err := runSingleStepFile(a.arch, file)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[op] == nil {
+				results[op] = r
+			} else {
+				results[op].run += r.run
+				results[op].failed += r.failed
+				if results[op].first == "" {
+					results[op].first = r.first
+				}
+			}
+		}
+	}
+
+	for op, r := range results {
+		if r != nil && r.failed > 0 {
+			t.Errorf("opcode $%02X: %d of %d failed, first: %s", op, r.failed, r.run, r.first)
+		}
+	}
+	t.Log(singleStepSummary(&results))
+}
+
+// Run the test vectors in a file and return the results.
+func runSingleStepFile(arch cpu.Architecture, file string) (*singleStepResult, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tests []singleStepTest
+	if err := json.Unmarshal(b, &tests); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	// The memory is shared by all of the vectors, and only the addresses
+	// used by each vector are cleared after it runs. The bus accesses of
+	// the cycle-accurate architectures are recorded.
+	var mem cpu.Memory
+	var load func(addr uint32) byte
+	var store func(addr uint32, v byte)
+	var bus *singleStepBus
+	if arch == cpu.W65C816 {
+		m := cpu.NewFlatLongMemory()
+		mem, load, store = m, m.LoadByteLong, m.StoreByteLong
+	} else {
+		bus = &singleStepBus{FlatMemory: cpu.NewFlatMemory()}
+		mem = bus
+		load = func(addr uint32) byte { return bus.FlatMemory.LoadByte(uint16(addr)) }
+		store = func(addr uint32, v byte) { bus.FlatMemory.StoreByte(uint16(addr), v) }
+	}
+
+	r := &singleStepResult{}
+	for i := range tests {
+		test := &tests[i]
+		c := cpu.NewCPU(arch, mem)
+		test.Initial.apply(c)
+		for _, m := range test.Initial.RAM {
+			store(m[0], byte(m[1]))
+		}
+
+		c.Step()
+
+		var diffs []string
+		got := singleStepCapture(c)
+		native := arch == cpu.W65C816 && !c.Reg.Emulation
+		diffs = append(diffs, got.diff(&test.Final, native)...)
+		for _, m := range test.Final.RAM {
+			if v := load(m[0]); v != byte(m[1]) {
+				diffs = append(diffs, fmt.Sprintf("[$%04X]=$%02X (exp $%02X)", m[0], v, m[1]))
+			}
+		}
+		if c.Cycles != uint64(len(test.Cycles)) {
+			diffs = append(diffs, fmt.Sprintf("cycles=%d (exp %d)", c.Cycles, len(test.Cycles)))
+		}
+
+		// Run the instruction again a cycle at a time, and compare each
+		// bus access with the vector's.
+		if bus != nil {
+			for _, m := range test.Initial.RAM {
+				store(m[0], byte(m[1]))
+			}
+			c = cpu.NewCPU(arch, bus)
+			test.Initial.apply(c)
+			d, err := singleStepTick(c, bus, test.Cycles)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q: %v", file, test.Name, err)
+			}
+			if d != "" {
+				diffs = append(diffs, d)
+			}
+		}
+
+		r.run++
+		if len(diffs) > 0 {
+			r.failed++
+			if r.first == "" {
+				r.first = fmt.Sprintf("%q: %s", test.Name, strings.Join(diffs, " "))
+			}
+		}
+
+		for _, m := range test.Initial.RAM {
+			store(m[0], 0)
+		}
+		for _, m := range test.Final.RAM {
+			store(m[0], 0)
+		}
+	}
+	return r, nil
+}
+
+// A bus access made during one cycle.
+type singleStepCycle struct {
+	addr  uint16
+	value byte
+	write bool
+}
+
+func (c singleStepCycle) String() string {
+	if c.write {
+		return fmt.Sprintf("W$%04X=$%02X", c.addr, c.value)
+	}
+	return fmt.Sprintf("R$%04X=$%02X", c.addr, c.value)
+}
+
+// Decode a vector's bus cycle, which is an array holding the address, the
+// value, and "read" or "write".
+func parseSingleStepCycle(b json.RawMessage) (singleStepCycle, error) {
+	var v []interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return singleStepCycle{}, err
+	}
+	if len(v) == 3 {
+		addr, ok1 := v[0].(float64)
+		value, ok2 := v[1].(float64)
+		kind, ok3 := v[2].(string)
+		if ok1 && ok2 && ok3 && (kind == "read" || kind == "write") {
+			return singleStepCycle{uint16(addr), byte(value), kind == "write"}, nil
+		}
+	}
+	return singleStepCycle{}, fmt.Errorf("invalid cycle %s", b)
+}
+
+// A singleStepBus is a memory that records the bus accesses of the CPU.
+type singleStepBus struct {
+	*cpu.FlatMemory
+	cycles []singleStepCycle
+}
+
+func (b *singleStepBus) LoadByte(addr uint16) byte {
+	v := b.FlatMemory.LoadByte(addr)
+	b.cycles = append(b.cycles, singleStepCycle{addr, v, false})
+	return v
+}
+
+func (b *singleStepBus) LoadBytes(addr uint16, buf []byte) {
+	for i := range buf {
+		buf[i] = b.LoadByte(addr + uint16(i))
+	}
+}
+
+func (b *singleStepBus) LoadAddress(addr uint16) uint16 {
+	next := addr + 1
+	if (addr & 0xff) == 0xff {
+		next = addr - 0xff
+	}
+	return uint16(b.LoadByte(addr)) | uint16(b.LoadByte(next))<<8
+}
+
+// Like a Bus, the memory may be peeked without recording an access.
+func (b *singleStepBus) Peek(addr uint16) (byte, bool) {
+	return b.FlatMemory.LoadByte(addr), true
+}
+
+func (b *singleStepBus) StoreByte(addr uint16, v byte) {
+	b.FlatMemory.StoreByte(addr, v)
+	b.cycles = append(b.cycles, singleStepCycle{addr, v, true})
+}
+
+// A singleStepDone is a tracer that notes when an instruction completes.
+type singleStepDone bool
+
+func (d *singleStepDone) BeforeInstruction(c *cpu.CPU, t *cpu.Trace) {}
+func (d *singleStepDone) AfterInstruction(c *cpu.CPU, t *cpu.Trace)  { *d = true }
+
+// Tick the CPU until its instruction completes, and return a description
+// of the first bus access that differs from the expected cycles, if any.
+func singleStepTick(c *cpu.CPU, bus *singleStepBus, cycles []json.RawMessage) (string, error) {
+	exp := make([]singleStepCycle, len(cycles))
+	for i, b := range cycles {
+		var err error
+		if exp[i], err = parseSingleStepCycle(b); err != nil {
+			return "", err
+		}
+	}
+
+	var done singleStepDone
+	c.AttachTracer(&done)
+	bus.cycles = bus.cycles[:0]
+	for n := 0; !done && n <= len(exp); n++ {
+		c.Tick()
+	}
+
+	for i := 0; i < len(exp) || i < len(bus.cycles); i++ {
+		switch {
+		case i >= len(bus.cycles):
+			return fmt.Sprintf("tick %d: none (exp %v)", i+1, exp[i]), nil
+		case i >= len(exp):
+			return fmt.Sprintf("tick %d: %v (exp none)", i+1, bus.cycles[i]), nil
+		case bus.cycles[i] != exp[i]:
+			return fmt.Sprintf("tick %d: %v (exp %v)", i+1, bus.cycles[i], exp[i]), nil
+		}
+	}
+	return "", nil
+}
+
+// Set the CPU registers from the state.
+func (s *singleStepState) apply(c *cpu.CPU) {
+	r := &c.Reg
+	if c.Arch == cpu.W65C816 {
+		r.Emulation = s.E != 0
+		switch r.Emulation {
+		case true:
+			r.RestorePS(s.P)
+			r.MemorySelect, r.IndexSelect = true, true
+		case false:
+			r.RestorePSNative(s.P)
+		}
+		r.B, r.XH, r.YH, r.SPH = byte(s.A>>8), byte(s.X>>8), byte(s.Y>>8), byte(s.S>>8)
+		r.D, r.DBR, r.PBR = s.D, s.DBR, s.PBR
+	} else {
+		r.RestorePS(s.P)
+	}
+	r.A, r.X, r.Y, r.SP = byte(s.A), byte(s.X), byte(s.Y), byte(s.S)
+	r.PC = s.PC
+}
+
+// Capture the CPU registers in a state.
+func singleStepCapture(c *cpu.CPU) singleStepState {
+	r := &c.Reg
+	s := singleStepState{
+		PC: r.PC,
+		S:  uint16(r.SP),
+		P:  r.SavePS(false),
+		A:  uint16(r.A),
+		X:  uint16(r.X),
+		Y:  uint16(r.Y),
+	}
+	if c.Arch == cpu.W65C816 {
+		s.S, s.A, s.X, s.Y = r.S16(), r.C(), r.X16(), r.Y16()
+		s.D, s.DBR, s.PBR = r.D, r.DBR, r.PBR
+		switch r.Emulation {
+		case true:
+			s.E = 1
+		case false:
+			s.P = r.SavePSNative()
+		}
+	}
+	return s
+}
+
+// Return a description of each register that differs from the expected
+// state. The break and reserved bits of the processor status are ignored
+// outside of 65c816 native mode.
+func (s *singleStepState) diff(exp *singleStepState, native bool) []string {
+	p, expP := s.P, exp.P
+	if !native {
+		p |= cpu.BreakBit | cpu.ReservedBit
+		expP |= cpu.BreakBit | cpu.ReservedBit
+	}
+
+	var d []string
+	check := func(name string, got, want int) {
+		if got != want {
+			d = append(d, fmt.Sprintf("%s=$%02X (exp $%02X)", name, got, want))
+		}
+	}
+	check("PC", int(s.PC), int(exp.PC))
+	check("S", int(s.S), int(exp.S))
+	check("P", int(p), int(expP))
+	check("A", int(s.A), int(exp.A))
+	check("X", int(s.X), int(exp.X))
+	check("Y", int(s.Y), int(exp.Y))
+	check("DBR", int(s.DBR), int(exp.DBR))
+	check("D", int(s.D), int(exp.D))
+	check("PBR", int(s.PBR), int(exp.PBR))
+	check("E", int(s.E), int(exp.E))
+	return d
+}
+
+// Return a 16x16 table of the results of each opcode: '.' if all of its
+// vectors passed, 'X' if any failed, and '-' if it wasn't tested.
+func singleStepSummary(results *[256]*singleStepResult) string {
+	var b strings.Builder
+	b.WriteString("\n   0 1 2 3 4 5 6 7 8 9 A B C D E F\n")
+	passed, failed := 0, 0
+	for hi := 0; hi < 16; hi++ {
+		fmt.Fprintf(&b, "%X ", hi)
+		for lo := 0; lo < 16; lo++ {
+			r := results[hi<<4|lo]
+			switch {
+			case r == nil:
+				b.WriteString(" -")
+			case r.failed > 0:
+				b.WriteString(" X")
+				failed++
+			default:
+				b.WriteString(" .")
+				passed++
+			}
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%d opcodes passed, %d failed", passed, failed)
+	return b.String()
+}
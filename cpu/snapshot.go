@@ -0,0 +1,383 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Errors
+var (
+	ErrSnapshotFormat   = errors.New("Invalid snapshot format")
+	ErrSnapshotVersion  = errors.New("Invalid snapshot version")
+	ErrSnapshotMismatch = errors.New("Snapshot doesn't match the memory configuration")
+	ErrSnapshotBusy     = errors.New("Snapshot requested while an instruction is in progress")
+)
+
+const (
+	snapshotSignature    = "sn65"
+	snapshotVersionMajor = 0
+	snapshotVersionMinor = 1
+)
+
+// The Snapshotter interface may be implemented by memory and devices whose
+// state should be saved in and restored from CPU snapshots. Memory that
+// doesn't implement the interface is saved as the 64K of bytes visible
+// through the Memory interface.
+type Snapshotter interface {
+	// SaveState writes the state to a snapshot stream.
+	SaveState(w io.Writer) error
+
+	// LoadState restores the state previously written by SaveState.
+	LoadState(r io.Reader) error
+}
+
+// The CPU state stored in a snapshot.
+type snapshotState struct {
+	Reg     Registers
+	Cycles  uint64
+	LastPC  uint16
+	IRQ     uint32
+	NMILine bool
+	NMI     bool
+	Delayed bool // the next IRQ poll sees Masked, not the InterruptDisable flag
+	Masked  bool
+	Stopped bool
+	Waiting bool
+	Halted  bool
+	Faulted bool   // an undefined opcode halted or jammed the CPU
+	Opcode  byte   // the undefined opcode
+	Address uint16 // address of the opcode
+}
+
+// Save writes a snapshot of the CPU and its memory to the writer. The
+// snapshot includes the architecture, registers, cycle count, pending
+// interrupts and any undefined opcode that halted the CPU. Save must not be
+// called while an instruction started by Tick is in progress.
+func (cpu *CPU) Save(w io.Writer) error {
+	if cpu.tick.busy {
+		return ErrSnapshotBusy
+	}
+
+	hdr := []byte(snapshotSignature)
+	hdr = append(hdr, snapshotVersionMajor, snapshotVersionMinor, byte(cpu.Arch))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+
+	s := snapshotState{
+		Reg:     cpu.Reg,
+		Cycles:  cpu.Cycles,
+		LastPC:  cpu.LastPC,
+		IRQ:     uint32(cpu.irq),
+		NMILine: cpu.nmiLine,
+		NMI:     cpu.nmi,
+		Delayed: cpu.irqDelayed,
+		Masked:  cpu.irqMasked,
+		Stopped: cpu.stopped,
+		Waiting: cpu.waiting,
+		Halted:  cpu.halted,
+	}
+	if e, ok := cpu.fault.(*UndefinedOpcodeError); ok {
+		s.Faulted, s.Opcode, s.Address = true, e.Opcode, e.Address
+	}
+	if err := binary.Write(w, binary.LittleEndian, &s); err != nil {
+		return err
+	}
+
+	return writeChunk(w, func(w io.Writer) error {
+		if m, ok := cpu.Mem.(Snapshotter); ok {
+			return m.SaveState(w)
+		}
+		var b [64 * 1024]byte
+		cpu.Mem.LoadBytes(0, b[:])
+		_, err := w.Write(b[:])
+		return err
+	})
+}
+
+// Load restores the CPU and its memory from a snapshot written by Save. The
+// CPU takes on the architecture stored in the snapshot. The memory must be
+// configured the same way as the memory that was saved. An attached History
+// is cleared. If the snapshot can't be loaded, neither the CPU nor its
+// memory is changed.
+func (cpu *CPU) Load(r io.Reader) error {
+	hdr := make([]byte, 7)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	if string(hdr[0:4]) != snapshotSignature {
+		return ErrSnapshotFormat
+	}
+	if hdr[4] != snapshotVersionMajor || hdr[5] != snapshotVersionMinor {
+		return ErrSnapshotVersion
+	}
+	arch := Architecture(hdr[6])
+	if arch >= numArchitectures {
+		return ErrSnapshotFormat
+	}
+
+	var s snapshotState
+	if err := binary.Read(r, binary.LittleEndian, &s); err != nil {
+		return err
+	}
+
+	b, err := readChunk(r)
+	if err != nil {
+		return err
+	}
+
+	// The memory is restored last, and its previous state is put back if
+	// the snapshot doesn't match it.
+	if m, ok := cpu.Mem.(Snapshotter); ok {
+		var prev bytes.Buffer
+		if err := m.SaveState(&prev); err != nil {
+			return err
+		}
+		if err := m.LoadState(bytes.NewReader(b)); err != nil {
+			m.LoadState(&prev)
+			return err
+		}
+	} else {
+		if len(b) != 64*1024 {
+			return ErrSnapshotMismatch
+		}
+		cpu.Mem.StoreBytes(0, b)
+	}
+
+	debugger, undefined, onUndefined := cpu.debugger, cpu.Undefined, cpu.OnUndefined
+	tracers, memHooks, history := cpu.tracers, cpu.memHooks, cpu.history
+	*cpu = *NewCPU(arch, cpu.Mem)
+	cpu.Undefined, cpu.OnUndefined = undefined, onUndefined
+	cpu.tracers, cpu.memHooks, cpu.history = tracers, memHooks, history
+	if history != nil {
+		history.Clear()
+	}
+	cpu.debugger = debugger
+	cpu.selectStoreByte()
+	cpu.Reg = s.Reg
+	cpu.Cycles = s.Cycles
+	cpu.LastPC = s.LastPC
+	cpu.irq = IRQSource(s.IRQ)
+	cpu.nmiLine = s.NMILine
+	cpu.nmi = s.NMI
+	cpu.irqDelayed, cpu.irqMasked = s.Delayed, s.Masked
+	cpu.stopped = s.Stopped
+	cpu.waiting = s.Waiting
+	cpu.halted = s.Halted
+	if s.Faulted {
+		cpu.fault = &UndefinedOpcodeError{Opcode: s.Opcode, Address: s.Address}
+	}
+	return nil
+}
+
+// Write a length-prefixed chunk of data produced by the function 'fn'.
+func writeChunk(w io.Writer, fn func(w io.Writer) error) error {
+	var b bytes.Buffer
+	if err := fn(&b); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(b.Len())); err != nil {
+		return err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Read a length-prefixed chunk of data written by writeChunk.
+func readChunk(r io.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+// SaveState writes the contents of memory to a snapshot stream.
+func (m *FlatMemory) SaveState(w io.Writer) error {
+	_, err := w.Write(m.b[:])
+	return err
+}
+
+// LoadState restores the contents of memory from a snapshot stream.
+func (m *FlatMemory) LoadState(r io.Reader) error {
+	_, err := io.ReadFull(r, m.b[:])
+	return err
+}
+
+// SaveState writes the contents of memory to a snapshot stream. Only the
+// banks that have been stored to are saved.
+func (m *FlatLongMemory) SaveState(w io.Writer) error {
+	if err := m.FlatMemory.SaveState(w); err != nil {
+		return err
+	}
+	for i, b := range m.banks {
+		if b == nil {
+			continue
+		}
+		if _, err := w.Write([]byte{byte(i + 1)}); err != nil {
+			return err
+		}
+		if _, err := w.Write(b[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadState restores the contents of memory from a snapshot stream.
+func (m *FlatLongMemory) LoadState(r io.Reader) error {
+	if err := m.FlatMemory.LoadState(r); err != nil {
+		return err
+	}
+	m.banks = [255]*[64 * 1024]byte{}
+
+	var bank [1]byte
+	for {
+		_, err := io.ReadFull(r, bank[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if bank[0] == 0 {
+			return ErrSnapshotFormat
+		}
+		b := new([64 * 1024]byte)
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return err
+		}
+		m.banks[bank[0]-1] = b
+	}
+}
+
+// SaveState writes the contents of memory, including every bank of every
+// window, to a snapshot stream.
+func (m *BankedMemory) SaveState(w io.Writer) error {
+	if err := m.FlatMemory.SaveState(w); err != nil {
+		return err
+	}
+	for _, win := range m.windows {
+		if err := binary.Write(w, binary.LittleEndian, uint32(win.bank)); err != nil {
+			return err
+		}
+		for _, b := range win.banks {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadState restores the contents of memory from a snapshot stream. The
+// memory must have the same windows as the memory that was saved.
+func (m *BankedMemory) LoadState(r io.Reader) error {
+	if err := m.FlatMemory.LoadState(r); err != nil {
+		return err
+	}
+	for _, win := range m.windows {
+		var bank uint32
+		if err := binary.Read(r, binary.LittleEndian, &bank); err != nil {
+			return ErrSnapshotMismatch
+		}
+		if int(bank) >= len(win.banks) {
+			return ErrSnapshotMismatch
+		}
+		win.bank = int(bank)
+		for _, b := range win.banks {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return ErrSnapshotMismatch
+			}
+		}
+	}
+	return nil
+}
+
+// SaveState writes the state of every mapped device that implements the
+// Snapshotter interface to a snapshot stream.
+func (b *Bus) SaveState(w io.Writer) error {
+	if _, err := w.Write([]byte{b.last, byte(len(b.regions))}); err != nil {
+		return err
+	}
+	for _, r := range b.regions {
+		err := writeChunk(w, func(w io.Writer) error {
+			if s, ok := r.device.(Snapshotter); ok {
+				return s.SaveState(w)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadState restores the state of every mapped device that implements the
+// Snapshotter interface from a snapshot stream. The devices must be mapped
+// in the same order as they were when the snapshot was saved. Devices that
+// have been entirely replaced or unmapped aren't included.
+func (b *Bus) LoadState(r io.Reader) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return err
+	}
+	if int(hdr[1]) != len(b.regions) {
+		return ErrSnapshotMismatch
+	}
+	b.last = hdr[0]
+
+	for _, reg := range b.regions {
+		data, err := readChunk(r)
+		if err != nil {
+			return err
+		}
+		if s, ok := reg.device.(Snapshotter); ok {
+			if err := s.LoadState(bytes.NewReader(data)); err != nil {
+				return err
+			}
+		} else if len(data) != 0 {
+			return ErrSnapshotMismatch
+		}
+	}
+	return nil
+}
+
+// SaveState writes the contents of the RAM to a snapshot stream.
+func (r *RAM) SaveState(w io.Writer) error {
+	_, err := w.Write(r.b)
+	return err
+}
+
+// LoadState restores the contents of the RAM from a snapshot stream.
+func (r *RAM) LoadState(rd io.Reader) error {
+	_, err := io.ReadFull(rd, r.b)
+	return err
+}
+
+// SaveState writes the state of the mirrored device to a snapshot stream
+// if it implements the Snapshotter interface.
+func (m *mirror) SaveState(w io.Writer) error {
+	if s, ok := m.device.(Snapshotter); ok {
+		return s.SaveState(w)
+	}
+	return nil
+}
+
+// LoadState restores the state of the mirrored device from a snapshot
+// stream if it implements the Snapshotter interface.
+func (m *mirror) LoadState(r io.Reader) error {
+	if s, ok := m.device.(Snapshotter); ok {
+		return s.LoadState(r)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// StackFaultKind identifies the kind of problem found by a StackChecker.
+type StackFaultKind byte
+
+const (
+	// StackOverflow indicates the stack pointer wrapped while pushing.
+	StackOverflow StackFaultKind = iota
+
+	// StackUnderflow indicates the stack pointer wrapped while pulling.
+	StackUnderflow
+
+	// StackFloorCrossed indicates the stack pointer dropped below the
+	// checker's floor.
+	StackFloorCrossed
+
+	// StackBadReturn indicates an RTS, RTL or RTI returned to an address
+	// that wasn't pushed by a subroutine call or interrupt.
+	StackBadReturn
+)
+
+// A StackFault describes a problem found by a StackChecker.
+type StackFault struct {
+	Kind   StackFaultKind
+	PC     uint16 // address of the responsible instruction
+	SP     uint16 // stack pointer after the instruction
+	Return uint16 // address returned to, for StackBadReturn
+}
+
+// A StackChecker is a Tracer that stops the CPU's Run function when the
+// stack pointer wraps around, when it drops below a floor, or, optionally,
+// when a return instruction doesn't return to an address pushed by a call
+// or interrupt. Attach it to a CPU with AttachTracer.
+//
+// Stack pointer wrapping is detected only on 65c816 emulation mode and
+// other 6502 architectures, where the stack is confined to page 1.
+type StackChecker struct {
+	Floor        uint16 // lowest stack address allowed, if non-zero
+	CheckReturns bool   // check that returns match calls and interrupts
+	calls        CallStack
+}
+
+// NewStackChecker creates a stack checker that detects stack pointer
+// wrapping.
+func NewStackChecker() *StackChecker {
+	return &StackChecker{}
+}
+
+// Reset forgets the subroutine calls and interrupts in progress. It should
+// be called after the CPU is reset.
+func (sc *StackChecker) Reset() {
+	sc.calls.Clear()
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+func (sc *StackChecker) BeforeInstruction(cpu *CPU, t *Trace) {
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (sc *StackChecker) AfterInstruction(cpu *CPU, t *Trace) {
+	before, after := stackPointer(cpu, &t.Reg), stackPointer(cpu, &cpu.Reg)
+
+	switch t.Inst.Name {
+	case "PHA", "PHP", "PHX", "PHY", "PHB", "PHD", "PHK", "PEA", "PEI", "PER",
+		"JSR", "JSL", "BRK", "COP":
+		if cpu.stackWraps() && after > before {
+			sc.fault(cpu, StackFault{Kind: StackOverflow, PC: t.PC, SP: after})
+			break
+		}
+		sc.checkFloor(cpu, t.PC, before, after)
+
+	case "PLA", "PLP", "PLX", "PLY", "PLB", "PLD":
+		if cpu.stackWraps() && after < before {
+			sc.fault(cpu, StackFault{Kind: StackUnderflow, PC: t.PC, SP: after})
+		}
+
+	case "RTS", "RTL", "RTI":
+		switch {
+		case cpu.stackWraps() && after < before:
+			sc.fault(cpu, StackFault{Kind: StackUnderflow, PC: t.PC, SP: after})
+		case sc.CheckReturns && !sc.returnMatches(cpu, before):
+			sc.fault(cpu, StackFault{Kind: StackBadReturn, PC: t.PC, SP: after, Return: cpu.Reg.PC})
+		}
+
+	case "TXS", "TCS":
+		sc.checkFloor(cpu, t.PC, before, after)
+	}
+
+	sc.calls.AfterInstruction(cpu, t)
+}
+
+// OnInterrupt is called by the CPU after it services an IRQ or NMI.
+func (sc *StackChecker) OnInterrupt(cpu *CPU, vector uint16) {
+	after := stackPointer(cpu, &cpu.Reg)
+	var before uint16
+	switch cpu.stackWraps() {
+	case true:
+		before = stackAddress(cpu.Reg.SP + 3)
+	case false:
+		before = after + 4
+	}
+
+	switch {
+	case cpu.stackWraps() && after > before:
+		sc.fault(cpu, StackFault{Kind: StackOverflow, PC: cpu.LastPC, SP: after})
+	default:
+		sc.checkFloor(cpu, cpu.LastPC, before, after)
+	}
+
+	sc.calls.OnInterrupt(cpu, vector)
+}
+
+// Save the calls in progress for a History.
+func (sc *StackChecker) saveState(buf interface{}) interface{} {
+	return sc.calls.saveState(buf)
+}
+
+// Restore the calls saved by saveState.
+func (sc *StackChecker) restoreState(s interface{}) {
+	sc.calls.restoreState(s)
+}
+
+// Check whether the stack pointer crossed below the floor.
+func (sc *StackChecker) checkFloor(cpu *CPU, pc, before, after uint16) {
+	if sc.Floor != 0 && after < sc.Floor && before >= sc.Floor {
+		sc.fault(cpu, StackFault{Kind: StackFloorCrossed, PC: pc, SP: after})
+	}
+}
+
+// Check whether a return instruction, executed with the stack pointer
+// 'sp', returned to the address pushed by the innermost call.
+func (sc *StackChecker) returnMatches(cpu *CPU, sp uint16) bool {
+	if len(sc.calls.Frames) == 0 {
+		return false
+	}
+	f := sc.calls.top()
+	return f.SP == sp && f.Return == cpu.Reg.PC
+}
+
+// Report a fault, which stops the CPU's Run function.
+func (sc *StackChecker) fault(cpu *CPU, f StackFault) {
+	if cpu.hit.Stack != nil {
+		return
+	}
+	cpu.hit.Stack = &f
+	if cpu.hit.DataBreakpoint == nil && cpu.hit.Watch == nil && cpu.hit.Code == nil {
+		cpu.hit.Reason = StopStackFault
+	}
+}
+
+// Return true if the stack pointer wraps within page 1.
+func (cpu *CPU) stackWraps() bool {
+	return cpu.Arch != W65C816 || cpu.Reg.Emulation
+}
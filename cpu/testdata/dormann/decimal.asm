@@ -0,0 +1,261 @@
+; Exhaustive test of decimal mode ADC and SBC, written for go6502 in the
+; style of Klaus Dormann's 6502_decimal_test, which is based on Bruce
+; Clark's decimal mode tutorial.
+;
+; Every pair of operands, valid BCD or not, is added and subtracted with
+; both carries. The accumulator and carry are compared with results
+; predicted using binary arithmetic. On a 65C02, whose decimal mode sets
+; the N and Z flags from the result, those flags are compared too.
+;
+; The test ends by trapping at DONE. ERROR is zero if every case passed.
+; Otherwise N1, N2 and the Y register hold the operands and carry of the
+; failing case.
+
+		.ARCH	6502
+		.ORG	$0200
+
+N1		.EQ	$00	; first operand
+N2		.EQ	$01	; second operand
+HA		.EQ	$02	; accumulator result
+HF		.EQ	$03	; flags result
+DA		.EQ	$04	; predicted accumulator
+DF		.EQ	$05	; predicted flags
+LO		.EQ	$06	; predicted low digit and its carry or borrow
+LOH		.EQ	$07	; high byte of LO
+SUM		.EQ	$08	; 16-bit predicted result
+T1		.EQ	$0A	; temporary
+ERROR		.EQ	$0B	; non-zero if a case failed
+T2		.EQ	$0C	; temporary
+CMOS		.EQ	$0D	; non-zero on a 65C02
+MASK		.EQ	$0E	; flags compared
+
+START		CLD
+		LDX	#$FF
+		TXS
+		LDA	#1
+		STA	ERROR
+
+		; Only a 65C02 sets the Z flag when $99 + $01 is $00.
+		SED
+		LDA	#$99
+		CLC
+		ADC	#$01
+		PHP
+		CLD
+		PLA
+		AND	#$02
+		STA	CMOS
+		LDA	#$01
+		LDX	CMOS
+		BEQ	@1
+		LDA	#$83
+@1		STA	MASK
+
+		LDA	#0
+		STA	N1
+		STA	N2
+LOOP		LDY	#0
+@carry		JSR	ADD
+		JSR	PADD
+		JSR	CHECK
+		BNE	DONE
+		JSR	SUB
+		JSR	PSUB
+		JSR	CHECK
+		BNE	DONE
+		INY
+		CPY	#2
+		BNE	@carry
+		INC	N2
+		BNE	LOOP
+		INC	N1
+		BNE	LOOP
+
+		LDA	#0
+		STA	ERROR
+DONE		JMP	DONE
+
+; Add N2 to N1 with the carry in Y, in decimal mode.
+ADD		SED
+		CPY	#1
+		LDA	N1
+		ADC	N2
+		PHP
+		STA	HA
+		PLA
+		STA	HF
+		CLD
+		RTS
+
+; Subtract N2 from N1 with the carry in Y, in decimal mode.
+SUB		SED
+		CPY	#1
+		LDA	N1
+		SBC	N2
+		PHP
+		STA	HA
+		PLA
+		STA	HF
+		CLD
+		RTS
+
+; Compare the results with the predicted ones. Return with Z set if they
+; match.
+CHECK		LDA	HA
+		CMP	DA
+		BNE	@1
+		LDA	HF
+		EOR	DF
+		AND	MASK
+@1		RTS
+
+; Set the N and Z flags of DF from the predicted accumulator.
+PNZ		LDA	DA
+		PHP
+		PLA
+		AND	#$82
+		STA	DF
+		RTS
+
+; Predict the result of ADD. The low digits are added and adjusted, and
+; the sum of the high digits is adjusted if it's $A0 or more. The carry
+; is set if the adjusted sum is $100 or more.
+PADD		CPY	#1
+		LDA	N1
+		AND	#$0F
+		STA	T1
+		LDA	N2
+		AND	#$0F
+		ADC	T1
+		CMP	#$0A
+		BCC	@1
+		ADC	#$05		; the carry is set, so this adds 6
+		AND	#$0F
+		ORA	#$10
+@1		STA	LO
+		LDA	N1
+		AND	#$F0
+		STA	T1
+		LDA	N2
+		AND	#$F0
+		CLC
+		ADC	T1
+		STA	SUM
+		LDA	#0
+		ROL
+		STA	SUM+1
+		LDA	SUM
+		CLC
+		ADC	LO
+		STA	SUM
+		BCC	@2
+		INC	SUM+1
+@2		LDA	SUM+1
+		BNE	@3
+		LDA	SUM
+		CMP	#$A0
+		BCC	@4
+@3		LDA	SUM
+		CLC
+		ADC	#$60
+		STA	SUM
+		BCC	@4
+		INC	SUM+1
+@4		LDA	SUM
+		STA	DA
+		JSR	PNZ
+		LDA	SUM+1
+		BEQ	@5
+		INC	DF
+@5		RTS
+
+; Predict the result of SUB. The carry is the carry of a binary
+; subtraction.
+PSUB		LDA	CMOS
+		BNE	PSUBC
+
+		; On a 6502, the low digits are subtracted and adjusted, and the
+		; difference of the high digits is adjusted if it's negative.
+		CPY	#1
+		LDA	N1
+		AND	#$0F
+		STA	T1
+		LDA	N2
+		AND	#$0F
+		STA	T2
+		LDA	T1
+		SBC	T2
+		BCS	@1
+		SEC
+		SBC	#$06
+		AND	#$0F
+		ORA	#$F0
+		STA	LO
+		LDA	#$FF
+		BNE	@2
+@1		STA	LO
+		LDA	#0
+@2		STA	LOH
+		LDA	N1
+		AND	#$F0
+		STA	T1
+		LDA	N2
+		AND	#$F0
+		STA	T2
+		LDA	T1
+		SEC
+		SBC	T2
+		STA	SUM
+		LDA	#0
+		SBC	#0
+		STA	SUM+1
+		LDA	SUM
+		CLC
+		ADC	LO
+		STA	SUM
+		LDA	SUM+1
+		ADC	LOH
+		BPL	@3
+		LDA	SUM
+		SEC
+		SBC	#$60
+		STA	SUM
+@3		LDA	SUM
+		STA	DA
+		JMP	PSUBF
+
+		; On a 65C02, the binary difference is adjusted if it's
+		; negative, and again if the difference of the low digits is.
+PSUBC		CPY	#1
+		LDA	N1
+		AND	#$0F
+		STA	T1
+		LDA	N2
+		AND	#$0F
+		STA	T2
+		LDA	T1
+		SBC	T2
+		LDA	#0
+		ROL
+		STA	LOH
+		CPY	#1
+		LDA	N1
+		SBC	N2
+		BCS	@1
+		SEC
+		SBC	#$60
+@1		LDX	LOH
+		BNE	@2
+		SEC
+		SBC	#$06
+@2		STA	DA
+
+PSUBF		JSR	PNZ
+		CPY	#1
+		LDA	N1
+		SBC	N2
+		LDA	#0
+		ROL
+		ORA	DF
+		STA	DF
+		RTS
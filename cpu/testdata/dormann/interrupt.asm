@@ -0,0 +1,211 @@
+; Interrupt test for go6502, in the style of Klaus Dormann's
+; 6502_interrupt_test.
+;
+; The test drives the IRQ and NMI inputs through a feedback port at
+; PORT. Bit 0 of a byte stored to the port sets the level of the IRQ
+; line and bit 1 sets the level of the NMI line; a set bit asserts the
+; line.
+;
+; A failing test traps by branching to itself, with its number in TESTNUM.
+; The test passes if it reaches SUCCESS.
+
+		.ARCH	6502
+		.ORG	$0400
+
+PORT		.EQ	$BFFC	; interrupt feedback port
+IRQBIT		.EQ	$01
+NMIBIT		.EQ	$02
+TESTNUM		.EQ	$0200	; number of the current test
+
+IRQCNT		.EQ	$00	; IRQs taken
+BRKCNT		.EQ	$01	; BRKs taken
+NMICNT		.EQ	$02	; NMIs taken
+PFLAGS		.EQ	$03	; status pushed by the last IRQ or BRK
+IFLAG		.EQ	$04	; status inside the last IRQ or BRK handler
+IRQX		.EQ	$05	; X register when the last IRQ or BRK was taken
+
+		.EX	SUCCESS
+
+START		CLD
+		SEI
+		LDX	#$FF
+		TXS
+		LDA	#0
+		STA	PORT
+		STA	IRQCNT
+		STA	BRKCNT
+		STA	NMICNT
+		LDA	#<NMI
+		STA	$FFFA
+		LDA	#>NMI
+		STA	$FFFB
+		LDA	#<IRQ
+		STA	$FFFE
+		LDA	#>IRQ
+		STA	$FFFF
+
+		; An IRQ is not taken while the InterruptDisable flag is set.
+		LDA	#1
+		STA	TESTNUM
+		LDA	#IRQBIT
+		STA	PORT
+		NOP
+		NOP
+		LDA	IRQCNT
+		BNE	$
+
+		; The pending IRQ is taken once the flag is cleared. It pushes
+		; the status with B clear, and its handler runs with the
+		; InterruptDisable flag set.
+		LDA	#2
+		STA	TESTNUM
+		CLI
+		NOP
+		NOP
+		SEI
+		LDA	IRQCNT
+		CMP	#1
+		BNE	$
+		LDA	PFLAGS
+		AND	#$14
+		BNE	$
+		LDA	IFLAG
+		AND	#$04
+		BEQ	$
+
+		; BRK is taken while the InterruptDisable flag is set. It pushes
+		; the status with B set and returns past its signature byte.
+		LDA	#3
+		STA	TESTNUM
+		LDX	#0
+		BRK
+		.DB	$00
+		INX
+		CPX	#1
+		BNE	$
+		LDA	BRKCNT
+		CMP	#1
+		BNE	$
+		LDA	IRQCNT
+		CMP	#1
+		BNE	$
+		LDA	PFLAGS
+		AND	#$10
+		BEQ	$
+
+		; An NMI is taken while the InterruptDisable flag is set.
+		LDA	#4
+		STA	TESTNUM
+		LDA	#NMIBIT
+		STA	PORT
+		NOP
+		LDA	NMICNT
+		CMP	#1
+		BNE	$
+
+		; Holding the NMI line asserted doesn't generate another NMI.
+		LDA	#5
+		STA	TESTNUM
+		NOP
+		NOP
+		LDA	NMICNT
+		CMP	#1
+		BNE	$
+
+		; Releasing and asserting the line again does.
+		LDA	#6
+		STA	TESTNUM
+		LDA	#0
+		STA	PORT
+		NOP
+		LDA	NMICNT
+		CMP	#1
+		BNE	$
+		LDA	#NMIBIT
+		STA	PORT
+		NOP
+		LDA	NMICNT
+		CMP	#2
+		BNE	$
+		LDA	#0
+		STA	PORT
+
+		; RTI restores the status pushed by the interrupt.
+		LDA	#7
+		STA	TESTNUM
+		LDA	#$C7
+		PHA
+		LDA	#NMIBIT
+		PLP
+		STA	PORT
+		PHP
+		PLA
+		AND	#$CF
+		CMP	#$C7
+		BNE	$
+		LDA	#0
+		STA	PORT
+
+		; The instruction following CLI runs before a pending IRQ is
+		; taken.
+		LDA	#8
+		STA	TESTNUM
+		LDX	#0
+		LDA	#IRQBIT
+		STA	PORT
+		CLI
+		INX
+		INX
+		SEI
+		LDA	IRQX
+		CMP	#1
+		BNE	$
+
+		; So does the instruction following PLP.
+		LDA	#9
+		STA	TESTNUM
+		LDA	#0
+		PHA
+		LDX	#0
+		LDA	#IRQBIT
+		STA	PORT
+		PLP
+		INX
+		INX
+		SEI
+		LDA	IRQX
+		CMP	#1
+		BNE	$
+
+SUCCESS		JMP	SUCCESS
+
+; Count an IRQ or BRK, telling them apart by the pushed B flag, and
+; release the IRQ line.
+IRQ		STX	IRQX
+		PHA
+		TXA
+		PHA
+		PHP
+		PLA
+		STA	IFLAG
+		TSX
+		LDA	$0103,X
+		STA	PFLAGS
+		AND	#$10
+		BEQ	@1
+		INC	BRKCNT
+		JMP	@2
+@1		INC	IRQCNT
+		LDA	#0
+		STA	PORT
+@2		PLA
+		TAX
+		PLA
+		RTI
+
+; Count an NMI and change the flags, which RTI restores.
+NMI		INC	NMICNT
+		LDA	#0
+		CLC
+		CLV
+		RTI
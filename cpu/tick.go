@@ -0,0 +1,656 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// A tickCycle performs the work of a single clock cycle of an instruction,
+// including its one bus access.
+type tickCycle func(cpu *CPU)
+
+// The state of an instruction being executed one cycle at a time.
+type tickState struct {
+	busy    bool         // an instruction or interrupt is in progress
+	queue   []tickCycle  // remaining cycles of the instruction
+	buf     [8]tickCycle // initial backing store for the queue
+	count   int          // number of cycles executed so far
+	inst    *Instruction // instruction being executed
+	operand [2]byte      // operand bytes fetched so far
+	n       int          // number of operand bytes fetched
+	base    uint16       // unindexed effective address
+	addr    uint16       // effective address
+	crossed bool         // indexing crossed a page boundary
+	vector  uint16       // interrupt vector address
+	data    byte         // last value read from the effective address
+	result  byte         // value to be written to the effective address
+	latched bool         // the instruction function is accessing the bus
+}
+
+// Tick advances the CPU by a single clock cycle. Instructions and
+// interrupt sequences are spread over as many calls to Tick as they take
+// cycles, and each call performs exactly one memory access, issuing the
+// same reads and writes as the hardware in the same order. This includes
+// the dummy reads made while indexing and the extra write made by the
+// NMOS read-modify-write instructions. Interrupt inputs are sampled when an
+// instruction completes.
+//
+// The 65c816 is not cycle-accurate. Its instructions perform all of their
+// memory accesses during their first cycle.
+//
+// Tick and Step may be mixed freely. A call to Step completes any
+// instruction that has been partially executed by Tick.
+func (cpu *CPU) Tick() {
+	if cpu.halted {
+		return
+	}
+
+	if cpu.tick.busy {
+		c := cpu.tick.queue[0]
+		cpu.tick.queue = cpu.tick.queue[1:]
+		cpu.tick.count++
+		c(cpu)
+	} else {
+		cpu.tickBegin()
+		if cpu.halted {
+			return
+		}
+	}
+	cpu.Cycles++
+
+	if cpu.tick.busy && len(cpu.tick.queue) == 0 {
+		cpu.tick.busy = false
+		if cpu.tick.inst == nil && cpu.tick.vector == vectorReset {
+			return
+		}
+		if cpu.tracers != nil && cpu.Arch != W65C816 {
+			switch {
+			case cpu.tick.inst != nil:
+				cpu.traceAfter()
+			default:
+				cpu.traceInterrupt(cpu.tick.vector)
+			}
+		}
+		if cpu.debugger != nil && cpu.Arch != W65C816 {
+			cpu.debugger.onUpdatePC(cpu, cpu.Reg.PC)
+		}
+	}
+}
+
+// Run the first cycle of the next instruction or interrupt sequence.
+func (cpu *CPU) tickBegin() {
+	if cpu.Arch == W65C816 {
+		// Execute the whole instruction now, and idle for the remainder of
+		// its cycles.
+		start := cpu.Cycles
+		cpu.Step()
+		n := cpu.Cycles - start
+		cpu.Cycles = start
+		for ; n > 1; n-- {
+			cpu.tickQueue((*CPU).tickIdle)
+		}
+		cpu.tick.busy = len(cpu.tick.queue) > 0
+		return
+	}
+
+	if cpu.stopped {
+		return
+	}
+	if cpu.waiting {
+		if !cpu.nmi && cpu.irq == 0 {
+			return
+		}
+		cpu.waiting = false
+	}
+
+	cpu.tick.busy = true
+	cpu.tick.queue = cpu.tick.buf[:0]
+	cpu.tick.count = 1
+	cpu.tick.n = 0
+	cpu.tick.crossed = false
+
+	// An interrupt replaces the next instruction. Its opcode is fetched and
+	// discarded.
+	if cpu.nmi || cpu.irqPending() {
+		cpu.LastPC = cpu.Reg.PC
+		cpu.tick.inst = nil
+		if cpu.nmi {
+			cpu.nmi = false
+			cpu.tick.vector = vectorNMI
+		} else {
+			cpu.tick.vector = vectorIRQ
+		}
+		cpu.fetch(cpu.Reg.PC)
+		cpu.tickQueue((*CPU).tickDummyPC, (*CPU).tickPushPCH, (*CPU).tickPushPCL,
+			(*CPU).tickPushPS, (*CPU).tickVectorLo, (*CPU).tickVectorHi)
+		return
+	}
+
+	opcode := cpu.fetch(cpu.Reg.PC)
+	inst := cpu.instSet.Lookup(opcode)
+
+	// Handle an undefined instruction according to the CPU's policy. The
+	// policy's actions take the place of the opcode fetch cycle.
+	if inst.Undefined() && cpu.Undefined != UndefinedIgnore {
+		cpu.tick.busy = false
+		cycles := cpu.Cycles
+		execute := cpu.handleUndefined(inst)
+		cpu.Cycles = cycles
+		if !execute {
+			if cpu.Undefined == UndefinedReset {
+				// The reset sequence has already run. Idle for the rest
+				// of the cycles it takes.
+				cpu.tick.busy = true
+				cpu.tick.queue = cpu.tick.buf[:0]
+				cpu.tick.inst = nil
+				cpu.tick.vector = vectorReset
+				for i := 1; i < interruptCycles; i++ {
+					cpu.tickQueue((*CPU).tickIdle)
+				}
+			}
+			return
+		}
+		cpu.tick.busy = true
+	}
+
+	// The tracer sees the operand before the instruction fetches it, so the
+	// operand is peeked rather than read from the bus. An operand byte that
+	// can't be peeked is traced as zero.
+	if cpu.tracers != nil {
+		var buf [2]byte
+		operand := buf[:inst.Length-1]
+		for i := range operand {
+			operand[i], _ = peekByte(cpu.Mem, cpu.Reg.PC+1+uint16(i))
+		}
+		cpu.traceBefore(inst, operand)
+	}
+
+	cpu.LastPC = cpu.Reg.PC
+	cpu.Reg.PC++
+	cpu.irqDelayed = false
+	cpu.tick.inst = inst
+	cpu.tickDecode(inst)
+}
+
+// Queue the remaining cycles of an instruction whose opcode has just been
+// fetched.
+func (cpu *CPU) tickDecode(inst *Instruction) {
+	switch inst.access {
+	case accessUnused:
+		if inst.Length == 1 {
+			cpu.tickExecute()
+			return
+		}
+		for i := byte(2); i < inst.Length; i++ {
+			cpu.tickQueue((*CPU).tickFetch)
+		}
+		cpu.tickQueue((*CPU).tickImmediate)
+		return
+	case accessPush:
+		cpu.tickQueue((*CPU).tickDummyPC, (*CPU).tickWrite)
+		return
+	case accessPull:
+		cpu.tickQueue((*CPU).tickDummyPC, (*CPU).tickDummyStack, (*CPU).tickPull)
+		return
+	case accessJump:
+		cpu.tickQueue((*CPU).tickFetch)
+		switch inst.Mode {
+		case ABS:
+			cpu.tickQueue((*CPU).tickJumpAbsolute)
+		case IND:
+			cpu.tickQueue((*CPU).tickFetchIndirect)
+		case ABX:
+			cpu.tickQueue((*CPU).tickFetchIndirectX)
+		}
+		return
+	case accessCall:
+		cpu.tickQueue((*CPU).tickFetch, (*CPU).tickDummyStack, (*CPU).tickPushPCH,
+			(*CPU).tickPushPCL, (*CPU).tickCall)
+		return
+	case accessReturn:
+		cpu.tickQueue((*CPU).tickDummyPC, (*CPU).tickDummyStack, (*CPU).tickPullPCL,
+			(*CPU).tickPullPCH, (*CPU).tickReturn)
+		return
+	case accessReturnInt:
+		cpu.tickQueue((*CPU).tickDummyPC, (*CPU).tickDummyStack, (*CPU).tickPullPS,
+			(*CPU).tickPullPCL, (*CPU).tickPullPCH)
+		return
+	case accessBreak:
+		cpu.tick.vector = vectorBRK
+		cpu.tickQueue((*CPU).tickBreak, (*CPU).tickPushPCH, (*CPU).tickPushPCL,
+			(*CPU).tickPushPS, (*CPU).tickVectorLo, (*CPU).tickVectorHi)
+		return
+	}
+
+	switch inst.Mode {
+	case IMP, ACC:
+		cpu.tickQueue((*CPU).tickImplied)
+	case IMM:
+		cpu.tickQueue((*CPU).tickImmediate)
+	case REL:
+		cpu.tickQueue((*CPU).tickBranch)
+	case ZPR:
+		cpu.tickQueue((*CPU).tickFetchZeroPage, (*CPU).tickLoad, (*CPU).tickDummyRead,
+			(*CPU).tickBranch)
+	case ZPG:
+		cpu.tickQueue((*CPU).tickFetchZeroPage)
+		cpu.tickQueueData()
+	case ZPX, ZPY:
+		cpu.tickQueue((*CPU).tickFetchZeroPage, (*CPU).tickIndexZeroPage)
+	case ABS:
+		cpu.tickQueue((*CPU).tickFetch, (*CPU).tickFetchAbsolute)
+	case ABX, ABY:
+		cpu.tickQueue((*CPU).tickFetch, (*CPU).tickFetchIndexed)
+	case IND:
+		cpu.tickQueue((*CPU).tickFetchZeroPage, (*CPU).tickPointerLo, (*CPU).tickPointerHi)
+	case IDX:
+		cpu.tickQueue((*CPU).tickFetchZeroPage, (*CPU).tickIndexZeroPage,
+			(*CPU).tickPointerLo, (*CPU).tickPointerHi)
+	case IDY:
+		cpu.tickQueue((*CPU).tickFetchZeroPage, (*CPU).tickPointerLo, (*CPU).tickPointerHiIndexed)
+	}
+}
+
+// Queue the cycles that access memory at the effective address once it
+// is known.
+func (cpu *CPU) tickQueueData() {
+	switch cpu.tick.inst.access {
+	case accessWrite:
+		cpu.tickQueue((*CPU).tickWrite)
+	case accessModify:
+		cpu.tickQueue((*CPU).tickRead, (*CPU).tickModify, (*CPU).tickWriteResult)
+	default:
+		cpu.tickQueue((*CPU).tickRead)
+	}
+}
+
+// Append cycles to the end of the instruction.
+func (cpu *CPU) tickQueue(c ...tickCycle) {
+	cpu.tick.queue = append(cpu.tick.queue, c...)
+}
+
+// Insert cycles to run immediately after the current one.
+func (cpu *CPU) tickInsert(c ...tickCycle) {
+	q := make([]tickCycle, 0, len(c)+len(cpu.tick.queue))
+	q = append(q, c...)
+	cpu.tick.queue = append(q, cpu.tick.queue...)
+}
+
+// Read a data byte from the bus.
+func (cpu *CPU) read(addr uint16) byte {
+	v := cpu.fetch(addr)
+	if cpu.debugger != nil && cpu.debugger.watchpoints != nil {
+		cpu.debugger.onWatchLoad(cpu, addr, v)
+	}
+	return v
+}
+
+// Read an instruction byte from the bus. Instruction fetches don't hit
+// watchpoints.
+func (cpu *CPU) fetch(addr uint16) byte {
+	v := cpu.Mem.LoadByte(addr)
+	for _, h := range cpu.memHooks {
+		h.OnRead(cpu, addr, v)
+	}
+	return v
+}
+
+// Read a 16-bit address from the bus. The memory's LoadAddress function
+// determines where the high byte is read from.
+func (cpu *CPU) readAddress(addr uint16) uint16 {
+	v := cpu.Mem.LoadAddress(addr)
+	if cpu.memHooks != nil {
+		next := addr + 1
+		if (addr & 0xff) == 0xff {
+			next = addr - 0xff
+		}
+		for _, h := range cpu.memHooks {
+			h.OnRead(cpu, addr, byte(v))
+			h.OnRead(cpu, next, byte(v>>8))
+		}
+	}
+	if cpu.debugger != nil && cpu.debugger.watchpoints != nil {
+		next := addr + 1
+		if (addr & 0xff) == 0xff {
+			next = addr - 0xff
+		}
+		cpu.debugger.onWatchLoad(cpu, addr, byte(v))
+		cpu.debugger.onWatchLoad(cpu, next, byte(v>>8))
+	}
+	return v
+}
+
+// Run the instruction's function. Its operand loads and stores are
+// redirected to the values transferred on the bus.
+func (cpu *CPU) tickRun() {
+	inst := cpu.tick.inst
+	cpu.pageCrossed = cpu.tick.crossed
+	cpu.deltaCycles = 0
+	cpu.tick.latched = true
+	inst.fn(cpu, inst, cpu.tick.operand[:inst.Length-1])
+	cpu.tick.latched = false
+}
+
+// Run the instruction's function, and pad the instruction with dummy reads
+// if the function reports that it takes extra cycles.
+func (cpu *CPU) tickExecute() {
+	cpu.tickRun()
+
+	inst := cpu.tick.inst
+	want := int(int8(inst.Cycles) + cpu.deltaCycles)
+	if cpu.pageCrossed {
+		want += int(inst.BPCycles)
+	}
+	for have := cpu.tick.count + len(cpu.tick.queue); have < want; have++ {
+		cpu.tickInsert((*CPU).tickDummyPC)
+	}
+}
+
+// Idle for a cycle without accessing the bus.
+func (cpu *CPU) tickIdle() {
+}
+
+// Read the byte at the program counter and discard it.
+func (cpu *CPU) tickDummyPC() {
+	cpu.fetch(cpu.Reg.PC)
+}
+
+// Read the byte at the top of the stack and discard it.
+func (cpu *CPU) tickDummyStack() {
+	cpu.read(stackAddress(cpu.Reg.SP))
+}
+
+// Read the effective address and discard the value.
+func (cpu *CPU) tickDummyRead() {
+	cpu.read(cpu.tick.addr)
+}
+
+// Execute an implied or accumulator mode instruction.
+func (cpu *CPU) tickImplied() {
+	cpu.fetch(cpu.Reg.PC)
+	cpu.tickExecute()
+}
+
+// Fetch an immediate operand and execute the instruction.
+func (cpu *CPU) tickImmediate() {
+	cpu.tickFetch()
+	cpu.tickExecute()
+}
+
+// Fetch the next operand byte.
+func (cpu *CPU) tickFetch() {
+	cpu.tick.operand[cpu.tick.n] = cpu.fetch(cpu.Reg.PC)
+	cpu.tick.n++
+	cpu.Reg.PC++
+}
+
+// Fetch a zero page address.
+func (cpu *CPU) tickFetchZeroPage() {
+	cpu.tickFetch()
+	cpu.tick.base = uint16(cpu.tick.operand[0])
+	cpu.tick.addr = cpu.tick.base
+}
+
+// Fetch the high byte of an absolute address.
+func (cpu *CPU) tickFetchAbsolute() {
+	cpu.tickFetch()
+	cpu.tick.base = operandToAddress(cpu.tick.operand[:2])
+	cpu.tick.addr = cpu.tick.base
+	cpu.tickQueueData()
+}
+
+// Fetch the high byte of an absolute address and index it.
+func (cpu *CPU) tickFetchIndexed() {
+	cpu.tickFetch()
+	cpu.tick.base = operandToAddress(cpu.tick.operand[:2])
+	cpu.tickIndex()
+}
+
+// Index the unindexed effective address by the X or Y register. Reads
+// take an extra cycle only when a page boundary is crossed, but writes
+// always take it.
+func (cpu *CPU) tickIndex() {
+	index := cpu.Reg.X
+	if cpu.tick.inst.Mode == ABY || cpu.tick.inst.Mode == IDY {
+		index = cpu.Reg.Y
+	}
+	cpu.tick.addr, cpu.tick.crossed = offsetAddress(cpu.tick.base, index)
+
+	switch {
+	case cpu.tick.crossed:
+	case cpu.tick.inst.access == accessWrite:
+	case cpu.tick.inst.access == accessModify && cpu.Arch.base() == NMOS:
+	default:
+		cpu.tickQueueData()
+		return
+	}
+	cpu.tickQueue((*CPU).tickDummyIndexed)
+	cpu.tickQueueData()
+}
+
+// Perform the dummy read of an indexed address. The NMOS 6502 reads the
+// address before the carry into the high byte is fixed. The 65c02 reads
+// the last operand byte instead.
+func (cpu *CPU) tickDummyIndexed() {
+	if cpu.Arch.base() == CMOS {
+		cpu.fetch(cpu.Reg.PC - 1)
+		return
+	}
+	cpu.read(cpu.tick.base&0xff00 | cpu.tick.addr&0x00ff)
+}
+
+// Index a zero page address. The NMOS 6502 reads the unindexed address
+// while adding the index register. The 65c02 reads the operand byte.
+func (cpu *CPU) tickIndexZeroPage() {
+	if cpu.Arch.base() == CMOS {
+		cpu.fetch(cpu.Reg.PC - 1)
+	} else {
+		cpu.read(cpu.tick.base)
+	}
+
+	index := cpu.Reg.X
+	if cpu.tick.inst.Mode == ZPY {
+		index = cpu.Reg.Y
+	}
+	cpu.tick.addr = offsetZeroPage(cpu.tick.base, index)
+	if cpu.tick.inst.Mode != IDX {
+		cpu.tickQueueData()
+	}
+}
+
+// Read the low byte of a zero page pointer.
+func (cpu *CPU) tickPointerLo() {
+	cpu.tick.data = cpu.read(cpu.tick.addr)
+}
+
+// Read the high byte of a zero page pointer.
+func (cpu *CPU) tickPointerHi() {
+	hi := cpu.read(offsetZeroPage(cpu.tick.addr, 1))
+	cpu.tick.base = uint16(hi)<<8 | uint16(cpu.tick.data)
+	cpu.tick.addr = cpu.tick.base
+	cpu.tickQueueData()
+}
+
+// Read the high byte of a zero page pointer and index it.
+func (cpu *CPU) tickPointerHiIndexed() {
+	hi := cpu.read(offsetZeroPage(cpu.tick.addr, 1))
+	cpu.tick.base = uint16(hi)<<8 | uint16(cpu.tick.data)
+	cpu.tickIndex()
+}
+
+// Read the operand from the effective address.
+func (cpu *CPU) tickLoad() {
+	cpu.tick.data = cpu.read(cpu.tick.addr)
+}
+
+// Read the operand from the effective address and execute the
+// instruction.
+func (cpu *CPU) tickRead() {
+	cpu.tickLoad()
+	cpu.tickExecute()
+}
+
+// Perform the middle cycle of a read-modify-write instruction. The NMOS
+// 6502 writes the unmodified value back. The 65c02 reads it again.
+func (cpu *CPU) tickModify() {
+	if cpu.Arch.base() == CMOS {
+		cpu.read(cpu.tick.addr)
+		return
+	}
+	cpu.storeByte(cpu, cpu.tick.addr, cpu.tick.data)
+}
+
+// Execute the instruction and write its result to the effective address.
+func (cpu *CPU) tickWrite() {
+	cpu.tickExecute()
+	cpu.tickWriteResult()
+}
+
+// Write the instruction's result to the effective address.
+func (cpu *CPU) tickWriteResult() {
+	cpu.storeByte(cpu, cpu.tick.addr, cpu.tick.result)
+}
+
+// Pull a register from the stack and execute the instruction.
+func (cpu *CPU) tickPull() {
+	cpu.tick.data = cpu.read(stackAddress(cpu.Reg.SP + 1))
+	cpu.tickExecute()
+}
+
+// Fetch a branch offset and execute the branch instruction. A taken
+// branch reads the next opcode and discards it, and a branch to another
+// page also reads from the target address before its high byte is fixed.
+func (cpu *CPU) tickBranch() {
+	cpu.tickFetch()
+	pc := cpu.Reg.PC
+	cpu.tickRun()
+
+	switch cpu.deltaCycles {
+	case 2:
+		cpu.tick.base = pc
+		cpu.tick.addr = pc&0xff00 | cpu.Reg.PC&0x00ff
+		cpu.tickQueue((*CPU).tickDummyBranch, (*CPU).tickDummyRead)
+	case 1:
+		cpu.tick.base = pc
+		cpu.tickQueue((*CPU).tickDummyBranch)
+	}
+}
+
+// Read the opcode following a taken branch and discard it.
+func (cpu *CPU) tickDummyBranch() {
+	cpu.read(cpu.tick.base)
+}
+
+// Fetch the high byte of the target address of a jump.
+func (cpu *CPU) tickJumpAbsolute() {
+	cpu.tickFetch()
+	cpu.Reg.PC = operandToAddress(cpu.tick.operand[:2])
+}
+
+// Fetch the high byte of a jump's indirect address. The 65c02 takes an
+// extra cycle to fix the NMOS bug when the address ends in $FF.
+func (cpu *CPU) tickFetchIndirect() {
+	cpu.tickFetch()
+	cpu.tick.addr = operandToAddress(cpu.tick.operand[:2])
+	if cpu.Arch.base() == CMOS && cpu.tick.operand[0] == 0xff {
+		cpu.tickQueue((*CPU).tickDummyOperand)
+	}
+	cpu.tickQueue((*CPU).tickPointerLo, (*CPU).tickJumpIndirect)
+}
+
+// Fetch the high byte of a jump's indexed indirect address.
+func (cpu *CPU) tickFetchIndirectX() {
+	cpu.tickFetch()
+	cpu.tick.addr = operandToAddress(cpu.tick.operand[:2]) + uint16(cpu.Reg.X)
+	cpu.tickQueue((*CPU).tickDummyOperand, (*CPU).tickPointerLo, (*CPU).tickJumpIndirect)
+}
+
+// Read the last operand byte and discard it.
+func (cpu *CPU) tickDummyOperand() {
+	cpu.fetch(cpu.Reg.PC - 1)
+}
+
+// Read the high byte of a jump's target address from memory. On the NMOS
+// 6502 the high byte's address wraps within the page.
+func (cpu *CPU) tickJumpIndirect() {
+	addr := cpu.tick.addr + 1
+	if cpu.Arch.base() == NMOS {
+		addr = cpu.tick.addr&0xff00 | addr&0x00ff
+	}
+	hi := cpu.read(addr)
+	cpu.Reg.PC = uint16(hi)<<8 | uint16(cpu.tick.data)
+}
+
+// Fetch the high byte of a subroutine address and jump to it.
+func (cpu *CPU) tickCall() {
+	hi := cpu.fetch(cpu.Reg.PC)
+	cpu.Reg.PC = uint16(hi)<<8 | uint16(cpu.tick.operand[0])
+}
+
+// Read the byte at the return address and step past it.
+func (cpu *CPU) tickReturn() {
+	cpu.fetch(cpu.Reg.PC)
+	cpu.Reg.PC++
+}
+
+// Read the padding byte following a BRK opcode and step past it.
+func (cpu *CPU) tickBreak() {
+	cpu.fetch(cpu.Reg.PC)
+	cpu.Reg.PC++
+}
+
+// Push the high byte of the program counter.
+func (cpu *CPU) tickPushPCH() {
+	cpu.tickPush(byte(cpu.Reg.PC >> 8))
+}
+
+// Push the low byte of the program counter.
+func (cpu *CPU) tickPushPCL() {
+	cpu.tickPush(byte(cpu.Reg.PC))
+}
+
+// Push the processor status for an interrupt or BRK instruction, and
+// disable interrupts. The break bit is set only by BRK.
+func (cpu *CPU) tickPushPS() {
+	cpu.tickPush(cpu.Reg.SavePS(cpu.tick.inst != nil))
+	cpu.Reg.InterruptDisable = true
+	cpu.irqDelayed = false
+	if cpu.Arch.base() == CMOS {
+		cpu.Reg.Decimal = false
+	}
+}
+
+// Read the low byte of the interrupt vector.
+func (cpu *CPU) tickVectorLo() {
+	cpu.tick.data = cpu.read(cpu.tick.vector)
+}
+
+// Read the high byte of the interrupt vector and jump to the handler.
+func (cpu *CPU) tickVectorHi() {
+	hi := cpu.read(cpu.tick.vector + 1)
+	cpu.Reg.PC = uint16(hi)<<8 | uint16(cpu.tick.data)
+}
+
+// Write a byte to the stack.
+func (cpu *CPU) tickPush(v byte) {
+	cpu.storeByte(cpu, stackAddress(cpu.Reg.SP), v)
+	cpu.Reg.SP--
+}
+
+// Pull the processor status from the stack.
+func (cpu *CPU) tickPullPS() {
+	cpu.Reg.SP++
+	cpu.Reg.RestorePS(cpu.read(stackAddress(cpu.Reg.SP)))
+}
+
+// Pull the low byte of the program counter from the stack.
+func (cpu *CPU) tickPullPCL() {
+	cpu.Reg.SP++
+	cpu.tick.data = cpu.read(stackAddress(cpu.Reg.SP))
+}
+
+// Pull the high byte of the program counter from the stack.
+func (cpu *CPU) tickPullPCH() {
+	cpu.Reg.SP++
+	hi := cpu.read(stackAddress(cpu.Reg.SP))
+	cpu.Reg.PC = uint16(hi)<<8 | uint16(cpu.tick.data)
+}
@@ -0,0 +1,194 @@
+// Copyright 2014-2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cpu
+
+// The Tracer interface may be implemented to observe each instruction the
+// CPU executes. Tracers may be used to build trace logs, profilers and
+// coverage tools. Interrupt sequences are not traced, but a Tracer may
+// implement InterruptTracer to be notified of them.
+type Tracer interface {
+	// BeforeInstruction is called when an instruction is about to execute.
+	BeforeInstruction(cpu *CPU, t *Trace)
+
+	// AfterInstruction is called after the instruction has executed. The
+	// CPU's registers hold the instruction's results.
+	AfterInstruction(cpu *CPU, t *Trace)
+}
+
+// The InterruptTracer interface may be implemented by a Tracer that wishes
+// to be notified when the CPU services an IRQ or NMI.
+type InterruptTracer interface {
+	// OnInterrupt is called after the interrupt sequence has pushed the
+	// return address and jumped to the handler. The vector is $FFFA for an
+	// NMI and $FFFE for an IRQ. The CPU's LastPC holds the address the
+	// handler returns to.
+	OnInterrupt(cpu *CPU, vector uint16)
+}
+
+// A Trace describes an instruction executed by the CPU. The same Trace is
+// passed to BeforeInstruction and AfterInstruction, and it is reused for
+// the next instruction, so a Tracer must copy any data it keeps.
+type Trace struct {
+	PC      uint16       // address of the instruction
+	Bank    int          // bank containing the instruction, if memory is Banked
+	Inst    *Instruction // the instruction, including its opcode
+	Operand []byte       // operand bytes
+	Reg     Registers    // registers before the instruction executed
+
+	// Address is the effective address of the instruction's memory
+	// operand, or the target address of a jump, call or branch. HasAddress
+	// is false if the instruction has no such address, or if computing it
+	// requires a pointer that can't be peeked, such as one in a device on a
+	// Bus. Effective addresses are not computed for the 65c816.
+	Address    uint16
+	HasAddress bool
+
+	// Cycles is the number of cycles the instruction took. It is set
+	// before AfterInstruction is called.
+	Cycles uint64
+
+	buf   [3]byte // storage for the operand
+	start uint64  // CPU cycle count when the instruction started
+}
+
+// The MemoryHook interface may be implemented to observe every byte the
+// CPU reads from or writes to memory, including opcode and operand fetches,
+// stack accesses and interrupt vectors. On the 65c816, only accesses to
+// bank 0 are observed.
+type MemoryHook interface {
+	// OnRead is called after a byte is read from memory.
+	OnRead(cpu *CPU, addr uint16, v byte)
+
+	// OnWrite is called after a byte is written to memory.
+	OnWrite(cpu *CPU, addr uint16, v byte)
+}
+
+// AttachTracer attaches a tracer to the CPU. Any number of tracers may be
+// attached; they are called in the order they were attached.
+func (cpu *CPU) AttachTracer(t Tracer) {
+	cpu.tracers = append(cpu.tracers, t)
+}
+
+// DetachTracer detaches a tracer from the CPU.
+func (cpu *CPU) DetachTracer(t Tracer) {
+	for i, tt := range cpu.tracers {
+		if tt == t {
+			cpu.tracers = append(cpu.tracers[:i:i], cpu.tracers[i+1:]...)
+			break
+		}
+	}
+	if len(cpu.tracers) == 0 {
+		cpu.tracers = nil
+	}
+}
+
+// AttachMemoryHook attaches a memory hook to the CPU. Any number of hooks
+// may be attached; they are called in the order they were attached.
+func (cpu *CPU) AttachMemoryHook(h MemoryHook) {
+	cpu.memHooks = append(cpu.memHooks, h)
+	cpu.selectStoreByte()
+}
+
+// DetachMemoryHook detaches a memory hook from the CPU.
+func (cpu *CPU) DetachMemoryHook(h MemoryHook) {
+	for i, hh := range cpu.memHooks {
+		if hh == h {
+			cpu.memHooks = append(cpu.memHooks[:i:i], cpu.memHooks[i+1:]...)
+			break
+		}
+	}
+	if len(cpu.memHooks) == 0 {
+		cpu.memHooks = nil
+	}
+	cpu.selectStoreByte()
+}
+
+// Notify the memory hooks of the operand bytes fetched from 'addr'.
+func (cpu *CPU) onFetch(addr uint16, operand []byte) {
+	for i, v := range operand {
+		for _, h := range cpu.memHooks {
+			h.OnRead(cpu, addr+uint16(i), v)
+		}
+	}
+}
+
+// Notify the tracers that an instruction is about to execute. The program
+// counter must still address the instruction.
+func (cpu *CPU) traceBefore(inst *Instruction, operand []byte) {
+	t := &cpu.trace
+	t.PC = cpu.Reg.PC
+	t.Bank = cpu.bank(t.PC)
+	t.Inst = inst
+	t.Operand = t.buf[:copy(t.buf[:], operand)]
+	t.Reg = cpu.Reg
+	t.Address, t.HasAddress = 0, false
+	if cpu.Arch != W65C816 {
+		t.Address, t.HasAddress = cpu.effectiveAddress(inst, operand)
+	}
+	t.Cycles = 0
+	t.start = cpu.Cycles
+	for _, tr := range cpu.tracers {
+		tr.BeforeInstruction(cpu, t)
+	}
+}
+
+// Notify the tracers that an instruction has executed.
+func (cpu *CPU) traceAfter() {
+	t := &cpu.trace
+	t.Cycles = cpu.Cycles - t.start
+	for _, tr := range cpu.tracers {
+		tr.AfterInstruction(cpu, t)
+	}
+}
+
+// Notify the tracers that an interrupt sequence has completed.
+func (cpu *CPU) traceInterrupt(vector uint16) {
+	for _, tr := range cpu.tracers {
+		if it, ok := tr.(InterruptTracer); ok {
+			it.OnInterrupt(cpu, vector)
+		}
+	}
+}
+
+// Return the effective address of an instruction's operand before the
+// instruction executes. Any pointer the instruction uses is peeked, so the
+// memory and the memory hooks don't see an access.
+func (cpu *CPU) effectiveAddress(inst *Instruction, operand []byte) (uint16, bool) {
+	switch inst.Mode {
+	case ZPG, ABS:
+		return operandToAddress(operand), true
+	case ZPR:
+		return uint16(operand[0]), true
+	case ZPX:
+		return offsetZeroPage(operandToAddress(operand), cpu.Reg.X), true
+	case ZPY:
+		return offsetZeroPage(operandToAddress(operand), cpu.Reg.Y), true
+	case ABX:
+		addr := operandToAddress(operand) + uint16(cpu.Reg.X)
+		if inst.access == accessJump {
+			return peekAddress(cpu.Mem, addr, false)
+		}
+		return addr, true
+	case ABY:
+		return operandToAddress(operand) + uint16(cpu.Reg.Y), true
+	case IDX:
+		return peekAddress(cpu.Mem, offsetZeroPage(operandToAddress(operand), cpu.Reg.X), true)
+	case IDY:
+		addr, ok := peekAddress(cpu.Mem, operandToAddress(operand), true)
+		if !ok {
+			return 0, false
+		}
+		return addr + uint16(cpu.Reg.Y), true
+	case IND:
+		// The CMOS JMP doesn't wrap the pointer at the end of a page.
+		wrap := inst.access != accessJump || cpu.Arch.base() != CMOS
+		return peekAddress(cpu.Mem, operandToAddress(operand), wrap)
+	case REL:
+		next := cpu.Reg.PC + uint16(inst.Length)
+		return next + uint16(int8(operand[0])), true
+	default:
+		return 0, false
+	}
+}
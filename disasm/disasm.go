@@ -14,19 +14,28 @@ import (
 
 // Disassembler formatting for addressing modes
 var modeFormat = []string{
-	"#$%s",    // IMM
-	"%s",      // IMP
-	"$%s",     // REL
-	"$%s",     // ZPG
-	"$%s,X",   // ZPX
-	"$%s,Y",   // ZPY
-	"$%s",     // ABS
-	"$%s,X",   // ABX
-	"$%s,Y",   // ABY
-	"($%s)",   // IND
-	"($%s,X)", // IDX
-	"($%s),Y", // IDY
-	"%s",      // ACC
+	"#$%s",      // IMM
+	"%s",        // IMP
+	"$%s",       // REL
+	"$%s",       // ZPG
+	"$%s,X",     // ZPX
+	"$%s,Y",     // ZPY
+	"$%s",       // ABS
+	"$%s,X",     // ABX
+	"$%s,Y",     // ABY
+	"($%s)",     // IND
+	"($%s,X)",   // IDX
+	"($%s),Y",   // IDY
+	"%s",        // ACC
+	"$%s,$%s",   // ZPR
+	"$%s",       // ABL
+	"$%s,X",     // ALX
+	"[$%s]",     // ILN
+	"[$%s],Y",   // ILY
+	"$%s,S",     // SRL
+	"($%s,S),Y", // SRY
+	"$%s",       // RLL
+	"$%s,$%s",   // BLK
 }
 
 var hex = "0123456789ABCDEF"
@@ -48,24 +57,99 @@ func hexString(b []byte) string {
 // 'line' string representing the disassembled instruction and a 'next'
 // address that starts the following line of machine code.
 func Disassemble(m cpu.Memory, addr uint16) (line string, next uint16) {
-	opcode := m.LoadByte(addr)
-	set := cpu.GetInstructionSet(cpu.CMOS)
+	return disassemble(m.LoadByte, addr, cpu.CMOS, false, false)
+}
+
+// DisassembleArch disassembles the machine code in memory 'm' at address
+// 'addr' using the instruction set of CPU architecture 'arch'. Return a
+// 'line' string representing the disassembled instruction and a 'next'
+// address that starts the following line of machine code.
+func DisassembleArch(m cpu.Memory, addr uint16, arch cpu.Architecture) (line string, next uint16) {
+	return disassemble(m.LoadByte, addr, arch, false, false)
+}
+
+// Disassemble65816 disassembles the 65c816 machine code in memory 'm' at
+// address 'addr'. The 'm16' and 'x16' flags indicate whether the
+// accumulator and index registers are 16 bits wide, which determines the
+// length of immediate operands. Return a 'line' string representing the
+// disassembled instruction and a 'next' address that starts the following
+// line of machine code.
+func Disassemble65816(m cpu.Memory, addr uint16, m16, x16 bool) (line string, next uint16) {
+	return disassemble(m.LoadByte, addr, cpu.W65C816, m16, x16)
+}
+
+// Disassemble65816Long disassembles the 65c816 machine code in memory 'm'
+// at the 24-bit address 'addr', such as the program bank register combined
+// with the program counter. The instruction's bytes are read from the same
+// bank, wrapping within it as the program counter does. Return a 'line'
+// string representing the disassembled instruction and the 24-bit 'next'
+// address that starts the following line of machine code.
+func Disassemble65816Long(m cpu.LongMemory, addr uint32, m16, x16 bool) (line string, next uint32) {
+	bank := addr & 0xff0000
+	load := func(a uint16) byte {
+		return m.LoadByteLong(bank | uint32(a))
+	}
+	line, pc := disassemble(load, uint16(addr), cpu.W65C816, m16, x16)
+	return line, bank | uint32(pc)
+}
+
+// DisassembleCode disassembles the instruction whose machine code 'code'
+// was located at address 'addr', using the instruction set of CPU
+// architecture 'arch'. The 'm16' and 'x16' flags are used as they are by
+// Disassemble65816. Missing operand bytes are disassembled as zero. Return
+// a 'line' string representing the disassembled instruction.
+func DisassembleCode(code []byte, addr uint16, arch cpu.Architecture, m16, x16 bool) (line string) {
+	load := func(a uint16) byte {
+		if i := int(a - addr); i < len(code) {
+			return code[i]
+		}
+		return 0
+	}
+	line, _ = disassemble(load, addr, arch, m16, x16)
+	return line
+}
+
+func disassemble(load func(addr uint16) byte, addr uint16, arch cpu.Architecture, m16, x16 bool) (line string, next uint16) {
+	opcode := load(addr)
+	set := cpu.GetInstructionSet(arch)
 	inst := set.Lookup(opcode)
+	length := inst.LengthFor(m16, x16)
 
-	var buf [2]byte
-	operand := buf[:inst.Length-1]
-	m.LoadBytes(addr+1, operand)
+	var buf [3]byte
+	operand := buf[:length-1]
+	for i := range operand {
+		operand[i] = load(addr + 1 + uint16(i))
+	}
 
-	if inst.Mode == cpu.REL {
+	format := "%s   " + modeFormat[inst.Mode]
+	switch inst.Mode {
+	case cpu.REL:
 		// Convert relative offset to absolute address.
-		operand = buf[:]
+		operand = buf[:2]
 		braddr := int(addr) + int(inst.Length) + byteToInt(operand[0])
 		operand[0] = byte(braddr)
 		operand[1] = byte(braddr >> 8)
+		line = fmt.Sprintf(format, inst.Name, hexString(operand))
+	case cpu.ZPR:
+		// Convert the relative offset following the zero page address to
+		// an absolute address.
+		braddr := int(addr) + int(inst.Length) + byteToInt(operand[1])
+		target := []byte{byte(braddr), byte(braddr >> 8)}
+		line = fmt.Sprintf(format, inst.Name, hexString(operand[:1]), hexString(target))
+	case cpu.RLL:
+		// Convert the 16-bit relative offset to an absolute address.
+		offset := int16(uint16(operand[0]) | uint16(operand[1])<<8)
+		braddr := int(addr) + int(inst.Length) + int(offset)
+		target := []byte{byte(braddr), byte(braddr >> 8)}
+		line = fmt.Sprintf(format, inst.Name, hexString(target))
+	case cpu.BLK:
+		// The destination bank is encoded first, but the source bank is
+		// written first.
+		line = fmt.Sprintf(format, inst.Name, hexString(operand[1:]), hexString(operand[:1]))
+	default:
+		line = fmt.Sprintf(format, inst.Name, hexString(operand))
 	}
-	format := "%s   " + modeFormat[inst.Mode]
-	line = fmt.Sprintf(format, inst.Name, hexString(operand))
-	next = addr + uint16(inst.Length)
+	next = addr + uint16(length)
 	return line, next
 }
 
@@ -82,6 +166,12 @@ func GetCompactRegisterString(r *cpu.Registers) string {
 	return fmt.Sprintf("A=%02X X=%02X Y=%02X PS=[%s]", r.A, r.X, r.Y, getStatusBits(r))
 }
 
+// GetStatusString returns a string describing the 6502 status flags. Each
+// flag that is set is shown by its letter, and each clear flag by a dash.
+func GetStatusString(r *cpu.Registers) string {
+	return getStatusBits(r)
+}
+
 func getStatusBits(r *cpu.Registers) string {
 	v := func(bit bool, ch byte) byte {
 		if bit {
@@ -4,6 +4,11 @@ import "github.com/beevik/cmd"
 
 var cmds *cmd.Tree
 
+// Commands that run or rewind the CPU, or that read further commands. They
+// can't be breakpoint actions, because actions execute while the CPU is
+// stopped in the middle of a run.
+var runCmds = make(map[*cmd.Command]bool)
+
 func init() {
 	root := cmd.NewTree("go6502")
 	root.AddCommand(cmd.Command{
@@ -66,32 +71,36 @@ func init() {
 		Subtree: bp,
 	})
 	bp.AddCommand(cmd.Command{
-		Name:        "list",
-		Brief:       "List breakpoints",
-		Description: "List all current breakpoints.",
-		Usage:       "breakpoint list",
-		Data:        (*Host).cmdBreakpointList,
+		Name:  "list",
+		Brief: "List breakpoints",
+		Description: "List all current breakpoints, along with their" +
+			" conditions and the number of times each has been hit.",
+		Usage: "breakpoint list",
+		Data:  (*Host).cmdBreakpointList,
 	})
 	bp.AddCommand(cmd.Command{
 		Name:  "add",
 		Brief: "Add a breakpoint",
 		Description: "Add a breakpoint at the specified address." +
-			" The breakpoints starts enabled.",
-		Usage: "breakpoint add <address>",
+			" The breakpoints starts enabled. If the system has banked" +
+			" memory, the breakpoint is hit only while the specified bank" +
+			" is mapped at the address. The bank defaults to the one" +
+			" currently mapped.",
+		Usage: "breakpoint add <address> [<bank>]",
 		Data:  (*Host).cmdBreakpointAdd,
 	})
 	bp.AddCommand(cmd.Command{
 		Name:        "remove",
 		Brief:       "Remove a breakpoint",
 		Description: "Remove a breakpoint at the specified address.",
-		Usage:       "breakpoint remove <address>",
+		Usage:       "breakpoint remove <address> [<bank>]",
 		Data:        (*Host).cmdBreakpointRemove,
 	})
 	bp.AddCommand(cmd.Command{
 		Name:        "enable",
 		Brief:       "Enable a breakpoint",
 		Description: "Enable a previously added breakpoint.",
-		Usage:       "breakpoint enable <address>",
+		Usage:       "breakpoint enable <address> [<bank>]",
 		Data:        (*Host).cmdBreakpointEnable,
 	})
 	bp.AddCommand(cmd.Command{
@@ -100,9 +109,59 @@ func init() {
 		Description: "Disable a previously added breakpoint. This" +
 			" prevents the breakpoint from being hit when running the" +
 			" CPU",
-		Usage: "breakpoint disable <address>",
+		Usage: "breakpoint disable <address> [<bank>]",
 		Data:  (*Host).cmdBreakpointDisable,
 	})
+	bp.AddCommand(cmd.Command{
+		Name:  "condition",
+		Brief: "Set a breakpoint's condition",
+		Description: "Make a breakpoint conditional on an expression. Each" +
+			" time the program counter reaches the breakpoint's address," +
+			" the expression is evaluated, and the breakpoint is hit only" +
+			" if the result is non-zero. Expressions may use the comparison" +
+			" operators ==, !=, <, <=, > and >=, the logical operators &&," +
+			" || and !, and [address] to read a byte of memory. For example," +
+			" 'x == $10 && [$02] > 3'. Omit the expression to make the" +
+			" breakpoint unconditional. The expression is parsed once," +
+			" when the condition is set. The bank defaults to the one" +
+			" currently mapped at the address.",
+		Usage: "breakpoint condition <address> [bank <bank>] [<expression>]",
+		Data:  (*Host).cmdBreakpointCondition,
+	})
+	bp.AddCommand(cmd.Command{
+		Name:  "action",
+		Brief: "Add an action to a breakpoint",
+		Description: "Add a command to the list of commands executed when" +
+			" a breakpoint is hit, such as 'memory dump $02 4' or" +
+			" 'register'. Commands execute in the order they were added." +
+			" Commands that run, step or reset the CPU can't be actions." +
+			" Omit the command to clear the breakpoint's actions. The bank" +
+			" defaults to the one currently mapped at the address.",
+		Usage: "breakpoint action <address> [bank <bank>] [<command>]",
+		Data:  (*Host).cmdBreakpointAction,
+	})
+	bp.AddCommand(cmd.Command{
+		Name:  "continue",
+		Brief: "Continue running after a breakpoint's actions",
+		Description: "Make a breakpoint continue running the CPU after" +
+			" executing its actions instead of stopping it. This turns the" +
+			" breakpoint into a logpoint, which may be used to instrument" +
+			" a program over a long run. Use 'off' to make the breakpoint" +
+			" stop the CPU again. The bank defaults to the one currently" +
+			" mapped at the address.",
+		Usage: "breakpoint continue <address> [on|off] [<bank>]",
+		Data:  (*Host).cmdBreakpointContinue,
+	})
+	bp.AddCommand(cmd.Command{
+		Name:  "ignore",
+		Brief: "Ignore a breakpoint's next hits",
+		Description: "Ignore the next <count> hits of a breakpoint. Ignored" +
+			" hits are counted but don't stop the CPU. Hits of a" +
+			" conditional breakpoint are counted only when its condition" +
+			" is true.",
+		Usage: "breakpoint ignore <address> <count> [<bank>]",
+		Data:  (*Host).cmdBreakpointIgnore,
+	})
 
 	// Data breakpoint commands
 	dbp := cmd.NewTree("Data breakpoint")
@@ -152,7 +211,110 @@ func init() {
 		Usage:       "databreakpoint disable <address>",
 		Data:        (*Host).cmdDataBreakpointDisable,
 	})
+	dbp.AddCommand(cmd.Command{
+		Name:  "watch",
+		Brief: "Add a watchpoint",
+		Description: "Add a watchpoint on a range of memory addresses. When" +
+			" the CPU reads (r), writes (w) or reads or writes (rw, the" +
+			" default) data within the range, the watchpoint will stop the" +
+			" CPU. The range is a single address if no end address is" +
+			" specified. Conditions may restrict the watchpoint to values" +
+			" matching new=<value>, to writes replacing a value matching" +
+			" old=<value>, or to writes that change the value (changed)." +
+			" Values are compared after being ANDed with mask=<value>.",
+		Usage: "databreakpoint watch <start> [<end>] [r|w|rw] [mask=<value>]" +
+			" [old=<value>] [new=<value>] [changed]",
+		Data: (*Host).cmdDataBreakpointWatch,
+	})
+	dbp.AddCommand(cmd.Command{
+		Name:        "unwatch",
+		Brief:       "Remove a watchpoint",
+		Description: "Remove a previously added watchpoint by number.",
+		Usage:       "databreakpoint unwatch <number>",
+		Data:        (*Host).cmdDataBreakpointUnwatch,
+	})
+
+	// Code check commands
+	cc := cmd.NewTree("Code check")
+	root.AddCommand(cmd.Command{
+		Name:    "codecheck",
+		Brief:   "Code check commands",
+		Subtree: cc,
+	})
+	cc.AddCommand(cmd.Command{
+		Name:  "list",
+		Brief: "List instruction and data ranges",
+		Description: "List the ranges of addresses known to hold" +
+			" instructions or data, along with the action taken for" +
+			" faults in each range. Ranges are taken from the source maps" +
+			" of loaded files and from the interactive assembler. Turn on" +
+			" the CodeCheck setting to stop the CPU when the program writes" +
+			" to an instruction byte or when the program counter enters a" +
+			" data range.",
+		Usage: "codecheck list",
+		Data:  (*Host).cmdCodeCheckList,
+	})
+	cc.AddCommand(cmd.Command{
+		Name:  "action",
+		Brief: "Set the action for code faults in a range",
+		Description: "Set the action taken when the program writes to" +
+			" instructions or enters data within a range of addresses." +
+			" The action may be 'stop' (the default), 'report' to display" +
+			" the fault without stopping, or 'ignore' to allow it, as for" +
+			" intentionally self-modifying code.",
+		Usage: "codecheck action <start> <end> stop|report|ignore",
+		Data:  (*Host).cmdCodeCheckAction,
+	})
+
+	// Coverage commands
+	cov := cmd.NewTree("Coverage")
+	root.AddCommand(cmd.Command{
+		Name:    "coverage",
+		Brief:   "Code coverage commands",
+		Subtree: cov,
+	})
+	cov.AddCommand(cmd.Command{
+		Name:  "report",
+		Brief: "Display an annotated coverage report",
+		Description: "Display the source code of loaded files annotated" +
+			" with the number of times each line executed, along with the" +
+			" number of times each conditional branch was taken and not" +
+			" taken. Lines that never executed are marked with #####." +
+			" Turn on the Coverage setting to collect coverage while the" +
+			" CPU runs. If a filename is specified, the report is written" +
+			" to the file instead.",
+		Usage: "coverage report [<filename>]",
+		Data:  (*Host).cmdCoverageReport,
+	})
+	cov.AddCommand(cmd.Command{
+		Name:  "lcov",
+		Brief: "Write an lcov tracefile",
+		Description: "Write the line and branch coverage of loaded files to" +
+			" a tracefile in the lcov format, which may be displayed by" +
+			" genhtml and other coverage tools.",
+		Usage: "coverage lcov <filename>",
+		Data:  (*Host).cmdCoverageLcov,
+	})
+	cov.AddCommand(cmd.Command{
+		Name:        "clear",
+		Brief:       "Clear coverage counts",
+		Description: "Reset all collected coverage counts to zero.",
+		Usage:       "coverage clear",
+		Data:        (*Host).cmdCoverageClear,
+	})
 
+	root.AddCommand(cmd.Command{
+		Name:  "backtrace",
+		Brief: "Display the call stack",
+		Description: "Display the subroutine calls and interrupts in" +
+			" progress, starting with the current instruction. Each frame" +
+			" shows the address of the call along with its nearest exported" +
+			" label and source line. A warning is displayed if the program" +
+			" has manipulated the stack in a way that broke the pairing of" +
+			" calls and returns.",
+		Usage: "backtrace",
+		Data:  (*Host).cmdBacktrace,
+	})
 	root.AddCommand(cmd.Command{
 		Name:  "disassemble",
 		Brief: "Disassemble code",
@@ -187,6 +349,15 @@ func init() {
 		Usage: "exports",
 		Data:  (*Host).cmdExports,
 	})
+	root.AddCommand(cmd.Command{
+		Name:  "history",
+		Brief: "Display the execution history",
+		Description: "Display the most recently executed instructions, along" +
+			" with the registers and cycle count before each one executed." +
+			" Each instruction is numbered by how many steps back it is.",
+		Usage: "history [<count>]",
+		Data:  (*Host).cmdHistory,
+	})
 	root.AddCommand(cmd.Command{
 		Name:  "list",
 		Brief: "List source code lines",
@@ -244,6 +415,55 @@ func init() {
 		Data:  (*Host).cmdMemoryCopy,
 	})
 
+	root.AddCommand(cmd.Command{
+		Name:  "power",
+		Brief: "Simulate a power cycle",
+		Description: "Simulate turning the emulated system off and on. RAM is" +
+			" filled according to the PowerOnFill setting, which may be" +
+			" \"zero\", \"random\" or a pattern of hexadecimal bytes such as" +
+			" \"FF00\". Random values are generated from the PowerOnSeed" +
+			" setting, or from a new seed if it is 0. All memory becomes" +
+			" uninitialized for the UninitCheck setting, the execution" +
+			" history and call stack are cleared, and the CPU is reset.",
+		Usage: "power",
+		Data:  (*Host).cmdPower,
+	})
+	// Profile commands
+	prof := cmd.NewTree("Profile")
+	root.AddCommand(cmd.Command{
+		Name:    "profile",
+		Brief:   "Cycle profiler commands",
+		Subtree: prof,
+	})
+	prof.AddCommand(cmd.Command{
+		Name:  "top",
+		Brief: "Display the subroutines that took the most cycles",
+		Description: "Display the subroutines that took the most CPU" +
+			" cycles, along with the cycles spent in each one's own" +
+			" instructions (self) and including the subroutines it called" +
+			" (cum). Subroutines are named by their exported labels. Turn" +
+			" on the Profile setting to collect a profile while the CPU" +
+			" runs.",
+		Usage: "profile top [<count>]",
+		Data:  (*Host).cmdProfileTop,
+	})
+	prof.AddCommand(cmd.Command{
+		Name:  "write",
+		Brief: "Write a pprof profile",
+		Description: "Write the profile to a file in the pprof format. Use" +
+			" 'go tool pprof' to display top lists, call graphs and flame" +
+			" graphs of the profile.",
+		Usage: "profile write <filename>",
+		Data:  (*Host).cmdProfileWrite,
+	})
+	prof.AddCommand(cmd.Command{
+		Name:        "clear",
+		Brief:       "Clear the profile",
+		Description: "Discard all collected profile data.",
+		Usage:       "profile clear",
+		Data:        (*Host).cmdProfileClear,
+	})
+
 	root.AddCommand(cmd.Command{
 		Name:        "quit",
 		Brief:       "Quit the program",
@@ -267,8 +487,11 @@ func init() {
 		Name:  "run",
 		Brief: "Run the CPU",
 		Description: "Run the CPU until a breakpoint is hit or until the" +
-			" user types Ctrl-C.",
-		Usage: "run",
+			" user types Ctrl-C. If an address is specified, the CPU starts" +
+			" running there. Use 'run back' to rewind the CPU until it" +
+			" reaches a breakpoint or the beginning of the execution" +
+			" history.",
+		Usage: "run [<address>|back]",
 		Data:  (*Host).cmdRun,
 	})
 	root.AddCommand(cmd.Command{
@@ -281,6 +504,32 @@ func init() {
 		Data:  (*Host).cmdSet,
 	})
 
+	// Snapshot commands
+	snap := cmd.NewTree("Snapshot")
+	root.AddCommand(cmd.Command{
+		Name:    "snapshot",
+		Brief:   "Snapshot commands",
+		Subtree: snap,
+	})
+	snap.AddCommand(cmd.Command{
+		Name:  "save",
+		Brief: "Save a snapshot of the system",
+		Description: "Save the state of the CPU, including its registers," +
+			" cycle count and pending interrupts, together with the" +
+			" contents of memory to a snapshot file.",
+		Usage: "snapshot save <filename>",
+		Data:  (*Host).cmdSnapshotSave,
+	})
+	snap.AddCommand(cmd.Command{
+		Name:  "load",
+		Brief: "Load a snapshot of the system",
+		Description: "Restore the state of the CPU and the contents of" +
+			" memory from a snapshot file. The CPU architecture is" +
+			" restored as well.",
+		Usage: "snapshot load <filename>",
+		Data:  (*Host).cmdSnapshotLoad,
+	})
+
 	// Step commands
 	step := cmd.NewTree("Step")
 	root.AddCommand(cmd.Command{
@@ -306,6 +555,93 @@ func init() {
 		Usage: "step over [<count>]",
 		Data:  (*Host).cmdStepOver,
 	})
+	step.AddCommand(cmd.Command{
+		Name:  "out",
+		Brief: "Step out of the current subroutine",
+		Description: "Run the CPU until the current subroutine or interrupt" +
+			" handler returns, or until a breakpoint is hit.",
+		Usage: "step out",
+		Data:  (*Host).cmdStepOut,
+	})
+	step.AddCommand(cmd.Command{
+		Name:  "back",
+		Brief: "Step back to the previous instruction",
+		Description: "Rewind the CPU and memory to their state before the" +
+			" most recently executed instruction. The number of steps may" +
+			" be specified as an option. The number of instructions that" +
+			" can be rewound is set by the HistorySize setting. The state" +
+			" of memory-mapped devices is not rewound.",
+		Usage: "step back [<count>]",
+		Data:  (*Host).cmdStepBack,
+	})
+
+	// Trace commands
+	trace := cmd.NewTree("Trace")
+	root.AddCommand(cmd.Command{
+		Name:    "trace",
+		Brief:   "Trace log commands",
+		Subtree: trace,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:  "on",
+		Brief: "Start writing a trace log",
+		Description: "Write a line to a file for each instruction the CPU" +
+			" executes, showing the registers before the instruction ran." +
+			" The layout of each line is chosen by the TraceFormat setting," +
+			" which may name a format (go6502, nestest or addr) or hold a" +
+			" custom format in which values are written as {name} or" +
+			" {name:width}. Values include pc, bytes, inst, a, x, y, sp, p," +
+			" flags and cycles.",
+		Usage: "trace on <filename>",
+		Data:  (*Host).cmdTraceOn,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:        "off",
+		Brief:       "Stop writing the trace log",
+		Description: "Stop tracing and close the trace log file.",
+		Usage:       "trace off",
+		Data:        (*Host).cmdTraceOff,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:  "range",
+		Brief: "Trace only a range of addresses",
+		Description: "Trace only the instructions within a range of" +
+			" addresses. Several ranges may be added; instructions in any" +
+			" of them are traced.",
+		Usage: "trace range <start> <end>",
+		Data:  (*Host).cmdTraceRange,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:  "start",
+		Brief: "Set the address that starts tracing",
+		Description: "Wait to trace until the instruction at an address" +
+			" executes. The instruction is the first one traced.",
+		Usage: "trace start <address>",
+		Data:  (*Host).cmdTraceStart,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:  "stop",
+		Brief: "Set the address that stops tracing",
+		Description: "Stop tracing after the instruction at an address" +
+			" executes. Tracing resumes when the start address executes" +
+			" again.",
+		Usage: "trace stop <address>",
+		Data:  (*Host).cmdTraceStop,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:        "clear",
+		Brief:       "Clear the trace ranges and triggers",
+		Description: "Remove all trace address ranges and start and stop addresses.",
+		Usage:       "trace clear",
+		Data:        (*Host).cmdTraceClear,
+	})
+	trace.AddCommand(cmd.Command{
+		Name:        "list",
+		Brief:       "Display the trace log status",
+		Description: "Display the trace log file, format, ranges and triggers.",
+		Usage:       "trace list",
+		Data:        (*Host).cmdTraceList,
+	})
 
 	// Add command shortcuts.
 	root.AddShortcut("a", "assemble file")
@@ -318,6 +654,10 @@ func init() {
 	root.AddShortcut("bl", "breakpoint list")
 	root.AddShortcut("be", "breakpoint enable")
 	root.AddShortcut("bd", "breakpoint disable")
+	root.AddShortcut("bc", "breakpoint condition")
+	root.AddShortcut("bi", "breakpoint ignore")
+	root.AddShortcut("bac", "breakpoint action")
+	root.AddShortcut("bco", "breakpoint continue")
 	root.AddShortcut("d", "disassemble")
 	root.AddShortcut("db", "databreakpoint")
 	root.AddShortcut("dbp", "databreakpoint")
@@ -326,6 +666,8 @@ func init() {
 	root.AddShortcut("dbr", "databreakpoint remove")
 	root.AddShortcut("dbe", "databreakpoint enable")
 	root.AddShortcut("dbd", "databreakpoint disable")
+	root.AddShortcut("dbw", "databreakpoint watch")
+	root.AddShortcut("dbu", "databreakpoint unwatch")
 	root.AddShortcut("e", "evaluate")
 	root.AddShortcut("l", "list")
 	root.AddShortcut("m", "memory dump")
@@ -334,8 +676,19 @@ func init() {
 	root.AddShortcut("r", "register")
 	root.AddShortcut("s", "step over")
 	root.AddShortcut("si", "step in")
+	root.AddShortcut("sb", "step back")
+	root.AddShortcut("so", "step out")
+	root.AddShortcut("bt", "backtrace")
+	root.AddShortcut("h", "help")
 	root.AddShortcut("?", "help")
 	root.AddShortcut(".", "register")
 
+	for _, line := range []string{"run", "step in", "step over", "step out",
+		"step back", "power", "snapshot load", "execute",
+		"assemble interactive"} {
+		c, _ := root.Lookup(line)
+		runCmds[c.Command] = true
+	}
+
 	cmds = root
 }
@@ -0,0 +1,190 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// The coverage of a single source code line.
+type lineCoverage struct {
+	count    uint64          // times the line's instructions executed
+	branch   bool            // the line holds a conditional branch
+	branches cpu.BranchCount // counts of the line's branch
+}
+
+// The coverage of a source code file, by line number.
+type fileCoverage struct {
+	filename string
+	lines    map[int]*lineCoverage
+}
+
+// Return the number of instrumented lines and the number executed.
+func (f *fileCoverage) lineCounts() (found, hit int) {
+	for _, l := range f.lines {
+		found++
+		if l.count > 0 {
+			hit++
+		}
+	}
+	return found, hit
+}
+
+// Return the number of branch directions and the number taken.
+func (f *fileCoverage) branchCounts() (found, hit int) {
+	for _, l := range f.lines {
+		if !l.branch {
+			continue
+		}
+		found += 2
+		if l.branches.Taken > 0 {
+			hit++
+		}
+		if l.branches.NotTaken > 0 {
+			hit++
+		}
+	}
+	return found, hit
+}
+
+// Return the sorted line numbers of the file's instrumented lines.
+func (f *fileCoverage) lineNumbers() []int {
+	nums := make([]int, 0, len(f.lines))
+	for n := range f.lines {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums
+}
+
+// Map the coverage counts back to the source code lines of the host's
+// source map. Files are sorted by name.
+func (h *Host) sourceCoverage() []*fileCoverage {
+	files := make(map[string]*fileCoverage)
+	for _, sl := range h.sourceMap.Lines {
+		filename := h.sourceMap.Files[sl.FileIndex]
+		f, ok := files[filename]
+		if !ok {
+			f = &fileCoverage{filename: filename, lines: make(map[int]*lineCoverage)}
+			files[filename] = f
+		}
+
+		l, ok := f.lines[sl.Line]
+		if !ok {
+			l = &lineCoverage{}
+			f.lines[sl.Line] = l
+		}
+
+		addr := uint16(sl.Address)
+		l.count += h.coverage.Count(addr, sl.Bank)
+		if b, ok := h.coverage.Branch(addr, sl.Bank); ok {
+			l.branch = true
+			l.branches.Taken += b.Taken
+			l.branches.NotTaken += b.NotTaken
+		} else if sl.Bank == h.bank(addr) && h.cpu.GetInstruction(addr).ConditionalBranch() {
+			l.branch = true
+		}
+	}
+
+	list := make([]*fileCoverage, 0, len(files))
+	for _, f := range files {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].filename < list[j].filename
+	})
+	return list
+}
+
+// Write an annotated source code report of the coverage to 'w'. Each
+// instrumented line is prefixed by its execution count, or by ##### if it
+// never executed.
+func (h *Host) writeCoverageReport(w io.Writer, files []*fileCoverage) error {
+	ww := bufio.NewWriter(w)
+	for i, f := range files {
+		if i > 0 {
+			fmt.Fprintln(ww)
+		}
+
+		lf, lh := f.lineCounts()
+		bf, bh := f.branchCounts()
+		fmt.Fprintf(ww, "File: %s\n", f.filename)
+		fmt.Fprintf(ww, "Lines executed: %d of %d (%s)\n", lh, lf, percent(lh, lf))
+		fmt.Fprintf(ww, "Branches taken: %d of %d (%s)\n\n", bh, bf, percent(bh, bf))
+
+		source, err := h.getSourceLines(f.filename)
+		if err != nil {
+			fmt.Fprintf(ww, "Source code not available: %v\n", err)
+			continue
+		}
+
+		for n, text := range source {
+			l, ok := f.lines[n+1]
+			var count string
+			switch {
+			case !ok:
+				count = "-"
+			case l.count == 0:
+				count = "#####"
+			default:
+				count = fmt.Sprintf("%d", l.count)
+			}
+			fmt.Fprintf(ww, "%9s:%5d:%s", count, n+1, text)
+			if ok && l.branch && l.count > 0 {
+				fmt.Fprintf(ww, "    [taken %d, not taken %d]", l.branches.Taken, l.branches.NotTaken)
+			}
+			fmt.Fprintln(ww)
+		}
+	}
+	return ww.Flush()
+}
+
+// Write the coverage to 'w' in the lcov tracefile format.
+func (h *Host) writeLcov(w io.Writer, files []*fileCoverage) error {
+	ww := bufio.NewWriter(w)
+	fmt.Fprintln(ww, "TN:")
+	for _, f := range files {
+		fmt.Fprintf(ww, "SF:%s\n", f.filename)
+
+		nums := f.lineNumbers()
+		for _, n := range nums {
+			l := f.lines[n]
+			if !l.branch {
+				continue
+			}
+			for i, c := range []uint64{l.branches.Taken, l.branches.NotTaken} {
+				switch l.count {
+				case 0:
+					fmt.Fprintf(ww, "BRDA:%d,0,%d,-\n", n, i)
+				default:
+					fmt.Fprintf(ww, "BRDA:%d,0,%d,%d\n", n, i, c)
+				}
+			}
+		}
+		bf, bh := f.branchCounts()
+		fmt.Fprintf(ww, "BRF:%d\nBRH:%d\n", bf, bh)
+
+		for _, n := range nums {
+			fmt.Fprintf(ww, "DA:%d,%d\n", n, f.lines[n].count)
+		}
+		lf, lh := f.lineCounts()
+		fmt.Fprintf(ww, "LF:%d\nLH:%d\n", lf, lh)
+		fmt.Fprintln(ww, "end_of_record")
+	}
+	return ww.Flush()
+}
+
+// Return a percentage string for 'n' out of 'total'.
+func percent(n, total int) string {
+	if total == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
@@ -20,6 +20,8 @@ const (
 	tokenOp
 	tokenLParen
 	tokenRParen
+	tokenLBracket
+	tokenRBracket
 )
 
 type token struct {
@@ -45,6 +47,16 @@ const (
 	opUnaryMinus
 	opUnaryPlus
 	opUnaryBinary
+	opLess
+	opLessEqual
+	opGreater
+	opGreaterEqual
+	opEqual
+	opNotEqual
+	opLogicalAnd
+	opLogicalOr
+	opLogicalNot
+	opDeref
 )
 
 type associativity byte
@@ -66,20 +78,40 @@ type op struct {
 
 var ops = []op{
 	{"", opNil, 0, right, 2, opNil, nil},
-	{"*", opMultiply, 6, right, 2, opNil, func(a, b int64) int64 { return a * b }},
-	{"/", opDivide, 6, right, 2, opNil, func(a, b int64) int64 { return a / b }},
-	{"%", opModulo, 6, right, 2, opUnaryBinary, func(a, b int64) int64 { return a % b }},
-	{"+", opAdd, 5, right, 2, opUnaryPlus, func(a, b int64) int64 { return a + b }},
-	{"-", opSubtract, 5, right, 2, opUnaryMinus, func(a, b int64) int64 { return a - b }},
-	{"<<", opShiftLeft, 4, right, 2, opNil, func(a, b int64) int64 { return a << uint32(b) }},
-	{">>", opShiftRight, 4, right, 2, opNil, func(a, b int64) int64 { return a >> uint32(b) }},
-	{"&", opBitwiseAnd, 3, right, 2, opNil, func(a, b int64) int64 { return a & b }},
-	{"^", opBitwiseXor, 2, right, 2, opNil, func(a, b int64) int64 { return a ^ b }},
-	{"|", opBitwiseOr, 1, right, 2, opNil, func(a, b int64) int64 { return a | b }},
-	{"~", opBitwiseNot, 7, left, 1, opNil, func(a, b int64) int64 { return ^a }},
-	{"-", opUnaryMinus, 7, left, 1, opNil, func(a, b int64) int64 { return -a }},
-	{"+", opUnaryPlus, 7, left, 1, opNil, func(a, b int64) int64 { return a }},
-	{"%", opUnaryBinary, 7, left, 1, opNil, func(a, b int64) int64 { return fromBinary(a) }},
+	{"*", opMultiply, 10, right, 2, opNil, func(a, b int64) int64 { return a * b }},
+	{"/", opDivide, 10, right, 2, opNil, func(a, b int64) int64 { return a / b }},
+	{"%", opModulo, 10, right, 2, opUnaryBinary, func(a, b int64) int64 { return a % b }},
+	{"+", opAdd, 9, right, 2, opUnaryPlus, func(a, b int64) int64 { return a + b }},
+	{"-", opSubtract, 9, right, 2, opUnaryMinus, func(a, b int64) int64 { return a - b }},
+	{"<<", opShiftLeft, 8, right, 2, opNil, func(a, b int64) int64 { return a << uint32(b) }},
+	{">>", opShiftRight, 8, right, 2, opNil, func(a, b int64) int64 { return a >> uint32(b) }},
+	{"&", opBitwiseAnd, 5, right, 2, opNil, func(a, b int64) int64 { return a & b }},
+	{"^", opBitwiseXor, 4, right, 2, opNil, func(a, b int64) int64 { return a ^ b }},
+	{"|", opBitwiseOr, 3, right, 2, opNil, func(a, b int64) int64 { return a | b }},
+	{"~", opBitwiseNot, 11, left, 1, opNil, func(a, b int64) int64 { return ^a }},
+	{"-", opUnaryMinus, 11, left, 1, opNil, func(a, b int64) int64 { return -a }},
+	{"+", opUnaryPlus, 11, left, 1, opNil, func(a, b int64) int64 { return a }},
+	{"%", opUnaryBinary, 11, left, 1, opNil, func(a, b int64) int64 { return fromBinary(a) }},
+	{"<", opLess, 7, left, 2, opNil, func(a, b int64) int64 { return truth(a < b) }},
+	{"<=", opLessEqual, 7, left, 2, opNil, func(a, b int64) int64 { return truth(a <= b) }},
+	{">", opGreater, 7, left, 2, opNil, func(a, b int64) int64 { return truth(a > b) }},
+	{">=", opGreaterEqual, 7, left, 2, opNil, func(a, b int64) int64 { return truth(a >= b) }},
+	{"==", opEqual, 6, left, 2, opNil, func(a, b int64) int64 { return truth(a == b) }},
+	{"!=", opNotEqual, 6, left, 2, opNil, func(a, b int64) int64 { return truth(a != b) }},
+	{"&&", opLogicalAnd, 2, left, 2, opNil, func(a, b int64) int64 { return truth(a != 0 && b != 0) }},
+	{"||", opLogicalOr, 1, left, 2, opNil, func(a, b int64) int64 { return truth(a != 0 || b != 0) }},
+	{"!", opLogicalNot, 11, left, 1, opNil, func(a, b int64) int64 { return truth(a == 0) }},
+	{"[", opDeref, 11, left, 1, opNil, nil}, // evaluated by the resolver
+}
+
+// Operators parsed by parseOp. When two operators share a first character,
+// the longer one is listed first.
+var multiCharOps = []opType{
+	opShiftLeft, opLessEqual, opLess,
+	opShiftRight, opGreaterEqual, opGreater,
+	opEqual, opNotEqual, opLogicalNot,
+	opLogicalAnd, opBitwiseAnd,
+	opLogicalOr, opBitwiseOr,
 }
 
 // lexeme identifiers
@@ -95,12 +127,11 @@ const (
 	lMod
 	lAdd
 	lSub
-	lShl
-	lShr
-	lAnd
 	lXor
-	lOra
 	lNot
+	lOps
+	lLBr
+	lRBr
 )
 
 // A table mapping lexeme identifiers to token data and parsers.
@@ -120,32 +151,32 @@ var lexeme = []struct {
 	/*lMod*/ {TokenType: tokenOp, OpType: opModulo},
 	/*lAdd*/ {TokenType: tokenOp, OpType: opAdd},
 	/*lSub*/ {TokenType: tokenOp, OpType: opSubtract},
-	/*lShl*/ {TokenType: tokenOp, OpType: opNil, Parse: (*exprParser).parseShiftOp},
-	/*lShr*/ {TokenType: tokenOp, OpType: opNil, Parse: (*exprParser).parseShiftOp},
-	/*lAnd*/ {TokenType: tokenOp, OpType: opBitwiseAnd},
 	/*lXor*/ {TokenType: tokenOp, OpType: opBitwiseXor},
-	/*lOra*/ {TokenType: tokenOp, OpType: opBitwiseOr},
 	/*lNot*/ {TokenType: tokenOp, OpType: opBitwiseNot},
+	/*lOps*/ {TokenType: tokenOp, OpType: opNil, Parse: (*exprParser).parseOp},
+	/*lLBr*/ {TokenType: tokenLBracket, OpType: opNil},
+	/*lRBr*/ {TokenType: tokenRBracket, OpType: opNil},
 }
 
 // A table mapping the first char of a lexeme to a lexeme identifier.
 var lex0 = [96]byte{
-	lNil, lNil, lNil, lNil, lNum, lMod, lAnd, lCha, // 32..39
+	lNil, lOps, lNil, lNil, lNum, lMod, lOps, lCha, // 32..39
 	lLPa, lRPa, lMul, lAdd, lNil, lSub, lIde, lDiv, // 40..47
 	lNum, lNum, lNum, lNum, lNum, lNum, lNum, lNum, // 48..55
-	lNum, lNum, lNil, lNil, lShl, lNil, lShr, lNil, // 56..63
+	lNum, lNum, lNil, lNil, lOps, lOps, lOps, lNil, // 56..63
 	lNil, lIde, lIde, lIde, lIde, lIde, lIde, lIde, // 64..71
 	lIde, lIde, lIde, lIde, lIde, lIde, lIde, lIde, // 72..79
 	lIde, lIde, lIde, lIde, lIde, lIde, lIde, lIde, // 80..87
-	lIde, lIde, lIde, lNil, lNil, lNil, lXor, lIde, // 88..95
+	lIde, lIde, lIde, lLBr, lNil, lRBr, lXor, lIde, // 88..95
 	lNil, lIde, lIde, lIde, lIde, lIde, lIde, lIde, // 96..103
 	lIde, lIde, lIde, lIde, lIde, lIde, lIde, lIde, // 104..111
 	lIde, lIde, lIde, lIde, lIde, lIde, lIde, lIde, // 112..119
-	lIde, lIde, lIde, lNil, lOra, lNil, lNot, lNil, // 120..127
+	lIde, lIde, lIde, lNil, lOps, lNil, lNot, lNil, // 120..127
 }
 
 type resolver interface {
 	resolveIdentifier(s string) (int64, error)
+	loadByte(addr uint16) byte
 }
 
 //
@@ -170,6 +201,16 @@ func (p *exprParser) Reset() {
 }
 
 func (p *exprParser) Parse(expr string, r resolver) (int64, error) {
+	e, err := p.Compile(expr)
+	if err != nil {
+		return 0, err
+	}
+	return e.Eval(r)
+}
+
+// Compile parses an expression so that it may be evaluated repeatedly
+// without being parsed again.
+func (p *exprParser) Compile(expr string) (*compiledExpr, error) {
 	defer p.Reset()
 
 	t := tstring(expr)
@@ -177,7 +218,7 @@ func (p *exprParser) Parse(expr string, r resolver) (int64, error) {
 	for {
 		tok, remain, err := p.parseToken(t)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		if tok.Type == tokenNil {
 			break
@@ -185,37 +226,38 @@ func (p *exprParser) Parse(expr string, r resolver) (int64, error) {
 		t = remain
 
 		switch tok.Type {
-		case tokenNumber:
-			p.output.push(tok)
-
-		case tokenIdentifier:
-			v, err := r.resolveIdentifier(tok.Value.(string))
-			if err != nil {
-				return 0, err
-			}
-			tok.Type, tok.Value = tokenNumber, v
+		case tokenNumber, tokenIdentifier:
 			p.output.push(tok)
 
-		case tokenLParen:
+		case tokenLParen, tokenLBracket:
 			p.operatorStack.push(tok)
 
-		case tokenRParen:
-			foundLParen := false
+		case tokenRParen, tokenRBracket:
+			open := tokenLParen
+			if tok.Type == tokenRBracket {
+				open = tokenLBracket
+			}
+			foundOpen := false
 			for !p.operatorStack.isEmpty() {
 				tmp := p.operatorStack.pop()
-				if tmp.Type == tokenLParen {
-					foundLParen = true
+				if tmp.Type == tokenLParen || tmp.Type == tokenLBracket {
+					foundOpen = tmp.Type == open
 					break
 				}
 				p.output.push(tmp)
 			}
-			if !foundLParen {
-				return 0, errExprParse
+			if !foundOpen {
+				return nil, errExprParse
+			}
+
+			// A bracketed expression is the address of a byte in memory.
+			if tok.Type == tokenRBracket {
+				p.output.push(token{tokenOp, &ops[opDeref]})
 			}
 
 		case tokenOp:
 			if err := p.checkForUnaryOp(&tok, t); err != nil {
-				return 0, err
+				return nil, err
 			}
 			for p.isCollapsible(&tok) {
 				p.output.push(p.operatorStack.pop())
@@ -228,21 +270,33 @@ func (p *exprParser) Parse(expr string, r resolver) (int64, error) {
 
 	for !p.operatorStack.isEmpty() {
 		tok := p.operatorStack.pop()
-		if tok.Type == tokenLParen {
-			return 0, errExprParse
+		if tok.Type == tokenLParen || tok.Type == tokenLBracket {
+			return nil, errExprParse
 		}
 		p.output.push(tok)
 	}
 
-	result, err := p.evalOutput()
-	if err != nil {
-		return 0, err
+	// Check that every operator has its operands and that a single value
+	// remains, so that evaluation can't fail on the expression's syntax.
+	depth := 0
+	for _, tok := range p.output.stack {
+		if tok.Type != tokenOp {
+			depth++
+			continue
+		}
+		args := int(tok.Value.(*op).Args)
+		if depth < args {
+			return nil, errExprParse
+		}
+		depth -= args - 1
 	}
-	if !p.output.isEmpty() {
-		return 0, errExprParse
+	if depth != 1 {
+		return nil, errExprParse
 	}
 
-	return result.Value.(int64), nil
+	e := &compiledExpr{tokens: make([]token, len(p.output.stack))}
+	copy(e.tokens, p.output.stack)
+	return e, nil
 }
 
 func (p *exprParser) parseToken(t tstring) (tok token, remain tstring, err error) {
@@ -340,61 +394,15 @@ func (p *exprParser) parseIdentifier(t tstring) (tok token, remain tstring, err
 	return tok, remain, nil
 }
 
-func (p *exprParser) parseShiftOp(t tstring) (tok token, remain tstring, err error) {
-	if len(t) < 2 || t[1] != t[0] {
-		return token{}, t, errExprParse
-	}
-
-	var op *op
-	switch t[0] {
-	case '<':
-		op = &ops[opShiftLeft]
-	default:
-		op = &ops[opShiftRight]
-	}
-
-	tok = token{tokenOp, op}
-	return tok, t.consume(2), nil
-}
-
-func (p *exprParser) evalOutput() (token, error) {
-	if p.output.isEmpty() {
-		return token{}, errExprParse
-	}
-
-	tok := p.output.pop()
-	if tok.Type == tokenNumber {
-		return tok, nil
-	}
-	if tok.Type != tokenOp {
-		return token{}, errExprParse
-	}
-
-	op := tok.Value.(*op)
-	switch op.Args {
-	case 1:
-		child, err := p.evalOutput()
-		if err != nil {
-			return token{}, err
-		}
-		tok.Type = tokenNumber
-		tok.Value = op.Eval(child.Value.(int64), 0)
-		return tok, nil
-
-	default:
-		child2, err := p.evalOutput()
-		if err != nil {
-			return token{}, err
-		}
-		child1, err := p.evalOutput()
-		if err != nil {
-			return token{}, err
+func (p *exprParser) parseOp(t tstring) (tok token, remain tstring, err error) {
+	for _, o := range multiCharOps {
+		sym := ops[o].Symbol
+		if len(t) >= len(sym) && string(t[:len(sym)]) == sym {
+			tok = token{tokenOp, &ops[o]}
+			return tok, t.consume(len(sym)), nil
 		}
-
-		tok.Type = tokenNumber
-		tok.Value = op.Eval(child1.Value.(int64), child2.Value.(int64))
-		return tok, nil
 	}
+	return token{}, t, errExprParse
 }
 
 func (p *exprParser) checkForUnaryOp(tok *token, t tstring) error {
@@ -405,7 +413,8 @@ func (p *exprParser) checkForUnaryOp(tok *token, t tstring) error {
 
 	// If this operation follows an operation, a left parenthesis, or nothing,
 	// then convert it to a unary op.
-	if p.prevTokenType == tokenOp || p.prevTokenType == tokenLParen || p.prevTokenType == tokenNil {
+	if p.prevTokenType == tokenOp || p.prevTokenType == tokenLParen ||
+		p.prevTokenType == tokenLBracket || p.prevTokenType == tokenNil {
 		tok.Value = &ops[o.UnaryOp]
 	}
 	return nil
@@ -432,6 +441,50 @@ func (p *exprParser) isCollapsible(opToken *token) bool {
 	return false
 }
 
+//
+// compiledExpr
+//
+
+// A compiledExpr is an expression parsed into postfix order. Its
+// identifiers are resolved each time it is evaluated.
+type compiledExpr struct {
+	tokens []token
+	values []int64 // evaluation stack
+}
+
+// Eval evaluates the expression.
+func (e *compiledExpr) Eval(r resolver) (int64, error) {
+	v := e.values[:0]
+	for _, tok := range e.tokens {
+		switch tok.Type {
+		case tokenNumber:
+			v = append(v, tok.Value.(int64))
+
+		case tokenIdentifier:
+			n, err := r.resolveIdentifier(tok.Value.(string))
+			if err != nil {
+				return 0, err
+			}
+			v = append(v, n)
+
+		case tokenOp:
+			op := tok.Value.(*op)
+			top := len(v) - 1
+			switch {
+			case op.Type == opDeref:
+				v[top] = int64(r.loadByte(uint16(v[top])))
+			case op.Args == 1:
+				v[top] = op.Eval(v[top], 0)
+			default:
+				v[top-1] = op.Eval(v[top-1], v[top])
+				v = v[:top]
+			}
+		}
+	}
+	e.values = v
+	return v[0], nil
+}
+
 //
 // tokenStack
 //
@@ -467,6 +520,13 @@ func (s *tokenStack) pop() token {
 // helpers
 //
 
+func truth(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func fromBinary(a int64) int64 {
 	v, err := strconv.ParseInt(strconv.FormatInt(a, 10), 2, 64)
 	if err != nil {
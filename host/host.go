@@ -15,6 +15,8 @@ package host
 
 import (
 	"bufio"
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash/crc32"
@@ -25,6 +27,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/beevik/cmd"
 	"github.com/beevik/go6502/asm"
@@ -43,6 +46,33 @@ const (
 	displayAll = displayRegisters | displayCycles | displayAnnotations
 )
 
+// CPU architectures selectable with the Arch setting.
+var archNames = map[string]cpu.Architecture{
+	"6502":   cpu.NMOS,
+	"65c02":  cpu.CMOS,
+	"6502x":  cpu.NMOSX,
+	"w65c02": cpu.WDC,
+	"65816":  cpu.W65C816,
+	"2a03":   cpu.R2A03,
+}
+
+var undefinedPolicies = map[string]cpu.UndefinedPolicy{
+	"ignore": cpu.UndefinedIgnore,
+	"halt":   cpu.UndefinedHalt,
+	"jam":    cpu.UndefinedJam,
+	"reset":  cpu.UndefinedReset,
+}
+
+// Return the name of an architecture used by the Arch setting.
+func archName(arch cpu.Architecture) string {
+	for name, a := range archNames {
+		if a == arch {
+			return name
+		}
+	}
+	return ""
+}
+
 type state byte
 
 const (
@@ -59,9 +89,24 @@ type Host struct {
 	input       *bufio.Scanner
 	output      *bufio.Writer
 	interactive bool
-	mem         *cpu.FlatMemory
+	mem         cpu.Memory
 	cpu         *cpu.CPU
 	debugger    *cpu.Debugger
+	history     *cpu.History // nil if history isn't recorded
+	callStack   *cpu.CallStack
+	mismatch    *cpu.CallMismatch // most recent call stack mismatch
+	stackCheck  *cpu.StackChecker // nil unless the StackCheck setting is on
+	initCheck   *cpu.InitChecker  // nil unless the UninitCheck setting is on
+	codeCheck   *cpu.CodeChecker  // attached while the CodeCheck setting is on
+	codeChecked bool              // the code checker is attached
+	coverage    *cpu.Coverage     // attached while the Coverage setting is on
+	covering    bool              // the coverage counter is attached
+	profiler    *cpu.Profiler     // attached while the Profile setting is on
+	profiling   bool              // the profiler is attached
+	traceLog    *traceLog         // attached while a trace log is open
+	tracing     bool              // the trace log is attached
+	actions     map[*cpu.Breakpoint]*breakActions
+	cancel      context.CancelFunc
 	lastCmd     *cmd.Selection
 	state       state
 	miniAddr    uint16
@@ -73,8 +118,14 @@ type Host struct {
 	annotations map[uint16]string
 }
 
-// New creates a new 6502 host environment.
+// New creates a new 6502 host environment with 64K of flat memory.
 func New() *Host {
+	return NewWithMemory(cpu.NewFlatMemory())
+}
+
+// NewWithMemory creates a new 6502 host environment on top of the provided
+// memory, such as a cpu.Bus with devices mapped into it.
+func NewWithMemory(mem cpu.Memory) *Host {
 	h := &Host{
 		state:       stateProcessingCommands,
 		exprParser:  newExprParser(),
@@ -82,16 +133,49 @@ func New() *Host {
 		sourceMap:   asm.NewSourceMap(),
 		settings:    newSettings(),
 		annotations: make(map[uint16]string),
+		actions:     make(map[*cpu.Breakpoint]*breakActions),
 	}
 
 	// Create the emulated CPU and memory.
-	h.mem = cpu.NewFlatMemory()
+	h.mem = mem
 	h.cpu = cpu.NewCPU(cpu.CMOS, h.mem)
 
-	// Create a CPU debugger and attach it to the CPU.
-	h.debugger = cpu.NewDebugger(newDebugHandler(h))
+	// Create a CPU debugger and attach it to the CPU. Breakpoints are
+	// reported as stop reasons by the CPU's Run function, which the host
+	// handles in onStop, so the debugger needs no handler.
+	h.debugger = cpu.NewDebugger(nil)
 	h.cpu.AttachDebugger(h.debugger)
 
+	// Track subroutine calls for backtraces and stepping.
+	h.callStack = cpu.NewCallStack()
+	h.callStack.OnMismatch = func(c *cpu.CPU, m *cpu.CallMismatch) {
+		mm := *m
+		h.mismatch = &mm
+	}
+	h.cpu.AttachTracer(h.callStack)
+
+	// Track which addresses hold instructions and data, so that code
+	// faults can be found once the CodeCheck setting is turned on.
+	h.codeCheck = cpu.NewCodeChecker()
+	h.codeCheck.OnFault = func(c *cpu.CPU, f *cpu.CodeFault) {
+		if f.Action == cpu.CodeReport {
+			h.printCodeFault(f)
+		}
+	}
+
+	// Count executed instructions while the Coverage setting is on.
+	h.coverage = cpu.NewCoverage()
+
+	// Attribute cycles to subroutines while the Profile setting is on.
+	h.profiler = cpu.NewProfiler()
+
+	// Write executed instructions to a file while a trace log is open.
+	h.traceLog = newTraceLog(h)
+
+	// Record the execution history so the CPU can be stepped backward.
+	h.history = cpu.NewHistory(h.settings.HistorySize)
+	h.cpu.AttachHistory(h.history)
+
 	return h
 }
 
@@ -140,10 +224,22 @@ func (h *Host) RunCommands(r io.Reader, w io.Writer, interactive bool) {
 			panic("invalid state")
 		}
 
+		// Flush the trace log so that it's complete between commands.
+		if h.tracing {
+			if ferr := h.traceLog.flush(); ferr != nil {
+				h.printf("%v\n", ferr)
+				h.closeTraceLog()
+			}
+		}
+
 		if err != nil {
 			break
 		}
 	}
+
+	if h.tracing {
+		h.closeTraceLog()
+	}
 }
 
 func (h *Host) processCommand(line string) error {
@@ -225,7 +321,9 @@ func (h *Host) assembleInline() error {
 	}
 
 	h.mem.StoreBytes(h.miniAddr, a.Code)
-	h.sourceMap.ClearRange(int(h.miniAddr), len(a.Code))
+	h.markInitialized(h.miniAddr, len(a.Code))
+	h.codeCheck.MarkInstructions(h.miniAddr, len(a.Code))
+	h.sourceMap.ClearBankRange(int(h.miniAddr), len(a.Code), h.bank(h.miniAddr))
 
 	for addr, end := int(h.miniAddr), int(h.miniAddr)+len(a.Code); addr < end; {
 		d, next := h.disassemble(uint16(addr), 0)
@@ -247,6 +345,9 @@ func (h *Host) Break() {
 	switch h.state {
 	case stateRunning:
 		h.state = stateInterrupted
+		if h.cancel != nil {
+			h.cancel()
+		}
 
 	case stateProcessingCommands:
 		h.println("Type 'quit' to exit the application.")
@@ -514,9 +615,31 @@ func (h *Host) cmdBreakpointList(c cmd.Selection) error {
 		return ""
 	}
 
+	condition := func(b *cpu.Breakpoint) string {
+		var s string
+		if c, ok := b.Condition.(*breakCondition); ok {
+			s = " when " + c.expr
+		}
+		if b.Hits > 0 {
+			s += fmt.Sprintf(", hit %d times", b.Hits)
+		}
+		if b.Ignore > 0 {
+			s += fmt.Sprintf(", ignoring next %d", b.Ignore)
+		}
+		if a, ok := h.actions[b]; ok && a.cont {
+			s += ", continues"
+		}
+		return s
+	}
+
 	h.println("Breakpoints:")
 	for _, b := range bp {
-		h.printf("   $%04X %s\n", b.Address, disabled(b))
+		h.printf("   $%s%s %s\n", h.addrString(b.Address, b.Bank), condition(b), disabled(b))
+		if a, ok := h.actions[b]; ok {
+			for _, cmd := range a.commands {
+				h.printf("      > %s\n", cmd)
+			}
+		}
 	}
 	return nil
 }
@@ -527,14 +650,14 @@ func (h *Host) cmdBreakpointAdd(c cmd.Selection) error {
 		return nil
 	}
 
-	addr, err := h.parseExpr(c.Args[0])
+	addr, bank, err := h.parseBankAddr(c.Args)
 	if err != nil {
 		h.printf("%v\n", err)
 		return nil
 	}
 
-	h.debugger.AddBreakpoint(addr)
-	h.printf("Breakpoint added at $%04x.\n", addr)
+	h.debugger.AddBankBreakpoint(addr, bank)
+	h.printf("Breakpoint added at $%04x%s.\n", addr, h.bankSuffix(bank))
 	return nil
 }
 
@@ -544,19 +667,20 @@ func (h *Host) cmdBreakpointRemove(c cmd.Selection) error {
 		return nil
 	}
 
-	addr, err := h.parseExpr(c.Args[0])
+	addr, bank, err := h.parseBankAddr(c.Args)
 	if err != nil {
 		h.printf("%v\n", err)
 		return nil
 	}
 
-	if h.debugger.GetBreakpoint(addr) == nil {
-		h.printf("No breakpoint was set on $%04X.\n", addr)
+	if h.debugger.GetBankBreakpoint(addr, bank) == nil {
+		h.printf("No breakpoint was set on $%04X%s.\n", addr, h.bankSuffix(bank))
 		return nil
 	}
 
-	h.debugger.RemoveBreakpoint(addr)
-	h.printf("Breakpoint at $%04x removed.\n", addr)
+	delete(h.actions, h.debugger.GetBankBreakpoint(addr, bank))
+	h.debugger.RemoveBankBreakpoint(addr, bank)
+	h.printf("Breakpoint at $%04x%s removed.\n", addr, h.bankSuffix(bank))
 	return nil
 }
 
@@ -566,20 +690,20 @@ func (h *Host) cmdBreakpointEnable(c cmd.Selection) error {
 		return nil
 	}
 
-	addr, err := h.parseExpr(c.Args[0])
+	addr, bank, err := h.parseBankAddr(c.Args)
 	if err != nil {
 		h.printf("%v\n", err)
 		return nil
 	}
 
-	b := h.debugger.GetBreakpoint(addr)
+	b := h.debugger.GetBankBreakpoint(addr, bank)
 	if b == nil {
-		h.printf("No breakpoint was set on $%04X.\n", addr)
+		h.printf("No breakpoint was set on $%04X%s.\n", addr, h.bankSuffix(bank))
 		return nil
 	}
 
 	b.Disabled = false
-	h.printf("Breakpoint at $%04x enabled.\n", addr)
+	h.printf("Breakpoint at $%04x%s enabled.\n", addr, h.bankSuffix(bank))
 	return nil
 }
 
@@ -589,26 +713,248 @@ func (h *Host) cmdBreakpointDisable(c cmd.Selection) error {
 		return nil
 	}
 
-	addr, err := h.parseExpr(c.Args[0])
+	addr, bank, err := h.parseBankAddr(c.Args)
 	if err != nil {
 		h.printf("%v\n", err)
 		return nil
 	}
 
-	b := h.debugger.GetBreakpoint(addr)
+	b := h.debugger.GetBankBreakpoint(addr, bank)
 	if b == nil {
-		h.printf("No breakpoint was set on $%04X.\n", addr)
+		h.printf("No breakpoint was set on $%04X%s.\n", addr, h.bankSuffix(bank))
 		return nil
 	}
 
 	b.Disabled = true
-	h.printf("Breakpoint at $%04x disabled.\n", addr)
+	h.printf("Breakpoint at $%04x%s disabled.\n", addr, h.bankSuffix(bank))
+	return nil
+}
+
+func (h *Host) cmdBreakpointCondition(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	args, rest := splitBankArg(c.Args)
+	addr, bank, b := h.lookupBreakpoint(args)
+	if b == nil {
+		return nil
+	}
+
+	if len(rest) == 0 {
+		b.Condition = nil
+		h.printf("Breakpoint at $%04x%s is now unconditional.\n", addr, h.bankSuffix(bank))
+		return nil
+	}
+
+	expr := strings.Join(rest, " ")
+	e, err := h.exprParser.Compile(expr)
+	if err == nil {
+		_, err = e.Eval(h)
+	}
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	b.Condition = &breakCondition{h: h, expr: expr, e: e}
+	h.printf("Breakpoint at $%04x%s is now hit only when %s.\n", addr, h.bankSuffix(bank), expr)
+	return nil
+}
+
+func (h *Host) cmdBreakpointIgnore(c cmd.Selection) error {
+	if len(c.Args) < 2 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	args := []string{c.Args[0]}
+	if len(c.Args) > 2 {
+		args = append(args, c.Args[2])
+	}
+	addr, bank, err := h.parseBankAddr(args)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	n, err := h.parseExpr(c.Args[1])
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	b := h.debugger.GetBankBreakpoint(addr, bank)
+	if b == nil {
+		h.printf("No breakpoint was set on $%04X%s.\n", addr, h.bankSuffix(bank))
+		return nil
+	}
+
+	b.Ignore = int(n)
+	h.printf("Breakpoint at $%04x%s will ignore its next %d hits.\n", addr, h.bankSuffix(bank), n)
+	return nil
+}
+
+func (h *Host) cmdBreakpointAction(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	args, rest := splitBankArg(c.Args)
+	addr, bank, b := h.lookupBreakpoint(args)
+	if b == nil {
+		return nil
+	}
+
+	if len(rest) == 0 {
+		if a, ok := h.actions[b]; ok {
+			a.commands = nil
+		}
+		h.printf("Breakpoint at $%04x%s actions cleared.\n", addr, h.bankSuffix(bank))
+		return nil
+	}
+
+	line := strings.Join(rest, " ")
+	s, err := cmds.Lookup(line)
+	if err != nil {
+		h.printf("Invalid action '%s': %v.\n", line, err)
+		return nil
+	}
+	if runCmds[s.Command] {
+		h.printf("Invalid action '%s': the command can't be used as an action.\n", line)
+		return nil
+	}
+
+	a := h.breakActions(b)
+	a.commands = append(a.commands, line)
+	h.printf("Breakpoint at $%04x%s action added: %s\n", addr, h.bankSuffix(bank), line)
+	return nil
+}
+
+func (h *Host) cmdBreakpointContinue(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	args := []string{c.Args[0]}
+	if len(c.Args) > 2 {
+		args = append(args, c.Args[2])
+	}
+	addr, bank, b := h.lookupBreakpoint(args)
+	if b == nil {
+		return nil
+	}
+
+	cont := true
+	if len(c.Args) > 1 {
+		switch strings.ToLower(c.Args[1]) {
+		case "on", "true", "1":
+			cont = true
+		case "off", "false", "0":
+			cont = false
+		default:
+			h.displayUsage(c.Command)
+			return nil
+		}
+	}
+
+	h.breakActions(b).cont = cont
+	switch cont {
+	case true:
+		h.printf("Breakpoint at $%04x%s now continues after its actions.\n", addr, h.bankSuffix(bank))
+	case false:
+		h.printf("Breakpoint at $%04x%s now stops the CPU.\n", addr, h.bankSuffix(bank))
+	}
 	return nil
 }
 
+// Look up the breakpoint at an address and an optional bank, as parsed by
+// parseBankAddr. A message is displayed if there is no such breakpoint.
+func (h *Host) lookupBreakpoint(args []string) (addr uint16, bank int, b *cpu.Breakpoint) {
+	addr, bank, err := h.parseBankAddr(args)
+	if err != nil {
+		h.printf("%v\n", err)
+		return 0, 0, nil
+	}
+
+	b = h.debugger.GetBankBreakpoint(addr, bank)
+	if b == nil {
+		h.printf("No breakpoint was set on $%04X%s.\n", addr, h.bankSuffix(bank))
+	}
+	return addr, bank, b
+}
+
+// The breakActions of a breakpoint are the host commands executed when it
+// is hit. If cont is true, the CPU continues running afterward, turning the
+// breakpoint into a logpoint.
+type breakActions struct {
+	commands []string
+	cont     bool
+}
+
+// Return the actions of a breakpoint, creating them if necessary.
+func (h *Host) breakActions(b *cpu.Breakpoint) *breakActions {
+	a, ok := h.actions[b]
+	if !ok {
+		a = &breakActions{}
+		h.actions[b] = a
+	}
+	return a
+}
+
+// Execute the commands attached to a breakpoint.
+func (h *Host) runActions(b *cpu.Breakpoint) {
+	a, ok := h.actions[b]
+	if !ok {
+		return
+	}
+
+	lastCmd := h.lastCmd
+	for _, line := range a.commands {
+		if err := h.processCommand(line); err != nil {
+			h.printf("%v\n", err)
+			break
+		}
+	}
+	h.lastCmd = lastCmd
+}
+
+// Split the arguments of a command that takes a breakpoint address followed
+// by free-form text. The address may be followed by the keyword "bank" and
+// a bank number. Return the address and bank arguments, for parseBankAddr,
+// and the remaining arguments.
+func splitBankArg(args []string) (addrArgs, rest []string) {
+	addrArgs, rest = args[:1], args[1:]
+	if len(rest) > 1 && strings.ToLower(rest[0]) == "bank" {
+		addrArgs, rest = []string{args[0], rest[1]}, rest[2:]
+	}
+	return addrArgs, rest
+}
+
+// A breakCondition is a breakpoint condition written in the host's
+// expression language. A non-zero result hits the breakpoint.
+type breakCondition struct {
+	h    *Host
+	expr string
+	e    *compiledExpr // the expression, parsed when the condition was set
+	err  error         // error from the most recent evaluation
+}
+
+// Check evaluates the condition. A condition that fails to evaluate hits
+// the breakpoint so the error can be reported.
+func (c *breakCondition) Check(_ *cpu.CPU) bool {
+	v, err := c.e.Eval(c.h)
+	c.err = err
+	return err != nil || v != 0
+}
+
 func (h *Host) cmdDataBreakpointList(c cmd.Selection) error {
 	bp := h.debugger.GetDataBreakpoints()
-	if len(bp) == 0 {
+	wp := h.debugger.GetWatchpoints()
+	if len(bp) == 0 && len(wp) == 0 {
 		h.println("No data breakpoints set.")
 		return nil
 	}
@@ -620,14 +966,142 @@ func (h *Host) cmdDataBreakpointList(c cmd.Selection) error {
 		return ""
 	}
 
-	h.println("Data breakpoints:")
-	for _, b := range h.debugger.GetDataBreakpoints() {
-		if b.Conditional {
-			h.printf("   $%04X on value $%02X %s\n", b.Address, b.Value, disabled(b))
-		} else {
-			h.printf("   $%04X %s\n", b.Address, disabled(b))
+	if len(bp) > 0 {
+		h.println("Data breakpoints:")
+		for _, b := range bp {
+			if b.Conditional {
+				h.printf("   $%04X on value $%02X %s\n", b.Address, b.Value, disabled(b))
+			} else {
+				h.printf("   $%04X %s\n", b.Address, disabled(b))
+			}
+		}
+	}
+
+	if len(wp) > 0 {
+		h.println("Watchpoints:")
+		for _, w := range wp {
+			var d string
+			if w.Disabled {
+				d = " (disabled)"
+			}
+			h.printf("   %d: %s on %s%s%s\n", w.ID, watchAccessNames[w.Access],
+				rangeString(w.Start, w.End), watchConditionString(w), d)
+		}
+	}
+	return nil
+}
+
+// Names of the watchpoint access kinds.
+var watchAccessNames = map[cpu.WatchAccess]string{
+	cpu.WatchRead:      "read",
+	cpu.WatchWrite:     "write",
+	cpu.WatchReadWrite: "read/write",
+}
+
+// Return a string describing an address range.
+func rangeString(start, end uint16) string {
+	if start == end {
+		return fmt.Sprintf("$%04X", start)
+	}
+	return fmt.Sprintf("$%04X-$%04X", start, end)
+}
+
+// Return a string describing a watchpoint's conditions.
+func watchConditionString(w *cpu.Watchpoint) string {
+	var s string
+	if w.Mask != 0xff {
+		s += fmt.Sprintf(" mask=$%02X", w.Mask)
+	}
+	if w.MatchOld {
+		s += fmt.Sprintf(" old=$%02X", w.Old)
+	}
+	if w.MatchNew {
+		s += fmt.Sprintf(" new=$%02X", w.New)
+	}
+	if w.Changed {
+		s += " changed"
+	}
+	return s
+}
+
+func (h *Host) cmdDataBreakpointWatch(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	start, err := h.parseExpr(c.Args[0])
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	w := &cpu.Watchpoint{Start: start, End: start, Access: cpu.WatchReadWrite, Mask: 0xff}
+	haveEnd := false
+	for _, arg := range c.Args[1:] {
+		var err error
+		switch a := strings.ToLower(arg); {
+		case a == "r":
+			w.Access = cpu.WatchRead
+		case a == "w":
+			w.Access = cpu.WatchWrite
+		case a == "rw":
+			w.Access = cpu.WatchReadWrite
+		case a == "changed":
+			w.Changed = true
+		case strings.HasPrefix(a, "mask="):
+			w.Mask, err = h.parseByte(arg[5:])
+		case strings.HasPrefix(a, "old="):
+			w.MatchOld = true
+			w.Old, err = h.parseByte(arg[4:])
+		case strings.HasPrefix(a, "new="):
+			w.MatchNew = true
+			w.New, err = h.parseByte(arg[4:])
+		case !haveEnd:
+			haveEnd = true
+			w.End, err = h.parseExpr(arg)
+		default:
+			h.displayUsage(c.Command)
+			return nil
 		}
+		if err != nil {
+			h.printf("%v\n", err)
+			return nil
+		}
+	}
+
+	if w.End < w.Start {
+		h.println("The end address must not precede the start address.")
+		return nil
+	}
+
+	wp := h.debugger.AddWatchpoint(w.Start, w.End, w.Access)
+	w.ID = wp.ID
+	*wp = *w
+	h.printf("Watchpoint %d added: %s on %s%s.\n", w.ID, watchAccessNames[w.Access],
+		rangeString(w.Start, w.End), watchConditionString(w))
+	return nil
+}
+
+func (h *Host) cmdDataBreakpointUnwatch(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	id, err := h.parseExpr(c.Args[0])
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
 	}
+
+	if h.debugger.GetWatchpoint(int(id)) == nil {
+		h.printf("No watchpoint %d.\n", id)
+		return nil
+	}
+
+	h.debugger.RemoveWatchpoint(int(id))
+	h.printf("Watchpoint %d removed.\n", id)
 	return nil
 }
 
@@ -727,49 +1201,197 @@ func (h *Host) cmdDataBreakpointDisable(c cmd.Selection) error {
 	return nil
 }
 
-func (h *Host) cmdDisassemble(c cmd.Selection) error {
-	if len(c.Args) == 0 {
-		c.Args = []string{"$"}
-	}
+var codeActionNames = map[cpu.CodeAction]string{
+	cpu.CodeStop:   "stop",
+	cpu.CodeReport: "report",
+	cpu.CodeIgnore: "ignore",
+}
 
-	addr, err := h.parseAddr(c.Args[0], h.settings.NextDisasmAddr)
-	if err != nil {
-		h.printf("%v\n", err)
-		return nil
-	}
+func (h *Host) cmdCodeCheckList(c cmd.Selection) error {
+	cc := h.codeCheck
 
-	lines := h.settings.DisasmLines
-	if len(c.Args) > 1 {
-		l, err := h.parseExpr(c.Args[1])
-		if err != nil {
-			h.printf("%v\n", err)
-			return nil
+	// Describe the kind and action of an address, or return an empty
+	// string if it isn't known to hold instructions or data.
+	describe := func(addr uint16) string {
+		var kind string
+		switch {
+		case cc.IsInstruction(addr):
+			kind = "instructions"
+		case cc.IsData(addr):
+			kind = "data"
+		default:
+			return ""
 		}
-		lines = int(l)
+		if a := cc.Action(addr); a != cpu.CodeStop {
+			kind += " (" + codeActionNames[a] + ")"
+		}
+		return kind
 	}
 
-	for i := 0; i < lines; i++ {
-		d, next := h.disassemble(addr, displayAnnotations)
-		h.println(d)
-		addr = next
+	n := 0
+	for addr := 0; addr < 0x10000; {
+		d := describe(uint16(addr))
+		end := addr + 1
+		for end < 0x10000 && describe(uint16(end)) == d {
+			end++
+		}
+		if d != "" {
+			if n == 0 {
+				h.println("Code ranges:")
+			}
+			h.printf("   $%04X-$%04X %s\n", addr, end-1, d)
+			n++
+		}
+		addr = end
 	}
 
-	h.settings.NextDisasmAddr = addr
-	h.lastCmd.Args = []string{"$", fmt.Sprintf("%d", lines)}
+	if n == 0 {
+		h.println("No instruction or data ranges known.")
+	}
 	return nil
 }
 
-func (h *Host) cmdExports(c cmd.Selection) error {
-	if len(h.sourceMap.Exports) == 0 {
-		h.println("No active exports.")
+func (h *Host) cmdCodeCheckAction(c cmd.Selection) error {
+	if len(c.Args) < 3 {
+		h.displayUsage(c.Command)
 		return nil
 	}
 
-	h.println("Exported addresses:")
-	for _, e := range h.sourceMap.Exports {
-		h.printf("   %-16s $%04X\n", e.Label, e.Address)
-	}
-	return nil
+	start, err := h.parseAddr(c.Args[0], 0)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	end, err := h.parseAddr(c.Args[1], 0)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	if end < start {
+		h.println("End address must be greater than or equal to start address.")
+		return nil
+	}
+
+	action, ok := cpu.CodeAction(0), false
+	for a, name := range codeActionNames {
+		if strings.ToLower(c.Args[2]) == name {
+			action, ok = a, true
+		}
+	}
+	if !ok {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	h.codeCheck.SetAction(start, int(end)-int(start)+1, action)
+	h.printf("Code faults at $%04X-$%04X set to %s.\n", start, end, codeActionNames[action])
+	return nil
+}
+
+func (h *Host) cmdCoverageReport(c cmd.Selection) error {
+	files := h.sourceCoverage()
+	if len(files) == 0 {
+		h.println("No source code loaded.")
+		return nil
+	}
+
+	if len(c.Args) == 0 {
+		h.writeCoverageReport(h.output, files)
+		return nil
+	}
+
+	err := h.writeCoverageFile(c.Args[0], files, h.writeCoverageReport)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	h.printf("Coverage report written to '%s'.\n", c.Args[0])
+	return nil
+}
+
+func (h *Host) cmdCoverageLcov(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	files := h.sourceCoverage()
+	if len(files) == 0 {
+		h.println("No source code loaded.")
+		return nil
+	}
+
+	err := h.writeCoverageFile(c.Args[0], files, h.writeLcov)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	h.printf("Coverage tracefile written to '%s'.\n", c.Args[0])
+	return nil
+}
+
+func (h *Host) cmdCoverageClear(c cmd.Selection) error {
+	h.coverage.Clear()
+	h.println("Coverage counts cleared.")
+	return nil
+}
+
+// Create a file and write the coverage to it using the write function.
+func (h *Host) writeCoverageFile(filename string, files []*fileCoverage,
+	write func(w io.Writer, files []*fileCoverage) error) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return write(file, files)
+}
+
+func (h *Host) cmdDisassemble(c cmd.Selection) error {
+	if len(c.Args) == 0 {
+		c.Args = []string{"$"}
+	}
+
+	addr, err := h.parseAddr(c.Args[0], h.settings.NextDisasmAddr)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	lines := h.settings.DisasmLines
+	if len(c.Args) > 1 {
+		l, err := h.parseExpr(c.Args[1])
+		if err != nil {
+			h.printf("%v\n", err)
+			return nil
+		}
+		lines = int(l)
+	}
+
+	for i := 0; i < lines; i++ {
+		d, next := h.disassemble(addr, displayAnnotations)
+		h.println(d)
+		addr = next
+	}
+
+	h.settings.NextDisasmAddr = addr
+	h.lastCmd.Args = []string{"$", fmt.Sprintf("%d", lines)}
+	return nil
+}
+
+func (h *Host) cmdExports(c cmd.Selection) error {
+	if len(h.sourceMap.Exports) == 0 {
+		h.println("No active exports.")
+		return nil
+	}
+
+	h.println("Exported addresses:")
+	for _, e := range h.sourceMap.Exports {
+		h.printf("   %-16s $%04X\n", e.Label, e.Address)
+	}
+	return nil
 }
 
 func (h *Host) cmdEvaluate(c cmd.Selection) error {
@@ -873,14 +1495,14 @@ func (h *Host) cmdList(c cmd.Selection) error {
 	// Keep track of the last displayed line number for each source file.
 	last := make(map[string]int)
 
-	b := make([]byte, 3)
+	b := make([]byte, 4)
 
 	// Search around the address for an address with source code, and attempt
 	// to display the first source code line.
 	for _, o := range []int{0, -1, -2, +1, +2, -3, +3, -4, +4, -5, +5} {
 		orig := uint16(int(addr) + o)
 
-		fn, li, err := h.sourceMap.Find(int(orig))
+		fn, li, err := h.sourceMap.FindInBank(int(orig), h.bank(orig))
 		if err != nil {
 			continue
 		}
@@ -890,11 +1512,11 @@ func (h *Host) cmdList(c cmd.Selection) error {
 			continue
 		}
 
-		_, addr = disasm.Disassemble(h.cpu.Mem, orig)
+		_, addr = h.disassembleInst(orig)
 		cn := addr - orig
 		h.cpu.Mem.LoadBytes(orig, b[:cn])
 		cs := codeString(b[:cn])
-		h.printf("%04X- %-8s\t%s\n", orig, cs, lines[li-1])
+		h.printf("%s- %-8s\t%s\n", h.addrString(orig, h.bank(orig)), cs, lines[li-1])
 
 		last[fn] = li
 		break
@@ -909,7 +1531,7 @@ func (h *Host) cmdList(c cmd.Selection) error {
 	for i := 0; i < nl-1; i++ {
 		orig := addr
 
-		fn, li, err := h.sourceMap.Find(int(orig))
+		fn, li, err := h.sourceMap.FindInBank(int(orig), h.bank(orig))
 		if err != nil {
 			continue
 		}
@@ -920,7 +1542,7 @@ func (h *Host) cmdList(c cmd.Selection) error {
 			continue
 		}
 
-		_, addr = disasm.Disassemble(h.cpu.Mem, orig)
+		_, addr = h.disassembleInst(orig)
 		cn := addr - orig
 		h.cpu.Mem.LoadBytes(orig, b[:cn])
 		cs := codeString(b[:cn])
@@ -935,7 +1557,7 @@ func (h *Host) cmdList(c cmd.Selection) error {
 			if i == j-1 {
 				c = cs
 			}
-			h.printf("%04X- %-8s\t%s\n", orig, c, lines[i])
+			h.printf("%s- %-8s\t%s\n", h.addrString(orig, h.bank(orig)), c, lines[i])
 		}
 
 		last[fn] = li
@@ -1019,6 +1641,7 @@ func (h *Host) cmdMemorySet(c cmd.Selection) error {
 			return nil
 		}
 		h.mem.StoreByte(addr, byte(v))
+		h.markInitialized(addr, 1)
 		addr++
 	}
 
@@ -1057,10 +1680,272 @@ func (h *Host) cmdMemoryCopy(c cmd.Selection) error {
 	b := make([]byte, src1-src0+1)
 	h.cpu.Mem.LoadBytes(src0, b)
 	h.cpu.Mem.StoreBytes(dst, b)
+	h.markInitialized(dst, len(b))
 	h.printf("%d bytes copied from $%04X to $%04X.\n", len(b), src0, dst)
 	return nil
 }
 
+func (h *Host) cmdPower(c cmd.Selection) error {
+	fill, err := parseFill(h.settings.PowerOnFill)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	seed := int64(h.settings.PowerOnSeed)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	cpu.FillRAM(h.mem, fill(seed))
+
+	// The reset sequence's vector fetch isn't reported. The memory checker
+	// starts over once the CPU has been reset.
+	h.markInitialized(0xfffc, 2)
+	h.cpu.Reset()
+	if h.initCheck != nil {
+		h.initCheck.Reset()
+	}
+	if h.history != nil {
+		h.history.Clear()
+	}
+	h.callStack.Clear()
+	if h.stackCheck != nil {
+		h.stackCheck.Reset()
+	}
+
+	switch strings.ToLower(h.settings.PowerOnFill) {
+	case "random":
+		h.printf("Memory filled with random values (seed %d).\n", seed)
+	default:
+		h.printf("Memory filled with '%s'.\n", h.settings.PowerOnFill)
+	}
+	h.displayPC()
+	return nil
+}
+
+// Parse the PowerOnFill setting, which is "zero", "random" or a pattern of
+// hexadecimal bytes. The returned function creates the fill function from a
+// random seed.
+func parseFill(s string) (func(seed int64) cpu.FillFunc, error) {
+	switch strings.ToLower(s) {
+	case "zero":
+		return func(int64) cpu.FillFunc { return cpu.PatternFill([]byte{0}) }, nil
+	case "random":
+		return cpu.RandomFill, nil
+	}
+
+	pattern, err := hex.DecodeString(strings.TrimPrefix(s, "$"))
+	if err != nil || len(pattern) == 0 {
+		return nil, fmt.Errorf("Invalid power-on fill '%s'", s)
+	}
+	return func(int64) cpu.FillFunc { return cpu.PatternFill(pattern) }, nil
+}
+
+func (h *Host) cmdProfileTop(c cmd.Selection) error {
+	count := 10
+	if len(c.Args) > 0 {
+		n, err := h.parseExpr(c.Args[0])
+		if err != nil {
+			h.printf("%v\n", err)
+			return nil
+		}
+		count = int(n)
+	}
+
+	total := h.profiler.Total()
+	if total == 0 {
+		h.println("No cycles profiled.")
+		return nil
+	}
+
+	routines := h.routineProfiles(h.profiler.Samples())
+	h.printf("Total: %d cycles\n", total)
+	h.println("        Self  Self%         Cum   Cum%  Routine")
+	for i := 0; i < count && i < len(routines); i++ {
+		r := routines[i]
+		h.printf("%12d %5.1f%% %11d %5.1f%%  %s\n", r.self, float64(r.self)*100/float64(total),
+			r.cum, float64(r.cum)*100/float64(total), r.name)
+	}
+	return nil
+}
+
+func (h *Host) cmdProfileWrite(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	if h.profiler.Total() == 0 {
+		h.println("No cycles profiled.")
+		return nil
+	}
+
+	file, err := os.OpenFile(c.Args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	defer file.Close()
+
+	if err := h.writeProfile(file, h.profiler.Samples()); err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	h.printf("Profile written to '%s'.\n", c.Args[0])
+	return nil
+}
+
+func (h *Host) cmdProfileClear(c cmd.Selection) error {
+	h.profiler.Clear()
+	h.println("Profile cleared.")
+	return nil
+}
+
+func (h *Host) cmdTraceOn(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	if h.tracing {
+		h.closeTraceLog()
+	}
+
+	if err := h.traceLog.open(c.Args[0]); err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	h.cpu.AttachTracer(h.traceLog)
+	h.tracing = true
+
+	if h.traceLog.start != nil {
+		h.printf("Tracing to '%s' from $%04X.\n", c.Args[0], *h.traceLog.start)
+	} else {
+		h.printf("Tracing to '%s'.\n", c.Args[0])
+	}
+	return nil
+}
+
+func (h *Host) cmdTraceOff(c cmd.Selection) error {
+	if !h.tracing {
+		h.println("No trace log open.")
+		return nil
+	}
+	h.closeTraceLog()
+	return nil
+}
+
+// Detach the trace log and close its file.
+func (h *Host) closeTraceLog() {
+	h.cpu.DetachTracer(h.traceLog)
+	h.tracing = false
+
+	l := h.traceLog
+	filename, lines := l.filename, l.lines
+	if err := l.close(); err != nil {
+		h.printf("%v\n", err)
+		return
+	}
+	h.printf("Trace log '%s' closed after %d instructions.\n", filename, lines)
+}
+
+func (h *Host) cmdTraceRange(c cmd.Selection) error {
+	if len(c.Args) < 2 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	start, err := h.parseAddr(c.Args[0], 0)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	end, err := h.parseAddr(c.Args[1], 0)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	if end < start {
+		h.println("End address must be greater than or equal to start address.")
+		return nil
+	}
+
+	h.traceLog.ranges = append(h.traceLog.ranges, traceRange{start, end})
+	h.printf("Tracing instructions at $%04X-$%04X.\n", start, end)
+	return nil
+}
+
+func (h *Host) cmdTraceStart(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	addr, err := h.parseAddr(c.Args[0], 0)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	h.traceLog.start = &addr
+	h.printf("Tracing starts when $%04X executes.\n", addr)
+	return nil
+}
+
+func (h *Host) cmdTraceStop(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	addr, err := h.parseAddr(c.Args[0], 0)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	h.traceLog.stop = &addr
+	h.printf("Tracing stops after $%04X executes.\n", addr)
+	return nil
+}
+
+func (h *Host) cmdTraceClear(c cmd.Selection) error {
+	l := h.traceLog
+	l.ranges, l.start, l.stop = nil, nil, nil
+	l.active = true
+	h.println("Trace filters cleared.")
+	return nil
+}
+
+func (h *Host) cmdTraceList(c cmd.Selection) error {
+	l := h.traceLog
+	switch {
+	case !h.tracing:
+		h.println("No trace log open.")
+	case l.active:
+		h.printf("Tracing to '%s' (%d instructions).\n", l.filename, l.lines)
+	default:
+		h.printf("Tracing to '%s' (%d instructions, waiting for start).\n", l.filename, l.lines)
+	}
+
+	h.printf("Format: %s\n", h.settings.TraceFormat)
+	if len(l.ranges) == 0 {
+		h.println("Ranges: all addresses")
+	}
+	for _, r := range l.ranges {
+		h.printf("Range:  $%04X-$%04X\n", r.start, r.end)
+	}
+	if l.start != nil {
+		h.printf("Start:  $%04X\n", *l.start)
+	}
+	if l.stop != nil {
+		h.printf("Stop:   $%04X\n", *l.stop)
+	}
+	return nil
+}
+
 func (h *Host) cmdQuit(c cmd.Selection) error {
 	return errors.New("Exiting program")
 }
@@ -1148,6 +2033,10 @@ func (h *Host) cmdRegister(c cmd.Selection) error {
 }
 
 func (h *Host) cmdRun(c cmd.Selection) error {
+	if len(c.Args) > 0 && strings.ToLower(c.Args[0]) == "back" {
+		return h.cmdRunBack(c)
+	}
+
 	if len(c.Args) > 0 {
 		pc, err := h.parseExpr(c.Args[0])
 		if err != nil {
@@ -1160,9 +2049,7 @@ func (h *Host) cmdRun(c cmd.Selection) error {
 	h.printf("Running from $%04X. Press ctrl-C to break.\n", h.cpu.Reg.PC)
 
 	h.state = stateRunning
-	for h.state == stateRunning {
-		h.step()
-	}
+	h.run(cpu.Budget{})
 
 	if h.state == stateInterrupted {
 		h.displayPC()
@@ -1206,18 +2093,250 @@ func (h *Host) cmdSet(c cmd.Selection) error {
 			}
 		}
 
+		if err == nil {
+			err = h.onSettingsUpdate()
+		}
+
 		if err == nil {
 			h.println("Setting updated.")
 		} else {
 			h.printf("%v\n", err)
 		}
+	}
+
+	return nil
+}
+
+func (h *Host) cmdSnapshotSave(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	filename := c.Args[0]
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	defer file.Close()
 
-		h.onSettingsUpdate()
+	w := bufio.NewWriter(file)
+	err = h.cpu.Save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
 	}
 
+	h.printf("Saved snapshot '%s'.\n", filepath.Base(filename))
 	return nil
 }
 
+func (h *Host) cmdSnapshotLoad(c cmd.Selection) error {
+	if len(c.Args) < 1 {
+		h.displayUsage(c.Command)
+		return nil
+	}
+
+	filename := c.Args[0]
+	file, err := os.Open(filename)
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+	defer file.Close()
+
+	err = h.cpu.Load(bufio.NewReader(file))
+	if err != nil {
+		h.printf("%v\n", err)
+		return nil
+	}
+
+	// The snapshot may have changed the CPU architecture.
+	h.settings.Arch = archName(h.cpu.Arch)
+	h.callStack.Clear()
+	if h.stackCheck != nil {
+		h.stackCheck.Reset()
+	}
+	h.markInitialized(0, 0x10000)
+
+	h.printf("Loaded snapshot '%s'.\n", filepath.Base(filename))
+	h.displayPC()
+	return nil
+}
+
+// Rewind the CPU until it reaches a breakpoint or the beginning of the
+// execution history.
+func (h *Host) cmdRunBack(c cmd.Selection) error {
+	if h.history == nil {
+		h.println("Execution history is disabled.")
+		return nil
+	}
+
+	for {
+		if err := h.history.StepBack(h.cpu); err != nil {
+			if err == cpu.ErrHistoryEmpty {
+				h.println("Reached the beginning of the execution history.")
+			} else {
+				h.printf("%v\n", err)
+			}
+			break
+		}
+
+		pc := h.cpu.Reg.PC
+		b := h.debugger.GetBankBreakpoint(pc, h.bank(pc))
+		if b != nil && !b.Disabled && (b.Condition == nil || b.Condition.Check(h.cpu)) {
+			h.printf("Breakpoint hit at $%04X%s.\n", b.Address, h.bankSuffix(b.Bank))
+			break
+		}
+	}
+	h.callStack.Unwind(h.cpu)
+
+	h.displayPC()
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+	return nil
+}
+
+func (h *Host) cmdStepBack(c cmd.Selection) error {
+	if h.history == nil {
+		h.println("Execution history is disabled.")
+		return nil
+	}
+
+	// Parse the number of steps.
+	count := 1
+	if len(c.Args) > 0 {
+		n, err := h.parseExpr(c.Args[0])
+		if err == nil {
+			count = int(n)
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		if err := h.history.StepBack(h.cpu); err != nil {
+			if err == cpu.ErrHistoryEmpty {
+				h.println("Reached the beginning of the execution history.")
+			} else {
+				h.printf("%v\n", err)
+			}
+			break
+		}
+	}
+	h.callStack.Unwind(h.cpu)
+
+	h.displayPC()
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+	return nil
+}
+
+func (h *Host) cmdHistory(c cmd.Selection) error {
+	if h.history == nil {
+		h.println("Execution history is disabled.")
+		return nil
+	}
+	if h.history.Len() == 0 {
+		h.println("No instructions recorded.")
+		return nil
+	}
+
+	count := h.settings.DisasmLines
+	if len(c.Args) > 0 {
+		n, err := h.parseExpr(c.Args[0])
+		if err != nil {
+			h.printf("%v\n", err)
+			return nil
+		}
+		count = int(n)
+	}
+	if count > h.history.Len() {
+		count = h.history.Len()
+	}
+
+	// Each instruction is shown as it was executed, with the registers and
+	// cycle count before it executed, numbered by how many steps back it
+	// is.
+	n := h.history.Len()
+	for i := n - count; i < n; i++ {
+		e := h.history.Entry(i)
+		r := &e.Reg
+		m16 := h.cpu.Arch == cpu.W65C816 && !r.Emulation && !r.MemorySelect
+		x16 := h.cpu.Arch == cpu.W65C816 && !r.Emulation && !r.IndexSelect
+		line := disasm.DisassembleCode(e.Code, e.PC, h.cpu.Arch, m16, x16)
+		h.printf("%6d  %s-   %-8s    %-15s %s C=%d\n", i-n,
+			h.addrString(e.PC, e.Bank), codeString(e.Code), line,
+			disasm.GetRegisterString(&e.Reg), e.Cycles)
+	}
+	return nil
+}
+
+func (h *Host) cmdBacktrace(c cmd.Selection) error {
+	frames := h.callStack.Frames
+
+	h.printf("#0  %s\n", h.frameString(h.cpu.Reg.PC))
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := &frames[i]
+		var kind string
+		switch f.Kind {
+		case cpu.CallBreak:
+			kind = " (break)"
+		case cpu.CallInterrupt:
+			kind = " (interrupt)"
+		}
+		h.printf("#%-2d %s%s\n", len(frames)-i, h.frameString(f.Site), kind)
+	}
+
+	if h.mismatch != nil {
+		m := h.mismatch
+		if m.Discarded {
+			h.printf("Warning: the return at $%04X discarded the call from $%04X to $%04X.\n",
+				m.PC, m.Frame.Site, m.Frame.Target)
+		} else {
+			h.printf("Warning: the return at $%04X went to $%04X instead of $%04X.\n",
+				m.PC, m.Return, m.Frame.Return)
+		}
+		if h.callStack.Mismatches > 1 {
+			h.printf("%d call stack mismatches have been detected.\n", h.callStack.Mismatches)
+		}
+	}
+	return nil
+}
+
+// Return a string describing a call stack frame's address, including its
+// nearest exported label and source line if they are known.
+func (h *Host) frameString(addr uint16) string {
+	bank := h.bank(addr)
+	s := "$" + h.addrString(addr, bank)
+
+	label := h.nearestExport(addr)
+	switch {
+	case label == nil:
+	case label.Address == addr:
+		s += " in " + label.Label
+	default:
+		s += fmt.Sprintf(" in %s+%d", label.Label, addr-label.Address)
+	}
+
+	if fn, line, err := h.sourceMap.FindInBank(int(addr), bank); err == nil {
+		s += fmt.Sprintf(" at %s:%d", filepath.Base(fn), line)
+	}
+	return s
+}
+
+// Return the exported label nearest to the address at or before it, or nil
+// if there is none.
+func (h *Host) nearestExport(addr uint16) *asm.Export {
+	var label *asm.Export
+	for i, e := range h.sourceMap.Exports {
+		if e.Address <= addr && (label == nil || e.Address > label.Address) {
+			label = &h.sourceMap.Exports[i]
+		}
+	}
+	return label
+}
+
 func (h *Host) cmdStepIn(c cmd.Selection) error {
 	// Parse the number of steps.
 	count := 1
@@ -1278,6 +2397,22 @@ func (h *Host) cmdStepOver(c cmd.Selection) error {
 	return nil
 }
 
+func (h *Host) cmdStepOut(c cmd.Selection) error {
+	depth := h.callStack.Depth()
+	if depth == 0 {
+		h.println("Not in a subroutine.")
+		return nil
+	}
+
+	h.state = stateRunning
+	h.stepToDepth(depth - 1)
+	h.displayPC()
+
+	h.state = stateProcessingCommands
+	h.settings.NextDisasmAddr = h.cpu.Reg.PC
+	return nil
+}
+
 func (h *Host) load(filename string, addr int) (origin uint16, err error) {
 	filename, err = filepath.Abs(filename)
 	basefile := filepath.Base(filename)
@@ -1351,48 +2486,287 @@ func (h *Host) load(filename string, addr int) (origin uint16, err error) {
 		return 0, nil
 	}
 
-	// Copy the code to the CPU memory and adjust the program counter.
+	// Copy the code to the CPU memory and adjust the program counter. Code
+	// with a banked source map is copied into its bank.
+	if m, ok := h.mem.(cpu.Banked); ok && sourceMap != nil {
+		bank := m.Bank(origin)
+		m.SetBank(origin, sourceMap.Bank)
+		defer m.SetBank(origin, bank)
+	}
 	h.cpu.Mem.StoreBytes(origin, a.Code)
+	h.markInitialized(origin, len(a.Code))
+	h.markCode(origin, len(a.Code), sourceMap)
 	h.printf("Loaded '%s' to $%04X..$%04X.\n", basefile, origin, int(origin)+len(a.Code)-1)
 
 	h.settings.NextDisasmAddr = origin
 	return origin, nil
 }
 
+// Run the CPU until the budget is exhausted or something stops it. Break
+// cancels the run.
+func (h *Host) run(budget cpu.Budget) cpu.Stop {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+
+	// Breakpoints that continue after their actions run don't stop the
+	// CPU. When running with a budget, the CPU stops anyway so the budget
+	// isn't exceeded.
+	var stop cpu.Stop
+	for {
+		stop = h.cpu.Run(ctx, budget)
+		a, ok := h.actions[stop.Breakpoint]
+		if stop.Reason != cpu.StopBreakpoint || !ok || !a.cont {
+			break
+		}
+		h.runActions(stop.Breakpoint)
+		if budget != (cpu.Budget{}) {
+			stop.Reason = cpu.StopBudget
+			break
+		}
+	}
+
+	h.cancel = nil
+	cancel()
+
+	if stop.Reason != cpu.StopBudget {
+		h.onStop(stop)
+	}
+	return stop
+}
+
 func (h *Host) step() {
-	h.cpu.Step()
+	h.run(cpu.Budget{Instructions: 1})
 }
 
 func (h *Host) stepOver() {
-	cpu := h.cpu
-
-	inst := cpu.GetInstruction(cpu.Reg.PC)
-	nextaddr := cpu.Reg.PC + uint16(inst.Length)
-	cpu.Step()
-
-	// If a JSR was just stepped, keep stepping until the return address
-	// is hit or a corresponding RTS is stepped.
-	if inst.Name == "JSR" {
-		count := 1
-	loop:
-		for h.state == stateRunning && cpu.Reg.PC != nextaddr {
-			inst := cpu.GetInstruction(cpu.Reg.PC)
-			cpu.Step()
-			switch inst.Name {
-			case "JSR":
-				count++
-			case "RTS":
-				count--
-				if count == 0 {
-					break loop
-				}
+	depth := h.callStack.Depth()
+	h.step()
+
+	// If a subroutine was just called, keep stepping until it returns.
+	h.stepToDepth(depth)
+}
+
+// Step the CPU until the call stack is no deeper than 'depth'.
+func (h *Host) stepToDepth(depth int) {
+	for h.state == stateRunning && h.callStack.Depth() > depth {
+		h.step()
+	}
+}
+
+func (h *Host) onSettingsUpdate() error {
+	h.exprParser.hexMode = h.settings.HexMode
+
+	arch, ok := archNames[strings.ToLower(h.settings.Arch)]
+	if !ok {
+		err := fmt.Errorf("Invalid architecture '%s'", h.settings.Arch)
+		h.settings.Arch = archName(h.cpu.Arch)
+		return err
+	}
+
+	if arch != h.cpu.Arch {
+		h.setArch(arch)
+	}
+
+	policy, ok := undefinedPolicies[strings.ToLower(h.settings.Undefined)]
+	if !ok {
+		err := fmt.Errorf("Invalid undefined opcode handling '%s'", h.settings.Undefined)
+		for name, p := range undefinedPolicies {
+			if p == h.cpu.Undefined {
+				h.settings.Undefined = name
 			}
 		}
+		return err
+	}
+	h.cpu.Undefined = policy
+
+	switch {
+	case h.settings.HistorySize <= 0:
+		h.settings.HistorySize = 0
+		h.cpu.DetachHistory()
+		h.history = nil
+	case h.history == nil || h.history.Size() != h.settings.HistorySize:
+		h.history = cpu.NewHistory(h.settings.HistorySize)
+		h.cpu.AttachHistory(h.history)
 	}
+
+	switch {
+	case h.settings.StackCheck && h.stackCheck == nil:
+		h.stackCheck = cpu.NewStackChecker()
+		h.cpu.AttachTracer(h.stackCheck)
+	case !h.settings.StackCheck && h.stackCheck != nil:
+		h.cpu.DetachTracer(h.stackCheck)
+		h.stackCheck = nil
+	}
+	if h.stackCheck != nil {
+		h.stackCheck.Floor = h.settings.StackFloor
+		h.stackCheck.CheckReturns = h.settings.StackReturns
+	}
+
+	switch {
+	case h.settings.UninitCheck && h.initCheck == nil:
+		h.initCheck = cpu.NewInitChecker()
+		h.initCheck.OnUninitialized = func(c *cpu.CPU, r *cpu.UninitializedRead) {
+			h.printf("Uninitialized read of $%04X by instruction at $%04X.\n", r.Address, r.PC)
+		}
+		h.cpu.AttachTracer(h.initCheck)
+		h.cpu.AttachMemoryHook(h.initCheck)
+	case !h.settings.UninitCheck && h.initCheck != nil:
+		h.cpu.DetachTracer(h.initCheck)
+		h.cpu.DetachMemoryHook(h.initCheck)
+		h.initCheck = nil
+	}
+
+	if h.settings.CodeCheck != h.codeChecked {
+		switch h.settings.CodeCheck {
+		case true:
+			h.cpu.AttachTracer(h.codeCheck)
+			h.cpu.AttachMemoryHook(h.codeCheck)
+		case false:
+			h.cpu.DetachTracer(h.codeCheck)
+			h.cpu.DetachMemoryHook(h.codeCheck)
+		}
+		h.codeChecked = h.settings.CodeCheck
+	}
+
+	if h.settings.Coverage != h.covering {
+		switch h.settings.Coverage {
+		case true:
+			h.cpu.AttachTracer(h.coverage)
+		case false:
+			h.cpu.DetachTracer(h.coverage)
+		}
+		h.covering = h.settings.Coverage
+	}
+
+	if h.settings.Profile != h.profiling {
+		switch h.settings.Profile {
+		case true:
+			h.cpu.AttachTracer(h.profiler)
+		case false:
+			h.cpu.DetachTracer(h.profiler)
+		}
+		h.profiling = h.settings.Profile
+	}
+
+	if _, err := parseFill(h.settings.PowerOnFill); err != nil {
+		h.settings.PowerOnFill = "zero"
+		return err
+	}
+
+	fields, err := parseTraceFormat(h.settings.TraceFormat)
+	if err != nil {
+		h.settings.TraceFormat = "go6502"
+		fields, _ = parseTraceFormat(h.settings.TraceFormat)
+		h.traceLog.fields = fields
+		return err
+	}
+	h.traceLog.fields = fields
+	return nil
 }
 
-func (h *Host) onSettingsUpdate() {
-	h.exprParser.hexMode = h.settings.HexMode
+// Record which addresses of loaded code hold instructions and which hold
+// data. Without a source map, the loaded addresses are unknown.
+func (h *Host) markCode(origin uint16, size int, sourceMap *asm.SourceMap) {
+	h.codeCheck.Unmark(origin, size)
+	if sourceMap == nil {
+		return
+	}
+	for _, r := range sourceMap.Ranges {
+		switch r.Data {
+		case true:
+			h.codeCheck.MarkData(uint16(r.Address), r.Size)
+		case false:
+			h.codeCheck.MarkInstructions(uint16(r.Address), r.Size)
+		}
+	}
+}
+
+// Mark bytes stored into memory by the host as initialized, so the
+// uninitialized memory checker doesn't report reads of them.
+func (h *Host) markInitialized(addr uint16, n int) {
+	if h.initCheck != nil {
+		h.initCheck.MarkInitialized(addr, n)
+	}
+}
+
+// Return the bank mapped at the address if the host's memory is banked.
+func (h *Host) bank(addr uint16) int {
+	if m, ok := h.mem.(cpu.Banked); ok {
+		return m.Bank(addr)
+	}
+	return 0
+}
+
+// Return a hexadecimal address string, prefixed by the bank number when the
+// host's memory is banked.
+func (h *Host) addrString(addr uint16, bank int) string {
+	if _, ok := h.mem.(cpu.Banked); ok {
+		return fmt.Sprintf("%02X:%04X", bank, addr)
+	}
+	return fmt.Sprintf("%04X", addr)
+}
+
+// Return a suffix describing the bank of an address in messages, or an
+// empty string if the host's memory isn't banked.
+func (h *Host) bankSuffix(bank int) string {
+	if _, ok := h.mem.(cpu.Banked); ok {
+		return fmt.Sprintf(" in bank %d", bank)
+	}
+	return ""
+}
+
+// Parse an address and an optional bank number. When the bank isn't
+// specified, the bank currently mapped at the address is used.
+func (h *Host) parseBankAddr(args []string) (addr uint16, bank int, err error) {
+	addr, err = h.parseExpr(args[0])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bank = h.bank(addr)
+	if len(args) > 1 {
+		b, err := h.parseExpr(args[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		bank = int(b)
+	}
+	return addr, bank, nil
+}
+
+// Replace the emulated CPU with one of a different architecture. The
+// memory, registers and cycle count carry over to the new CPU.
+func (h *Host) setArch(arch cpu.Architecture) {
+	reg, cycles := h.cpu.Reg, h.cpu.Cycles
+	h.cpu = cpu.NewCPU(arch, h.mem)
+	h.cpu.Reg = reg
+	h.cpu.Cycles = cycles
+	h.cpu.Undefined = undefinedPolicies[strings.ToLower(h.settings.Undefined)]
+	h.cpu.AttachDebugger(h.debugger)
+	h.cpu.AttachTracer(h.callStack)
+	if h.stackCheck != nil {
+		h.cpu.AttachTracer(h.stackCheck)
+	}
+	if h.initCheck != nil {
+		h.cpu.AttachTracer(h.initCheck)
+		h.cpu.AttachMemoryHook(h.initCheck)
+	}
+	if h.codeChecked {
+		h.cpu.AttachTracer(h.codeCheck)
+		h.cpu.AttachMemoryHook(h.codeCheck)
+	}
+	if h.covering {
+		h.cpu.AttachTracer(h.coverage)
+	}
+	if h.profiling {
+		h.cpu.AttachTracer(h.profiler)
+	}
+	if h.tracing {
+		h.cpu.AttachTracer(h.traceLog)
+	}
+	if h.history != nil {
+		h.cpu.AttachHistory(h.history)
+	}
 }
 
 func (h *Host) parseAddr(s string, next uint16) (uint16, error) {
@@ -1423,23 +2797,63 @@ func (h *Host) parseExpr(expr string) (uint16, error) {
 	return uint16(v), nil
 }
 
-func (h *Host) disassemble(addr uint16, flags displayFlags) (str string, next uint16) {
-	cpu := h.cpu
+// Parse an expression whose value must fit in a byte.
+func (h *Host) parseByte(expr string) (byte, error) {
+	v, err := h.parseExpr(expr)
+	if err != nil {
+		return 0, err
+	}
+	if v > 0xff {
+		return 0, fmt.Errorf("value $%X doesn't fit in a byte", v)
+	}
+	return byte(v), nil
+}
+
+// Disassemble the instruction at address 'addr' using the host CPU's
+// architecture and, on a 65c816, its current register widths and program
+// bank.
+func (h *Host) disassembleInst(addr uint16) (line string, next uint16) {
+	if h.cpu.Arch == cpu.W65C816 {
+		r := &h.cpu.Reg
+		m16 := !r.Emulation && !r.MemorySelect
+		x16 := !r.Emulation && !r.IndexSelect
+		if m, ok := h.mem.(cpu.LongMemory); ok {
+			line, next := disasm.Disassemble65816Long(m, uint32(r.PBR)<<16|uint32(addr), m16, x16)
+			return line, uint16(next)
+		}
+		return disasm.Disassemble65816(h.cpu.Mem, addr, m16, x16)
+	}
+	return disasm.DisassembleArch(h.cpu.Mem, addr, h.cpu.Arch)
+}
 
+// Load the instruction bytes at address 'addr'. On a 65c816, they're
+// loaded from the program bank.
+func (h *Host) loadCode(addr uint16, b []byte) {
+	if m, ok := h.mem.(cpu.LongMemory); ok && h.cpu.Arch == cpu.W65C816 {
+		bank := uint32(h.cpu.Reg.PBR) << 16
+		for i := range b {
+			b[i] = m.LoadByteLong(bank | uint32(addr+uint16(i)))
+		}
+		return
+	}
+	h.cpu.Mem.LoadBytes(addr, b)
+}
+
+func (h *Host) disassemble(addr uint16, flags displayFlags) (str string, next uint16) {
 	var line string
-	line, next = disasm.Disassemble(cpu.Mem, addr)
+	line, next = h.disassembleInst(addr)
 
 	l := next - addr
 	b := make([]byte, l)
-	cpu.Mem.LoadBytes(addr, b)
+	h.loadCode(addr, b)
 
 	if h.settings.CompactMode && (flags&displayVerbose) == 0 {
-		str = fmt.Sprintf("%04X- %-8s  %-15s", addr, codeString(b[:l]), line)
+		str = fmt.Sprintf("%s- %-8s  %-15s", h.addrString(addr, h.bank(addr)), codeString(b[:l]), line)
 		if (flags & displayRegisters) != 0 {
 			str = disasm.GetCompactRegisterString(&h.cpu.Reg) + "  " + str
 		}
 	} else {
-		str = fmt.Sprintf("%04X-   %-8s    %-15s", addr, codeString(b[:l]), line)
+		str = fmt.Sprintf("%s-   %-8s    %-15s", h.addrString(addr, h.bank(addr)), codeString(b[:l]), line)
 
 		if (flags & displayRegisters) != 0 {
 			str += " " + disasm.GetRegisterString(&h.cpu.Reg)
@@ -1584,21 +2998,86 @@ func (h *Host) resolveIdentifier(s string) (int64, error) {
 	return 0, fmt.Errorf("identifier '%s' not found", s)
 }
 
-func (h *Host) onBreakpoint(cpu *cpu.CPU, b *cpu.Breakpoint) {
-	h.state = stateBreakpoint
-	h.printf("Breakpoint hit at $%04X.\n", b.Address)
-	h.displayPC()
+func (h *Host) loadByte(addr uint16) byte {
+	return h.cpu.Mem.LoadByte(addr)
 }
 
-func (h *Host) onDataBreakpoint(cpu *cpu.CPU, b *cpu.DataBreakpoint) {
-	h.printf("Data breakpoint hit on address $%04X.\n", b.Address)
-
-	h.state = stateBreakpoint
+// Describe a fault found by the code checker.
+func (h *Host) printCodeFault(f *cpu.CodeFault) {
+	switch f.Kind {
+	case cpu.CodeModified:
+		h.printf("Code modified at $%04X: the instruction wrote to $%04X.\n", f.PC, f.Address)
+	case cpu.DataExecuted:
+		h.printf("Data executed at $%04X: the program counter entered data at $%04X.\n", f.PC, f.Address)
+	}
+}
 
-	if cpu.LastPC != cpu.Reg.PC {
-		d, _ := h.disassemble(cpu.LastPC, displayAll)
-		h.println(d)
+// Describe a fault found by the stack checker.
+func (h *Host) printStackFault(f *cpu.StackFault) {
+	switch f.Kind {
+	case cpu.StackOverflow:
+		h.printf("Stack overflow at $%04X: the stack pointer wrapped to $%04X.\n", f.PC, f.SP)
+	case cpu.StackUnderflow:
+		h.printf("Stack underflow at $%04X: the stack pointer wrapped to $%04X.\n", f.PC, f.SP)
+	case cpu.StackFloorCrossed:
+		h.printf("Stack floor crossed at $%04X: the stack pointer dropped to $%04X.\n", f.PC, f.SP)
+	case cpu.StackBadReturn:
+		h.printf("Bad return at $%04X: returned to $%04X, which wasn't pushed by a call.\n", f.PC, f.Return)
 	}
+}
 
-	h.displayPC()
+// Report the reason the CPU stopped running before its budget was
+// exhausted.
+func (h *Host) onStop(stop cpu.Stop) {
+	switch stop.Reason {
+	case cpu.StopBreakpoint:
+		b := stop.Breakpoint
+		h.state = stateBreakpoint
+		h.printf("Breakpoint hit at $%04X%s.\n", b.Address, h.bankSuffix(b.Bank))
+		if c, ok := b.Condition.(*breakCondition); ok && c.err != nil {
+			h.printf("Breakpoint condition '%s' failed: %v\n", c.expr, c.err)
+		}
+		h.runActions(b)
+		h.displayPC()
+
+	case cpu.StopDataBreakpoint, cpu.StopWatchpoint, cpu.StopStackFault, cpu.StopCodeFault:
+		switch stop.Reason {
+		case cpu.StopDataBreakpoint:
+			h.printf("Data breakpoint hit on address $%04X.\n", stop.DataBreakpoint.Address)
+		case cpu.StopStackFault:
+			h.printStackFault(stop.Stack)
+		case cpu.StopCodeFault:
+			h.printCodeFault(stop.Code)
+		default:
+			w := stop.Watch
+			switch {
+			case w.Access == cpu.WatchRead:
+				h.printf("Watchpoint %d hit: read $%02X from $%04X.\n", w.Watchpoint.ID, w.New, w.Address)
+			case w.Watchpoint.MatchOld || w.Watchpoint.Changed:
+				h.printf("Watchpoint %d hit: wrote $%02X to $%04X (was $%02X).\n", w.Watchpoint.ID, w.New, w.Address, w.Old)
+			default:
+				h.printf("Watchpoint %d hit: wrote $%02X to $%04X.\n", w.Watchpoint.ID, w.New, w.Address)
+			}
+		}
+
+		h.state = stateBreakpoint
+
+		if h.cpu.LastPC != h.cpu.Reg.PC {
+			d, _ := h.disassemble(h.cpu.LastPC, displayAll)
+			h.println(d)
+		}
+
+		h.displayPC()
+
+	case cpu.StopHalted:
+		h.state = stateBreakpoint
+		if stop.Err != nil {
+			h.printf("Execution stopped: %v.\n", stop.Err)
+		} else {
+			h.println("Execution stopped: the CPU is stopped until reset.")
+		}
+
+	case cpu.StopCanceled:
+		h.state = stateInterrupted
+	}
 }
@@ -0,0 +1,506 @@
+package host
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// Run host commands and return the lines of output they produce.
+func runHost(h *Host, commands string) []string {
+	var b bytes.Buffer
+	h.RunCommands(strings.NewReader(commands), &b, false)
+	return strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+}
+
+// Check that the lines of output end with the expected lines.
+func checkOutput(t *testing.T, got []string, exp ...string) {
+	t.Helper()
+	if len(got) < len(exp) {
+		t.Fatalf("output incorrect. exp: %q, got: %q", exp, got)
+	}
+	got = got[len(got)-len(exp):]
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("output line %d incorrect. exp: %q, got: %q", i, exp[i], got[i])
+		}
+	}
+}
+
+func TestBreakpointActionRejectsRun(t *testing.T) {
+	h := New()
+	runHost(h, "breakpoint add $1000\n")
+
+	actions := []string{
+		"run", "run back", "step in", "step over", "step out", "step back",
+		"s", "si", "so", "sb", "power", "snapshot load x.snap",
+		"execute x.cmd", "assemble interactive",
+	}
+	for _, a := range actions {
+		out := runHost(h, "breakpoint action $1000 "+a+"\n")
+		checkOutput(t, out, "Invalid action '"+a+"': the command can't be used as an action.")
+	}
+
+	b := h.debugger.GetBreakpoint(0x1000)
+	if a, ok := h.actions[b]; ok && len(a.commands) > 0 {
+		t.Errorf("actions added: %q", a.commands)
+	}
+}
+
+func TestBreakpointActionOrder(t *testing.T) {
+	h := New()
+	out := runHost(h, `
+memory set $1000 $E8 $E8 $4C $00 $10
+breakpoint add $1001
+breakpoint action $1001 evaluate 1
+breakpoint action $1001 register
+breakpoint action $1001 evaluate 2
+run $1000
+`)
+	checkOutput(t, out,
+		"Running from $1000. Press ctrl-C to break.",
+		"Breakpoint hit at $1001.",
+		"$0001",
+		"A=00 X=01 Y=00 PS=[------] SP=FF PC=1001 C=2",
+		"$0002",
+	)
+
+	// The actions don't replace the command repeated by an empty line.
+	out = runHost(h, "\n")
+	checkOutput(t, out,
+		"Running from $1000. Press ctrl-C to break.",
+		"Breakpoint hit at $1001.",
+		"$0001",
+		"A=00 X=02 Y=00 PS=[------] SP=FF PC=1001 C=4",
+		"$0002",
+	)
+}
+
+func TestBreakpointContinue(t *testing.T) {
+	h := New()
+
+	// Loop until X is 3, then reach the breakpoint at $1005, which stops
+	// the CPU. The breakpoint at $1000 isn't hit when the run starts there.
+	out := runHost(h, `
+memory set $1000 $E8 $E0 $03 $D0 $FB $EA
+breakpoint add $1000
+breakpoint action $1000 register
+breakpoint continue $1000
+breakpoint add $1005
+run $1000
+`)
+	checkOutput(t, out,
+		"Running from $1000. Press ctrl-C to break.",
+		"A=00 X=01 Y=00 PS=[N-----] SP=FF PC=1000 C=7",
+		"A=00 X=02 Y=00 PS=[N-----] SP=FF PC=1000 C=14",
+		"Breakpoint hit at $1005.",
+	)
+
+	// A step runs the actions of a breakpoint it reaches, and then stops
+	// instead of continuing.
+	out = runHost(h, "register PC $1001\nregister X 0\nstep in 2\nregister\n")
+	checkOutput(t, out,
+		"A=00 X=00 Y=00 PS=[N-----] SP=FF PC=1000 C=25",
+		"A=00 X=00 Y=00 PS=[N-----] SP=FF PC=1000 C=25",
+	)
+}
+
+func TestBreakpointCondition(t *testing.T) {
+	// The condition is parsed when it is set, so turning on hexadecimal
+	// input afterward doesn't change it.
+	h := New()
+	out := runHost(h, `
+memory set $1000 $A9 $0A $EA $00
+breakpoint add $1002
+breakpoint condition $1002 a == 10
+set HexMode true
+run $1000
+`)
+	checkOutput(t, out,
+		"Running from $1000. Press ctrl-C to break.",
+		"Breakpoint hit at $1002.",
+	)
+}
+
+func TestBreakpointBank(t *testing.T) {
+	mem := cpu.NewBankedMemory()
+	if _, err := mem.AddWindow(0x8000, 0x1000, 4, 0x7fff); err != nil {
+		t.Fatal(err)
+	}
+	h := NewWithMemory(mem)
+
+	// The breakpoint commands accept a bank other than the one mapped.
+	out := runHost(h, `
+breakpoint add $8000 2
+breakpoint condition $8000 bank 2 a == 1
+breakpoint action $8000 bank 2 register
+breakpoint continue $8000 on 2
+breakpoint condition $8000 a == 1
+breakpoint list
+`)
+	checkOutput(t, out,
+		"Breakpoint at $8000 in bank 2 is now hit only when a == 1.",
+		"Breakpoint at $8000 in bank 2 action added: register",
+		"Breakpoint at $8000 in bank 2 now continues after its actions.",
+		"No breakpoint was set on $8000 in bank 0.",
+		"Breakpoints:",
+		"   $02:8000 when a == 1, continues ",
+		"      > register",
+	)
+}
+
+func TestExprCompile(t *testing.T) {
+	h := New()
+	e, err := h.exprParser.Compile("a + [$10] * 2 == -(x - 9)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Identifiers and memory are read each time the expression is
+	// evaluated.
+	h.cpu.Reg.A, h.cpu.Reg.X = 1, 2
+	h.cpu.Mem.StoreByte(0x10, 3)
+	if v, err := e.Eval(h); v != 1 || err != nil {
+		t.Errorf("Eval incorrect. exp: 1, got: %d (%v)", v, err)
+	}
+	h.cpu.Reg.A = 2
+	if v, err := e.Eval(h); v != 0 || err != nil {
+		t.Errorf("Eval incorrect. exp: 0, got: %d (%v)", v, err)
+	}
+
+	for _, s := range []string{"", "1 2", "1 +", "(1", "[1", "1)", "!"} {
+		if _, err := h.exprParser.Compile(s); err == nil {
+			t.Errorf("Invalid expression '%s' not rejected.", s)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	// The history shows the instructions as they were executed, even after
+	// the code is overwritten.
+	h := New()
+	out := runHost(h, "memory set $1000 $E8 $8D $00 $10\nregister PC $1000\nstep in 2\nhistory 2\n")
+	checkOutput(t, out,
+		"    -2  1000-   E8          INX             A=00 X=00 Y=00 PS=[------] SP=FF PC=1000 C=0",
+		"    -1  1001-   8D 00 10    STA   $1000     A=00 X=01 Y=00 PS=[------] SP=FF PC=1001 C=2",
+	)
+}
+
+// A protoMessage is a decoded protobuf message. It holds the values of
+// each field in the order they were encoded: a uint64 for a varint field
+// and a []byte for a length-delimited field.
+type protoMessage map[int][]interface{}
+
+// Decode a protobuf varint, and return it with the number of bytes it
+// used. The number is zero if the varint is invalid.
+func protoVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// Decode a protobuf message containing only varint and length-delimited
+// fields.
+func decodeProto(t *testing.T, b []byte) protoMessage {
+	t.Helper()
+	m := make(protoMessage)
+	for len(b) > 0 {
+		key, n := protoVarint(b)
+		if n == 0 {
+			t.Fatalf("invalid field key")
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := protoVarint(b)
+			if n == 0 {
+				t.Fatalf("field %d: invalid varint", field)
+			}
+			b = b[n:]
+			m[field] = append(m[field], v)
+		case 2:
+			l, n := protoVarint(b)
+			if n == 0 || uint64(len(b)-n) < l {
+				t.Fatalf("field %d: invalid length", field)
+			}
+			m[field] = append(m[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("field %d: unexpected wire type %d", field, key&7)
+		}
+	}
+	return m
+}
+
+// Return the value of a varint field, or zero if the field is absent.
+func (m protoMessage) uint(field int) uint64 {
+	if v := m[field]; len(v) > 0 {
+		u, _ := v[0].(uint64)
+		return u
+	}
+	return 0
+}
+
+// Return the values of a repeated varint field in packed form.
+func (m protoMessage) packed(t *testing.T, field int) []uint64 {
+	t.Helper()
+	var vs []uint64
+	for _, v := range m[field] {
+		b, _ := v.([]byte)
+		for len(b) > 0 {
+			u, n := protoVarint(b)
+			if n == 0 {
+				t.Fatalf("field %d: invalid packed varint", field)
+			}
+			vs = append(vs, u)
+			b = b[n:]
+		}
+	}
+	return vs
+}
+
+// Decode the values of an embedded message field.
+func (m protoMessage) messages(t *testing.T, field int) []protoMessage {
+	t.Helper()
+	var ms []protoMessage
+	for _, v := range m[field] {
+		b, _ := v.([]byte)
+		ms = append(ms, decodeProto(t, b))
+	}
+	return ms
+}
+
+func TestWriteProfile(t *testing.T) {
+	h := New()
+	samples := []cpu.ProfileSample{
+		{
+			Stack: []cpu.ProfileLocation{
+				{PC: 0x1234, Routine: 0x1230, Called: true},
+				{PC: 0x1006},
+			},
+			Cycles: 10, Instructions: 4,
+		},
+		{Stack: []cpu.ProfileLocation{{PC: 0x1008}}, Cycles: 5, Instructions: 2},
+		{
+			Stack: []cpu.ProfileLocation{
+				{PC: 0x1236, Routine: 0x1230, Called: true},
+				{PC: 0x1006},
+			},
+			Cycles: 3, Instructions: 1,
+		},
+	}
+
+	var b bytes.Buffer
+	if err := h.writeProfile(&b, samples); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := decodeProto(t, data)
+
+	// The fields are numbered as in pprof's profile.proto. Strings are
+	// indices into the string table, which starts with the empty string.
+	var strs []string
+	for _, v := range p[6] {
+		s, _ := v.([]byte)
+		strs = append(strs, string(s))
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("String table incorrect. got: %q", strs)
+	}
+	str := func(i uint64) string {
+		if i >= uint64(len(strs)) {
+			t.Fatalf("String index %d out of range", i)
+		}
+		return strs[i]
+	}
+
+	var types []string
+	for _, st := range p.messages(t, 1) {
+		types = append(types, str(st.uint(1))+"/"+str(st.uint(2)))
+	}
+	if got := strings.Join(types, " "); got != "instructions/count cycles/count" {
+		t.Errorf("Sample types incorrect. got: %s", got)
+	}
+	period := p.messages(t, 11)
+	if str(p.uint(14)) != "cycles" || p.uint(12) != 1 || len(period) != 1 || str(period[0].uint(1)) != "cycles" {
+		t.Errorf("Period incorrect. got: %s %d %v", str(p.uint(14)), p.uint(12), period)
+	}
+	if m := p.messages(t, 3); len(m) != 1 || m[0].uint(1) != 1 {
+		t.Errorf("Mapping incorrect. got: %v", m)
+	}
+
+	funcs := make(map[uint64]string)
+	for _, f := range p.messages(t, 5) {
+		funcs[f.uint(1)] = str(f.uint(2))
+	}
+	type location struct {
+		addr uint64
+		fn   uint64
+	}
+	locs := make(map[uint64]location)
+	for _, l := range p.messages(t, 4) {
+		lines := l.messages(t, 4)
+		if l.uint(2) != 1 || len(lines) != 1 {
+			t.Fatalf("Location %d incorrect. got: %v", l.uint(1), l)
+		}
+		locs[l.uint(1)] = location{l.uint(3), lines[0].uint(1)}
+	}
+
+	// Each sample's stack is a list of locations, innermost first, whose
+	// addresses are the profile's PCs and whose functions are named by the
+	// subroutines containing them.
+	exp := []string{
+		"4 10 $1234:$1230 $1006:(top level)",
+		"2 5 $1008:(top level)",
+		"1 3 $1236:$1230 $1006:(top level)",
+	}
+	ss := p.messages(t, 2)
+	if len(ss) != len(exp) {
+		t.Fatalf("Sample count incorrect. exp: %d, got: %d", len(exp), len(ss))
+	}
+	for i, s := range ss {
+		v := s.packed(t, 2)
+		if len(v) != 2 {
+			t.Fatalf("Sample %d values incorrect. got: %v", i, v)
+		}
+		got := fmt.Sprintf("%d %d", v[0], v[1])
+		for _, id := range s.packed(t, 1) {
+			l, ok := locs[id]
+			name, ok2 := funcs[l.fn]
+			if !ok || !ok2 {
+				t.Fatalf("Sample %d location %d not found", i, id)
+			}
+			got += fmt.Sprintf(" $%04X:%s", l.addr, name)
+		}
+		if got != exp[i] {
+			t.Errorf("Sample %d incorrect. exp: %q, got: %q", i, exp[i], got)
+		}
+	}
+
+	// Locations and functions are shared between samples.
+	if len(locs) != 4 || len(funcs) != 2 {
+		t.Errorf("Locations and functions incorrect. exp: 4 2, got: %d %d", len(locs), len(funcs))
+	}
+}
+
+func TestParseTraceFormat(t *testing.T) {
+	f, err := parseTraceFormat("{PC:6}|{a}")
+	exp := []traceField{{token: "pc", width: 6}, {text: "|"}, {token: "a"}}
+	if err != nil || fmt.Sprint(f) != fmt.Sprint(exp) {
+		t.Errorf("Format incorrect. exp: %v, got: %v (%v)", exp, f, err)
+	}
+
+	// Preset names are case-insensitive.
+	for _, name := range []string{"go6502", "NESTEST", "Addr"} {
+		if _, err := parseTraceFormat(name); err != nil {
+			t.Errorf("Preset %s failed: %v", name, err)
+		}
+	}
+
+	for _, s := range []string{"plain", "{pc", "{pc:x}", "{pc:-1}", "{bogus}"} {
+		if _, err := parseTraceFormat(s); err == nil {
+			t.Errorf("Invalid format '%s' not rejected.", s)
+		}
+	}
+}
+
+// Run host commands with a trace log open, and return the lines written to
+// the log.
+func runTrace(t *testing.T, h *Host, commands string) []string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "trace.log")
+	runHost(h, "trace on "+file+"\n"+commands+"trace off\n")
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+}
+
+func TestTraceLogNestest(t *testing.T) {
+	// The first line of nestest.log, without the PPU columns.
+	h := New()
+	h.setArch(cpu.R2A03)
+	h.cpu.Mem.StoreBytes(0xc000, []byte{0x4c, 0xf5, 0xc5})
+	h.cpu.SetPC(0xc000)
+	h.cpu.Reg.SP = 0xfd
+	h.cpu.Reg.RestorePS(0x24)
+	h.cpu.Cycles = 7
+
+	lines := runTrace(t, h, "set TraceFormat nestest\nstep in\n")
+	checkOutput(t, lines,
+		"C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD CYC:7")
+}
+
+func TestTraceLogFilters(t *testing.T) {
+	// Loop three times, and then run two NOPs to the breakpoint at $1007.
+	prog := "memory set $1000 $E8 $E0 $03 $D0 $FB $EA $EA $EA\n" +
+		"breakpoint add $1007\nset TraceFormat addr\n"
+
+	tests := []struct {
+		filters string
+		exp     []string
+	}{
+		{"", []string{"1000", "1001", "1003", "1000", "1001", "1003", "1000", "1001", "1003", "1005", "1006"}},
+		{"trace range $1001 $1001\n", []string{"1001", "1001", "1001"}},
+		{"trace range $1000 $1000\ntrace range $1005 $1006\n", []string{"1000", "1000", "1000", "1005", "1006"}},
+		{"trace start $1003\ntrace stop $1003\n", []string{"1003", "1003", "1003"}},
+		{"trace start $1005\n", []string{"1005", "1006"}},
+		{"trace stop $1001\n", []string{"1000", "1001"}},
+		{"trace range $1000 $1001\ntrace start $1001\n", []string{"1001", "1000", "1001", "1000", "1001"}},
+	}
+	for i, test := range tests {
+		h := New()
+		runHost(h, prog+test.filters)
+		lines := runTrace(t, h, "run $1000\n")
+		if strings.Join(lines, " ") != strings.Join(test.exp, " ") {
+			t.Errorf("Test %d incorrect. exp: %v, got: %v", i, test.exp, lines)
+		}
+	}
+}
+
+func TestTraceOnOff(t *testing.T) {
+	h := New()
+	file := filepath.Join(t.TempDir(), "trace.log")
+	out := runHost(h, "trace off\n")
+	checkOutput(t, out, "No trace log open.")
+
+	// Instructions executed after the log is closed aren't written.
+	out = runHost(h, "set TraceFormat addr\n"+
+		"memory set $1000 $EA $EA $EA\n"+
+		"register PC $1000\n"+
+		"trace on "+file+"\n"+
+		"step in 2\n"+
+		"trace off\n"+
+		"step in\n")
+	checkOutput(t, out,
+		"Tracing to '"+file+"'.",
+		"Trace log '"+file+"' closed after 2 instructions.",
+	)
+	b, err := ioutil.ReadFile(file)
+	if err != nil || string(b) != "1000\n1001\n" {
+		t.Errorf("Trace log incorrect. got: %q (%v)", b, err)
+	}
+}
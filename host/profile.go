@@ -0,0 +1,256 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// The cycles spent in a subroutine, excluding (self) and including (cum)
+// the subroutines it called.
+type routineProfile struct {
+	name string
+	self uint64
+	cum  uint64
+}
+
+// Return the name of the subroutine containing a profile location. A
+// called subroutine is named by the exported label at its entry address.
+// Code outside of any call is named by its nearest exported label.
+func (h *Host) routineName(l cpu.ProfileLocation) string {
+	addr := l.PC
+	if l.Called {
+		addr = l.Routine
+	}
+
+	e := h.nearestExport(addr)
+	switch {
+	case e == nil && l.Called:
+		return fmt.Sprintf("$%04X", addr)
+	case e == nil:
+		return "(top level)"
+	case e.Address == addr || !l.Called:
+		return e.Label
+	default:
+		return fmt.Sprintf("%s+%d", e.Label, addr-e.Address)
+	}
+}
+
+// Attribute the profile's cycles to subroutines, ordered by decreasing
+// self cycles.
+func (h *Host) routineProfiles(samples []cpu.ProfileSample) []routineProfile {
+	routines := make(map[string]*routineProfile)
+	lookup := func(name string) *routineProfile {
+		r, ok := routines[name]
+		if !ok {
+			r = &routineProfile{name: name}
+			routines[name] = r
+		}
+		return r
+	}
+
+	for _, s := range samples {
+		lookup(h.routineName(s.Stack[0])).self += s.Cycles
+
+		// A recursive subroutine counts once toward the cumulative cycles.
+		seen := make(map[string]bool)
+		for _, l := range s.Stack {
+			name := h.routineName(l)
+			if !seen[name] {
+				seen[name] = true
+				lookup(name).cum += s.Cycles
+			}
+		}
+	}
+
+	list := make([]routineProfile, 0, len(routines))
+	for _, r := range routines {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].self != list[j].self {
+			return list[i].self > list[j].self
+		}
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+// Write the profile to 'w' as a gzipped protocol buffer in the pprof
+// profile format. Each sample carries the number of instructions executed
+// and the number of cycles spent at an address with a call stack.
+func (h *Host) writeProfile(w io.Writer, samples []cpu.ProfileSample) error {
+	var p protoWriter
+
+	// The string table starts with the empty string.
+	strs := []string{""}
+	strIndex := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		i, ok := strIndex[s]
+		if !ok {
+			i = len(strs)
+			strs = append(strs, s)
+			strIndex[s] = i
+		}
+		return uint64(i)
+	}
+
+	valueType := func(typ, unit string) *protoWriter {
+		var m protoWriter
+		m.uint(1, str(typ))
+		m.uint(2, str(unit))
+		return &m
+	}
+	p.message(1, valueType("instructions", "count"))
+	p.message(1, valueType("cycles", "count"))
+
+	// Functions are the named subroutines, and locations are the addresses
+	// within them.
+	type locKey struct {
+		pc   uint16
+		name string
+	}
+	funcs := make(map[string]uint64)
+	locs := make(map[locKey]uint64)
+	var fm, lm []*protoWriter
+
+	function := func(l cpu.ProfileLocation) uint64 {
+		name := h.routineName(l)
+		if id, ok := funcs[name]; ok {
+			return id
+		}
+		id := uint64(len(funcs) + 1)
+		funcs[name] = id
+
+		start := l.PC
+		if l.Called {
+			start = l.Routine
+		}
+		var m protoWriter
+		m.uint(1, id)
+		m.uint(2, str(name))
+		m.uint(3, str(name))
+		if fn, line, err := h.sourceMap.FindInBank(int(start), h.bank(start)); err == nil {
+			m.uint(4, str(fn))
+			m.uint(5, uint64(line))
+		}
+		fm = append(fm, &m)
+		return id
+	}
+
+	location := func(l cpu.ProfileLocation) uint64 {
+		key := locKey{l.PC, h.routineName(l)}
+		if id, ok := locs[key]; ok {
+			return id
+		}
+		id := uint64(len(locs) + 1)
+		locs[key] = id
+
+		var line protoWriter
+		line.uint(1, function(l))
+		if _, n, err := h.sourceMap.FindInBank(int(l.PC), h.bank(l.PC)); err == nil {
+			line.uint(2, uint64(n))
+		}
+
+		var m protoWriter
+		m.uint(1, id)
+		m.uint(2, 1)
+		m.uint(3, uint64(l.PC))
+		m.message(4, &line)
+		lm = append(lm, &m)
+		return id
+	}
+
+	for _, s := range samples {
+		ids := make([]uint64, len(s.Stack))
+		for i, l := range s.Stack {
+			ids[i] = location(l)
+		}
+		var m protoWriter
+		m.packed(1, ids)
+		m.packed(2, []uint64{s.Instructions, s.Cycles})
+		p.message(2, &m)
+	}
+
+	var mapping protoWriter
+	mapping.uint(1, 1)
+	mapping.uint(3, 0x10000)
+	mapping.uint(5, str("go6502"))
+	mapping.uint(7, 1)
+	mapping.uint(8, 1)
+	mapping.uint(9, 1)
+	p.message(3, &mapping)
+
+	for _, m := range lm {
+		p.message(4, m)
+	}
+	for _, m := range fm {
+		p.message(5, m)
+	}
+
+	p.uint(9, uint64(time.Now().UnixNano()))
+	p.message(11, valueType("cycles", "count"))
+	p.uint(12, 1)
+	p.uint(14, str("cycles"))
+
+	// The string table is complete once everything else is encoded.
+	for _, s := range strs {
+		p.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// A protoWriter encodes a protocol buffer message.
+type protoWriter struct {
+	b []byte
+}
+
+func (p *protoWriter) varint(v uint64) {
+	for v >= 0x80 {
+		p.b = append(p.b, byte(v)|0x80)
+		v >>= 7
+	}
+	p.b = append(p.b, byte(v))
+}
+
+// Encode a varint field, omitting it if it is zero.
+func (p *protoWriter) uint(field int, v uint64) {
+	if v != 0 {
+		p.varint(uint64(field) << 3)
+		p.varint(v)
+	}
+}
+
+// Encode a length-delimited field.
+func (p *protoWriter) bytes(field int, b []byte) {
+	p.varint(uint64(field)<<3 | 2)
+	p.varint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+// Encode a repeated varint field in packed form.
+func (p *protoWriter) packed(field int, v []uint64) {
+	var m protoWriter
+	for _, vv := range v {
+		m.varint(vv)
+	}
+	p.bytes(field, m.b)
+}
+
+// Encode an embedded message field.
+func (p *protoWriter) message(field int, m *protoWriter) {
+	p.bytes(field, m.b)
+}
@@ -15,12 +15,25 @@ import (
 )
 
 type settings struct {
+	Arch            string `doc:"CPU architecture"`
+	Undefined       string `doc:"undefined opcode handling"`
 	HexMode         bool   `doc:"hexadecimal input mode"`
 	CompactMode     bool   `doc:"compact disassembly output"`
 	MemDumpBytes    int    `doc:"default number of memory bytes to dump"`
 	DisasmLines     int    `doc:"default number of lines to disassemble"`
 	SourceLines     int    `doc:"default number of source lines to display"`
 	MaxStepLines    int    `doc:"max lines to disassemble when stepping"`
+	HistorySize     int    `doc:"instructions recorded for stepping back"`
+	StackCheck      bool   `doc:"stop when the stack pointer wraps"`
+	StackFloor      uint16 `doc:"lowest stack address allowed by StackCheck"`
+	StackReturns    bool   `doc:"stop on returns that don't match calls"`
+	UninitCheck     bool   `doc:"report reads of uninitialized memory"`
+	CodeCheck       bool   `doc:"stop on code modification or data execution"`
+	Coverage        bool   `doc:"collect code coverage"`
+	Profile         bool   `doc:"collect a cycle profile"`
+	TraceFormat     string `doc:"trace log format: go6502, nestest, addr or custom"`
+	PowerOnFill     string `doc:"RAM at power-on: zero, random or hex bytes"`
+	PowerOnSeed     int    `doc:"seed for random fill, or 0 for a new seed"`
 	NextDisasmAddr  uint16 `doc:"address of next disassembly"`
 	NextSourceAddr  uint16 `doc:"address of next source line display"`
 	NextMemDumpAddr uint16 `doc:"address of next memory dump"`
@@ -28,12 +41,17 @@ type settings struct {
 
 func newSettings() *settings {
 	return &settings{
+		Arch:            "65c02",
+		Undefined:       "ignore",
 		HexMode:         false,
 		CompactMode:     false,
 		MemDumpBytes:    64,
 		DisasmLines:     10,
 		SourceLines:     10,
 		MaxStepLines:    20,
+		HistorySize:     10000,
+		PowerOnFill:     "zero",
+		TraceFormat:     "go6502",
 		NextDisasmAddr:  0,
 		NextMemDumpAddr: 0,
 	}
@@ -0,0 +1,219 @@
+// Copyright 2018 Brett Vickers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package host
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/go6502/cpu"
+	"github.com/beevik/go6502/disasm"
+)
+
+// Trace log formats that may be selected by name with the TraceFormat
+// setting.
+var traceFormats = map[string]string{
+	// The go6502 stepping display.
+	"go6502": "{pc}-   {bytes:8}    {inst:15} A={a} X={x} Y={y} PS=[{flags}] SP={sp} C={cycles}",
+
+	// The Nintendulator log layout used by nestest.log, without the PPU
+	// columns.
+	"nestest": "{pc}  {bytes:8}  {inst:32}A:{a} X:{x} Y:{y} P:{p} SP:{sp} CYC:{cycles}",
+
+	// Instruction addresses only, for comparing program flow.
+	"addr": "{pc}",
+}
+
+// A field of a trace log format: literal text or a value of the traced
+// instruction, padded to a minimum width.
+type traceField struct {
+	text  string // literal text, if token is empty
+	token string // name of the value
+	width int    // minimum width of the value
+}
+
+// Parse a trace log format, which is either the name of a format or a
+// string in which values are written as {name} or {name:width}.
+func parseTraceFormat(s string) ([]traceField, error) {
+	if f, ok := traceFormats[strings.ToLower(s)]; ok {
+		s = f
+	}
+	if !strings.Contains(s, "{") {
+		return nil, fmt.Errorf("Invalid trace format '%s'", s)
+	}
+
+	var fields []traceField
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			fields = append(fields, traceField{text: s})
+			break
+		}
+		if i > 0 {
+			fields = append(fields, traceField{text: s[:i]})
+		}
+
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("Unterminated trace format value '%s'", s[i:])
+		}
+		token, width := strings.ToLower(s[i+1:i+j]), "0"
+		if k := strings.IndexByte(token, ':'); k >= 0 {
+			token, width = token[:k], token[k+1:]
+		}
+
+		f := traceField{token: token}
+		var err error
+		if f.width, err = strconv.Atoi(width); err != nil || f.width < 0 {
+			return nil, fmt.Errorf("Invalid trace format width '%s'", width)
+		}
+		switch token {
+		case "pc", "bytes", "inst", "a", "x", "y", "sp", "p", "flags", "cycles":
+		default:
+			return nil, fmt.Errorf("Unknown trace format value '%s'", token)
+		}
+		fields = append(fields, f)
+		s = s[i+j+1:]
+	}
+	return fields, nil
+}
+
+// An inclusive range of instruction addresses to trace.
+type traceRange struct {
+	start uint16
+	end   uint16
+}
+
+// A traceLog is a tracer that writes a line to a file for each instruction
+// the CPU executes. Instructions may be filtered by address, and tracing
+// may be started and stopped when instructions at trigger addresses
+// execute.
+type traceLog struct {
+	h        *Host
+	file     *os.File
+	w        *bufio.Writer
+	filename string
+	fields   []traceField
+	ranges   []traceRange // addresses traced, or all if empty
+	start    *uint16      // address that starts tracing, if any
+	stop     *uint16      // address that stops tracing, if any
+	active   bool         // instructions are traced
+	lines    int          // number of lines written
+}
+
+func newTraceLog(h *Host) *traceLog {
+	fields, _ := parseTraceFormat("go6502")
+	return &traceLog{h: h, fields: fields}
+}
+
+// Open the file the trace is written to. If a start trigger is set,
+// tracing waits until its address executes.
+func (l *traceLog) open(filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.w = bufio.NewWriter(file)
+	l.filename = filename
+	l.active = l.start == nil
+	l.lines = 0
+	return nil
+}
+
+// Flush the buffered lines to the file.
+func (l *traceLog) flush() error {
+	return l.w.Flush()
+}
+
+// Flush and close the file.
+func (l *traceLog) close() error {
+	err := l.w.Flush()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file, l.w = nil, nil
+	return err
+}
+
+// Return true if the address is traced by the address ranges.
+func (l *traceLog) inRange(addr uint16) bool {
+	if len(l.ranges) == 0 {
+		return true
+	}
+	for _, r := range l.ranges {
+		if addr >= r.start && addr <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// BeforeInstruction is called by the CPU before an instruction executes.
+// The line is written before execution so that it shows the registers the
+// instruction started with.
+func (l *traceLog) BeforeInstruction(c *cpu.CPU, t *cpu.Trace) {
+	if l.start != nil && t.PC == *l.start {
+		l.active = true
+	}
+	if l.active && l.inRange(t.PC) {
+		l.write(c, t)
+	}
+	if l.stop != nil && t.PC == *l.stop {
+		l.active = false
+	}
+}
+
+// AfterInstruction is called by the CPU after an instruction executes.
+func (l *traceLog) AfterInstruction(c *cpu.CPU, t *cpu.Trace) {
+}
+
+// Write a line describing the instruction about to execute.
+func (l *traceLog) write(c *cpu.CPU, t *cpu.Trace) {
+	var b strings.Builder
+	for _, f := range l.fields {
+		if f.token == "" {
+			b.WriteString(f.text)
+			continue
+		}
+
+		var v string
+		switch f.token {
+		case "pc":
+			v = fmt.Sprintf("%04X", t.PC)
+		case "bytes":
+			v = fmt.Sprintf("% X", append([]byte{t.Inst.Opcode}, t.Operand...))
+		case "inst":
+			line, _ := l.h.disassembleInst(t.PC)
+			v = strings.Join(strings.Fields(line), " ")
+		case "a":
+			v = fmt.Sprintf("%02X", t.Reg.A)
+		case "x":
+			v = fmt.Sprintf("%02X", t.Reg.X)
+		case "y":
+			v = fmt.Sprintf("%02X", t.Reg.Y)
+		case "sp":
+			v = fmt.Sprintf("%02X", t.Reg.SP)
+		case "p":
+			v = fmt.Sprintf("%02X", t.Reg.SavePS(false))
+		case "flags":
+			v = disasm.GetStatusString(&t.Reg)
+		case "cycles":
+			v = strconv.FormatUint(c.Cycles, 10)
+		}
+		b.WriteString(v)
+		for n := len(v); n < f.width; n++ {
+			b.WriteByte(' ')
+		}
+	}
+
+	// Trailing padding is removed, as other emulators' logs don't have it.
+	l.w.WriteString(strings.TrimRight(b.String(), " "))
+	l.w.WriteByte('\n')
+	l.lines++
+}